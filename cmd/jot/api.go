@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bjarneo/jot/internal/api"
+	"github.com/bjarneo/jot/internal/daemon"
+	"github.com/bjarneo/jot/internal/ui"
+)
+
+// defaultAPISocketPath puts the API socket next to the daemon's.
+func defaultAPISocketPath() string {
+	return filepath.Join(filepath.Dir(daemon.DefaultSocketPath()), "api.sock")
+}
+
+// serveAPI opens the API socket at path for the client about to run with
+// config, exiting if it cannot. It returns a func that closes the socket, and
+// does nothing if path is empty.
+func serveAPI(config *ui.Config, path string) func() {
+	if path == "" {
+		return func() {}
+	}
+	server, err := api.Listen(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	config.API = server
+	return func() { server.Close() }
+}
+
+// runAPI implements `jot api`, which calls the API of a client started with -api
+// and prints its JSON answer.
+func runAPI(args []string) {
+	fs := flag.NewFlagSet("api", flag.ExitOnError)
+	socketPath := fs.String("socket", defaultAPISocketPath(), "Socket of the client's API")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: jot api [-socket path] status | participants | send <text>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	req := api.Request{Method: fs.Arg(0)}
+	switch {
+	case req.Method == api.MethodSend && fs.NArg() > 1:
+		req.Text = strings.Join(fs.Args()[1:], " ")
+	case req.Method == api.MethodStatus && fs.NArg() == 1, req.Method == api.MethodParticipants && fs.NArg() == 1:
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	response, err := api.Call(*socketPath, req)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	out, _ := json.MarshalIndent(response, "", "  ")
+	fmt.Println(string(out))
+	if !response.OK {
+		os.Exit(1)
+	}
+}
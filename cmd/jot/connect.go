@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// connectFlags tune how long reaching the relay may take and how often the
+// client tries again.
+type connectFlags struct {
+	connectTimeout   *time.Duration
+	handshakeTimeout *time.Duration
+	retries          *int
+	retryBackoff     *time.Duration
+	chaos            *string
+}
+
+func addConnectFlags(fs *flag.FlagSet) connectFlags {
+	f := connectFlags{
+		connectTimeout:   fs.Duration("connect-timeout", network.DefaultConnectTimeout, "How long resolving and connecting to the relay server may take"),
+		handshakeTimeout: fs.Duration("handshake-timeout", network.DefaultHandshakeTimeout, "How long the TLS handshake and the relay server's first answer may take"),
+		retries:          fs.Int("retries", 2, "How many more times to try reaching the relay server after a failed attempt"),
+		retryBackoff:     fs.Duration("retry-backoff", time.Second, "Wait before the first retry; it doubles for each retry after that"),
+		// For testing only, so it is left out of -help.
+		chaos: fs.String("chaos", "", "Drop, delay and repeat frames on purpose, e.g. drop=0.05,dup=0.02,delay=0.1:3s"),
+	}
+	hideFlags(fs, "chaos")
+	return f
+}
+
+// hideFlags leaves the named flags out of the usage message of fs.
+func hideFlags(fs *flag.FlagSet, names ...string) {
+	fs.Usage = func() {
+		shown := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		shown.SetOutput(fs.Output())
+		fs.VisitAll(func(f *flag.Flag) {
+			if !slices.Contains(names, f.Name) {
+				shown.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		shown.PrintDefaults()
+	}
+}
+
+// applyProfile takes the profile's connection settings for any flag not given
+// on the command line.
+func (f connectFlags) applyProfile(fs *flag.FlagSet, p config.Profile) error {
+	durations := []struct {
+		flag, value string
+		target      *time.Duration
+	}{
+		{"connect-timeout", p.ConnectTimeout, f.connectTimeout},
+		{"handshake-timeout", p.HandshakeTimeout, f.handshakeTimeout},
+		{"retry-backoff", p.RetryBackoff, f.retryBackoff},
+	}
+	for _, d := range durations {
+		if d.value == "" || isFlagSet(fs, d.flag) {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s in profile: %w", d.flag, err)
+		}
+		*d.target = value
+	}
+	if p.Retries != nil && !isFlagSet(fs, "retries") {
+		*f.retries = *p.Retries
+	}
+	return nil
+}
+
+func (f connectFlags) policy() (network.ConnectPolicy, error) {
+	policy := network.ConnectPolicy{
+		ConnectTimeout:   *f.connectTimeout,
+		HandshakeTimeout: *f.handshakeTimeout,
+		Retries:          *f.retries,
+		RetryBackoff:     *f.retryBackoff,
+	}
+	if policy.ConnectTimeout <= 0 || policy.HandshakeTimeout <= 0 {
+		return policy, fmt.Errorf("connect and handshake timeouts must be positive")
+	}
+	if policy.Retries < 0 || policy.RetryBackoff < 0 {
+		return policy, fmt.Errorf("retries and retry backoff may not be negative")
+	}
+	if *f.chaos != "" {
+		chaos, err := network.ParseChaos(*f.chaos)
+		if err != nil {
+			return policy, err
+		}
+		policy.Chaos = chaos
+	}
+	return policy, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bjarneo/jot/internal/contacts"
+	"github.com/bjarneo/jot/internal/crypto"
+)
+
+const contactsUsage = `Usage:
+  jot contacts [-profile name] add <alias> <fingerprint>
+  jot contacts [-profile name] list
+  jot contacts [-profile name] remove <alias|fingerprint>`
+
+// runContacts implements `jot contacts`, which manages the roster used to
+// label peers by their identity key instead of the nickname they chose.
+func runContacts(args []string) {
+	fs := flag.NewFlagSet("contacts", flag.ExitOnError)
+	profileName := fs.String("profile", "", "Manage the contacts of this profile")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, contactsUsage) }
+	fs.Parse(args)
+
+	path, err := contacts.DefaultPath()
+	var identity ed25519.PrivateKey
+	if *profileName != "" {
+		var p *profile
+		p, err = loadProfile(*profileName)
+		if err == nil {
+			path, identity = p.ContactsPath, p.Identity
+		}
+	} else if err == nil {
+		identity, err = loadDefaultIdentity()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	store, err := contacts.Load(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	rest := fs.Args()
+	if len(rest) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	switch {
+	case rest[0] == "add" && len(rest) == 3:
+		if err := store.Add(rest[1], rest[2], true); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		if err := store.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %s (%s).\n", rest[1], contacts.NormalizeFingerprint(rest[2]))
+	case rest[0] == "list" && len(rest) == 1:
+		if identity != nil {
+			fmt.Printf("Your identity fingerprint: %s\n\n", crypto.Fingerprint(identity.Public().(ed25519.PublicKey)))
+		}
+		list := store.List()
+		if len(list) == 0 {
+			fmt.Println("No contacts saved.")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ALIAS\tFINGERPRINT\tVERIFIED")
+		for _, c := range list {
+			fmt.Fprintf(w, "%s\t%s\t%t\n", c.Alias, c.Fingerprint, c.Verified)
+		}
+		w.Flush()
+	case rest[0] == "remove" && len(rest) == 2:
+		if !store.Remove(rest[1]) {
+			fmt.Fprintf(os.Stderr, "Error: no contact %q\n", rest[1])
+			os.Exit(1)
+		}
+		if err := store.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %s.\n", rest[1])
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
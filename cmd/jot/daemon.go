@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bjarneo/jot/internal/daemon"
+	"github.com/bjarneo/jot/internal/ui"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// daemonChildEnv marks the detached process started by -daemon.
+const daemonChildEnv = "JOT_DAEMON_CHILD=1"
+
+// runDaemon starts the client in a detached background process and attaches
+// this terminal to it. In the background process it runs the client itself,
+// serving the API on apiSocket unless it is empty.
+func runDaemon(config ui.Config, socketPath, apiSocket string) {
+	if os.Getenv("JOT_DAEMON_CHILD") == "1" {
+		defer serveAPI(&config, apiSocket)()
+		err := daemon.Run(socketPath, func(s *daemon.Server) *tea.Program {
+			config.Detached = func() bool { return !s.Attached() }
+			config.Terminal = s
+			return ui.NewProgram(config, tea.WithInput(s.Input()), tea.WithOutput(s))
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := daemon.Spawn(socketPath, os.Args[1:], daemonChildEnv); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	attach(socketPath)
+}
+
+// runAttach implements `jot attach`, connecting this terminal to a running daemon.
+func runAttach(args []string) {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	socketPath := fs.String("socket", daemon.DefaultSocketPath(), "Socket of the daemon to attach to")
+	fs.Parse(args)
+
+	attach(*socketPath)
+}
+
+func attach(socketPath string) {
+	err := daemon.Attach(socketPath)
+	switch {
+	case errors.Is(err, daemon.ErrDetached):
+		fmt.Println("Detached. The session keeps running; reattach with: jot attach")
+	case err != nil:
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// runDoctor implements `jot doctor`: it checks each layer between us and the
+// relay in turn, so a failure to connect can be pinned on DNS, TCP, TLS or
+// the relay itself.
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	relayServerAddr := fs.String("relay-server", "relay.hemmelig.app:443", "Address of the relay server (e.g., localhost:8080)")
+	clientCertFile := fs.String("client-cert", "", "PEM certificate presented to relays that require client certificates")
+	clientKeyFile := fs.String("client-key", "", "PEM private key for -client-cert")
+	pinCert := fs.String("pin-cert", "", "Trust the relay only if its TLS certificate or public key has this hash (sha256:<hex>), instead of checking it against the system's CAs; for self-signed relays")
+	connectTimeout := fs.Duration("connect-timeout", network.DefaultConnectTimeout, "How long resolving and connecting to the relay server may take")
+	handshakeTimeout := fs.Duration("handshake-timeout", network.DefaultHandshakeTimeout, "How long the TLS handshake and the relay server's first answer may take")
+	fs.Parse(args)
+
+	clientCert, err := network.LoadClientCert(*clientCertFile, *clientKeyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	pinnedCert, err := network.ParseCertPin(*pinCert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if *connectTimeout <= 0 || *handshakeTimeout <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: connect and handshake timeouts must be positive")
+		os.Exit(1)
+	}
+
+	opts := network.DialOptions{
+		RelayServerAddr: *relayServerAddr,
+		ClientCert:      clientCert,
+		PinnedCert:      pinnedCert,
+		ConnectPolicy:   network.ConnectPolicy{ConnectTimeout: *connectTimeout, HandshakeTimeout: *handshakeTimeout},
+	}
+	fmt.Printf("Checking the relay server at %s\n\n", *relayServerAddr)
+	var failed string
+	ok := network.Diagnose(opts, func(check network.Check) {
+		mark := "✓"
+		if check.Err != nil {
+			mark, failed = "✗", check.Step
+		}
+		fmt.Printf("%s %-9s %s\n", mark, check.Step, check.Detail)
+		if check.Hint != "" {
+			fmt.Printf("  %-9s → %s\n", "", check.Hint)
+		}
+	})
+	if !ok {
+		fmt.Printf("\nThe %s check failed, so the checks after it were skipped.\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("\nAll checks passed; Jot can reach this relay.")
+}
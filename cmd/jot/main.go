@@ -4,19 +4,212 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/daemon"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/notify"
+	"github.com/bjarneo/jot/internal/protocol"
 	"github.com/bjarneo/jot/internal/ui"
 )
 
 func main() {
-	const maxFileSize = 10 // MB
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "msg":
+			runMsg(os.Args[2:])
+			return
+		case "attach":
+			runAttach(os.Args[2:])
+			return
+		case "api":
+			runAPI(os.Args[2:])
+			return
+		case "unpack":
+			runUnpack(os.Args[2:])
+			return
+		case "contacts":
+			runContacts(os.Args[2:])
+			return
+		case "rooms":
+			runRooms(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		}
+	}
+
+	// `jot browse` is the usual client, starting at the relay's room directory.
+	browse := len(os.Args) > 1 && os.Args[1] == "browse"
+	if browse {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	relayServerAddr := flag.String("relay-server", "relay.hemmelig.app:443", "Address of the relay server (e.g., localhost:8080)")
+	waitingRoom := flag.Bool("waiting-room", false, "When creating a session, hold everyone who tries to join until you /admit or /deny them")
+	password := flag.Bool("password", false, "Ask for a session password agreed on with your peer, which both clients prove to each other with SPAKE2 so the relay cannot intercept the session")
+	observe := flag.Bool("observe", false, "When joining, ask the owner to let you in as an observer, who reads along but cannot post or send files")
+	label := flag.String("label", "", "When creating a session, a name for it shown in both participants' status bars; shared end-to-end encrypted")
+	via := flag.String("via", "", "Reach the relay server through this relay, so neither relay sees both your address and your session (e.g., relay.example.com:443)")
+	clientCertFile := flag.String("client-cert", "", "PEM certificate presented to relays that require client certificates")
+	clientKeyFile := flag.String("client-key", "", "PEM private key for -client-cert")
+	pinCert := flag.String("pin-cert", "", "Trust the relay only if its TLS certificate or public key has this hash (sha256:<hex>), instead of checking it against the system's CAs; for self-signed relays")
+	maxFileSize := flag.Int("max-file-size", 10, "Maximum size in MB of files you send or accept; advertised to the peer")
+	cipherName := flag.String("cipher", "aes-gcm", "AEAD for outgoing messages: aes-gcm or xchacha20")
+	compress := flag.Bool("compress", false, "Compress long messages before encrypting them, for peers that support it; see the README for the tradeoff")
+	maxIncomingOffers := flag.Int("max-incoming-offers", 3, "Maximum incoming file offers pending or in progress; excess offers are rejected automatically (0 = unlimited)")
+	maxUnverifiedMB := flag.Int("max-unverified-mb-per-hour", 100, "Maximum MB per hour accepted from unverified peers; larger offers are rejected automatically (0 = unlimited)")
+	maxMessageRate := flag.Int("max-message-rate", 30, "Messages from the peer shown per 10 seconds; faster ones are held back on one line until /show (0 = unlimited)")
+	rekeyMessages := flag.Int("rekey-messages", 1000, "Replace the session keys after this many messages sent and received, as /rekey does, for peers that support it (0 = never)")
+	rekeyInterval := flag.Duration("rekey-interval", time.Hour, "Replace the session keys after using them this long, as /rekey does, for peers that support it (0 = never)")
+	openCommand := flag.String("open-command", "", "Command used by /open for received files (default: the platform's default handler)")
+	postReceive := flag.String("post-receive", "", "Command run on every received file, with its path appended (e.g. \"clamscan --no-summary\")")
+	onMessage := flag.String("on-message", "", "Command run for every received message, with the text on stdin and JOT_SENDER/JOT_SESSION set")
+	daemonMode := flag.Bool("daemon", false, "Keep the session running in a background process; detach with Ctrl-] and reattach with `jot attach`")
+	socketPath := flag.String("socket", daemon.DefaultSocketPath(), "Socket used to attach to the daemon started by -daemon")
+	apiEnabled := flag.Bool("api", false, "Serve a local API on -api-socket so scripts can read the session's status and send messages; see `jot api`")
+	apiSocket := flag.String("api-socket", defaultAPISocketPath(), "Socket the -api is served on")
+	notifyURL := flag.String("notify-url", "", "With -daemon, push a notification here for messages received while detached (ntfy topic URL or Gotify message URL with ?token=)")
+	notifyProvider := flag.String("notify-provider", notify.ProviderNtfy, "Push notification service for -notify-url: ntfy or gotify")
+	notifyContent := flag.Bool("notify-content", false, "Include message content in push notifications instead of only a subject line")
+	profileName := flag.String("profile", "", "Named profile from the config file to use; each profile has its own identity key and trust store")
+	ephemeralIdentity := flag.Bool("ephemeral-identity", false, "Use a fresh identity key for this session instead of the one kept in the config directory, so peers cannot recognise you")
+	themeName := flag.String("theme", "default", "Color theme: default, light or mono")
+	timestamps := flag.String("timestamps", ui.TimestampsAbsolute, "Timestamp style: absolute (HH:MM) or relative (\"2m ago\", with day separators); /timestamps switches")
+	connect := addConnectFlags(flag.CommandLine)
 	flag.Parse()
 
+	var selected *profile
+	if *profileName != "" {
+		p, err := loadProfile(*profileName)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		selected = p
+		if p.RelayServer != "" && !isFlagSet(flag.CommandLine, "relay-server") {
+			*relayServerAddr = p.RelayServer
+		}
+		if p.Theme != "" && !isFlagSet(flag.CommandLine, "theme") {
+			*themeName = p.Theme
+		}
+		if p.Timestamps != "" && !isFlagSet(flag.CommandLine, "timestamps") {
+			*timestamps = p.Timestamps
+		}
+		if err := connect.applyProfile(flag.CommandLine, p.Profile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if err := ui.ApplyTheme(*themeName); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *timestamps != ui.TimestampsAbsolute && *timestamps != ui.TimestampsRelative {
+		fmt.Printf("unknown timestamp style %q (available: %s, %s)\n", *timestamps, ui.TimestampsAbsolute, ui.TimestampsRelative)
+		os.Exit(1)
+	}
+
 	if *relayServerAddr == "" {
 		fmt.Println("Usage: jot -relay-server <address>")
 		os.Exit(1)
 	}
 
-	ui.StartInitialUI(*relayServerAddr, maxFileSize)
+	if len(*label) > protocol.MaxLabel {
+		fmt.Printf("-label is longer than %d bytes\n", protocol.MaxLabel)
+		os.Exit(1)
+	}
+
+	cipher, err := crypto.ParseCipher(*cipherName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	clientCert, err := network.LoadClientCert(*clientCertFile, *clientKeyFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	pinnedCert, err := network.ParseCertPin(*pinCert)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	connectPolicy, err := connect.policy()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	config := ui.Config{
+		RelayServerAddr: *relayServerAddr,
+		Via:             *via,
+		ClientCert:      clientCert,
+		PinnedCert:      pinnedCert,
+		Connect:         connectPolicy,
+		WaitingRoom:     *waitingRoom,
+		Observe:         *observe,
+		Label:           *label,
+		Browse:          browse,
+		MaxFileSize:     *maxFileSize,
+		Cipher:          cipher,
+		AskPassword:     *password,
+		Compress:        *compress,
+
+		RelativeTimestamps: *timestamps == ui.TimestampsRelative,
+
+		MaxIncomingOffers:      *maxIncomingOffers,
+		MaxUnverifiedMBPerHour: *maxUnverifiedMB,
+		MaxMessageRate:         *maxMessageRate,
+
+		RekeyMessages: *rekeyMessages,
+		RekeyInterval: *rekeyInterval,
+
+		PostReceiveCommand: *postReceive,
+		OpenCommand:        *openCommand,
+		OnMessageCommand:   *onMessage,
+	}
+
+	if selected != nil {
+		config.Identity = selected.Identity
+		config.TrustStorePath = selected.TrustStorePath
+		config.PinsPath = selected.PinsPath
+		config.ContactsPath = selected.ContactsPath
+		config.Nickname = selected.Nickname
+	} else if !*ephemeralIdentity {
+		if config.Identity, err = loadDefaultIdentity(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if config.Alerts, err = loadAlerts(selected); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *notifyURL != "" {
+		notifier, err := notify.New(*notifyURL, *notifyProvider, *notifyContent)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		config.Notifier = notifier
+	}
+
+	if !*apiEnabled {
+		*apiSocket = ""
+	}
+	if *daemonMode {
+		runDaemon(config, *socketPath, *apiSocket)
+		return
+	}
+	defer serveAPI(&config, *apiSocket)()
+	ui.StartInitialUI(config)
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// maxPipedMessageSize bounds how much of stdin a one-shot message may carry.
+const maxPipedMessageSize = protocol.MaxText
+
+// runMsg implements `jot msg`: it joins a session, sends stdin as a single
+// encrypted message and exits. Intended for scripts, CI jobs and cron.
+func runMsg(args []string) {
+	fs := flag.NewFlagSet("msg", flag.ExitOnError)
+	relayServerAddr := fs.String("relay-server", "relay.hemmelig.app:443", "Address of the relay server (e.g., localhost:8080)")
+	via := fs.String("via", "", "Reach the relay server through this relay")
+	sessionID := fs.String("session", "", "ID of the session to deliver the message to")
+	nickname := fs.String("nickname", "jot", "Nickname shown to the peer")
+	cipherName := fs.String("cipher", "aes-gcm", "AEAD for the message: aes-gcm or xchacha20")
+	knock := fs.String("knock", "", "Note asking the owner of a locked session to let you in")
+	passwordFile := fs.String("password-file", "", "File whose first line is the session password, for sessions whose participants set one with -password")
+	clientCertFile := fs.String("client-cert", "", "PEM certificate presented to relays that require client certificates")
+	clientKeyFile := fs.String("client-key", "", "PEM private key for -client-cert")
+	pinCert := fs.String("pin-cert", "", "Trust the relay only if its TLS certificate or public key has this hash (sha256:<hex>), instead of checking it against the system's CAs; for self-signed relays")
+	connect := addConnectFlags(fs)
+	fs.Parse(args)
+
+	if *sessionID == "" {
+		fmt.Fprintln(os.Stderr, "Usage: echo \"message\" | jot msg -session <id>")
+		os.Exit(1)
+	}
+
+	clientCert, err := network.LoadClientCert(*clientCertFile, *clientKeyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	pinnedCert, err := network.ParseCertPin(*pinCert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	connectPolicy, err := connect.policy()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	password, err := readPasswordFile(*passwordFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	if err := sendPipedMessage(network.DialOptions{RelayServerAddr: *relayServerAddr, Via: *via, ClientCert: clientCert, PinnedCert: pinnedCert, ConnectPolicy: connectPolicy}, *sessionID, *nickname, *cipherName, *knock, password, os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// readPasswordFile returns the first line of path, or nil if path is empty.
+func readPasswordFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the password file: %w", err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	if line = strings.TrimRight(line, "\r"); line == "" {
+		return nil, fmt.Errorf("the password file %s is empty", path)
+	}
+	return []byte(line), nil
+}
+
+func sendPipedMessage(dialOpts network.DialOptions, sessionID, nickname, cipherName, knock string, password []byte, input io.Reader) error {
+	cipher, err := crypto.ParseCipher(cipherName)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(input, maxPipedMessageSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read stdin: %w", err)
+	}
+	if len(data) > maxPipedMessageSize {
+		return fmt.Errorf("message exceeds %d bytes", maxPipedMessageSize)
+	}
+	text := strings.TrimRight(string(data), "\r\n")
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("nothing to send: stdin is empty")
+	}
+
+	identity, err := crypto.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+
+	dialOpts.Introduce = func(prompt string) (network.JoinIntro, error) {
+		if network.IsLockedPrompt(prompt) && knock == "" {
+			return network.JoinIntro{}, fmt.Errorf("session is locked; use -knock to ask the owner to let you in")
+		}
+		fmt.Fprintln(os.Stderr, "Waiting for the session owner to admit you...")
+		return network.JoinIntro{Nickname: nickname, Fingerprint: crypto.Fingerprint(identity.Public().(ed25519.PublicKey)), Note: knock}, nil
+	}
+	conn, _, err := network.Connect(dialOpts, "JOIN", sessionID)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	keys, _, _, err := network.Handshake(conn, bufio.NewReader(conn), crypto.SessionKeys{Identity: identity, Cipher: cipher, Password: password}, false)
+	if err != nil {
+		return err
+	}
+
+	if err := network.SendData(conn, keys, protocol.TypeNickname, []byte(nickname)); err != nil {
+		return fmt.Errorf("failed to send nickname: %w", err)
+	}
+	if err := network.SendData(conn, keys, protocol.TypeText, []byte(text)); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	// Half-close and wait for the relay to hang up, so our frames are flushed to
+	// the peer instead of being discarded by a reset on close.
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		if err := cw.CloseWrite(); err == nil {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			io.Copy(io.Discard, conn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bjarneo/jot/internal/config"
+)
+
+// profile is a named profile from the config file together with its
+// isolated key storage.
+type profile struct {
+	config.Profile
+	Identity       ed25519.PrivateKey
+	TrustStorePath string
+	PinsPath       string
+	ContactsPath   string
+}
+
+func loadProfile(name string) (*profile, error) {
+	path, err := config.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := file.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := config.ProfileDir(name)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := config.LoadOrCreateIdentity(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &profile{
+		Profile:        settings,
+		Identity:       identity,
+		TrustStorePath: filepath.Join(dir, "trust.json"),
+		PinsPath:       filepath.Join(dir, "known_peers.json"),
+		ContactsPath:   filepath.Join(dir, "contacts.json"),
+	}, nil
+}
+
+// loadDefaultIdentity reads the identity key used without a profile, kept
+// next to the config file, creating it on first use.
+func loadDefaultIdentity() (ed25519.PrivateKey, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create config directory: %w", err)
+	}
+	return config.LoadOrCreateIdentity(dir)
+}
+
+// loadAlerts reads the event alerts from the config file, letting those of
+// the selected profile, if any, take precedence.
+func loadAlerts(selected *profile) (map[string]config.Alert, error) {
+	path, err := config.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if selected == nil {
+		return file.Alerts(nil)
+	}
+	return file.Alerts(&selected.Profile)
+}
+
+// isFlagSet reports whether the named flag was given on the command line,
+// so explicit flags can take precedence over profile settings.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// runRooms implements `jot rooms`: it prints the sessions listed in the
+// relay's public directory, ready to join with -session.
+func runRooms(args []string) {
+	fs := flag.NewFlagSet("rooms", flag.ExitOnError)
+	relayServerAddr := fs.String("relay-server", "relay.hemmelig.app:443", "Address of the relay server (e.g., localhost:8080)")
+	via := fs.String("via", "", "Reach the relay server through this relay")
+	clientCertFile := fs.String("client-cert", "", "PEM certificate presented to relays that require client certificates")
+	clientKeyFile := fs.String("client-key", "", "PEM private key for -client-cert")
+	pinCert := fs.String("pin-cert", "", "Trust the relay only if its TLS certificate or public key has this hash (sha256:<hex>), instead of checking it against the system's CAs; for self-signed relays")
+	connect := addConnectFlags(fs)
+	fs.Parse(args)
+
+	clientCert, err := network.LoadClientCert(*clientCertFile, *clientKeyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	pinnedCert, err := network.ParseCertPin(*pinCert)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	connectPolicy, err := connect.policy()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+
+	rooms, err := network.ListRooms(network.DialOptions{RelayServerAddr: *relayServerAddr, Via: *via, ClientCert: clientCert, PinnedCert: pinnedCert, ConnectPolicy: connectPolicy})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	if len(rooms) == 0 {
+		fmt.Println("No rooms are listed on this relay.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPEOPLE\tSESSION\tTOPIC")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "%s\t%d/2\t%s\t%s\n", room.Name, room.Participants, room.SessionID, room.Topic)
+	}
+	w.Flush()
+}
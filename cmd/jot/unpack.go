@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bjarneo/jot/internal/archive"
+	"github.com/charmbracelet/x/term"
+)
+
+// runUnpack implements `jot unpack`, which decrypts a session recording made with /record.
+func runUnpack(args []string) {
+	fs := flag.NewFlagSet("unpack", flag.ExitOnError)
+	outDir := fs.String("o", "", "Directory to extract into (default: the archive name without its extension)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: jot unpack [-o dir] <archive.jotrec>")
+		os.Exit(1)
+	}
+	path := fs.Arg(0)
+	if *outDir == "" {
+		*outDir = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+
+	passphrase, err := readPassphrase()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	written, err := archive.Extract(path, passphrase, *outDir)
+	for _, file := range written {
+		fmt.Println(file)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+// readPassphrase prompts for a passphrase without echo, or reads a line from
+// stdin when it is not a terminal.
+func readPassphrase() (string, error) {
+	if term.IsTerminal(os.Stdin.Fd()) {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+		passphrase, err := term.ReadPassword(os.Stdin.Fd())
+		fmt.Fprintln(os.Stderr)
+		return string(passphrase), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
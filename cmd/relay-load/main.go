@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// relay-load puts a relay under load, to measure how changes to the
+// way it forwards data perform. It opens sessions of two clients each, has
+// every client send its peer messages at a steady rate, and reports how long
+// the messages took to arrive. Given the relay's -metrics-addr, it also
+// reports how much memory the relay used.
+
+// timestampSize is the send time every message starts with, in nanoseconds.
+const timestampSize = 8
+
+// drainTime is how long the clients keep reading after they stop sending, for
+// the messages still on their way.
+const drainTime = 2 * time.Second
+
+// client is one end of a session.
+type client struct {
+	conn            net.Conn
+	reader          *bufio.Reader
+	keys            *crypto.SessionKeys
+	peerExchangeKey []byte // Checked against the peer's identity message
+}
+
+// results collects what the clients saw.
+type results struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+
+	sent     atomic.Int64
+	received atomic.Int64
+	failures atomic.Int64
+}
+
+func (r *results) arrived(latency time.Duration) {
+	r.received.Add(1)
+	r.mu.Lock()
+	r.latencies = append(r.latencies, latency)
+	r.mu.Unlock()
+}
+
+func main() {
+	relayServerAddr := flag.String("relay-server", "localhost:8080", "Address of the relay server to load")
+	sessions := flag.Int("sessions", 10, "Sessions to open, each with two clients")
+	size := flag.Int("size", 256, fmt.Sprintf("Bytes in each message, at least %d", timestampSize))
+	rate := flag.Float64("rate", 10, "Messages each client sends per second")
+	duration := flag.Duration("duration", 30*time.Second, "How long the clients send for")
+	kind := flag.String("type", "text", "What to send: text messages, or chunk for file chunks")
+	cipherName := flag.String("cipher", "aes-gcm", "AEAD for the messages: aes-gcm or xchacha20")
+	metricsAddr := flag.String("metrics", "", "The relay's -metrics-addr, to report the memory it uses; empty skips it")
+	flag.Parse()
+
+	msgType := protocol.TypeText
+	switch *kind {
+	case "text":
+	case "chunk":
+		msgType = protocol.TypeFileChunk
+	default:
+		fail(fmt.Errorf("unknown -type %q: use text or chunk", *kind))
+	}
+	if *sessions < 1 || *rate <= 0 || *duration <= 0 {
+		fail(errors.New("-sessions, -rate and -duration must be positive"))
+	}
+	if *size < timestampSize || *size > protocol.MaxFramePayload/2 {
+		fail(fmt.Errorf("-size must be between %d and %d", timestampSize, protocol.MaxFramePayload/2))
+	}
+	cipher, err := crypto.ParseCipher(*cipherName)
+	if err != nil {
+		fail(err)
+	}
+
+	var before relayMemory
+	if *metricsAddr != "" {
+		if before, err = readRelayMemory(*metricsAddr); err != nil {
+			fail(err)
+		}
+	}
+
+	opts := network.DialOptions{RelayServerAddr: *relayServerAddr}
+	fmt.Printf("Opening %d sessions on %s...\n", *sessions, *relayServerAddr)
+	clients, err := openSessions(opts, cipher, *sessions)
+	if err != nil {
+		fail(err)
+	}
+
+	res := &results{}
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			c.receive(res)
+		}()
+	}
+
+	fmt.Printf("Sending %d-byte messages at %g per second from each of %d clients for %s...\n", *size, *rate, len(clients), *duration)
+	started := time.Now()
+	stop := started.Add(*duration)
+	var senders sync.WaitGroup
+	for _, c := range clients {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			c.send(res, msgType, *size, *rate, stop)
+		}()
+	}
+	senders.Wait()
+	elapsed := time.Since(started)
+	time.Sleep(drainTime)
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	readers.Wait()
+
+	report(res, *size, elapsed)
+	if *metricsAddr != "" {
+		after, err := readRelayMemory(*metricsAddr)
+		if err != nil {
+			fail(err)
+		}
+		allocated := after.allocated - before.allocated
+		fmt.Printf("Relay heap:  %s (%s before the run)\n", formatBytes(after.heap), formatBytes(before.heap))
+		fmt.Printf("Relay allocated %s during the run", formatBytes(allocated))
+		if relayed := after.relayed - before.relayed; relayed > 0 {
+			fmt.Printf(", %.2f bytes per byte relayed", float64(allocated)/float64(relayed))
+		}
+		fmt.Println()
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
+
+// openSessions creates n sessions and joins each of them, returning both
+// clients of every session once their keys are exchanged.
+func openSessions(opts network.DialOptions, cipher crypto.Cipher, n int) ([]*client, error) {
+	clients := make([]*client, 2*n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[2*i], clients[2*i+1], errs[i] = openSession(opts, cipher)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for _, c := range clients {
+			if c != nil {
+				c.conn.Close()
+			}
+		}
+		return nil, err
+	}
+	return clients, nil
+}
+
+// openSession creates a session and joins it.
+func openSession(opts network.DialOptions, cipher crypto.Cipher) (*client, *client, error) {
+	ownerConn, sessionID, err := network.Connect(opts, "CREATE", "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create a session: %w", err)
+	}
+	guestConn, _, err := network.Connect(opts, "JOIN", sessionID)
+	if err != nil {
+		ownerConn.Close()
+		return nil, nil, fmt.Errorf("could not join session %s: %w", sessionID, err)
+	}
+
+	var owner, guest *client
+	var ownerErr, guestErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		owner, ownerErr = handshake(ownerConn, cipher, true)
+	}()
+	go func() {
+		defer wg.Done()
+		guest, guestErr = handshake(guestConn, cipher, false)
+	}()
+	wg.Wait()
+	if err := errors.Join(ownerErr, guestErr); err != nil {
+		ownerConn.Close()
+		guestConn.Close()
+		return nil, nil, fmt.Errorf("key exchange in session %s failed: %w", sessionID, err)
+	}
+	return owner, guest, nil
+}
+
+// handshake exchanges keys on conn under a fresh identity.
+func handshake(conn net.Conn, cipher crypto.Cipher, isInitiator bool) (*client, error) {
+	identity, err := crypto.GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	keys, _, peerExchangeKey, err := network.Handshake(conn, reader, crypto.SessionKeys{Identity: identity, Cipher: cipher}, isInitiator)
+	if err != nil {
+		return nil, err
+	}
+	return &client{conn: conn, reader: reader, keys: keys, peerExchangeKey: peerExchangeKey}, nil
+}
+
+// send sends messages of size bytes at rate per second until stop, each
+// starting with the time it was sent.
+func (c *client) send(res *results, msgType byte, size int, rate float64, stop time.Time) {
+	message := make([]byte, size)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if now.After(stop) {
+			return
+		}
+		binary.BigEndian.PutUint64(message, uint64(time.Now().UnixNano()))
+		if err := network.SendData(c.conn, c.keys, msgType, message); err != nil {
+			res.failures.Add(1)
+			return
+		}
+		res.sent.Add(1)
+	}
+}
+
+// receive reads the peer's messages until the connection closes, the way a
+// client does: each is decrypted and its signature checked.
+func (c *client) receive(res *results) {
+	buf := protocol.GetFrameBuffer()
+	defer protocol.PutFrameBuffer(buf)
+	var peer ed25519.PublicKey
+	for {
+		msgType, encrypted, err := protocol.ReadFrameInto(c.reader, buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				res.failures.Add(1)
+			}
+			return
+		}
+		decrypted, err := crypto.DecryptInPlace(encrypted, c.keys.ReceiveKey, c.keys.ReceiveAAD())
+		if err != nil {
+			res.failures.Add(1)
+			return
+		}
+		if msgType == protocol.TypeIdentity {
+			// The identity key signs its own envelope, so only its signature
+			// over the peer's exchange key is checked.
+			data, _, err := crypto.OpenEnvelope(nil, msgType, decrypted)
+			if err == nil {
+				peer, err = crypto.ParseIdentityPayload(data, c.peerExchangeKey)
+			}
+			if err != nil {
+				res.failures.Add(1)
+				return
+			}
+			continue
+		}
+		data, signature, err := crypto.OpenEnvelopeInPlace(peer, msgType, decrypted)
+		if err != nil || signature != crypto.SignatureValid || len(data) < timestampSize {
+			res.failures.Add(1)
+			return
+		}
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		res.arrived(time.Since(sent))
+	}
+}
+
+// report prints what the run delivered and how long it took.
+func report(res *results, size int, elapsed time.Duration) {
+	sent, received := res.sent.Load(), res.received.Load()
+	fmt.Printf("Sent:        %d messages (%.1f per second, %s per second)\n", sent, float64(sent)/elapsed.Seconds(), formatBytes(int64(float64(sent)*float64(size)/elapsed.Seconds())))
+	fmt.Printf("Received:    %d messages, %d lost, %d failures\n", received, sent-received, res.failures.Load())
+	if len(res.latencies) == 0 {
+		return
+	}
+	slices.Sort(res.latencies)
+	fmt.Printf("Latency:     p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(res.latencies, 50), percentile(res.latencies, 90), percentile(res.latencies, 99), res.latencies[len(res.latencies)-1])
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := min((len(sorted)*p+99)/100, len(sorted)) - 1
+	return sorted[max(i, 0)].Round(time.Microsecond)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(n)/1024/1024)
+	case n >= 1024:
+		return fmt.Sprintf("%.2f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// relayMemory is what the relay's metrics say about its memory.
+type relayMemory struct {
+	heap      int64
+	allocated int64
+	relayed   int64
+}
+
+// readRelayMemory scrapes the relay's metrics at addr.
+func readRelayMemory(addr string) (relayMemory, error) {
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		return relayMemory{}, fmt.Errorf("could not read the relay's metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return relayMemory{}, fmt.Errorf("could not read the relay's metrics: %s", resp.Status)
+	}
+
+	var mem relayMemory
+	found := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		var field *int64
+		switch name {
+		case "jot_relay_heap_bytes":
+			field = &mem.heap
+		case "jot_relay_allocated_bytes_total":
+			field = &mem.allocated
+		case "jot_relay_bytes_relayed_total":
+			field = &mem.relayed
+		default:
+			continue
+		}
+		if *field, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return relayMemory{}, fmt.Errorf("could not parse %s in the relay's metrics: %w", name, err)
+		}
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return relayMemory{}, fmt.Errorf("could not read the relay's metrics: %w", err)
+	}
+	if found < 3 {
+		return relayMemory{}, errors.New("the relay's metrics do not report its memory")
+	}
+	return mem, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// serveAdmin serves the admin API on addr to callers presenting token as a
+// bearer token:
+//
+//	GET    /reports        lists the abuse reports waiting for review, oldest first
+//	DELETE /reports/{id}   dismisses a report
+//	GET    /bans           lists the banned identity fingerprints
+//	PUT    /bans/{fp}      bans a fingerprint, with an optional {"reason": "..."} body
+//	DELETE /bans/{fp}      lifts a ban
+func (s *RelayServer) serveAdmin(addr, token string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /reports", s.listReports)
+	mux.HandleFunc("DELETE /reports/{id}", s.dismissReport)
+	mux.HandleFunc("GET /bans", s.listBans)
+	mux.HandleFunc("PUT /bans/{fingerprint}", s.addBan)
+	mux.HandleFunc("DELETE /bans/{fingerprint}", s.liftBan)
+
+	authorized := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	log.Printf("Serving the admin API on %s", addr)
+	if err := http.ListenAndServe(addr, authorized); err != nil {
+		log.Printf("Admin API stopped: %v", err)
+	}
+}
+
+// readAdminToken reads the admin API's bearer token from path.
+func readAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
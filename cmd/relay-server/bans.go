@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Bans turn identity keys away from the relay. A client that proves an identity
+// key with CREATE or JOIN is refused if its fingerprint is banned. Clients that
+// prove none are not affected, so a ban only holds for as long as the banned
+// user keeps the identity, typically that of a profile, peers know them by.
+
+// bansPurpose is authenticated with an encrypted ban file.
+const bansPurpose = "jot-relay-bans"
+
+// ban is an identity key the relay refuses.
+type ban struct {
+	Fingerprint string    `json:"fingerprint"`
+	Reason      string    `json:"reason,omitempty"`
+	Added       time.Time `json:"added"`
+}
+
+// banList holds the relay's bans, and keeps them in a file if it has one.
+type banList struct {
+	path string // Empty to keep bans in memory only
+	key  []byte // Encrypts the file; nil writes it in the clear
+
+	mu   sync.Mutex
+	bans map[string]ban // By fingerprint
+}
+
+// loadBans reads the bans kept in path. A file that does not exist yet holds
+// no bans; it is created with the first one. With a key, the file is kept
+// encrypted, and a file in the clear is encrypted right away.
+func loadBans(path string, key []byte) (*banList, error) {
+	l := &banList{path: path, key: key, bans: make(map[string]ban)}
+	if path == "" {
+		return l, nil
+	}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload reads the bans from the file again, replacing the current ones.
+func (l *banList) reload() error {
+	bans, sealed, err := readBans(l.path, l.key)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bans = bans
+	if l.key != nil && !sealed && len(bans) > 0 {
+		log.Printf("Encrypting the bans in %s.", l.path)
+		return l.save()
+	}
+	return nil
+}
+
+// readBans reads the bans kept in path, and reports whether the file was encrypted.
+func readBans(path string, key []byte) (map[string]ban, bool, error) {
+	bans := make(map[string]ban)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return bans, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	sealed := isSealed(data)
+	if sealed {
+		if data, err = unseal(key, data, bansPurpose); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var list []ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, b := range list {
+		if err := validFingerprint(b.Fingerprint); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		bans[b.Fingerprint] = b
+	}
+	return bans, sealed, nil
+}
+
+// validFingerprint checks that fingerprint looks like one of ours, so a typo
+// does not silently ban nobody.
+func validFingerprint(fingerprint string) error {
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != 16 {
+		return fmt.Errorf("invalid fingerprint %q: want 16 hex digits", fingerprint)
+	}
+	return nil
+}
+
+// banned reports whether fingerprint is banned.
+func (l *banList) banned(fingerprint string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.bans[fingerprint]
+	return ok
+}
+
+// list returns the bans, oldest first.
+func (l *banList) list() []ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sorted()
+}
+
+// sorted returns the bans, oldest first. The caller must hold l.mu.
+func (l *banList) sorted() []ban {
+	list := make([]ban, 0, len(l.bans))
+	for _, b := range l.bans {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Added.Before(list[j].Added) })
+	return list
+}
+
+// add bans fingerprint, or updates the reason of an existing ban.
+func (l *banList) add(fingerprint, reason string) error {
+	if err := validFingerprint(fingerprint); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.bans[fingerprint]
+	if !ok {
+		b = ban{Fingerprint: fingerprint, Added: time.Now().UTC()}
+	}
+	b.Reason = reason
+	l.bans[fingerprint] = b
+	return l.save()
+}
+
+// remove lifts the ban on fingerprint, and reports whether there was one.
+func (l *banList) remove(fingerprint string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.bans[fingerprint]; !ok {
+		return false, nil
+	}
+	delete(l.bans, fingerprint)
+	return true, l.save()
+}
+
+// save writes the bans to the file, replacing it in one step. The caller must
+// hold l.mu.
+func (l *banList) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if l.key != nil {
+		if data, err = seal(l.key, data, bansPurpose); err != nil {
+			return err
+		}
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// reloadOnHangup reads the ban file again whenever the relay receives SIGHUP,
+// so bans edited by hand take effect without a restart. A file that fails to
+// load leaves the current bans in place.
+func (l *banList) reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := l.reload(); err != nil {
+			log.Printf("Could not reload the bans: %v", err)
+			continue
+		}
+		log.Printf("Reloaded %d bans from %s.", len(l.list()), l.path)
+	}
+}
+
+// provenIdentity returns the fingerprint of the identity key a CREATE or JOIN
+// request proves, or "" if it proves none.
+func provenIdentity(msg ClientMessage) (string, error) {
+	if msg.CreateProof == nil {
+		return "", nil
+	}
+	switch msg.Command {
+	case "CREATE":
+		return msg.CreateProof.Verify(msg.SessionID, time.Now())
+	case "JOIN":
+		return msg.CreateProof.VerifyJoin(msg.SessionID, time.Now())
+	}
+	return "", nil
+}
+
+// listBans answers GET /bans.
+func (s *RelayServer) listBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.bans.list())
+}
+
+// addBan answers PUT /bans/{fingerprint}.
+func (s *RelayServer) addBan(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&body); err != nil {
+			http.Error(w, "invalid body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	fingerprint := r.PathValue("fingerprint")
+	if err := validFingerprint(fingerprint); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The ban holds even if it could not be saved, until the relay restarts.
+	if err := s.bans.add(fingerprint, body.Reason); err != nil {
+		log.Printf("Banned identity %s, but could not save the bans: %v", fingerprint, err)
+		http.Error(w, "banned, but could not save the bans: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Banned identity %s.", fingerprint)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// liftBan answers DELETE /bans/{fingerprint}.
+func (s *RelayServer) liftBan(w http.ResponseWriter, r *http.Request) {
+	fingerprint := r.PathValue("fingerprint")
+	found, err := s.bans.remove(fingerprint)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Lifted the ban on identity %s, but could not save the bans: %v", fingerprint, err)
+		http.Error(w, "lifted, but could not save the bans: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Lifted the ban on identity %s.", fingerprint)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// A client may leave a file on the relay for its peer to fetch later, rather
+// than sending it while both are online. The client encrypts the file with a
+// key that only travels inside the session, so the relay keeps bytes it cannot
+// read. Uploading takes UPLOAD and the notice key of a client of the session,
+// and the upload counts against that client's data budget. The relay answers
+// with a token, and whoever holds it may DOWNLOAD the file until it expires.
+// Files are kept in memory only, within the -blob-store budget.
+
+const (
+	maxBlobsPerSession = 8               // Files a session may keep on the relay at once
+	blobTokenLength    = 32              // Hex digits
+	uploadTimeout      = 5 * time.Minute // How long an upload may take once the relay is ready for it
+)
+
+// blob is a file kept for download.
+type blob struct {
+	data    []byte
+	session *Session // The session it was uploaded in
+}
+
+// blobStore holds the files clients uploaded.
+type blobStore struct {
+	mu         sync.Mutex
+	blobs      map[string]*blob // By token
+	size       int64            // Bytes held or reserved for uploads in progress
+	perSession map[*Session]int // Files held or being uploaded, by session
+}
+
+// reserve makes room for a file of size bytes from session, and reports why
+// it cannot if there is none.
+func (b *blobStore) reserve(session *Session, size, capacity int64) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.perSession[session] >= maxBlobsPerSession {
+		return "Too many files from this session"
+	}
+	if b.size+size > capacity {
+		return "The relay's file store is full"
+	}
+	if b.perSession == nil {
+		b.perSession = make(map[*Session]int)
+	}
+	b.perSession[session]++
+	b.size += size
+	return ""
+}
+
+// release gives back what reserve took.
+func (b *blobStore) release(session *Session, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size -= size
+	if b.perSession[session]--; b.perSession[session] <= 0 {
+		delete(b.perSession, session)
+	}
+}
+
+// add keeps data under token for ttl, in room reserve made for it.
+func (b *blobStore) add(token string, data []byte, session *Session, ttl time.Duration) {
+	b.mu.Lock()
+	if b.blobs == nil {
+		b.blobs = make(map[string]*blob)
+	}
+	b.blobs[token] = &blob{data: data, session: session}
+	b.mu.Unlock()
+	time.AfterFunc(ttl, func() {
+		b.mu.Lock()
+		delete(b.blobs, token)
+		b.mu.Unlock()
+		b.release(session, int64(len(data)))
+	})
+}
+
+// held returns how many files session keeps on the relay, or is uploading.
+func (b *blobStore) held(session *Session) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.perSession[session]
+}
+
+// get returns the file kept under token, or nil.
+func (b *blobStore) get(token string) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if blob := b.blobs[token]; blob != nil {
+		return blob.data
+	}
+	return nil
+}
+
+// uploadBlob receives a file of size bytes from the client of sessionID
+// holding noticeKey, and keeps it for download.
+func (s *RelayServer) uploadBlob(conn net.Conn, reader *bufio.Reader, sessionID, noticeKey string, size int64) {
+	defer conn.Close()
+	cfg := s.settings.Load()
+	if cfg.blobStore == 0 {
+		conn.Write([]byte("Error: This relay does not store files\n"))
+		return
+	}
+	if size <= 0 {
+		conn.Write([]byte("Error: File size is missing\n"))
+		return
+	}
+
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	slot := -1
+	if exists {
+		slot = session.noticeSlot(noticeKey)
+	}
+	observer := slot == 1 && session.observer
+	s.mu.Unlock()
+	if slot < 0 {
+		conn.Write([]byte("Error: Session not found or notice key is wrong\n"))
+		return
+	}
+	if observer {
+		conn.Write([]byte("Error: Observers cannot send files\n"))
+		return
+	}
+	session.mu.Lock()
+	remaining := cfg.maxDataRelayed - session.sent[slot]
+	session.mu.Unlock()
+	if size > remaining {
+		conn.Write([]byte("Error: File exceeds what is left of your data budget\n"))
+		return
+	}
+	if reason := s.blobs.reserve(session, size, cfg.blobStore); reason != "" {
+		conn.Write([]byte("Error: " + reason + "\n"))
+		return
+	}
+
+	conn.Write([]byte("Upload: ready\n"))
+	data := make([]byte, size)
+	conn.SetReadDeadline(time.Now().Add(uploadTimeout))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		s.blobs.release(session, size)
+		log.Printf("An upload to session '%s' failed: %v", sessionID, err)
+		return
+	}
+	s.quotaTracker(session, slot, cfg.maxDataRelayed)(size)
+
+	token := generateShortID(blobTokenLength)
+	expires := time.Now().Add(cfg.blobTTL)
+	s.blobs.add(token, data, session, cfg.blobTTL)
+	log.Printf("Keeping a %d byte file from session '%s' until %s.", size, sessionID, expires.UTC().Format(time.RFC3339))
+	conn.Write([]byte(fmt.Sprintf("Uploaded: %s %d\n", token, expires.Unix())))
+	s.warnLimit(session, slot, "files", int64(s.blobs.held(session)), maxBlobsPerSession)
+}
+
+// downloadBlob sends the file kept under token.
+func (s *RelayServer) downloadBlob(conn net.Conn, token string) {
+	defer conn.Close()
+	data := s.blobs.get(token)
+	if data == nil {
+		conn.Write([]byte("Error: File not found or expired\n"))
+		return
+	}
+	conn.Write([]byte(fmt.Sprintf("Download: %d\n", len(data))))
+	conn.Write(data)
+}
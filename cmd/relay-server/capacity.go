@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// A relay that runs out of goroutines, file descriptors or memory fails in
+// ways nobody can predict, taking sessions that are already open down with it.
+// With -max-goroutines, -max-fds and -max-session-memory it refuses new work
+// once it reaches any of them, with an error that names the resource, while
+// everything already open carries on. The same figures are served at /metrics
+// and /healthz.
+
+const (
+	// connMemory estimates what one client connection costs the relay: the
+	// goroutine reading it, with its stack, and the buffers it reads into.
+	connMemory = 16 * 1024
+	// capacityRetryAfter is how long a client turned away for lack of
+	// capacity is told to wait before it tries again, in seconds.
+	capacityRetryAfter = 30
+)
+
+// capacityRefusals counts work refused for each resource, by name.
+var capacityRefusals = map[string]*int64{
+	"goroutines": new(int64),
+	"fds":        new(int64),
+	"memory":     new(int64),
+}
+
+// startsWork reports whether command takes on new work that a relay short of
+// capacity should refuse. Commands for sessions that are already open, such as
+// RESUME and NOTICES, are let through, as are those that only read.
+func startsWork(command string) bool {
+	switch command {
+	case "CREATE", "JOIN", "FORWARD", "DATA", "UPLOAD":
+		return true
+	}
+	return false
+}
+
+// openFDs counts the file descriptors the relay has open, or returns -1 where
+// the system does not tell.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries) - 1 // Less the descriptor reading the directory
+}
+
+// sessionMemory estimates what session costs the relay: its connections and
+// the data held for clients that may resume. The caller must hold s.mu.
+func (s *RelayServer) sessionMemory(session *Session) int64 {
+	conns := len(session.pending) + 2*len(session.transfers)
+	for i := range session.Clients {
+		if session.Clients[i] != nil {
+			conns++
+		}
+		if session.notices[i] != nil {
+			conns++
+		}
+	}
+	if session.control != nil {
+		conns++
+	}
+	estimate := int64(conns) * connMemory
+	if session.resume != nil {
+		for _, l := range session.resume.legs {
+			l.mu.Lock()
+			estimate += int64(l.replay.Held())
+			l.mu.Unlock()
+		}
+	}
+	return estimate
+}
+
+// usage is what the relay has in use of the resources it watches.
+type usage struct {
+	Goroutines    int   `json:"goroutines"`
+	OpenFDs       int   `json:"openFDs"` // -1 where the system does not tell
+	Sessions      int   `json:"sessions"`
+	SessionMemory int64 `json:"sessionMemory"` // Estimated bytes, over all sessions
+}
+
+func (s *RelayServer) usage() usage {
+	s.mu.Lock()
+	u := usage{Sessions: len(s.sessions)}
+	for _, session := range s.sessions {
+		u.SessionMemory += s.sessionMemory(session)
+	}
+	s.mu.Unlock()
+	u.Goroutines = runtime.NumGoroutine()
+	u.OpenFDs = openFDs()
+	return u
+}
+
+// overCapacity returns the resource u has reached a limit of, with how much is
+// used and the limit, or nil if it has reached none.
+func (cfg *settings) overCapacity(u usage) *network.Capacity {
+	over := func(resource string, used, limit int64) *network.Capacity {
+		if limit <= 0 || used < limit {
+			return nil
+		}
+		return &network.Capacity{Resource: resource, Used: used, Limit: limit, RetryAfter: capacityRetryAfter}
+	}
+	if c := over("goroutines", int64(u.Goroutines), int64(cfg.maxGoroutines)); c != nil {
+		return c
+	}
+	if c := over("fds", int64(u.OpenFDs), int64(cfg.maxOpenFDs)); c != nil {
+		return c
+	}
+	return over("memory", u.SessionMemory, cfg.maxSessionMemory)
+}
+
+// refuseOverCapacity turns conn away if the relay has reached one of its
+// limits, and reports whether it did.
+func (s *RelayServer) refuseOverCapacity(conn net.Conn, command string) bool {
+	cfg := s.settings.Load()
+	if cfg.maxGoroutines == 0 && cfg.maxOpenFDs == 0 && cfg.maxSessionMemory == 0 {
+		return false
+	}
+	capacity := cfg.overCapacity(s.usage())
+	if capacity == nil {
+		return false
+	}
+	atomic.AddInt64(capacityRefusals[capacity.Resource], 1)
+	log.Printf("Refused %s: the relay is at capacity (%s %d of %d).", command, capacity.Resource, capacity.Used, capacity.Limit)
+	detail, _ := json.Marshal(capacity)
+	conn.Write([]byte(fmt.Sprintf("Error: Relay is at capacity %s\n", detail)))
+	conn.Close()
+	return true
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// A file transfer may run over data connections of its own, so its chunks do
+// not queue up behind each other and the session's chat on one TCP stream.
+// The receiver opens its data connection first, with DATA and the offer's ID,
+// and the relay holds it until the sender opens the matching one. From then
+// on the relay copies what the sender writes to the receiver. Both prove
+// which end of the session they are with their notice keys, and what the
+// sender writes counts against its data budget like the rest of its traffic.
+
+const (
+	maxTransfersPerSession = 4                // Data connection pairs a session may hold at once
+	maxTransferID          = 64               // Bytes
+	transferTimeout        = 30 * time.Second // How long the receiver's data connection waits for the sender's
+)
+
+// transfer is a pair of data connections for one file transfer.
+type transfer struct {
+	receiver int      // Slot of the client receiving the file, which opened first
+	recv     net.Conn // The receiver's data connection
+	send     net.Conn // The sender's data connection; nil until it opens
+	timer    *time.Timer
+}
+
+// close closes the transfer's connections. The caller must hold s.mu.
+func (t *transfer) close() {
+	t.timer.Stop()
+	t.recv.Close()
+	if t.send != nil {
+		t.send.Close()
+	}
+}
+
+// openTransfer makes conn a data connection for transfer id of sessionID. The
+// first connection for id waits for the second, which must come from the
+// other client of the session.
+func (s *RelayServer) openTransfer(conn net.Conn, sessionID, noticeKey, id string) {
+	refuse := func(reason string) {
+		conn.Write([]byte("Error: " + reason + "\n"))
+		conn.Close()
+	}
+	if id == "" || len(id) > maxTransferID {
+		refuse("Transfer ID is missing or too long")
+		return
+	}
+
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	slot := -1
+	if exists && session.Clients[1] != nil {
+		slot = session.noticeSlot(noticeKey)
+	}
+	if slot < 0 {
+		s.mu.Unlock()
+		refuse("Session not found or notice key is wrong")
+		return
+	}
+
+	t, ok := session.transfers[id]
+	// The first connection is the receiver's. An observer may receive files
+	// but not send them.
+	if session.observer && (!ok && slot == 0 || ok && slot == 1) {
+		s.mu.Unlock()
+		refuse("Observers cannot send files")
+		return
+	}
+	if !ok {
+		if len(session.transfers) >= maxTransfersPerSession {
+			s.mu.Unlock()
+			refuse("Too many transfers in this session")
+			return
+		}
+		t = &transfer{receiver: slot, recv: conn}
+		t.timer = time.AfterFunc(transferTimeout, func() { s.expireTransfer(session, id, t) })
+		if session.transfers == nil {
+			session.transfers = make(map[string]*transfer)
+		}
+		session.transfers[id] = t
+		s.mu.Unlock()
+		conn.Write([]byte("Data: waiting\n"))
+		return
+	}
+	if t.send != nil || t.receiver == slot {
+		s.mu.Unlock()
+		refuse("Transfer is already connected")
+		return
+	}
+	t.timer.Stop()
+	t.send = conn
+	s.mu.Unlock()
+
+	log.Printf("Data connections for a transfer in session '%s' are connected.", sessionID)
+	conn.Write([]byte("Data: connected\n"))
+
+	limit := s.settings.Load().maxDataRelayed
+	// File chunks count against the sender's byte rate, but not its message rate.
+	src := s.limitReads(session, 1-t.receiver, conn, nil)
+	s.pipe(src, t.recv, limit, s.quotaTracker(session, 1-t.receiver, limit), nil)
+
+	s.mu.Lock()
+	if session.transfers[id] == t {
+		delete(session.transfers, id)
+	}
+	s.mu.Unlock()
+}
+
+// expireTransfer drops the receiver's data connection for transfer id if the
+// sender has not opened its own in time.
+func (s *RelayServer) expireTransfer(session *Session, id string, t *transfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session.transfers[id] != t || t.send != nil {
+		return
+	}
+	delete(session.transfers, id)
+	t.recv.Close()
+	log.Printf("A data connection in session '%s' was closed; the sender never opened its end.", session.ID)
+}
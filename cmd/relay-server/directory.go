@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// maxListedRooms bounds how many sessions the public directory holds.
+const maxListedRooms = 500
+
+// publishSession lists sessionID in the public directory as listing, or takes
+// it off with a nil listing, for the session's owner.
+func (s *RelayServer) publishSession(conn net.Conn, sessionID, ownerKey string, listing *network.Listing) {
+	defer conn.Close()
+	if !s.settings.Load().directory {
+		conn.Write([]byte("Error: This relay has no public directory\n"))
+		return
+	}
+	if listing != nil {
+		switch {
+		case listing.Name == "" || !utf8.ValidString(listing.Name) || !utf8.ValidString(listing.Topic):
+			conn.Write([]byte("Error: A listing needs a name\n"))
+			return
+		case len(listing.Name) > network.MaxRoomName:
+			conn.Write([]byte(fmt.Sprintf("Error: Name is longer than %d bytes\n", network.MaxRoomName)))
+			return
+		case len(listing.Topic) > network.MaxRoomTopic:
+			conn.Write([]byte(fmt.Sprintf("Error: Topic is longer than %d bytes\n", network.MaxRoomTopic)))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.isOwner(ownerKey) {
+		log.Println("Refused a directory listing that does not come from the session owner.")
+		conn.Write([]byte("Error: Session not found or you are not its owner\n"))
+		return
+	}
+	if listing == nil {
+		session.listing = nil
+		log.Printf("Session '%s' was taken off the directory.", sessionID)
+		conn.Write([]byte(fmt.Sprintf("Unpublished: %s\n", s.qualify(sessionID))))
+		return
+	}
+	if session.inviteOnly {
+		conn.Write([]byte("Error: Sessions joined by invite cannot be listed\n"))
+		return
+	}
+	if session.listing == nil && s.listedRooms() >= maxListedRooms {
+		conn.Write([]byte("Error: The directory is full\n"))
+		return
+	}
+	session.listing = listing
+	log.Printf("Session '%s' was listed in the directory.", sessionID)
+	conn.Write([]byte(fmt.Sprintf("Published: %s\n", s.qualify(sessionID))))
+}
+
+// listedRooms counts the sessions in the directory. The caller must hold s.mu.
+func (s *RelayServer) listedRooms() int {
+	n := 0
+	for _, session := range s.sessions {
+		if session.listing != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// listRooms answers LIST with the sessions in the public directory, by name.
+func (s *RelayServer) listRooms(conn net.Conn) {
+	defer conn.Close()
+	if !s.settings.Load().directory {
+		conn.Write([]byte("Error: This relay has no public directory\n"))
+		return
+	}
+
+	s.mu.Lock()
+	rooms := []network.Room{}
+	for id, session := range s.sessions {
+		// Minting an invite makes the session ID useless for joining.
+		if session.listing == nil || session.inviteOnly {
+			continue
+		}
+		participants := 0
+		for _, client := range session.Clients {
+			if client != nil {
+				participants++
+			}
+		}
+		rooms = append(rooms, network.Room{SessionID: s.qualify(id), Name: session.listing.Name, Topic: session.listing.Topic, Participants: participants})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	list, _ := json.Marshal(rooms)
+	conn.Write(append(append([]byte("Rooms: "), list...), '\n'))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// activePipes counts the directions of sessions, forwards and federated joins being relayed.
+var activePipes int64
+
+// drainOnSignal lets the relay be replaced without kicking anyone. On SIGTERM
+// or an interrupt it stops accepting connections, so a new relay listening on
+// the same address with -reuse-port takes over, and exits once everything
+// being relayed has ended or timeout has passed. A second signal exits at once.
+func (s *RelayServer) drainOnSignal(timeout time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	s.mu.Lock()
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	// New clients reach the new relay, so nobody can join sessions that are
+	// still waiting for a peer here; their owners have to create them again.
+	for id, session := range s.sessions {
+		if session.Clients[1] != nil {
+			continue
+		}
+		session.Clients[0].Close()
+		if session.control != nil {
+			session.control.conn.Close()
+		}
+		if session.notices[0] != nil {
+			session.notices[0].conn.Close()
+		}
+		for _, req := range session.pending {
+			req.timer.Stop()
+			req.conn.Close()
+		}
+		delete(s.sessions, id)
+	}
+	log.Printf("Draining: no longer accepting connections; waiting for %d sessions to end.", len(s.sessions))
+	s.mu.Unlock()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt64(&activePipes) == 0 {
+				log.Println("Drained: all sessions have ended.")
+				os.Exit(0)
+			}
+		case <-deadline:
+			log.Println("Drain timeout reached; closing the remaining sessions.")
+			os.Exit(0)
+		case <-signals:
+			log.Println("Interrupted again; closing the remaining sessions.")
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A connection is half-open from when it is accepted until its first line,
+// the command, has arrived. Half-open connections cost the relay a goroutine
+// and buffers while telling it nothing, so an attacker could hold thousands of
+// them open by sending nothing, or a byte now and then. The relay bounds how
+// many may be half-open at once, in total and from each address, and how long
+// each may take: the first byte, which for TLS includes the handshake, must
+// arrive within handshakeFirstByte, every further buffer of the command
+// within handshakeProgress, and the whole command within handshakeTimeout.
+// WebSocket connections take their place as soon as they are accepted, so
+// that their HTTP upgrade counts as well.
+
+const (
+	handshakeFirstByte = 10 * time.Second
+	handshakeProgress  = 5 * time.Second
+	handshakeTimeout   = 30 * time.Second
+	// maxCommandSize bounds the command line, which is a few hundred bytes
+	// even with an abuse report.
+	maxCommandSize = 64 * 1024
+)
+
+var errCommandTooLong = errors.New("command line too long")
+
+// handshakeRefusals counts connections refused for having too many others
+// half-open, in total and from the same address.
+var handshakeRefusals = map[string]*int64{
+	"total":   new(int64),
+	"address": new(int64),
+}
+
+// handshakes tracks the connections whose command has not arrived yet.
+type handshakes struct {
+	mu     sync.Mutex
+	total  int
+	byAddr map[string]int
+}
+
+// open counts the connections still handshaking.
+func (h *handshakes) open() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// startHandshake takes a place for conn among the half-open connections, and
+// returns the function that gives it back. Without a place, conn is told why
+// and closed, and ok is false.
+func (s *RelayServer) startHandshake(conn net.Conn) (release func(), ok bool) {
+	if ws, isWS := conn.(*wsConn); isWS {
+		if counted, isCounted := ws.Conn.(*handshakeConn); isCounted {
+			return counted.done, true
+		}
+	}
+	cfg := s.settings.Load()
+	ip := remoteIP(conn)
+	h := &s.handshakes
+	h.mu.Lock()
+	refused := ""
+	switch {
+	case cfg.maxHandshakes > 0 && h.total >= cfg.maxHandshakes:
+		refused = "total"
+	case cfg.maxHandshakesPerIP > 0 && h.byAddr[ip] >= cfg.maxHandshakesPerIP:
+		refused = "address"
+	}
+	if refused == "" {
+		if h.byAddr == nil {
+			h.byAddr = make(map[string]int)
+		}
+		h.total++
+		h.byAddr[ip]++
+	}
+	h.mu.Unlock()
+
+	if refused != "" {
+		atomic.AddInt64(handshakeRefusals[refused], 1)
+		if refused == "address" {
+			log.Println("Refused a connection from an address with too many others still handshaking.")
+		} else {
+			log.Println("Refused a connection: too many others are still handshaking.")
+		}
+		// A TLS client has not even said hello, so the error may never reach it.
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("Error: Too many connections are opening; try again shortly\n"))
+		conn.Close()
+		return nil, false
+	}
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.total--
+		if h.byAddr[ip]--; h.byAddr[ip] <= 0 {
+			delete(h.byAddr, ip)
+		}
+	}, true
+}
+
+// handshakeListener takes a place among the half-open connections for every
+// connection it accepts, before anything is read from it. It sits under the
+// WebSocket listener, whose connections first go through an HTTP upgrade.
+type handshakeListener struct {
+	net.Listener
+	s *RelayServer
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if release, ok := l.s.startHandshake(conn); ok {
+			return &handshakeConn{Conn: conn, release: release}, nil
+		}
+	}
+}
+
+// handshakeConn is a connection that holds a place among the half-open
+// connections from when it was accepted until its command is read, or it
+// closes before that.
+type handshakeConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// done gives the connection's place back. Only the first call does anything.
+func (c *handshakeConn) done() {
+	c.once.Do(c.release)
+}
+
+func (c *handshakeConn) Close() error {
+	c.done()
+	return c.Conn.Close()
+}
+
+// readCommand reads the command line a client starts with, within the
+// handshake deadlines, and clears the deadline again for the connection
+// that follows.
+func readCommand(conn net.Conn, reader *bufio.Reader) ([]byte, error) {
+	started := time.Now()
+	if err := conn.SetReadDeadline(started.Add(handshakeFirstByte)); err != nil {
+		return nil, err
+	}
+	if _, err := reader.Peek(1); err != nil {
+		return nil, err
+	}
+
+	var line []byte
+	for {
+		deadline := time.Now().Add(handshakeProgress)
+		if end := started.Add(handshakeTimeout); deadline.After(end) {
+			deadline = end
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxCommandSize {
+			return nil, errCommandTooLong
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	return line, conn.SetReadDeadline(time.Time{})
+}
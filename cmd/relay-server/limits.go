@@ -0,0 +1,85 @@
+package main
+
+// The relay warns clients before it enforces a limit on them, so they can
+// adapt rather than be cut off: once a client has used limitWarnPercents of a
+// limit, and once it has reached it. The data budget is reported with "quota"
+// events, which older clients understand; every other limit with a "limit"
+// event naming it as the resource, along with how much of it is used.
+//
+// Resources reported with "limit" events:
+//
+//   - "waiting-room": joiners waiting for the owner, out of maxPendingJoiners;
+//     sent to the owner.
+//   - "invites": unused invites, out of maxInvitesPerSession; sent to the owner.
+//   - "resume-buffer": bytes held for an absent peer, out of -resume-buffer;
+//     sent to the client whose data is held. The session ends when it
+//     overflows.
+//   - "files": files the session keeps on the relay, out of
+//     maxBlobsPerSession; sent to the client that uploaded the latest.
+
+// limitWarnPercents are the shares of a limit at which its clients are warned.
+var limitWarnPercents = [...]int64{80, 95}
+
+// limitReachedLevel is the level of a limit that has been reached.
+const limitReachedLevel = len(limitWarnPercents) + 1
+
+// limitLevel tells how far used is through limit: 0 before the first warning,
+// one more for each warning passed, and limitReachedLevel once used reaches
+// limit.
+func limitLevel(used, limit int64) int {
+	if limit <= 0 {
+		return 0
+	}
+	if used >= limit {
+		return limitReachedLevel
+	}
+	level := 0
+	for _, percent := range limitWarnPercents {
+		if used*100 >= limit*percent {
+			level++
+		}
+	}
+	return level
+}
+
+// limitKey names a limit one client of a session is warned about. Limits that
+// concern the owner, whoever that is, use slot 0.
+type limitKey struct {
+	resource string
+	slot     int
+}
+
+// limitWarning records that the client in slot uses used of the limit on
+// resource, and returns the "limit" event to warn it with if that takes it to
+// a level it was not warned about yet. Once usage drops, the levels it fell
+// below are warned about again.
+func (session *Session) limitWarning(resource string, slot int, used, limit int64) (controlEvent, bool) {
+	level := limitLevel(used, limit)
+	key := limitKey{resource, slot}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if level <= session.limits[key] {
+		if level < session.limits[key] {
+			session.limits[key] = level
+		}
+		return controlEvent{}, false
+	}
+	if session.limits == nil {
+		session.limits = make(map[limitKey]int)
+	}
+	session.limits[key] = level
+	return controlEvent{Event: "limit", Resource: resource, Used: used, Limit: limit}, true
+}
+
+// warnLimit sends the client in slot the warning limitWarning returns, if
+// any, over its NOTICES connection.
+func (s *RelayServer) warnLimit(session *Session, slot int, resource string, used, limit int64) {
+	event, warn := session.limitWarning(resource, slot, used, limit)
+	if !warn {
+		return
+	}
+	s.mu.Lock()
+	notices := session.notices[slot]
+	s.mu.Unlock()
+	notices.send(event)
+}
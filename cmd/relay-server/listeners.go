@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultAddr is where the relay listens when no -addr is given.
+const defaultAddr = ":8080"
+
+// listenSpec describes one address the relay listens on.
+type listenSpec struct {
+	network   string      // "tcp", "tcp4" or "tcp6"
+	addr      string      // host:port
+	tls       *tls.Config // nil serves plain TCP
+	websocket bool        // Clients connect with WebSocket, over TLS if tls is set
+}
+
+func (l listenSpec) String() string {
+	kind := "TCP"
+	if l.tls != nil {
+		kind = "TLS"
+	}
+	if l.websocket {
+		kind = "WebSocket"
+		if l.tls != nil {
+			kind += " over TLS"
+		}
+	}
+	if l.network != "tcp" {
+		kind += ", IPv" + strings.TrimPrefix(l.network, "tcp") + " only"
+	}
+	return fmt.Sprintf("%s (%s)", l.addr, kind)
+}
+
+// addrList collects the values of the repeatable -addr flag.
+type addrList []string
+
+func (a *addrList) String() string { return strings.Join(*a, ", ") }
+
+func (a *addrList) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+// tlsDefaults are the -tls-cert, -tls-key and -client-ca flags, which apply to
+// every TLS listener that does not override them, and the TLS policy, which
+// applies to all of them.
+type tlsDefaults struct {
+	certFile, keyFile, clientCAFile string
+	config                          *tls.Config // Loaded from the three files; nil without -tls-cert
+	policy                          tlsPolicy
+}
+
+// parseListenSpec parses an -addr value of the form [scheme://]host:port[?options].
+// The scheme is tcp (the default), tls, ws or wss, optionally followed by 4 or
+// 6 to listen on only IPv4 or IPv6. TLS and wss listeners accept the options
+// cert, key and client-ca, which override the matching flags for that
+// listener alone.
+func parseListenSpec(value string, defaults tlsDefaults) (listenSpec, error) {
+	raw := value
+	if !strings.Contains(raw, "://") {
+		raw = "tcp://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return listenSpec{}, fmt.Errorf("invalid address %q: %w", value, err)
+	}
+	if u.Host == "" || u.Port() == "" || u.Path != "" {
+		return listenSpec{}, fmt.Errorf("invalid address %q: expected [scheme://]host:port", value)
+	}
+
+	spec := listenSpec{addr: u.Host}
+	useTLS := false
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		spec.network = u.Scheme
+	case "tls", "tls4", "tls6":
+		spec.network = "tcp" + strings.TrimPrefix(u.Scheme, "tls")
+		useTLS = true
+	case "ws", "ws4", "ws6":
+		spec.network = "tcp" + strings.TrimPrefix(u.Scheme, "ws")
+		spec.websocket = true
+	case "wss", "wss4", "wss6":
+		spec.network = "tcp" + strings.TrimPrefix(u.Scheme, "wss")
+		spec.websocket = true
+		useTLS = true
+	default:
+		return listenSpec{}, fmt.Errorf("unknown scheme %q in %q; use tcp, tls, ws or wss, optionally followed by 4 or 6", u.Scheme, value)
+	}
+
+	options := u.Query()
+	certFile, keyFile, clientCAFile := defaults.certFile, defaults.keyFile, defaults.clientCAFile
+	for name := range options {
+		if !useTLS {
+			return listenSpec{}, fmt.Errorf("option %q in %q only applies to tls and wss listeners", name, value)
+		}
+		switch name {
+		case "cert":
+			certFile = options.Get(name)
+		case "key":
+			keyFile = options.Get(name)
+		case "client-ca":
+			clientCAFile = options.Get(name)
+		default:
+			return listenSpec{}, fmt.Errorf("unknown option %q in %q; use cert, key or client-ca", name, value)
+		}
+	}
+	if !useTLS {
+		return spec, nil
+	}
+
+	if len(options) == 0 {
+		spec.tls = defaults.config
+	} else if spec.tls, err = loadTLSConfig(certFile, keyFile, clientCAFile, defaults.policy); err != nil {
+		return listenSpec{}, fmt.Errorf("%s: %w", value, err)
+	}
+	if spec.tls == nil {
+		return listenSpec{}, fmt.Errorf("%s needs a certificate: set -tls-cert and -tls-key, or cert and key options", value)
+	}
+	return spec, nil
+}
+
+// listenSpecs turns the -addr values into listeners. Without any, the relay
+// listens on defaultAddr, serving TLS if -tls-cert is set. wsAddr, from
+// -ws-addr, adds a WebSocket listener alongside them, over TLS if -tls-cert is
+// set.
+func listenSpecs(values []string, wsAddr string, defaults tlsDefaults) ([]listenSpec, error) {
+	var specs []listenSpec
+	if len(values) == 0 {
+		specs = append(specs, listenSpec{network: "tcp", addr: defaultAddr, tls: defaults.config})
+	}
+	if wsAddr != "" {
+		scheme := "ws://"
+		if defaults.config != nil {
+			scheme = "wss://"
+		}
+		spec, err := parseListenSpec(scheme+wsAddr, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("-ws-addr: %w", err)
+		}
+		specs = append(specs, spec)
+	}
+	for _, value := range values {
+		spec, err := parseListenSpec(value, defaults)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// logFile is the relay's log when written with -log-file. It starts a new file
+// once the current one grows past maxSize bytes or gets older than maxAge,
+// renaming the old ones to path.1, path.2 and so on, newest first, and keeps
+// at most keep of them. A zero maxSize or maxAge disables that limit.
+type logFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	keep    int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time // When we started writing the current file; a log left by an earlier run counts from then too
+}
+
+// openLogFile opens path for appending, creating it if needed.
+func openLogFile(path string, maxSize int64, maxAge time.Duration, keep int) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size, l.started = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p, which the log package hands over one entry at a time, to
+// the log, first starting a new file if the current one is full or too old.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && (l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize || l.maxAge > 0 && time.Since(l.started) > l.maxAge) {
+		if err := l.rotate(); err != nil {
+			// Keep logging to the file we have rather than losing entries.
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", l.path, err)
+			l.started = time.Now()
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one.
+func (l *logFile) rotate() error {
+	l.file.Close()
+	if err := l.moveAside(); err != nil {
+		if openErr := l.open(); openErr != nil {
+			return fmt.Errorf("%v, and reopening the log failed: %w", err, openErr)
+		}
+		return err
+	}
+	return l.open()
+}
+
+// moveAside shifts the rotated files along, dropping the oldest, and makes
+// the current file the newest of them.
+func (l *logFile) moveAside() error {
+	if l.keep == 0 {
+		return os.Remove(l.path)
+	}
+	os.Remove(l.rotated(l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+	return os.Rename(l.path, l.rotated(1))
+}
+
+// rotated is the name of the i-th newest rotated file.
+func (l *logFile) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
@@ -2,18 +2,25 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/bjarneo/jot/internal/network"
 	"github.com/google/uuid"
 )
 
@@ -29,40 +36,143 @@ func generateShortID(length int) string {
 	return hex.EncodeToString(bytes)
 }
 
+// maxInvitesPerSession bounds the number of unused invite tokens a session may hold.
+const maxInvitesPerSession = 10
+
 // Session represents a chat session with two connected clients.
 type Session struct {
 	ID      string
 	Clients [2]net.Conn
-	mu      sync.Mutex
+
+	mu       sync.Mutex       // Guards sent, quota, lastPing, limits and rates, which the connections of both clients update
+	sent     [2]int64         // Bytes each client has sent through the relay, over all its connections
+	quota    [2]int           // How far each client is through its data budget, as a limitLevel
+	lastPing [2]time.Time     // When each client last pinged us on its NOTICES connection
+	limits   map[limitKey]int // How far clients are through other limits they were warned about
+	rates    [2]*clientRate   // How fast each client may send; nil while the relay limits no rates
+
+	ownerKey         string   // Secret chosen by the creator; authorizes owner-only requests such as INVITE
+	ownerFingerprint string   // Identity fingerprint the creator proved with CREATE, if any
+	reserved         bool     // The session's name is held for ownerFingerprint after it ends
+	invites          []string // Unused invite tokens for this session
+	inviteOnly       bool     // Set once an invite is minted; the raw session ID can no longer be used to join
+
+	waitingRoom bool                    // Joining clients wait for the owner's approval
+	locked      bool                    // Joining clients must knock and be admitted by the owner
+	pending     map[string]*joinRequest // Parked joiners by request ID
+	control     *controlConn            // The owner's WATCH connection, if any
+	bannedKeys  map[string]bool         // Identity fingerprints the owner banned with BAN
+	bannedIPs   map[string]bool         // IP addresses the owner banned with BAN
+
+	observer   bool            // The joining client came in as an observer, so it may not send files
+	noticeKeys [2]string       // Secrets with which each client may subscribe to notices and resume
+	notices    [2]*controlConn // Each client's NOTICES connection, if any
+
+	resume *resumable // Set once both clients are in, if they may resume after losing their connection
+
+	reports [2]int // Reports each client has filed
+
+	listing *network.Listing // How the session is listed in the public directory; nil if it is not
+
+	transfers map[string]*transfer // Data connections for file transfers, by offer ID
+}
+
+// isOwner reports whether ownerKey is the key the session was created with.
+func (session *Session) isOwner(ownerKey string) bool {
+	return session.ownerKey != "" && subtle.ConstantTimeCompare([]byte(session.ownerKey), []byte(ownerKey)) == 1
 }
 
 // RelayServer holds the state of the relay server.
 type RelayServer struct {
-	sessions       map[string]*Session
-	mu             sync.Mutex
-	maxDataRelayed int64
+	sessions map[string]*Session
+	invites  map[string]string // Single-use invite token to session ID
+	mu       sync.Mutex
+	settings atomic.Pointer[settings] // Replaced as a whole when the settings are reloaded
+
+	// publicAddr is the address clients and other relays use to reach this relay.
+	// When set, session IDs are handed out as "id@publicAddr" so they can be joined
+	// through any relay that federates with this one.
+	publicAddr string
+
+	reusePort bool           // Listen with SO_REUSEPORT, so a new relay can take over the address
+	listeners []net.Listener // Closed when the relay drains
+
+	reservations map[string]*reservation // Held session names
+
+	handshakes handshakes // Connections whose command has not arrived yet
+
+	reports []*abuseReport // Waiting for an operator to review them, oldest first
+	bans    *banList       // Identity keys the relay turns away
+	blobs   blobStore      // Files clients left for their peers to fetch
 }
 
 // NewRelayServer creates a new RelayServer instance.
-func NewRelayServer(maxDataRelayed int64) *RelayServer {
-	return &RelayServer{
-		sessions:       make(map[string]*Session),
-		maxDataRelayed: maxDataRelayed,
+func NewRelayServer(publicAddr string, cfg *settings) *RelayServer {
+	s := &RelayServer{
+		sessions:     make(map[string]*Session),
+		invites:      make(map[string]string),
+		reservations: make(map[string]*reservation),
+		publicAddr:   publicAddr,
+		bans:         &banList{bans: make(map[string]ban)},
 	}
+	s.settings.Store(cfg)
+	return s
 }
 
-// Start listens for incoming connections and handles them.
-func (s *RelayServer) Start(addr string) {
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatalf("Failed to listen: %v", err)
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
+
+// Start listens on every address in listeners and handles incoming connections.
+// All addresses are bound before any connection is accepted. It returns once a
+// drain has closed every listener.
+func (s *RelayServer) Start(listeners []listenSpec) {
+	var lc net.ListenConfig
+	if s.reusePort {
+		lc.Control = reusePort
+	}
+	opened := make([]net.Listener, 0, len(listeners))
+	for _, spec := range listeners {
+		listener, err := lc.Listen(context.Background(), spec.network, spec.addr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", spec.addr, err)
+		}
+		if spec.tls != nil {
+			listener = tls.NewListener(listener, spec.tls)
+		}
+		if spec.websocket {
+			listener = listenWebSocket(&handshakeListener{Listener: listener, s: s})
+		}
+		opened = append(opened, listener)
+		log.Printf("Relay server listening on %s", spec)
 	}
-	defer listener.Close()
+	s.mu.Lock()
+	s.listeners = append(s.listeners, opened...)
+	s.mu.Unlock()
 
-	log.Printf("Relay server listening on %s", addr)
+	var wg sync.WaitGroup
+	for _, listener := range opened {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			defer listener.Close()
+			s.accept(listener)
+		}(listener)
+	}
+	wg.Wait()
+}
 
+// accept hands each connection on listener to handleConnection until the listener is closed.
+func (s *RelayServer) accept(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			log.Printf("Failed to accept connection: %v", err)
 			continue
@@ -73,39 +183,161 @@ func (s *RelayServer) Start(addr string) {
 
 // ClientMessage represents the initial message from a client.
 type ClientMessage struct {
-	Command   string `json:"command"` // "CREATE" or "JOIN"
+	Command   string `json:"command"` // "CREATE", "JOIN", "INVITE", "TRANSFER", "WATCH", "NOTICES", "RESUME", "REPORT", "PUBLISH", "DATA", "UPLOAD", "DOWNLOAD", "LIST" or "FORWARD"
 	SessionID string `json:"sessionID,omitempty"`
+	Target    string `json:"target,omitempty"`    // Next relay for "FORWARD"
+	OwnerKey  string `json:"ownerKey,omitempty"`  // Set with "CREATE", and proves ownership for "INVITE", "TRANSFER", "WATCH" and "PUBLISH"
+	NoticeKey string `json:"noticeKey,omitempty"` // Set with "CREATE" or "JOIN", and proves who asks for "NOTICES", "RESUME", "REPORT", "TRANSFER", "DATA" or "UPLOAD"
+	Received  int64  `json:"received,omitempty"`  // With "RESUME", how many bytes the client had read from the relay
+	Presence  bool   `json:"presence,omitempty"`  // With "RESUME", asks whether the peer is still connected
+	Observe   bool   `json:"observe,omitempty"`   // With "JOIN", join as an observer, who may not send files
+
+	// Transfer, with "DATA", names the file transfer the connection is for.
+	Transfer string `json:"transfer,omitempty"`
+
+	// Size, with "UPLOAD", is the length of the file that follows; Blob, with
+	// "DOWNLOAD", is the token the upload was answered with.
+	Size int64  `json:"size,omitempty"`
+	Blob string `json:"blob,omitempty"`
+
+	// Report, with "REPORT", is what the client reports about its peer.
+	Report *network.Report `json:"report,omitempty"`
+
+	// Listing, with "PUBLISH", lists the session in the public directory; nil takes it off.
+	Listing *network.Listing `json:"listing,omitempty"`
+
+	// WaitingRoom, with "CREATE", parks joining clients until the owner admits them.
+	WaitingRoom bool `json:"waitingRoom,omitempty"`
+
+	// CreateProof, with "CREATE", names the identity key asking for SessionID.
+	*network.CreateProof
 }
 
 // handleConnection handles a new client connection.
 func (s *RelayServer) handleConnection(conn net.Conn) {
 	log.Println("New anonymous connection received.")
 
-	// Set a deadline for reading the initial message to prevent Slowloris attacks.
-	if err := conn.SetReadDeadline(time.Now().Add(30 * time.Second)); err != nil {
-		log.Println("Could not set read deadline for new connection.")
-		conn.Close()
+	// Until its command arrives, the connection is held to the handshake
+	// limits, to prevent Slowloris attacks.
+	release, ok := s.startHandshake(conn)
+	if !ok {
 		return
 	}
-
 	reader := bufio.NewReader(conn)
-	messageBytes, err := reader.ReadBytes('\n')
+	messageBytes, err := readCommand(conn, reader)
+	release()
 	if err != nil {
-		log.Println("Error reading initial message from new connection.")
+		log.Printf("Error reading initial message from new connection: %v", err)
 		conn.Close()
 		return
 	}
 
-	// Reset the deadline to allow for long-lived connections.
-	if err := conn.SetReadDeadline(time.Time{}); err != nil {
-		log.Println("Could not reset read deadline for connection.")
+	var clientMsg ClientMessage
+	if err := json.Unmarshal(messageBytes, &clientMsg); err != nil {
+		log.Println("Error unmarshaling initial message from connection.")
 		conn.Close()
 		return
 	}
 
-	var clientMsg ClientMessage
-	if err := json.Unmarshal(messageBytes, &clientMsg); err != nil {
-		log.Println("Error unmarshaling initial message from connection.")
+	if startsWork(clientMsg.Command) && s.refuseOverCapacity(conn, clientMsg.Command) {
+		return
+	}
+
+	if clientMsg.Command == "FORWARD" {
+		s.forwardConnection(conn, reader, clientMsg.Target)
+		return
+	}
+	if clientMsg.Command == "LIST" {
+		s.listRooms(conn)
+		return
+	}
+	if clientMsg.Command == "DOWNLOAD" {
+		s.downloadBlob(conn, clientMsg.Blob)
+		return
+	}
+
+	// Clients may prove their identity key with CREATE and JOIN; banned keys
+	// are turned away before they get any further.
+	fingerprint, err := provenIdentity(clientMsg)
+	if err != nil {
+		log.Printf("Ignoring the identity proof for session '%s': %v", clientMsg.SessionID, err)
+	}
+	if fingerprint != "" && s.bans.banned(fingerprint) {
+		log.Printf("Refused %s from banned identity %s.", clientMsg.Command, fingerprint)
+		conn.Write([]byte("Error: Your identity is banned from this relay\n"))
+		conn.Close()
+		return
+	}
+
+	if s.requiresWork(clientMsg.Command) && !s.checkWork(conn, reader) {
+		return
+	}
+
+	// A session ID of the form "id@relay" names the relay that hosts the session.
+	if id, home, ok := strings.Cut(clientMsg.SessionID, "@"); ok {
+		if clientMsg.Command == "CREATE" {
+			conn.Write([]byte("Error: Session ID may not contain '@'\n"))
+			conn.Close()
+			return
+		}
+		if home != s.publicAddr {
+			if clientMsg.Command != "JOIN" {
+				conn.Write([]byte("Error: Session is hosted on another relay\n"))
+				conn.Close()
+				return
+			}
+			s.joinFederated(conn, reader, id, home)
+			return
+		}
+		clientMsg.SessionID = id
+	}
+
+	if clientMsg.Command == "WATCH" {
+		s.watchSession(conn, reader, clientMsg.SessionID, clientMsg.OwnerKey)
+		return
+	}
+	if clientMsg.Command == "NOTICES" {
+		s.subscribeNotices(conn, reader, clientMsg.SessionID, clientMsg.NoticeKey)
+		return
+	}
+	if clientMsg.Command == "RESUME" {
+		s.resumeClient(conn, clientMsg.SessionID, clientMsg.NoticeKey, clientMsg.Received, clientMsg.Presence)
+		return
+	}
+	if clientMsg.Command == "REPORT" {
+		s.fileReport(conn, clientMsg.SessionID, clientMsg.NoticeKey, clientMsg.Report)
+		return
+	}
+	if clientMsg.Command == "PUBLISH" {
+		s.publishSession(conn, clientMsg.SessionID, clientMsg.OwnerKey, clientMsg.Listing)
+		return
+	}
+	if clientMsg.Command == "DATA" {
+		s.openTransfer(conn, clientMsg.SessionID, clientMsg.NoticeKey, clientMsg.Transfer)
+		return
+	}
+	if clientMsg.Command == "UPLOAD" {
+		s.uploadBlob(conn, reader, clientMsg.SessionID, clientMsg.NoticeKey, clientMsg.Size)
+		return
+	}
+	if clientMsg.Command == "INVITE" {
+		s.mu.Lock()
+		control, warning := s.mintInvite(conn, clientMsg.SessionID, clientMsg.OwnerKey)
+		s.mu.Unlock()
+		// The owner's control connection may be slow to take it, so the
+		// warning goes out without holding up the relay.
+		control.send(warning)
+		conn.Close()
+		return
+	}
+	if clientMsg.Command == "TRANSFER" {
+		s.mu.Lock()
+		previous, heir := s.transferOwnership(conn, clientMsg.SessionID, clientMsg.OwnerKey, clientMsg.NoticeKey)
+		s.mu.Unlock()
+		// As with the invite warning, neither client's notices connection
+		// may hold up the relay.
+		previous.send(controlEvent{Event: "owner"})
+		heir.send(controlEvent{Event: "owner", Owner: true})
 		conn.Close()
 		return
 	}
@@ -120,56 +352,80 @@ func (s *RelayServer) handleConnection(conn net.Conn) {
 
 	switch clientMsg.Command {
 	case "CREATE":
+		var reserved bool
 		if requestedSessionID != "" {
 			// User provided a session ID
-			_, exists = s.sessions[requestedSessionID]
+			exists = s.nameTaken(requestedSessionID, fingerprint)
 			if exists {
 				// Collision: prepend a short unique ID
 				log.Printf("Session ID '%s' already exists. Generating a new one.", requestedSessionID)
 				prefix := generateShortID(6) // Generate a 6-character hex prefix (3 bytes)
 				finalSessionID = prefix + "-" + requestedSessionID
 				// Check again for the highly unlikely case of collision with the new ID
-				_, exists = s.sessions[finalSessionID]
+				exists = s.nameTaken(finalSessionID, fingerprint)
 				for exists { // Keep generating until unique
 					prefix = generateShortID(6)
 					finalSessionID = prefix + "-" + requestedSessionID
-					_, exists = s.sessions[finalSessionID]
+					exists = s.nameTaken(finalSessionID, fingerprint)
 				}
 				log.Printf("Using modified session ID: '%s'", finalSessionID)
 			} else {
 				// User-provided ID is unique
 				finalSessionID = requestedSessionID
+				reserved = s.reserveName(finalSessionID, fingerprint)
 			}
 		} else {
 			// User did not provide a session ID, generate a new UUID
 			finalSessionID = uuid.New().String()
 		}
 
-		session = &Session{ID: finalSessionID}
+		session = &Session{ID: finalSessionID, ownerKey: clientMsg.OwnerKey, ownerFingerprint: fingerprint, reserved: reserved, waitingRoom: clientMsg.WaitingRoom && clientMsg.OwnerKey != ""}
+		session.noticeKeys[0] = clientMsg.NoticeKey
 		session.Clients[0] = conn
 		s.sessions[finalSessionID] = session
 		atomic.AddInt64(&totalSessions, 1)
 		log.Printf("New session created with ID '%s'. Total active sessions: %d", finalSessionID, len(s.sessions))
-		conn.Write([]byte(fmt.Sprintf("Session created: %s\n", finalSessionID)))
+		conn.Write([]byte(fmt.Sprintf("Session created: %s\n", s.qualify(finalSessionID))))
 
 	case "JOIN":
-		session, exists = s.sessions[requestedSessionID]
+		if sessionID, ok := s.invites[requestedSessionID]; ok {
+			// Invite tokens are single use, so the token is spent even if the join fails below.
+			delete(s.invites, requestedSessionID)
+			session, exists = s.sessions[sessionID]
+			requestedSessionID = sessionID
+		} else {
+			session, exists = s.sessions[requestedSessionID]
+			if exists && session.inviteOnly {
+				exists = false
+			}
+		}
 		if !exists || session.Clients[1] != nil {
 			log.Printf("Attempted to join session '%s' which does not exist or is full.", requestedSessionID)
 			conn.Write([]byte("Error: Session not found or full\n"))
 			conn.Close()
 			return
 		}
-		session.Clients[1] = conn
-		finalSessionID = requestedSessionID // For logging and consistency
-		log.Printf("Client joined session '%s'. Total active sessions: %d", finalSessionID, len(s.sessions))
-		conn.Write([]byte(fmt.Sprintf("Joined session: %s\n", finalSessionID)))
-
-		// Start relaying data between clients
-		go s.relayData(session.Clients[0], session.Clients[1], finalSessionID)
-		go s.relayData(session.Clients[1], session.Clients[0], finalSessionID)
+		if session.isBanned(fingerprint, conn) {
+			log.Printf("Refused a banned client from session '%s'.", session.ID)
+			conn.Write([]byte("Error: You are banned from this session\n"))
+			conn.Close()
+			return
+		}
+		if session.locked {
+			s.parkJoiner(conn, reader, session, clientMsg.NoticeKey, fingerprint, clientMsg.Observe, "Locked: The session is locked. Knock to ask the owner to let you in", knockTimeout)
+			return
+		}
+		// Observers ask the owner too, where the owner is watching. Elsewhere,
+		// as in a rejoined session, they come in like anyone else would.
+		if session.waitingRoom || clientMsg.Observe && session.control != nil {
+			s.parkJoiner(conn, reader, session, clientMsg.NoticeKey, fingerprint, clientMsg.Observe, "Waiting: The session owner must approve your request", 30*time.Second)
+			return
+		}
+		session.observer = clientMsg.Observe
+		s.completeJoin(session, conn, clientMsg.NoticeKey)
 
 	default:
+		atomic.AddInt64(&unknownCommands, 1)
 		log.Println("Received unknown command from a client.")
 		conn.Write([]byte("Error: Unknown command\n"))
 		conn.Close()
@@ -177,52 +433,475 @@ func (s *RelayServer) handleConnection(conn net.Conn) {
 	}
 }
 
-// relayData relays data from src to dst, closing the session on error or inactivity.
-func (s *RelayServer) relayData(src, dst net.Conn, sessionID string) {
+// completeJoin makes conn the second client of session and starts relaying.
+// The caller must hold s.mu.
+func (s *RelayServer) completeJoin(session *Session, conn net.Conn, noticeKey string) {
+	session.Clients[1] = conn
+	session.noticeKeys[1] = noticeKey
+	// The session is full now, so any other invites for it are useless.
+	for _, token := range session.invites {
+		delete(s.invites, token)
+	}
+	session.invites = nil
+	log.Printf("Client joined session '%s'. Total active sessions: %d", session.ID, len(s.sessions))
+	conn.Write([]byte(fmt.Sprintf("Joined session: %s\n", s.qualify(session.ID))))
+
+	// Start relaying data between clients
+	if cfg := s.settings.Load(); cfg.resumeGrace > 0 {
+		s.relayResumable(session, cfg.resumeGrace, cfg.resumeBuffer)
+		return
+	}
+	go s.relayData(session, 0)
+	go s.relayData(session, 1)
+}
+
+// announceObserver tells the client in slot 0, on its notices connection, that
+// its peer joined as an observer. The relay cannot read their messages to drop
+// the observer's posts, so the peer's client ignores them, on our word rather
+// than on what the observer says of itself.
+func (s *RelayServer) announceObserver(session *Session) {
+	s.mu.Lock()
+	observer, notices := session.observer, session.notices[0]
+	s.mu.Unlock()
+	if observer {
+		notices.send(controlEvent{Event: "observer"})
+	}
+}
+
+// mintInvite creates a single-use join token for sessionID and sends it to conn.
+// Only the session's creator, identified by the owner key it chose at CREATE, may
+// mint invites. From then on the session can only be joined with a token.
+// It returns the owner's control connection with a warning to send over it once
+// s.mu is released, or a nil connection if there is nothing to send. The caller
+// must hold s.mu.
+func (s *RelayServer) mintInvite(conn net.Conn, sessionID, ownerKey string) (*controlConn, controlEvent) {
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.isOwner(ownerKey) {
+		log.Println("Refused an invite request that does not come from the session owner.")
+		conn.Write([]byte("Error: Session not found or you are not its owner\n"))
+		return nil, controlEvent{}
+	}
+	if session.Clients[1] != nil {
+		conn.Write([]byte("Error: Session is already full\n"))
+		return nil, controlEvent{}
+	}
+	if len(session.invites) >= maxInvitesPerSession {
+		conn.Write([]byte(fmt.Sprintf("Error: Session already has %d unused invites\n", maxInvitesPerSession)))
+		return nil, controlEvent{}
+	}
+
+	token := generateShortID(32)
+	s.invites[token] = sessionID
+	session.invites = append(session.invites, token)
+	session.inviteOnly = true
+	log.Printf("Invite minted for session '%s'.", sessionID)
+	conn.Write([]byte(fmt.Sprintf("Invite: %s\n", s.qualify(token))))
+	if warning, warn := session.limitWarning("invites", 0, int64(len(session.invites)), maxInvitesPerSession); warn {
+		return session.control, warning
+	}
+	return nil, controlEvent{}
+}
+
+// transferOwnership makes the other client of sessionID its owner, at the
+// request of the owner, who proves with noticeKey which client it is. The heir
+// proves its ownership from then on with its own notice key, which the old
+// owner never learns, and both clients hear of the change on their notices
+// connections. The old owner's control connection is closed; the lock and
+// directory listing stay with the session. The caller must hold s.mu, and
+// tells the previous owner and the heir of the change on the notices
+// connections returned, which are nil when nothing changed.
+func (s *RelayServer) transferOwnership(conn net.Conn, sessionID, ownerKey, noticeKey string) (previous, heir *controlConn) {
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.isOwner(ownerKey) {
+		log.Println("Refused an ownership transfer that does not come from the session owner.")
+		conn.Write([]byte("Error: Session not found or you are not its owner\n"))
+		return nil, nil
+	}
+	slot := session.noticeSlot(noticeKey)
+	if session.Clients[1] == nil || slot < 0 {
+		conn.Write([]byte("Error: There is nobody to hand the session to\n"))
+		return nil, nil
+	}
+	if session.observer {
+		conn.Write([]byte("Error: An observer cannot own the session\n"))
+		return nil, nil
+	}
+	heirKey := session.noticeKeys[1-slot]
+	if heirKey == "" || session.notices[1-slot] == nil {
+		conn.Write([]byte("Error: Your peer's client cannot take over the session\n"))
+		return nil, nil
+	}
+
+	session.ownerKey = heirKey
+	if session.control != nil {
+		session.control.conn.Close()
+		session.control = nil
+	}
+	log.Printf("Ownership of session '%s' was handed to the other client.", sessionID)
+	conn.Write([]byte(fmt.Sprintf("Transferred: %s\n", s.qualify(sessionID))))
+	return session.notices[slot], session.notices[1-slot]
+}
+
+// forwardConnection connects conn to another relay and pipes bytes between them unmodified.
+// The client runs its TLS session with the target relay through this tunnel, so this relay
+// learns only the client's address and the next hop, while the target relay never sees the
+// client's address.
+func (s *RelayServer) forwardConnection(conn net.Conn, reader *bufio.Reader, target string) {
+	if !s.settings.Load().forwardTargets[target] {
+		log.Println("Refused to forward a connection to a target that is not allowed.")
+		conn.Write([]byte("Error: Forwarding to this relay is not allowed\n"))
+		conn.Close()
+		return
+	}
+
+	next, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil {
+		log.Printf("Could not connect to forward target %s: %v", target, err)
+		conn.Write([]byte("Error: Could not reach the next relay\n"))
+		conn.Close()
+		return
+	}
+
+	// Pass on anything the client sent after the command line.
+	if buffered, _ := reader.Peek(reader.Buffered()); len(buffered) > 0 {
+		if _, err := next.Write(buffered); err != nil {
+			conn.Close()
+			next.Close()
+			return
+		}
+	}
+
+	log.Printf("Forwarding a connection to %s.", target)
+	conn.Write([]byte(fmt.Sprintf("Forwarding to: %s\n", target)))
+
+	limit := s.settings.Load().maxDataRelayed
+	go s.pipe(conn, next, limit, nil, nil)
+	go s.pipe(next, conn, limit, nil, nil)
+}
+
+// qualify appends this relay's public address to a session ID, if one is configured.
+func (s *RelayServer) qualify(sessionID string) string {
+	if s.publicAddr == "" {
+		return sessionID
+	}
+	return sessionID + "@" + s.publicAddr
+}
+
+// joinFederated joins session sessionID on the relay at home on behalf of conn and
+// relays the encrypted traffic between them. Relays federate through the ordinary
+// client protocol: to home, this relay is simply the joining client, so home needs
+// no configuration and never learns the address of the client behind it.
+func (s *RelayServer) joinFederated(conn net.Conn, reader *bufio.Reader, sessionID, home string) {
+	if !s.settings.Load().federationPeers[home] {
+		log.Println("Refused to join a session on a relay that is not a federation peer.")
+		conn.Write([]byte(fmt.Sprintf("Error: Relay %s is not a federation peer of this relay\n", home)))
+		conn.Close()
+		return
+	}
+
+	// If the session has a waiting room or is locked, the client introduces itself through us.
+	introduce := func(prompt string) (network.JoinIntro, error) {
+		var intro network.JoinIntro
+		conn.Write([]byte(prompt + "\n"))
+		conn.SetReadDeadline(time.Now().Add(knockTimeout))
+		line, err := reader.ReadSlice('\n')
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return intro, err
+		}
+		return intro, json.Unmarshal(line, &intro)
+	}
+
+	// Proof of work demanded by the home relay is the client's to pay, not ours.
+	work := func(prompt string) (network.WorkSolution, error) {
+		var answer network.WorkSolution
+		conn.Write([]byte(prompt + "\n"))
+		conn.SetReadDeadline(time.Now().Add(workTimeout))
+		line, err := reader.ReadSlice('\n')
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return answer, err
+		}
+		return answer, json.Unmarshal(line, &answer)
+	}
+
+	remote, _, err := network.Connect(network.DialOptions{RelayServerAddr: home, Introduce: introduce, Work: work}, "JOIN", sessionID)
+	if err != nil {
+		log.Printf("Could not join a session on federation peer %s: %v", home, err)
+		conn.Write([]byte("Error: Session not found or full\n"))
+		conn.Close()
+		return
+	}
+
+	log.Printf("Client joined a session on federation peer %s.", home)
+	conn.Write([]byte(fmt.Sprintf("Joined session: %s@%s\n", sessionID, home)))
+
+	limit := s.settings.Load().maxDataRelayed
+	go s.pipe(conn, remote, limit, nil, nil)
+	go s.pipe(remote, conn, limit, nil, nil)
+}
+
+// relayData relays data from the client in slot from to the other one, closing
+// the session on error or inactivity.
+func (s *RelayServer) relayData(session *Session, from int) {
+	defer s.closeSession(session)
+
+	limit := s.settings.Load().maxDataRelayed
+	present := func() bool { return session.pinging(from) }
+	if from == 1 {
+		// Before relaying anything the observer sends.
+		s.announceObserver(session)
+	}
+	src := s.limitReads(session, from, session.Clients[from], &frameCounter{})
+	s.pipe(src, session.Clients[1-from], limit, s.quotaTracker(session, from, limit), present)
+}
+
+// closeSession forgets a session whose clients are gone, along with its side connections.
+func (s *RelayServer) closeSession(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[session.ID] != session {
+		return
+	}
+	delete(s.sessions, session.ID)
+	s.releaseName(session)
+	if session.control != nil {
+		session.control.conn.Close()
+	}
+	for _, notices := range session.notices {
+		if notices != nil {
+			notices.conn.Close()
+		}
+	}
+	for _, t := range session.transfers {
+		t.close()
+	}
+	log.Printf("Session closed. Total active sessions: %d", len(s.sessions))
+}
+
+// pipe copies data from src to dst until either side fails, the data limit is reached,
+// or the connection is inactive for too long. Both connections are closed on return.
+// If progress is not nil, it is called with the total relayed after every chunk, and
+// the pipe stops once it reports that the sender's data budget is used up.
+func (s *RelayServer) pipe(src, dst net.Conn, limit int64, progress func(relayed int64) bool, present func() bool) {
+	var relayed int64
+	started := time.Now()
+	atomic.AddInt64(&activePipes, 1)
+	queue := newClientQueue(dst)
 	defer func() {
+		// What was read before the pipe stopped still reaches the receiver.
+		queue.close()
+		atomic.AddInt64(&activePipes, -1)
 		src.Close()
 		dst.Close()
-		s.mu.Lock()
-		if _, ok := s.sessions[sessionID]; ok {
-			delete(s.sessions, sessionID)
-			log.Printf("Session closed. Total active sessions: %d", len(s.sessions))
+		if elapsed := time.Since(started).Seconds(); relayed > 0 && elapsed > 0 {
+			pipeThroughput.observe(float64(relayed) / elapsed)
 		}
-		s.mu.Unlock()
 	}()
 
-	// Use a limited reader to prevent bandwidth abuse.
-	// We wrap the source connection with a reader that will return EOF
-	// after maxDataRelayed bytes have been read.
-	limitedSrc := io.LimitReader(src, s.maxDataRelayed)
-
 	// Continuously copy data, but also manage an inactivity timer.
 	// We do this by setting a deadline on the underlying connection before each read.
-	for {
+	// Reads never go past limit, to prevent bandwidth abuse. Each read goes into
+	// a buffer of its own, which the queue hands back once it is written.
+	for relayed < limit {
 		if err := src.SetReadDeadline(time.Now().Add(5 * time.Minute)); err != nil {
 			log.Println("Could not set read deadline for a session.")
 			return
 		}
 
-		// Copy a chunk of data. io.Copy will use our limitedSrc.
-		// We copy in chunks to allow the deadline to be checked periodically.
-		_, err := io.CopyN(dst, limitedSrc, 4096)
+		buf := relayBuffers.Get().(*[]byte)
+		n, err := src.Read((*buf)[:min(int64(len(*buf)), limit-relayed)])
+		if n == 0 {
+			relayBuffers.Put(buf)
+		} else {
+			if err := queue.push(buf, n); err != nil {
+				if errors.Is(err, errQueueFull) {
+					log.Printf("Disconnecting a client that did not read for %s.", clientWriteTimeout)
+				} else {
+					log.Println("Data relay finished for a session.")
+				}
+				return
+			}
+			relayed += int64(n)
+			atomic.AddInt64(&bytesRelayed, int64(n))
+			if progress != nil && progress(relayed) {
+				log.Println("Data relay finished for a session.")
+				return
+			}
+		}
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				if present != nil && present() {
+					// Idle, but its pings show the client is still there.
+					continue
+				}
 				log.Println("A session timed out due to 5 minutes of inactivity.")
 			} else if err != io.EOF {
-				// This could be a "read past limit" error from LimitReader, which is fine.
 				log.Println("Data relay finished for a session.")
 			}
-			// On any error (timeout, EOF, limit reached), we exit.
+			// On any error (timeout, EOF), we exit.
 			return
 		}
 	}
 }
 
+// relayBuffers holds the buffers relayed data passes through, shared by all
+// sessions so relaying does not allocate for every chunk a client sends.
+var relayBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, 4096)
+	return &buf
+}}
+
+// splitList parses a comma-separated flag value, ignoring empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	maxDataRelayed := flag.Int64("max-data-relayed", 50, "Maximum data to relay per session in MB")
+	allowForward := flag.String("allow-forward", "", "Comma-separated relay addresses (host:port) this server may forward clients to; empty disables forwarding")
+	publicAddr := flag.String("public-addr", "", "Address (host:port) clients and federated relays use to reach this relay; session IDs are handed out as id@public-addr")
+	federationPeers := flag.String("federation-peers", "", "Comma-separated relay addresses (host:port) whose sessions clients of this relay may join")
+	workBits := flag.Int("pow-bits", 0, fmt.Sprintf("Make clients solve a proof-of-work challenge of this many bits (at most %d) before creating a session; 0 disables", network.MaxWorkBits))
+	workOnJoin := flag.Bool("pow-join", false, "With -pow-bits, also demand proof of work before joining a session")
+	reserveNames := flag.Duration("reserve-names", 0, "Hold a chosen session name for the identity key that created it for this long after the session ends (e.g. 24h); 0 disables")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address (e.g., 127.0.0.1:9090); empty disables")
+	adminAddr := flag.String("admin-addr", "", "Serve the admin API, for reviewing abuse reports, on this address (e.g., 127.0.0.1:9091); empty disables")
+	adminTokenFile := flag.String("admin-token-file", "", "File holding the bearer token the admin API requires; needed with -admin-addr")
+	banFile := flag.String("ban-file", "", "JSON file keeping the identity fingerprints banned from the relay; it is read again on SIGHUP")
+	configPath := flag.String("config", "", "JSON file with settings that override the flags; it is read again on SIGHUP")
+	var addrs addrList
+	flag.Var(&addrs, "addr", "Address to listen on as [tcp|tls|ws|wss][4|6]://host:port, with ?cert=&key=&client-ca= for a TLS listener; repeat to listen on several (default "+defaultAddr+")")
+	wsAddr := flag.String("ws-addr", "", "Also accept clients over WebSocket on this address (e.g., :8443), with TLS if -tls-cert is set; empty disables")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate for serving TLS directly; without it the relay serves plain TCP")
+	tlsKey := flag.String("tls-key", "", "PEM private key for -tls-cert")
+	clientCA := flag.String("client-ca", "", "With -tls-cert, only admit clients presenting a certificate signed by this PEM CA")
+	tlsMinVersion := flag.String("tls-min-version", "1.2", "Oldest TLS version the TLS listeners accept: 1.2 or 1.3")
+	tlsCiphers := flag.String("tls-ciphers", "", "Comma-separated TLS 1.2 cipher suites the TLS listeners accept, by their Go names, in order of preference; empty keeps Go's secure defaults")
+	tlsALPN := flag.String("tls-alpn", "", "Comma-separated ALPN protocols the TLS listeners offer, e.g. to share a port behind an SNI/ALPN router; clients offering none of them are refused")
+	tlsTicketRotation := flag.Duration("tls-ticket-rotation", 0, "Replace the TLS session ticket key this often (e.g. 1h), accepting tickets under the previous key until the next rotation; 0 leaves rotation to Go")
+	reusePortFlag := flag.Bool("reuse-port", false, "Listen with SO_REUSEPORT, so a new relay can start on the same address while this one drains")
+	resumeGrace := flag.Duration("resume-grace", 0, "How long a client that lost its connection may take to resume its session, while the relay holds data for it; 0 disables")
+	resumeBufferKB := flag.Int("resume-buffer", 256, "With -resume-grace, KB of data the relay holds for each client of a session")
+	directory := flag.Bool("directory", false, "Keep a public directory of sessions their owners chose to list, which anyone can retrieve with LIST")
+	blobStoreMB := flag.Int64("blob-store", 0, "Keep encrypted files clients upload for their peers to fetch later, in memory, up to this many MB in total; 0 disables")
+	blobTTL := flag.Duration("blob-ttl", time.Hour, "With -blob-store, how long an uploaded file is kept")
+	maxGoroutines := flag.Int("max-goroutines", 0, "Refuse new sessions, joins, forwards and transfers while the relay runs this many goroutines; 0 disables")
+	maxOpenFDs := flag.Int("max-fds", 0, "Refuse new work while the relay has this many file descriptors open (Linux only); 0 disables")
+	maxSessionMemoryMB := flag.Int64("max-session-memory", 0, "Refuse new work while open sessions take an estimated this many MB; 0 disables")
+	rateMessages := flag.Float64("rate-msgs", 0, "Messages per second each client of a session may send, with bursts of four seconds' worth; faster clients are slowed down. 0 disables")
+	rateKB := flag.Int64("rate-kb", 0, "KB per second each client of a session may send, files included, with bursts of four seconds' worth; faster clients are slowed down. 0 disables")
+	rateDisconnect := flag.Duration("rate-disconnect", 30*time.Second, "With -rate-msgs or -rate-kb, disconnect a client that stays over its rate this long; 0 only slows it down")
+	maxHandshakes := flag.Int("max-handshakes", 4096, "Refuse new connections while this many others have not sent their command yet; 0 disables")
+	maxHandshakesPerIP := flag.Int("max-handshakes-per-ip", 32, "Refuse new connections from an address while this many others from it have not sent their command yet; 0 disables")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Minute, "On SIGTERM, how long to wait for open sessions to end before exiting; 0 waits indefinitely")
+	logPath := flag.String("log-file", "", "Write the log to this file instead of stderr, rotating it by size and age")
+	logMaxSizeMB := flag.Int64("log-max-size", 100, "With -log-file, start a new log once it grows past this many MB; 0 disables")
+	logMaxAge := flag.Duration("log-max-age", 24*time.Hour, "With -log-file, start a new log once it is this old; 0 disables")
+	logKeep := flag.Int("log-keep", 7, "With -log-file, how many rotated logs to keep as <file>.1 (newest) to <file>.N")
 	flag.Parse()
 
-	server := NewRelayServer(*maxDataRelayed * 1024 * 1024) // Convert MB to bytes
-	server.Start(":8080")
+	if *logPath != "" {
+		if *logMaxSizeMB < 0 || *logMaxAge < 0 || *logKeep < 0 {
+			log.Fatalf("Invalid flags: -log-max-size, -log-max-age and -log-keep must not be negative")
+		}
+		logFile, err := openLogFile(*logPath, *logMaxSizeMB*1024*1024, *logMaxAge, *logKeep)
+		if err != nil {
+			log.Fatalf("Failed to open the log file: %v", err)
+		}
+		log.SetOutput(logFile)
+	}
+
+	policy, err := parseTLSPolicy(*tlsMinVersion, *tlsCiphers, *tlsALPN, *tlsTicketRotation)
+	if err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+	tlsConfig, err := loadTLSConfig(*tlsCert, *tlsKey, *clientCA, policy)
+	if err != nil {
+		log.Fatalf("Failed to set up TLS: %v", err)
+	}
+	listeners, err := listenSpecs(addrs, *wsAddr, tlsDefaults{certFile: *tlsCert, keyFile: *tlsKey, clientCAFile: *clientCA, config: tlsConfig, policy: policy})
+	if err != nil {
+		log.Fatalf("Invalid listen address: %v", err)
+	}
+	var tlsConfigs []*tls.Config
+	for _, spec := range listeners {
+		if spec.tls != nil {
+			checkTLS(spec, policy)
+			if !slices.Contains(tlsConfigs, spec.tls) {
+				tlsConfigs = append(tlsConfigs, spec.tls)
+			}
+		}
+	}
+	if policy.ticketRotation > 0 && len(tlsConfigs) > 0 {
+		rotateTicketKeys(tlsConfigs, policy.ticketRotation)
+	}
+
+	base := settings{
+		maxDataRelayed:     *maxDataRelayed * 1024 * 1024, // Convert MB to bytes
+		forwardTargets:     toSet(splitList(*allowForward)),
+		federationPeers:    toSet(splitList(*federationPeers)),
+		workBits:           *workBits,
+		workOnJoin:         *workOnJoin,
+		reserveFor:         *reserveNames,
+		resumeGrace:        *resumeGrace,
+		resumeBuffer:       *resumeBufferKB * 1024,
+		directory:          *directory,
+		blobStore:          *blobStoreMB * 1024 * 1024,
+		blobTTL:            *blobTTL,
+		maxGoroutines:      *maxGoroutines,
+		maxOpenFDs:         *maxOpenFDs,
+		maxSessionMemory:   *maxSessionMemoryMB * 1024 * 1024,
+		rateMessages:       *rateMessages,
+		rateBytes:          *rateKB * 1024,
+		rateDisconnect:     *rateDisconnect,
+		maxHandshakes:      *maxHandshakes,
+		maxHandshakesPerIP: *maxHandshakesPerIP,
+	}
+	cfg := &base
+	if *configPath != "" {
+		if cfg, err = loadSettings(*configPath, base); err != nil {
+			log.Fatalf("Failed to load settings: %v", err)
+		}
+	} else if err := base.validate(); err != nil {
+		log.Fatalf("Invalid flags: %v", err)
+	}
+
+	server := NewRelayServer(*publicAddr, cfg)
+	if *banFile != "" {
+		stateKey, err := loadStateKey()
+		if err != nil {
+			log.Fatalf("Invalid environment: %v", err)
+		}
+		if server.bans, err = loadBans(*banFile, stateKey); err != nil {
+			log.Fatalf("Failed to load bans: %v", err)
+		}
+		go server.bans.reloadOnHangup()
+	}
+	server.reusePort = *reusePortFlag
+	go server.drainOnSignal(*drainTimeout)
+	if *configPath != "" {
+		go server.reloadOnHangup(*configPath, base)
+	}
+	if *metricsAddr != "" {
+		go server.serveMetrics(*metricsAddr)
+	}
+	if *adminAddr != "" {
+		if *adminTokenFile == "" {
+			log.Fatalf("Invalid flags: -admin-addr needs -admin-token-file")
+		}
+		token, err := readAdminToken(*adminTokenFile)
+		if err != nil {
+			log.Fatalf("Failed to read the admin token: %v", err)
+		}
+		go server.serveAdmin(*adminAddr, token)
+	}
+	server.Start(listeners)
+	select {} // Draining; drainOnSignal exits the process
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// Relay metrics, served in the Prometheus text format by serveMetrics. They only
+// describe the volume and timing of traffic, which the relay sees anyway.
+var (
+	bytesRelayed    int64 // Bytes relayed between clients, in either direction
+	unknownCommands int64 // Commands the relay does not know, such as those of newer clients
+
+	messageSizes = newHistogram("jot_relay_message_size_bytes",
+		"Size of each read relayed from one client to the other.",
+		[]float64{64, 128, 256, 512, 1024, 2048, 4096}) // The relay reads at most 4096 bytes at a time
+	forwardLatency = newHistogram("jot_relay_forward_latency_seconds",
+		"Time taken to write a relayed read to the other client.",
+		[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1})
+	pipeThroughput = newHistogram("jot_relay_pipe_throughput_bytes_per_second",
+		"Average rate at which one direction of a connection was relayed, recorded when it ends.",
+		[]float64{1024, 16384, 65536, 262144, 1048576, 4194304, 16777216})
+)
+
+// meteredWriter records the size and write latency of every read relayed through it.
+type meteredWriter struct {
+	net.Conn
+}
+
+func (w meteredWriter) Write(p []byte) (int, error) {
+	started := time.Now()
+	n, err := w.Conn.Write(p)
+	forwardLatency.observe(time.Since(started).Seconds())
+	messageSizes.observe(float64(len(p)))
+	return n, err
+}
+
+// histogram counts observations into fixed buckets, like a Prometheus histogram.
+type histogram struct {
+	name, help string
+	bounds     []float64
+
+	mu     sync.Mutex
+	counts []uint64 // Per bucket, not cumulative; the last one is +Inf
+	sum    float64
+}
+
+func newHistogram(name, help string, bounds []float64) *histogram {
+	return &histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	i := 0
+	for i < len(h.bounds) && value > h.bounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += value
+	h.mu.Unlock()
+}
+
+func (h *histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum := h.sum
+	h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cumulative uint64
+	for i, count := range counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, strconv.FormatFloat(sum, 'g', -1, 64), h.name, cumulative)
+}
+
+// serveMetrics serves the relay's metrics on addr at /metrics, and its
+// health at /healthz.
+func (s *RelayServer) serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		u := s.usage()
+		active := u.Sessions
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintf(w, "# HELP jot_relay_sessions_created_total Sessions created since the relay started.\n# TYPE jot_relay_sessions_created_total counter\njot_relay_sessions_created_total %d\n", atomic.LoadInt64(&totalSessions))
+		fmt.Fprintf(w, "# HELP jot_relay_sessions_active Sessions currently open.\n# TYPE jot_relay_sessions_active gauge\njot_relay_sessions_active %d\n", active)
+		fmt.Fprintf(w, "# HELP jot_relay_bytes_relayed_total Bytes relayed between clients.\n# TYPE jot_relay_bytes_relayed_total counter\njot_relay_bytes_relayed_total %d\n", atomic.LoadInt64(&bytesRelayed))
+		fmt.Fprintf(w, "# HELP jot_relay_unknown_commands_total Commands from clients that the relay does not know, typically sent by newer clients.\n# TYPE jot_relay_unknown_commands_total counter\njot_relay_unknown_commands_total %d\n", atomic.LoadInt64(&unknownCommands))
+		fmt.Fprintf(w, "# HELP jot_relay_heap_bytes Bytes of heap memory in use.\n# TYPE jot_relay_heap_bytes gauge\njot_relay_heap_bytes %d\n", mem.HeapAlloc)
+		fmt.Fprintf(w, "# HELP jot_relay_allocated_bytes_total Bytes of memory allocated since the relay started.\n# TYPE jot_relay_allocated_bytes_total counter\njot_relay_allocated_bytes_total %d\n", mem.TotalAlloc)
+		fmt.Fprintf(w, "# HELP jot_relay_goroutines Goroutines running.\n# TYPE jot_relay_goroutines gauge\njot_relay_goroutines %d\n", u.Goroutines)
+		if u.OpenFDs >= 0 {
+			fmt.Fprintf(w, "# HELP jot_relay_open_fds File descriptors open.\n# TYPE jot_relay_open_fds gauge\njot_relay_open_fds %d\n", u.OpenFDs)
+		}
+		fmt.Fprintf(w, "# HELP jot_relay_session_memory_bytes Estimated memory taken by open sessions.\n# TYPE jot_relay_session_memory_bytes gauge\njot_relay_session_memory_bytes %d\n", u.SessionMemory)
+		fmt.Fprintf(w, "# HELP jot_relay_capacity_refusals_total Commands refused because the relay reached a capacity limit, by resource.\n# TYPE jot_relay_capacity_refusals_total counter\n")
+		for _, resource := range []string{"goroutines", "fds", "memory"} {
+			fmt.Fprintf(w, "jot_relay_capacity_refusals_total{resource=\"%s\"} %d\n", resource, atomic.LoadInt64(capacityRefusals[resource]))
+		}
+		fmt.Fprintf(w, "# HELP jot_relay_handshakes_open Connections whose command has not arrived yet.\n# TYPE jot_relay_handshakes_open gauge\njot_relay_handshakes_open %d\n", s.handshakes.open())
+		fmt.Fprintf(w, "# HELP jot_relay_handshake_refusals_total Connections refused because too many others had not sent their command yet, in total or from the same address.\n# TYPE jot_relay_handshake_refusals_total counter\n")
+		for _, limit := range []string{"total", "address"} {
+			fmt.Fprintf(w, "jot_relay_handshake_refusals_total{limit=\"%s\"} %d\n", limit, atomic.LoadInt64(handshakeRefusals[limit]))
+		}
+		fmt.Fprintf(w, "# HELP jot_relay_throttled_total Times a client was slowed down for sending faster than -rate-msgs or -rate-kb allow.\n# TYPE jot_relay_throttled_total counter\njot_relay_throttled_total %d\n", atomic.LoadInt64(&throttledReads))
+		messageSizes.writeTo(w)
+		forwardLatency.writeTo(w)
+		pipeThroughput.writeTo(w)
+	})
+
+	// /healthz answers 503 while the relay refuses new work, so a load balancer
+	// can send clients elsewhere.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		u := s.usage()
+		health := struct {
+			Status   string            `json:"status"` // "ok" or "at-capacity"
+			Capacity *network.Capacity `json:"capacity,omitempty"`
+			usage
+		}{Status: "ok", Capacity: s.settings.Load().overCapacity(u), usage: u}
+		w.Header().Set("Content-Type", "application/json")
+		if health.Capacity != nil {
+			health.Status = "at-capacity"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Metrics server stopped: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	quotaCloseDelay = 2 * time.Second // How long a client that used up its budget has to read why, before we close
+	// pingTimeout is how long a client that pings us on its NOTICES connection
+	// may go without one before we take it for dead. Clients ping every 15 seconds.
+	pingTimeout = 45 * time.Second
+)
+
+// noticeSlot returns which client of the session chose noticeKey, or -1.
+func (session *Session) noticeSlot(noticeKey string) int {
+	slot := -1
+	if noticeKey == "" {
+		return slot
+	}
+	for i, key := range session.noticeKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(noticeKey)) == 1 {
+			slot = i
+		}
+	}
+	return slot
+}
+
+// subscribeNotices turns conn into a client's NOTICES connection, on which the
+// relay tells it about limits it is about to hit. The client proves which end
+// of the session it is with the notice key it sent with CREATE or JOIN.
+func (s *RelayServer) subscribeNotices(conn net.Conn, reader *bufio.Reader, sessionID, noticeKey string) {
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	slot := -1
+	if exists {
+		slot = session.noticeSlot(noticeKey)
+	}
+	if slot < 0 {
+		s.mu.Unlock()
+		conn.Write([]byte("Error: Session not found or notice key is wrong\n"))
+		conn.Close()
+		return
+	}
+	if session.notices[slot] != nil {
+		session.notices[slot].conn.Close()
+	}
+	notices := &controlConn{conn: conn}
+	session.notices[slot] = notices
+	// A client that subscribes after an observer joined still hears of it.
+	observed := slot == 0 && session.observer && session.Clients[1] != nil
+	s.mu.Unlock()
+
+	conn.Write([]byte(fmt.Sprintf("Subscribed: %s\n", s.qualify(sessionID))))
+	if observed {
+		notices.send(controlEvent{Event: "observer"})
+	}
+
+	// Clients that support heartbeats ping us here, and the pongs let them
+	// measure the round trip. Older clients send nothing, and reading only
+	// tells us when the connection closes.
+	scanner := bufio.NewScanner(reader)
+	pinged := false
+	for {
+		if pinged {
+			conn.SetReadDeadline(time.Now().Add(pingTimeout))
+		}
+		if !scanner.Scan() {
+			break
+		}
+		var ping struct {
+			Command string `json:"command"`
+			Seq     int64  `json:"seq"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &ping) != nil || ping.Command != "PING" {
+			continue
+		}
+		pinged = true
+		session.mu.Lock()
+		session.lastPing[slot] = time.Now()
+		session.mu.Unlock()
+		notices.send(controlEvent{Event: "pong", Seq: ping.Seq})
+	}
+	s.mu.Lock()
+	if session.notices[slot] == notices {
+		session.notices[slot] = nil
+	}
+	s.mu.Unlock()
+	conn.Close()
+
+	var netErr net.Error
+	if errors.As(scanner.Err(), &netErr) && netErr.Timeout() {
+		log.Printf("A client of session '%s' stopped pinging; dropping its connection.", session.ID)
+		s.dropClient(session, slot)
+	}
+}
+
+// pinging reports whether the client in slot pinged us recently, so it is
+// still there even if it has sent its peer nothing for a while.
+func (session *Session) pinging(slot int) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return !session.lastPing[slot].IsZero() && time.Since(session.lastPing[slot]) < pingTimeout
+}
+
+// dropClient closes the session connection of the client in slot, whose pings
+// stopped. A client that may resume gets the grace period to come back;
+// otherwise the session ends.
+func (s *RelayServer) dropClient(session *Session, slot int) {
+	s.mu.Lock()
+	r, client := session.resume, session.Clients[slot]
+	s.mu.Unlock()
+	if r == nil {
+		if client != nil {
+			client.Close()
+		}
+		return
+	}
+	l := r.legs[slot]
+	l.mu.Lock()
+	conn := l.conn
+	l.mu.Unlock()
+	if conn != nil {
+		s.clientAway(r, slot, conn)
+	}
+}
+
+// quotaTracker returns a progress callback for the data the client in slot
+// from sends over one of its connections, which counts it against the
+// client's budget for the whole session, warns the client at each of
+// limitWarnPercents of the budget and when it has used it up, and reports
+// whether it has.
+func (s *RelayServer) quotaTracker(session *Session, from int, limit int64) func(relayed int64) bool {
+	var counted int64 // Of the bytes relayed on this connection, those already added to the session's count
+	return func(relayed int64) bool {
+		session.mu.Lock()
+		session.sent[from] += relayed - counted
+		counted = relayed
+		total := session.sent[from]
+		state := limitLevel(total, limit)
+		reached := state > session.quota[from]
+		if reached {
+			session.quota[from] = state
+		}
+		session.mu.Unlock()
+		if !reached {
+			return total >= limit
+		}
+		if state == limitReachedLevel {
+			log.Printf("A client of session '%s' used up its data budget.", session.ID)
+		}
+		s.mu.Lock()
+		notices := session.notices[from]
+		s.mu.Unlock()
+		if notices != nil {
+			notices.send(controlEvent{Event: "quota", Relayed: total, Limit: limit})
+			if state == limitReachedLevel {
+				// Closing right away would reach the client as a bare write error first.
+				time.Sleep(quotaCloseDelay)
+			}
+		}
+		return total >= limit
+	}
+}
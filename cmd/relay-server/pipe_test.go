@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"testing"
+)
+
+// tcpPair returns the two ends of a loopback TCP connection.
+func tcpPair(b *testing.B) (net.Conn, net.Conn) {
+	b.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		b.Fatal("accept failed")
+	}
+	return client, server
+}
+
+// BenchmarkPipe measures relaying writes of various sizes from one client to
+// the other through pipe, over loopback TCP.
+func BenchmarkPipe(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(out)
+	s := NewRelayServer("", &settings{})
+	for _, size := range []int{64, 1024, 4096, 64 * 1024} {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			sender, src := tcpPair(b)
+			receiver, dst := tcpPair(b)
+			defer sender.Close()
+			defer receiver.Close()
+			go s.pipe(src, dst, math.MaxInt64, nil, nil)
+
+			want := int64(b.N) * int64(size)
+			received := make(chan error, 1)
+			go func() {
+				_, err := io.CopyN(io.Discard, receiver, want)
+				received <- err
+			}()
+			message := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := sender.Write(message); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := <-received; err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
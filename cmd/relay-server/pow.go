@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// workTimeout is how long a client may take to answer a proof-of-work challenge.
+const workTimeout = 30 * time.Second
+
+// requiresWork reports whether command must be paid for with proof of work.
+func (s *RelayServer) requiresWork(command string) bool {
+	cfg := s.settings.Load()
+	if cfg.workBits == 0 {
+		return false
+	}
+	return command == "CREATE" || (command == "JOIN" && cfg.workOnJoin)
+}
+
+// checkWork challenges conn to prove it did some work before we spend a session
+// on it. On failure it closes conn and returns false.
+func (s *RelayServer) checkWork(conn net.Conn, reader *bufio.Reader) bool {
+	nonce, workBits := generateShortID(32), s.settings.Load().workBits
+	conn.Write([]byte(fmt.Sprintf("Challenge: %s %d\n", nonce, workBits)))
+
+	conn.SetReadDeadline(time.Now().Add(workTimeout))
+	line, err := reader.ReadSlice('\n')
+	conn.SetReadDeadline(time.Time{})
+	var answer network.WorkSolution
+	if err != nil || json.Unmarshal(line, &answer) != nil || !network.VerifyWork(nonce, answer.Solution, workBits) {
+		log.Println("Refused a client that did not solve its proof-of-work challenge.")
+		conn.Write([]byte("Error: Proof of work is missing or wrong\n"))
+		conn.Close()
+		return false
+	}
+	return true
+}
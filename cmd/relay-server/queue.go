@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// clientQueueChunks bounds what is queued for one client, in reads of up
+	// to 4096 bytes from its peer.
+	clientQueueChunks = 64
+	// clientWriteTimeout is how long a client may take to accept a write, or
+	// leave its queue full, before it is disconnected as too slow.
+	clientWriteTimeout = 30 * time.Second
+)
+
+var errQueueFull = errors.New("the client stopped reading")
+
+// chunk is a read from one client, queued for the other. buf goes back to
+// relayBuffers once it is written.
+type chunk struct {
+	buf *[]byte
+	n   int
+}
+
+// clientQueue holds what is relayed to one client, so a burst from the sender
+// does not wait on every write to a slower receiver. A goroutine writes the
+// queue out, with a deadline on each write.
+type clientQueue struct {
+	conn    net.Conn
+	chunks  chan chunk
+	failed  chan struct{} // Closed once a write failed; err says why
+	drained chan struct{} // Closed once the writer is done
+	err     error
+}
+
+func newClientQueue(conn net.Conn) *clientQueue {
+	q := &clientQueue{
+		conn:    conn,
+		chunks:  make(chan chunk, clientQueueChunks),
+		failed:  make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *clientQueue) run() {
+	defer close(q.drained)
+	w := meteredWriter{q.conn}
+	for c := range q.chunks {
+		if q.err == nil {
+			q.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			if _, err := w.Write((*c.buf)[:c.n]); err != nil {
+				q.err = err
+				close(q.failed)
+			}
+		}
+		relayBuffers.Put(c.buf)
+	}
+}
+
+// push queues the first n bytes of buf, which the queue now owns. If the queue
+// is full, it waits up to clientWriteTimeout for the client to catch up.
+func (q *clientQueue) push(buf *[]byte, n int) error {
+	select {
+	case q.chunks <- chunk{buf, n}:
+		return nil
+	case <-q.failed:
+		relayBuffers.Put(buf)
+		return q.err
+	default:
+	}
+	timer := time.NewTimer(clientWriteTimeout)
+	defer timer.Stop()
+	select {
+	case q.chunks <- chunk{buf, n}:
+		return nil
+	case <-q.failed:
+		relayBuffers.Put(buf)
+		return q.err
+	case <-timer.C:
+		relayBuffers.Put(buf)
+		return errQueueFull
+	}
+}
+
+// close waits until what is queued has been written, or has failed to be.
+func (q *clientQueue) close() {
+	close(q.chunks)
+	<-q.drained
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// With -rate-msgs or -rate-kb, the relay holds each client of a session to a
+// steady rate of frames and bytes, with token buckets that allow bursts of
+// rateBurst seconds' worth. A client over its rate is slowed down rather than
+// having its data dropped, which would break the framing: the relay stops
+// reading from it until it is back under, so the flood piles up in the
+// client's own socket instead of reaching its peer. Both clients hear about
+// it with a "throttled" notice. A client that stays over its rate for
+// -rate-disconnect is disconnected, which ends the session.
+
+const (
+	rateBurst = 4 // Seconds' worth of traffic a client may send at once
+	// throttleNoticeInterval is how often at most the session hears that a
+	// client is being slowed down.
+	throttleNoticeInterval = 30 * time.Second
+)
+
+// throttledReads counts the times a client was slowed down for sending too fast.
+var throttledReads int64
+
+var errRateExceeded = errors.New("the client kept sending too fast")
+
+// tokenBucket refills at rate tokens per second up to burst. Taking more than
+// it holds puts it into debt, which must be paid off before the next take.
+type tokenBucket struct {
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate * rateBurst
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// owed refills the bucket and returns how long it takes to get out of debt.
+func (b *tokenBucket) owed(now time.Time) time.Duration {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// clientRate limits what one client sends over all its connections.
+type clientRate struct {
+	mu             sync.Mutex
+	messages       *tokenBucket // Frames; nil when not limited
+	bytes          *tokenBucket // nil when not limited
+	disconnect     time.Duration
+	throttledSince time.Time // When the client went over its rate; zero while it is under
+	noticed        time.Time // When the session last heard about it
+
+	notify func(disconnect bool) // Tells the session that the client is slowed down or cut off
+}
+
+// clientRate returns the rate limiter of the client in slot, or nil if the
+// relay limits no rates. The limits are those of the settings in force when
+// it was first asked for.
+func (s *RelayServer) clientRate(session *Session, slot int) *clientRate {
+	cfg := s.settings.Load()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.rates[slot] != nil || cfg.rateMessages <= 0 && cfg.rateBytes <= 0 {
+		return session.rates[slot]
+	}
+	r := &clientRate{disconnect: cfg.rateDisconnect}
+	if cfg.rateMessages > 0 {
+		r.messages = newTokenBucket(cfg.rateMessages)
+	}
+	if cfg.rateBytes > 0 {
+		r.bytes = newTokenBucket(float64(cfg.rateBytes))
+	}
+	r.notify = func(disconnect bool) { s.noticeThrottled(session, slot, disconnect) }
+	session.rates[slot] = r
+	return r
+}
+
+// wait blocks until the client is back under its rate. It fails once the
+// client has been over it for longer than the disconnect limit.
+func (r *clientRate) wait() error {
+	for {
+		r.mu.Lock()
+		now := time.Now()
+		owed := time.Duration(0)
+		for _, bucket := range []*tokenBucket{r.messages, r.bytes} {
+			if bucket != nil {
+				owed = max(owed, bucket.owed(now))
+			}
+		}
+		if owed == 0 {
+			r.throttledSince = time.Time{}
+			r.mu.Unlock()
+			return nil
+		}
+		notify := false
+		if r.throttledSince.IsZero() {
+			r.throttledSince = now
+			atomic.AddInt64(&throttledReads, 1)
+			if now.Sub(r.noticed) >= throttleNoticeInterval {
+				r.noticed = now
+				notify = true
+			}
+		}
+		if r.disconnect > 0 && now.Sub(r.throttledSince)+owed > r.disconnect {
+			r.mu.Unlock()
+			r.notify(true)
+			return errRateExceeded
+		}
+		r.mu.Unlock()
+		if notify {
+			r.notify(false)
+		}
+		time.Sleep(min(owed, time.Second))
+	}
+}
+
+// charge takes what the client just sent from its buckets.
+func (r *clientRate) charge(messages, bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.messages != nil {
+		r.messages.tokens -= float64(messages)
+	}
+	if r.bytes != nil {
+		r.bytes.tokens -= float64(bytes)
+	}
+}
+
+// noticeThrottled tells both clients of the session that the one in slot is
+// being slowed down for sending too fast, or disconnected for it.
+func (s *RelayServer) noticeThrottled(session *Session, slot int, disconnect bool) {
+	if disconnect {
+		log.Printf("Disconnecting a client of session '%s' that kept sending too fast.", session.ID)
+	} else {
+		log.Printf("Slowing down a client of session '%s' that sends too fast.", session.ID)
+	}
+	s.mu.Lock()
+	notices := session.notices
+	s.mu.Unlock()
+	notices[slot].send(controlEvent{Event: "throttled", Disconnect: disconnect})
+	notices[1-slot].send(controlEvent{Event: "throttled", Peer: true, Disconnect: disconnect})
+}
+
+// frameCounter counts the frames that start in a stream read in arbitrary
+// pieces. It only reads their headers, to skip over their payloads.
+type frameCounter struct {
+	header    [protocol.FrameHeaderSize]byte
+	have      int    // Header bytes read of the next frame
+	remaining uint32 // Payload bytes of the current frame still to come
+}
+
+func (c *frameCounter) count(p []byte) int {
+	frames := 0
+	for len(p) > 0 {
+		if c.remaining > 0 {
+			skip := min(uint32(len(p)), c.remaining)
+			c.remaining -= skip
+			p = p[skip:]
+			continue
+		}
+		n := copy(c.header[c.have:], p)
+		c.have += n
+		p = p[n:]
+		if c.have == len(c.header) {
+			frames++
+			c.have = 0
+			c.remaining = binary.BigEndian.Uint32(c.header[1:])
+		}
+	}
+	return frames
+}
+
+// rateLimitedConn reads from a client's connection no faster than its
+// clientRate allows.
+type rateLimitedConn struct {
+	net.Conn
+	rate   *clientRate
+	frames *frameCounter
+}
+
+// limitReads returns conn, read from at the rate allowed to the client in slot.
+// frames counts the frames it carries; with nil, only bytes are limited.
+func (s *RelayServer) limitReads(session *Session, slot int, conn net.Conn, frames *frameCounter) net.Conn {
+	rate := s.clientRate(session, slot)
+	if rate == nil {
+		return conn
+	}
+	return &rateLimitedConn{Conn: conn, rate: rate, frames: frames}
+}
+
+func (c *rateLimitedConn) Read(p []byte) (int, error) {
+	if err := c.rate.wait(); err != nil {
+		return 0, err
+	}
+	n, err := c.Conn.Read(p)
+	messages := 0
+	if c.frames != nil {
+		messages = c.frames.count(p[:n])
+	}
+	c.rate.charge(messages, n)
+	return n, err
+}
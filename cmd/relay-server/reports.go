@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+const (
+	// maxReports bounds how many reports the relay keeps for review; the
+	// oldest are dropped first.
+	maxReports = 1000
+	// maxReportsPerClient bounds how many reports each client of a session may file.
+	maxReportsPerClient = 3
+)
+
+// abuseReport is a report filed with REPORT, kept in memory until an operator
+// dismisses it through the admin API.
+type abuseReport struct {
+	ID        string    `json:"id"`
+	Received  time.Time `json:"received"`
+	SessionID string    `json:"sessionID"`
+	Reporter  string    `json:"reporter"` // "creator" or "joiner" of the session
+	network.Report
+}
+
+// fileReport records report from the client of sessionID holding noticeKey.
+func (s *RelayServer) fileReport(conn net.Conn, sessionID, noticeKey string, report *network.Report) {
+	defer conn.Close()
+	if report == nil {
+		conn.Write([]byte("Error: Report is missing\n"))
+		return
+	}
+	if len(report.Note) > network.MaxReportNote {
+		conn.Write([]byte(fmt.Sprintf("Error: Note is longer than %d bytes\n", network.MaxReportNote)))
+		return
+	}
+
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	slot := -1
+	if exists {
+		slot = session.noticeSlot(noticeKey)
+	}
+	if slot < 0 {
+		s.mu.Unlock()
+		conn.Write([]byte("Error: Session not found or notice key is wrong\n"))
+		return
+	}
+	if session.reports[slot] >= maxReportsPerClient {
+		s.mu.Unlock()
+		conn.Write([]byte("Error: Too many reports from this session\n"))
+		return
+	}
+	session.reports[slot]++
+	filed := &abuseReport{ID: generateShortID(12), Received: time.Now().UTC(), SessionID: sessionID, Reporter: [2]string{"creator", "joiner"}[slot], Report: *report}
+	s.reports = append(s.reports, filed)
+	if len(s.reports) > maxReports {
+		s.reports = s.reports[len(s.reports)-maxReports:]
+	}
+	s.mu.Unlock()
+
+	log.Printf("Report %s filed by the %s of session '%s'.", filed.ID, filed.Reporter, sessionID)
+	conn.Write([]byte(fmt.Sprintf("Reported: %s\n", filed.ID)))
+}
+
+// listReports answers GET /reports.
+func (s *RelayServer) listReports(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	reports := append([]*abuseReport{}, s.reports...)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// dismissReport answers DELETE /reports/{id}.
+func (s *RelayServer) dismissReport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	found := false
+	for i, report := range s.reports {
+		if report.ID == id {
+			s.reports = append(s.reports[:i], s.reports[i+1:]...)
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
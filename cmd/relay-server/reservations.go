@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// maxReservations bounds how many session names the relay holds for their owners.
+const maxReservations = 10000
+
+// reservation holds a session name for the identity key that last created it.
+type reservation struct {
+	fingerprint string    // Fingerprint of the owner's identity key
+	lastUsed    time.Time // When the owner's session under this name was last open
+}
+
+// nameTaken reports whether a session name cannot be handed to the client with
+// the given identity fingerprint, which is empty for clients without one.
+// The caller must hold s.mu.
+func (s *RelayServer) nameTaken(name, fingerprint string) bool {
+	if _, exists := s.sessions[name]; exists {
+		return true
+	}
+	r, ok := s.reservations[name]
+	if !ok {
+		return false
+	}
+	if time.Since(r.lastUsed) > s.settings.Load().reserveFor {
+		delete(s.reservations, name)
+		return false
+	}
+	return r.fingerprint != fingerprint
+}
+
+// reserveName holds name for fingerprint until the grace period after its
+// session ends, and reports whether it did. The caller must hold s.mu.
+func (s *RelayServer) reserveName(name, fingerprint string) bool {
+	reserveFor := s.settings.Load().reserveFor
+	if reserveFor == 0 || fingerprint == "" {
+		return false
+	}
+	if _, ok := s.reservations[name]; !ok && len(s.reservations) >= maxReservations {
+		for other, r := range s.reservations {
+			if time.Since(r.lastUsed) > reserveFor {
+				delete(s.reservations, other)
+			}
+		}
+		if len(s.reservations) >= maxReservations {
+			log.Printf("Not reserving session name '%s': too many names are reserved.", name)
+			return false
+		}
+	}
+	s.reservations[name] = &reservation{fingerprint: fingerprint, lastUsed: time.Now()}
+	return true
+}
+
+// releaseName starts the grace period for a session's reserved name once the
+// session ends. The caller must hold s.mu.
+func (s *RelayServer) releaseName(session *Session) {
+	if session.reserved {
+		s.reserveName(session.ID, session.ownerFingerprint)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// With -resume-grace, a client whose connection drops gets that long to
+// reconnect with RESUME before its session ends. Meanwhile the relay keeps
+// whatever its peer sends, in memory and still end-to-end encrypted, and
+// delivers it when the client is back. Both ends count the bytes they have
+// received, so each side can send again exactly what the other missed.
+
+// resumable is the relay's state for a session whose clients may resume.
+type resumable struct {
+	session *Session
+	legs    [2]*leg
+	grace   time.Duration // How long a client may be away
+	buffer  int           // Bytes held for each client
+
+	endOnce sync.Once
+}
+
+// leg is the relay's end of one client of a resumable session.
+type leg struct {
+	writeMu sync.Mutex // Held while writing to the client, so replays and new data never interleave
+
+	mu        sync.Mutex
+	cond      *sync.Cond // Signalled when conn is replaced or the session ends
+	conn      net.Conn   // nil while the client is away
+	received  int64      // Bytes read from the client
+	replay    *network.ReplayBuffer
+	awayAt    int64       // Bytes sent to the client when it went away
+	awaySince time.Time   // When the client went away
+	grace     *time.Timer // Ends the session unless the client resumes in time
+	ended     bool
+}
+
+// relayResumable starts relaying a session whose clients may resume. The
+// caller must hold s.mu.
+func (s *RelayServer) relayResumable(session *Session, grace time.Duration, buffer int) {
+	r := &resumable{session: session, grace: grace, buffer: buffer}
+	for i := range r.legs {
+		l := &leg{conn: session.Clients[i], replay: network.NewReplayBuffer(buffer)}
+		l.cond = sync.NewCond(&l.mu)
+		r.legs[i] = l
+	}
+	session.resume = r
+	go s.relayLeg(r, 0)
+	go s.relayLeg(r, 1)
+}
+
+// relayLeg relays what the client in slot from sends to the other client,
+// following the sender across reconnections.
+func (s *RelayServer) relayLeg(r *resumable, from int) {
+	atomic.AddInt64(&activePipes, 1)
+	defer atomic.AddInt64(&activePipes, -1)
+
+	if from == 1 {
+		s.announceObserver(r.session)
+	}
+	src := r.legs[from]
+	limit := s.settings.Load().maxDataRelayed
+	progress := s.quotaTracker(r.session, from, limit)
+	// What we drop is sent again, so only what we accept counts against the
+	// client's rate, and its framing carries over to its next connection.
+	rate, frames := s.clientRate(r.session, from), &frameCounter{}
+	var relayed int64
+	started := time.Now()
+	defer func() {
+		if elapsed := time.Since(started).Seconds(); relayed > 0 && elapsed > 0 {
+			pipeThroughput.observe(float64(relayed) / elapsed)
+		}
+	}()
+
+	pooled := relayBuffers.Get().(*[]byte)
+	defer relayBuffers.Put(pooled)
+	buf := *pooled
+	for {
+		conn := src.await()
+		if conn == nil {
+			return
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(5 * time.Minute)); err != nil {
+			s.clientAway(r, from, conn)
+			continue
+		}
+		if rate != nil {
+			if err := rate.wait(); err != nil {
+				s.endResumable(r)
+				return
+			}
+		}
+		n, err := conn.Read(buf[:min(int64(len(buf)), limit-relayed)])
+		// Bytes read from a connection that was replaced meanwhile are dropped;
+		// the client sends them again, since we did not count them as received.
+		if n > 0 && src.accept(conn, n) {
+			if rate != nil {
+				rate.charge(frames.count(buf[:n]), n)
+			}
+			relayed += int64(n)
+			atomic.AddInt64(&bytesRelayed, int64(n))
+			s.deliver(r, 1-from, buf[:n])
+			if progress(relayed) {
+				log.Println("Data relay finished for a session.")
+				s.endResumable(r)
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if !src.isCurrent(conn) {
+			continue
+		}
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) && netErr.Timeout() && r.session.pinging(from):
+			// Idle, but its pings show the client is still there.
+			continue
+		case errors.As(err, &netErr) && netErr.Timeout():
+			log.Println("A session timed out due to 5 minutes of inactivity.")
+			s.endResumable(r)
+			return
+		case errors.Is(err, io.EOF):
+			// The client hung up rather than losing its connection.
+			s.endResumable(r)
+			return
+		}
+		s.clientAway(r, from, conn)
+	}
+}
+
+// await returns the client's current connection, waiting while it is away.
+// It returns nil once the session has ended.
+func (l *leg) await() net.Conn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.conn == nil && !l.ended {
+		l.cond.Wait()
+	}
+	if l.ended {
+		return nil
+	}
+	return l.conn
+}
+
+// accept counts n bytes read from conn as received, unless conn is no longer
+// the client's connection.
+func (l *leg) accept(conn net.Conn, n int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != conn {
+		return false
+	}
+	l.received += int64(n)
+	return true
+}
+
+func (l *leg) isCurrent(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conn == conn
+}
+
+// deliver sends p to the client in slot to, or keeps it for the client's
+// return if it is away.
+func (s *RelayServer) deliver(r *resumable, to int, p []byte) {
+	dst := r.legs[to]
+	dst.writeMu.Lock()
+	defer dst.writeMu.Unlock()
+
+	dst.mu.Lock()
+	dst.replay.Write(p)
+	conn := dst.conn
+	backlog := dst.replay.End() - dst.awayAt
+	dst.mu.Unlock()
+
+	if conn == nil {
+		if backlog > int64(r.buffer) {
+			log.Printf("Ending session '%s': more data arrived for an absent client than the relay holds.", r.session.ID)
+			s.endResumable(r)
+			return
+		}
+		// The sender can hold back until its peer is back.
+		s.warnLimit(r.session, 1-to, "resume-buffer", backlog, int64(r.buffer))
+		return
+	}
+	// A client that stops reading is treated as away, and what it missed
+	// waits in its replay buffer like anything sent while it is gone.
+	conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if _, err := (meteredWriter{conn}).Write(p); err != nil {
+		s.clientAway(r, to, conn)
+	}
+}
+
+// clientAway marks the client in slot as away after conn failed, and gives it
+// the grace period to resume.
+func (s *RelayServer) clientAway(r *resumable, slot int, conn net.Conn) {
+	l := r.legs[slot]
+	l.mu.Lock()
+	if l.conn != conn || l.ended {
+		l.mu.Unlock()
+		return
+	}
+	l.conn = nil
+	l.awayAt = l.replay.End()
+	l.awaySince = time.Now()
+	l.grace = time.AfterFunc(r.grace, func() {
+		l.mu.Lock()
+		away := l.conn == nil && !l.ended
+		l.mu.Unlock()
+		if away {
+			log.Printf("Ending session '%s': a client did not come back within %s.", r.session.ID, r.grace)
+			s.endResumable(r)
+		}
+	})
+	l.mu.Unlock()
+	conn.Close()
+	log.Printf("A client of session '%s' lost its connection; holding the session for %s.", r.session.ID, r.grace)
+}
+
+// endResumable closes both clients' connections and the session.
+func (s *RelayServer) endResumable(r *resumable) {
+	r.endOnce.Do(func() {
+		for _, l := range r.legs {
+			l.mu.Lock()
+			l.ended = true
+			if l.grace != nil {
+				l.grace.Stop()
+			}
+			if l.conn != nil {
+				l.conn.Close()
+			}
+			l.cond.Broadcast()
+			l.mu.Unlock()
+		}
+		s.closeSession(r.session)
+	})
+}
+
+// presence describes the client in slot, for the other client.
+func (r *resumable) presence(slot int) network.Presence {
+	l := r.legs[slot]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return network.Presence{PeerAway: time.Since(l.awaySince).Round(time.Second)}
+	}
+	return network.Presence{PeerConnected: true}
+}
+
+// resumeClient hands a client that lost its connection a new one. received is
+// how much the client had read from the relay, which sends it everything after
+// that and tells it how much of its own data arrived. If the client asks for
+// presence, the reply also tells it whether its peer is still connected.
+func (s *RelayServer) resumeClient(conn net.Conn, sessionID, noticeKey string, received int64, presence bool) {
+	refuse := func(reason string) {
+		conn.Write([]byte("Error: " + reason + "\n"))
+		conn.Close()
+	}
+
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	slot := -1
+	if exists && session.resume != nil {
+		slot = session.noticeSlot(noticeKey)
+	}
+	s.mu.Unlock()
+	if slot < 0 {
+		refuse("Session cannot be resumed")
+		return
+	}
+	l := session.resume.legs[slot]
+
+	// Close the old connection first, so a write blocked on it gives up writeMu.
+	l.mu.Lock()
+	old := l.conn
+	l.mu.Unlock()
+	if old != nil {
+		s.clientAway(session.resume, slot, old)
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	l.mu.Lock()
+	missed, ok := l.replay.Since(received)
+	if l.ended || !ok {
+		l.mu.Unlock()
+		refuse("Session cannot be resumed")
+		return
+	}
+	ours := l.received
+	l.conn = conn
+	l.grace.Stop()
+	l.cond.Broadcast()
+	l.mu.Unlock()
+	// Nothing is held for the client any more, so its peer is warned afresh
+	// the next time it is away.
+	session.limitWarning("resume-buffer", 1-slot, 0, int64(session.resume.buffer))
+
+	reply := fmt.Sprintf("Resumed: %d", ours)
+	if presence {
+		snapshot, _ := json.Marshal(session.resume.presence(1 - slot))
+		reply += " " + string(snapshot)
+	}
+	log.Printf("A client of session '%s' resumed; sending it %d bytes it missed.", sessionID, len(missed))
+	conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+	if _, err := conn.Write(append([]byte(reply+"\n"), missed...)); err != nil {
+		s.clientAway(session.resume, slot, conn)
+	}
+}
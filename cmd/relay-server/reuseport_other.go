@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// reusePort is unavailable on this platform.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("-reuse-port is not supported on this platform")
+}
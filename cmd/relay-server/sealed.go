@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bjarneo/jot/internal/crypto"
+)
+
+// The relay can encrypt the state it keeps on disk, so a copy of the disk or
+// a backup does not reveal it. The key comes from the environment, where a
+// secret manager or KMS agent can place it without it ever touching the disk.
+
+// stateKeyEnv names the environment variable holding the key, as 64 hex digits.
+const stateKeyEnv = "JOT_RELAY_STATE_KEY"
+
+// sealedHeader starts every file the relay encrypted.
+const sealedHeader = "jot-sealed-v1\n"
+
+// loadStateKey returns the key in stateKeyEnv, or nil if it is not set.
+func loadStateKey() ([]byte, error) {
+	value := strings.TrimSpace(os.Getenv(stateKeyEnv))
+	if value == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s must be 64 hex digits (e.g. from `openssl rand -hex 32`)", stateKeyEnv)
+	}
+	return key, nil
+}
+
+// isSealed reports whether data was written by seal.
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedHeader))
+}
+
+// seal encrypts data with key. purpose is authenticated along with it, so a
+// file sealed for one purpose cannot be passed off as another.
+func seal(key, data []byte, purpose string) ([]byte, error) {
+	sealed, err := crypto.Encrypt(crypto.CipherXChaCha20Poly1305, data, key, []byte(purpose))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(sealedHeader), sealed...), nil
+}
+
+// unseal decrypts data written by seal with the same key and purpose.
+func unseal(key, data []byte, purpose string) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("the file is encrypted; set %s to the key it was written with", stateKeyEnv)
+	}
+	plain, err := crypto.Decrypt(data[len(sealedHeader):], key, []byte(purpose))
+	if err != nil {
+		return nil, fmt.Errorf("the file cannot be decrypted with the key in %s", stateKeyEnv)
+	}
+	return plain, nil
+}
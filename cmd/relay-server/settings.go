@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// settings are the relay's limits and access lists. They can be replaced while
+// the relay runs; connections that are already open keep the limits they started with.
+type settings struct {
+	maxDataRelayed     int64           // Bytes each client of a session may send
+	forwardTargets     map[string]bool // Relays that FORWARD may connect to; empty disables forwarding
+	federationPeers    map[string]bool // Relays whose sessions our clients may join
+	workBits           int             // Leading zero bits of proof of work demanded before CREATE; 0 disables
+	workOnJoin         bool            // Also demand proof of work before JOIN
+	reserveFor         time.Duration   // How long a session name stays held for its owner after the session ends; 0 disables
+	resumeGrace        time.Duration   // How long a client may take to resume after losing its connection; 0 disables
+	resumeBuffer       int             // Bytes held for each client of a resumable session
+	directory          bool            // Keep a public directory of sessions their owners listed
+	blobStore          int64           // Bytes of uploaded files kept for download; 0 disables uploads
+	blobTTL            time.Duration   // How long an uploaded file is kept
+	maxGoroutines      int             // Goroutines past which new work is refused; 0 disables
+	maxOpenFDs         int             // Open file descriptors past which new work is refused; 0 disables
+	maxSessionMemory   int64           // Estimated bytes of session memory past which new work is refused; 0 disables
+	rateMessages       float64         // Frames per second each client may send; 0 disables
+	rateBytes          int64           // Bytes per second each client may send; 0 disables
+	rateDisconnect     time.Duration   // How long a client may stay over its rate before it is disconnected; 0 never
+	maxHandshakes      int             // Connections that may be waiting for their command at once; 0 disables
+	maxHandshakesPerIP int             // The same, from one address; 0 disables
+}
+
+// settingsFile is the JSON file given with -config. Every field is optional and
+// overrides the matching flag.
+type settingsFile struct {
+	MaxDataRelayedMB   *int64   `json:"maxDataRelayedMB"`
+	AllowForward       []string `json:"allowForward"`
+	FederationPeers    []string `json:"federationPeers"`
+	PowBits            *int     `json:"powBits"`
+	PowJoin            *bool    `json:"powJoin"`
+	ReserveNames       *string  `json:"reserveNames"` // A duration such as "24h"
+	ResumeGrace        *string  `json:"resumeGrace"`  // A duration such as "30s"
+	ResumeBufferKB     *int     `json:"resumeBufferKB"`
+	Directory          *bool    `json:"directory"`
+	BlobStoreMB        *int64   `json:"blobStoreMB"`
+	BlobTTL            *string  `json:"blobTTL"` // A duration such as "1h"
+	MaxGoroutines      *int     `json:"maxGoroutines"`
+	MaxOpenFDs         *int     `json:"maxOpenFDs"`
+	MaxSessionMemoryMB *int64   `json:"maxSessionMemoryMB"`
+	RateMsgs           *float64 `json:"rateMsgs"`
+	RateKB             *int64   `json:"rateKB"`
+	RateDisconnect     *string  `json:"rateDisconnect"` // A duration such as "30s"
+	MaxHandshakes      *int     `json:"maxHandshakes"`
+	MaxHandshakesPerIP *int     `json:"maxHandshakesPerIP"`
+}
+
+// loadSettings reads path and applies it on top of base, which holds the flag values.
+func loadSettings(path string, base settings) (*settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	loaded := base
+	if file.MaxDataRelayedMB != nil {
+		loaded.maxDataRelayed = *file.MaxDataRelayedMB * 1024 * 1024
+	}
+	if file.AllowForward != nil {
+		loaded.forwardTargets = toSet(file.AllowForward)
+	}
+	if file.FederationPeers != nil {
+		loaded.federationPeers = toSet(file.FederationPeers)
+	}
+	if file.PowBits != nil {
+		loaded.workBits = *file.PowBits
+	}
+	if file.PowJoin != nil {
+		loaded.workOnJoin = *file.PowJoin
+	}
+	if file.ReserveNames != nil {
+		if loaded.reserveFor, err = time.ParseDuration(*file.ReserveNames); err != nil {
+			return nil, fmt.Errorf("invalid reserveNames in %s: %w", path, err)
+		}
+	}
+	if file.ResumeGrace != nil {
+		if loaded.resumeGrace, err = time.ParseDuration(*file.ResumeGrace); err != nil {
+			return nil, fmt.Errorf("invalid resumeGrace in %s: %w", path, err)
+		}
+	}
+	if file.ResumeBufferKB != nil {
+		loaded.resumeBuffer = *file.ResumeBufferKB * 1024
+	}
+	if file.Directory != nil {
+		loaded.directory = *file.Directory
+	}
+	if file.BlobStoreMB != nil {
+		loaded.blobStore = *file.BlobStoreMB * 1024 * 1024
+	}
+	if file.BlobTTL != nil {
+		if loaded.blobTTL, err = time.ParseDuration(*file.BlobTTL); err != nil {
+			return nil, fmt.Errorf("invalid blobTTL in %s: %w", path, err)
+		}
+	}
+	if file.MaxGoroutines != nil {
+		loaded.maxGoroutines = *file.MaxGoroutines
+	}
+	if file.MaxOpenFDs != nil {
+		loaded.maxOpenFDs = *file.MaxOpenFDs
+	}
+	if file.MaxSessionMemoryMB != nil {
+		loaded.maxSessionMemory = *file.MaxSessionMemoryMB * 1024 * 1024
+	}
+	if file.RateMsgs != nil {
+		loaded.rateMessages = *file.RateMsgs
+	}
+	if file.RateKB != nil {
+		loaded.rateBytes = *file.RateKB * 1024
+	}
+	if file.RateDisconnect != nil {
+		if loaded.rateDisconnect, err = time.ParseDuration(*file.RateDisconnect); err != nil {
+			return nil, fmt.Errorf("invalid rateDisconnect in %s: %w", path, err)
+		}
+	}
+	if file.MaxHandshakes != nil {
+		loaded.maxHandshakes = *file.MaxHandshakes
+	}
+	if file.MaxHandshakesPerIP != nil {
+		loaded.maxHandshakesPerIP = *file.MaxHandshakesPerIP
+	}
+	if err := loaded.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &loaded, nil
+}
+
+func (cfg *settings) validate() error {
+	if cfg.maxDataRelayed <= 0 {
+		return fmt.Errorf("the data limit must be positive")
+	}
+	if cfg.workBits < 0 || cfg.workBits > network.MaxWorkBits {
+		return fmt.Errorf("proof-of-work bits must be between 0 and %d", network.MaxWorkBits)
+	}
+	if cfg.reserveFor < 0 {
+		return fmt.Errorf("the name reservation period may not be negative")
+	}
+	if cfg.resumeGrace < 0 {
+		return fmt.Errorf("the resume grace period may not be negative")
+	}
+	if cfg.resumeBuffer <= 0 {
+		return fmt.Errorf("the resume buffer must be positive")
+	}
+	if cfg.blobStore < 0 {
+		return fmt.Errorf("the file store may not be negative")
+	}
+	if cfg.blobTTL <= 0 {
+		return fmt.Errorf("the time files are kept must be positive")
+	}
+	if cfg.maxGoroutines < 0 || cfg.maxOpenFDs < 0 || cfg.maxSessionMemory < 0 {
+		return fmt.Errorf("capacity limits may not be negative")
+	}
+	if cfg.rateMessages < 0 || cfg.rateBytes < 0 || cfg.rateDisconnect < 0 {
+		return fmt.Errorf("rate limits may not be negative")
+	}
+	if cfg.maxHandshakes < 0 || cfg.maxHandshakesPerIP < 0 {
+		return fmt.Errorf("handshake limits may not be negative")
+	}
+	return nil
+}
+
+// reloadOnHangup re-reads the settings file whenever the relay receives SIGHUP.
+// A file that fails to load leaves the current settings in place.
+func (s *RelayServer) reloadOnHangup(path string, base settings) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		loaded, err := loadSettings(path, base)
+		if err != nil {
+			log.Printf("Keeping the current settings; could not reload them: %v", err)
+			continue
+		}
+		s.settings.Store(loaded)
+		log.Printf("Reloaded settings from %s.", path)
+	}
+}
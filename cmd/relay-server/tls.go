@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// tlsPolicy holds the -tls-min-version, -tls-ciphers, -tls-alpn and
+// -tls-ticket-rotation flags, which apply to every TLS listener.
+type tlsPolicy struct {
+	minVersion     uint16
+	cipherSuites   []uint16      // TLS 1.2 suites, in order of preference; nil keeps Go's defaults
+	nextProtos     []string      // ALPN protocols offered, in order of preference
+	ticketRotation time.Duration // How often session ticket keys are replaced; 0 leaves it to Go
+}
+
+// parseTLSPolicy validates the TLS flags. Only suites Go considers secure may
+// be named, and none at all when TLS 1.3 is the minimum, since Go does not let
+// TLS 1.3 suites be chosen.
+func parseTLSPolicy(minVersion, ciphers, alpn string, ticketRotation time.Duration) (tlsPolicy, error) {
+	var policy tlsPolicy
+	switch minVersion {
+	case "1.2":
+		policy.minVersion = tls.VersionTLS12
+	case "1.3":
+		policy.minVersion = tls.VersionTLS13
+	default:
+		return tlsPolicy{}, fmt.Errorf("-tls-min-version must be 1.2 or 1.3, not %q", minVersion)
+	}
+
+	names := splitList(ciphers)
+	if len(names) > 0 && policy.minVersion == tls.VersionTLS13 {
+		return tlsPolicy{}, fmt.Errorf("-tls-ciphers only applies to TLS 1.2, and -tls-min-version is 1.3")
+	}
+	known := make(map[string]uint16)
+	var available []string
+	for _, suite := range tls.CipherSuites() {
+		if slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			known[suite.Name] = suite.ID
+			available = append(available, suite.Name)
+		}
+	}
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return tlsPolicy{}, fmt.Errorf("unknown or insecure cipher suite %q in -tls-ciphers; choose from %s", name, strings.Join(available, ", "))
+		}
+		policy.cipherSuites = append(policy.cipherSuites, id)
+	}
+
+	policy.nextProtos = splitList(alpn)
+	if ticketRotation < 0 {
+		return tlsPolicy{}, fmt.Errorf("-tls-ticket-rotation must not be negative")
+	}
+	policy.ticketRotation = ticketRotation
+	return policy, nil
+}
+
+// loadTLSConfig builds the relay's TLS configuration from -tls-cert, -tls-key
+// and -client-ca. It returns nil when TLS is not configured, in which case the
+// relay serves plain TCP and TLS is expected to be terminated in front of it.
+// With a client CA, only clients presenting a certificate it signed get in.
+func loadTLSConfig(certFile, keyFile, clientCAFile string, policy tlsPolicy) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS needs both -tls-cert and -tls-key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   policy.minVersion,
+		CipherSuites: policy.cipherSuites,
+		NextProtos:   policy.nextProtos,
+	}
+
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// rotateTicketKeys replaces the session ticket keys of configs every interval.
+// Tickets issued under the previous key are still accepted, so a client
+// resuming just after a rotation does not need a full handshake.
+func rotateTicketKeys(configs []*tls.Config, every time.Duration) {
+	var current, previous [32]byte
+	rotate := func() {
+		previous = current
+		rand.Read(current[:])
+		keys := [][32]byte{current}
+		if previous != ([32]byte{}) {
+			keys = append(keys, previous)
+		}
+		for _, config := range configs {
+			config.SetSessionTicketKeys(keys)
+		}
+	}
+	rotate()
+	go func() {
+		for range time.Tick(every) {
+			rotate()
+		}
+	}()
+}
+
+// checkTLS logs the effective TLS settings of a listener and how its
+// certificate stands, so an operator sees at startup what clients are offered.
+// It warns about certificates that have expired or are about to.
+func checkTLS(spec listenSpec, policy tlsPolicy) {
+	config := spec.tls
+	version := "TLS 1.2"
+	if config.MinVersion == tls.VersionTLS13 {
+		version = "TLS 1.3"
+	}
+	suites := "Go defaults"
+	switch {
+	case config.MinVersion == tls.VersionTLS13:
+		suites = "those of TLS 1.3"
+	case len(config.CipherSuites) > 0:
+		names := make([]string, len(config.CipherSuites))
+		for i, id := range config.CipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		suites = strings.Join(names, ", ")
+	}
+	alpn := "none"
+	if len(config.NextProtos) > 0 {
+		alpn = strings.Join(config.NextProtos, ", ")
+	}
+	tickets := "keys rotated by Go"
+	if policy.ticketRotation > 0 {
+		tickets = "keys rotated every " + policy.ticketRotation.String()
+	}
+	clients := "not required"
+	if config.ClientAuth == tls.RequireAndVerifyClientCert {
+		clients = "required"
+	}
+	log.Printf("TLS on %s: minimum %s; cipher suites: %s; ALPN: %s; session tickets: %s; client certificates: %s", spec.addr, version, suites, alpn, tickets, clients)
+
+	leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if err != nil {
+		log.Printf("Warning: could not parse the TLS certificate of %s: %v", spec.addr, err)
+		return
+	}
+	names := leaf.DNSNames
+	if len(names) == 0 {
+		names = []string{leaf.Subject.CommonName}
+	}
+	switch left := time.Until(leaf.NotAfter); {
+	case left <= 0:
+		log.Printf("Warning: the TLS certificate of %s (%s) expired on %s; clients will refuse to connect.", spec.addr, strings.Join(names, ", "), leaf.NotAfter.Format(time.DateOnly))
+	case left < 30*24*time.Hour:
+		log.Printf("Warning: the TLS certificate of %s (%s) expires on %s.", spec.addr, strings.Join(names, ", "), leaf.NotAfter.Format(time.DateOnly))
+	default:
+		log.Printf("TLS certificate of %s: %s, valid until %s", spec.addr, strings.Join(names, ", "), leaf.NotAfter.Format(time.DateOnly))
+	}
+}
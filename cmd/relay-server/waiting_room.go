@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxPendingJoiners  = 5               // Joiners that may wait for approval per session
+	joinRequestTimeout = 5 * time.Minute // How long a joiner waits for the owner's decision
+	maxIntroNickname   = 64              // Bytes of a joiner's nickname passed on to the owner
+	maxKnockNote       = 200             // Bytes of a knock note passed on to the owner
+	knockTimeout       = 2 * time.Minute // How long a joiner may take to write a knock note
+)
+
+// joinRequest is a client parked in a session's waiting room.
+type joinRequest struct {
+	id          string
+	conn        net.Conn
+	nickname    string
+	fingerprint string
+	noticeKey   string // Sent with JOIN, for NOTICES once the client is in
+	note        string // Knock note, for a locked session
+	observer    bool   // The client asked to join as an observer
+	parkedAt    time.Time
+	timer       *time.Timer
+}
+
+// controlEvent is a line sent to the owner over a WATCH connection, or to a
+// client over its NOTICES connection.
+type controlEvent struct {
+	Event       string `json:"event"` // "join-request", "join-cancelled", "quota", "limit", "throttled", "pong", "owner", "observer" or "error"
+	RequestID   string `json:"requestID,omitempty"`
+	Nickname    string `json:"nickname,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Note        string `json:"note,omitempty"`     // A knock on a locked session
+	Observer    bool   `json:"observer,omitempty"` // The joiner asks to observe
+	Message     string `json:"message,omitempty"`
+
+	// Relayed and Limit describe a client's data budget, for "quota" notices.
+	// Limit is also the size of the limit named by Resource, and Used how much
+	// of it is taken, for "limit" notices (see limits.go).
+	Relayed  int64  `json:"relayed,omitempty"`
+	Limit    int64  `json:"limit,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Used     int64  `json:"used,omitempty"`
+
+	Seq   int64 `json:"seq,omitempty"`   // The ping a "pong" answers
+	Owner bool  `json:"owner,omitempty"` // With "owner", the client is now the session owner
+
+	// Peer, with "throttled", says it is the client's peer that sends too fast;
+	// Disconnect says the relay cut it off for it rather than slowing it down.
+	Peer       bool `json:"peer,omitempty"`
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// controlConn is the owner's WATCH connection. Writes are serialized because
+// events are sent from the goroutines of joining clients.
+type controlConn struct {
+	conn net.Conn
+	mu   sync.Mutex
+}
+
+func (c *controlConn) send(event controlEvent) {
+	if c == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		c.conn.Close()
+	}
+}
+
+// joinIntro is how a parked client introduces itself to the session owner.
+type joinIntro struct {
+	Nickname    string `json:"nickname"`
+	Fingerprint string `json:"fingerprint"`
+	Note        string `json:"note,omitempty"`
+}
+
+// parkJoiner sends prompt, asking conn to introduce itself within timeout, and
+// then holds it in the session's waiting room until the owner admits or denies
+// it. fingerprint is the identity the client proved with JOIN, if any, and
+// observer whether it asked to observe. The caller must hold s.mu.
+func (s *RelayServer) parkJoiner(conn net.Conn, reader *bufio.Reader, session *Session, noticeKey, fingerprint string, observer bool, prompt string, timeout time.Duration) {
+	if len(session.pending) >= maxPendingJoiners {
+		log.Printf("Refused a joiner for session '%s': the waiting room is full.", session.ID)
+		conn.Write([]byte("Error: Too many people are waiting to join this session\n"))
+		conn.Close()
+		return
+	}
+	conn.Write([]byte(prompt + "\n"))
+	go s.awaitIntro(conn, reader, session, noticeKey, fingerprint, observer, timeout)
+}
+
+// awaitIntro reads a parked client's introduction and passes it on to the owner.
+// An identity proven with JOIN replaces the one the introduction claims.
+func (s *RelayServer) awaitIntro(conn net.Conn, reader *bufio.Reader, session *Session, noticeKey, proven string, observer bool, timeout time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := reader.ReadSlice('\n')
+	conn.SetReadDeadline(time.Time{})
+	var intro joinIntro
+	if err != nil || json.Unmarshal(line, &intro) != nil {
+		log.Println("A parked joiner did not introduce itself.")
+		conn.Close()
+		return
+	}
+	if len(intro.Nickname) > maxIntroNickname {
+		intro.Nickname = intro.Nickname[:maxIntroNickname]
+	}
+	if len(intro.Note) > maxKnockNote {
+		intro.Note = intro.Note[:maxKnockNote]
+	}
+	if _, err := hex.DecodeString(intro.Fingerprint); err != nil || len(intro.Fingerprint) > 64 {
+		intro.Fingerprint = ""
+	}
+	if proven != "" {
+		intro.Fingerprint = proven
+	}
+
+	s.mu.Lock()
+	if s.sessions[session.ID] != session || session.Clients[1] != nil || len(session.pending) >= maxPendingJoiners {
+		s.mu.Unlock()
+		conn.Write([]byte("Error: Session not found or full\n"))
+		conn.Close()
+		return
+	}
+	if session.isBanned(intro.Fingerprint, conn) {
+		s.mu.Unlock()
+		log.Printf("Refused a banned client from session '%s'.", session.ID)
+		conn.Write([]byte("Error: You are banned from this session\n"))
+		conn.Close()
+		return
+	}
+	req := &joinRequest{id: generateShortID(16), conn: conn, nickname: intro.Nickname, fingerprint: intro.Fingerprint, noticeKey: noticeKey, note: intro.Note, observer: observer, parkedAt: time.Now()}
+	if session.pending == nil {
+		session.pending = make(map[string]*joinRequest)
+	}
+	session.pending[req.id] = req
+	req.timer = time.AfterFunc(joinRequestTimeout, func() {
+		if s.removeJoinRequest(session, req.id) != nil {
+			conn.Write([]byte("Error: The session owner did not respond\n"))
+			conn.Close()
+		}
+	})
+	control := session.control
+	warning, warn := session.limitWarning("waiting-room", 0, int64(len(session.pending)), maxPendingJoiners)
+	s.mu.Unlock()
+
+	log.Printf("A client is waiting to join session '%s'.", session.ID)
+	control.send(req.event())
+	if warn {
+		control.send(warning)
+	}
+}
+
+func (r *joinRequest) event() controlEvent {
+	return controlEvent{Event: "join-request", RequestID: r.id, Nickname: r.nickname, Fingerprint: r.fingerprint, Note: r.note, Observer: r.observer}
+}
+
+// removeJoinRequest takes a request out of the waiting room and tells the owner.
+// It returns nil if the request was already decided.
+func (s *RelayServer) removeJoinRequest(session *Session, id string) *joinRequest {
+	s.mu.Lock()
+	req, ok := session.pending[id]
+	if ok {
+		delete(session.pending, id)
+		req.timer.Stop()
+		session.limitWarning("waiting-room", 0, int64(len(session.pending)), maxPendingJoiners)
+	}
+	control := session.control
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	control.send(controlEvent{Event: "join-cancelled", RequestID: id})
+	return req
+}
+
+// watchSession turns conn into the owner's control connection for a session:
+// the relay reports join requests on it, and the owner answers with ADMIT, DENY
+// or BAN, or locks and unlocks the session with LOCK and UNLOCK.
+func (s *RelayServer) watchSession(conn net.Conn, reader *bufio.Reader, sessionID, ownerKey string) {
+	s.mu.Lock()
+	session, exists := s.sessions[sessionID]
+	if !exists || !session.isOwner(ownerKey) {
+		s.mu.Unlock()
+		log.Println("Refused a watch request that does not come from the session owner.")
+		conn.Write([]byte("Error: Session not found or you are not its owner\n"))
+		conn.Close()
+		return
+	}
+	if session.control != nil {
+		session.control.conn.Close()
+	}
+	control := &controlConn{conn: conn}
+	session.control = control
+	var waiting []controlEvent
+	for _, req := range session.pending {
+		waiting = append(waiting, req.event())
+	}
+	s.mu.Unlock()
+
+	conn.Write([]byte(fmt.Sprintf("Watching: %s\n", s.qualify(sessionID))))
+	for _, event := range waiting {
+		control.send(event)
+	}
+
+	defer func() {
+		s.mu.Lock()
+		if session.control == control {
+			session.control = nil
+		}
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var cmd struct {
+			Command   string `json:"command"`
+			RequestID string `json:"requestID"`
+			IP        bool   `json:"ip"` // With BAN, ban the client's IP address too
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+			return
+		}
+		var err error
+		switch cmd.Command {
+		case "ADMIT":
+			err = s.admit(session, cmd.RequestID)
+		case "DENY":
+			err = s.deny(session, cmd.RequestID)
+		case "BAN":
+			err = s.ban(session, cmd.RequestID, cmd.IP)
+		case "LOCK":
+			err = s.setLocked(session, true)
+		case "UNLOCK":
+			err = s.setLocked(session, false)
+		default:
+			atomic.AddInt64(&unknownCommands, 1)
+			err = fmt.Errorf("unknown command %q", cmd.Command)
+		}
+		if err != nil {
+			control.send(controlEvent{Event: "error", RequestID: cmd.RequestID, Message: err.Error()})
+		}
+	}
+}
+
+// admit lets a parked client join the session. Everyone else still waiting is
+// turned away, since the session is full from then on.
+func (s *RelayServer) admit(session *Session, id string) error {
+	s.mu.Lock()
+	req, ok := session.pending[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("no pending request %s", id)
+	}
+	if s.sessions[session.ID] != session || session.Clients[1] != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("session is no longer open")
+	}
+	delete(session.pending, id)
+	req.timer.Stop()
+	session.observer = req.observer
+	s.completeJoin(session, req.conn, req.noticeKey)
+	others := session.pending
+	session.pending = nil
+	control := session.control
+	s.mu.Unlock()
+
+	for otherID, other := range others {
+		other.timer.Stop()
+		other.conn.Write([]byte("Error: Session not found or full\n"))
+		other.conn.Close()
+		control.send(controlEvent{Event: "join-cancelled", RequestID: otherID})
+	}
+	return nil
+}
+
+// deny turns a parked client away.
+func (s *RelayServer) deny(session *Session, id string) error {
+	req := s.removeJoinRequest(session, id)
+	if req == nil {
+		return fmt.Errorf("no pending request %s", id)
+	}
+	log.Printf("The owner of session '%s' denied a join request.", session.ID)
+	req.conn.Write([]byte("Error: The session owner declined your request to join\n"))
+	req.conn.Close()
+	return nil
+}
+
+// ban turns a parked client away and keeps its identity key, and its IP
+// address if banIP is set, from joining again for as long as the session lasts.
+func (s *RelayServer) ban(session *Session, id string, banIP bool) error {
+	s.mu.Lock()
+	req, ok := session.pending[id]
+	if ok && req.fingerprint == "" && !banIP {
+		s.mu.Unlock()
+		return fmt.Errorf("the client has no identity key; ban its IP address instead")
+	}
+	if ok {
+		if session.bannedKeys == nil {
+			session.bannedKeys = make(map[string]bool)
+			session.bannedIPs = make(map[string]bool)
+		}
+		if req.fingerprint != "" {
+			session.bannedKeys[req.fingerprint] = true
+		}
+		if ip := remoteIP(req.conn); banIP && ip != "" {
+			session.bannedIPs[ip] = true
+		}
+	}
+	s.mu.Unlock()
+
+	req = s.removeJoinRequest(session, id)
+	if req == nil {
+		return fmt.Errorf("no pending request %s", id)
+	}
+	log.Printf("The owner of session '%s' banned a client.", session.ID)
+	req.conn.Write([]byte("Error: You are banned from this session\n"))
+	req.conn.Close()
+	return nil
+}
+
+// isBanned reports whether the owner banned fingerprint, which may be empty,
+// or the IP address conn comes from. The caller must hold s.mu.
+func (session *Session) isBanned(fingerprint string, conn net.Conn) bool {
+	if fingerprint != "" && session.bannedKeys[fingerprint] {
+		return true
+	}
+	ip := remoteIP(conn)
+	return ip != "" && session.bannedIPs[ip]
+}
+
+// remoteIP returns the IP address conn comes from, or "" if it has none.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// setLocked locks or unlocks a session. Unlocking an ordinary session lets in
+// whoever knocked first, since they were only held back by the lock; in a
+// session with a waiting room they keep waiting for the owner's decision.
+func (s *RelayServer) setLocked(session *Session, locked bool) error {
+	s.mu.Lock()
+	session.locked = locked
+	var first *joinRequest
+	if !locked && !session.waitingRoom {
+		for _, req := range session.pending {
+			if first == nil || req.parkedAt.Before(first.parkedAt) {
+				first = req
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if locked {
+		log.Printf("Session '%s' was locked by its owner.", session.ID)
+	} else {
+		log.Printf("Session '%s' was unlocked by its owner.", session.ID)
+	}
+	if first == nil {
+		return nil
+	}
+	return s.admit(session, first.id)
+}
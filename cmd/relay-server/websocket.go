@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket listeners carry the relay's usual protocol, JSON lines followed by
+// frames, for clients that cannot open a raw TCP connection, such as browsers
+// or clients behind proxies that only pass HTTP. The protocol is a byte stream
+// and WebSocket only transports it: what arrives in the messages a client
+// sends, binary or text, is read in order as if it came over TCP, and every
+// write to the client is sent as one binary message. Once upgraded, a
+// WebSocket connection is handled like any other.
+
+// wsGUID is the value RFC 6455 appends to a client's key to accept it.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSControlPayload is the largest payload RFC 6455 allows a control frame.
+const maxWSControlPayload = 125
+
+// WebSocket opcodes.
+const (
+	wsContinuation byte = 0x0
+	wsText         byte = 0x1
+	wsBinary       byte = 0x2
+	wsClose        byte = 0x8
+	wsPing         byte = 0x9
+	wsPong         byte = 0xA
+)
+
+// wsListener accepts WebSocket upgrades on an HTTP listener and hands out the
+// upgraded connections.
+type wsListener struct {
+	inner  net.Listener
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// listenWebSocket serves WebSocket upgrades on inner, on any path.
+func listenWebSocket(inner net.Listener) net.Listener {
+	l := &wsListener{inner: inner, conns: make(chan net.Conn), closed: make(chan struct{})}
+	server := &http.Server{
+		Handler: http.HandlerFunc(l.upgrade),
+		// The same bounds as for the command on a TCP connection. Anything
+		// but an upgrade closes the connection after its answer, so the
+		// HTTP part of a handshake never outlasts handshakeTimeout.
+		ReadHeaderTimeout: handshakeTimeout,
+		ReadTimeout:       handshakeTimeout,
+		WriteTimeout:      handshakeTimeout,
+		IdleTimeout:       handshakeTimeout,
+		MaxHeaderBytes:    maxCommandSize,
+		ErrorLog:          log.New(io.Discard, "", 0),
+	}
+	go func() {
+		server.Serve(inner)
+		l.Close()
+	}()
+	return l
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.inner.Close()
+}
+
+func (l *wsListener) Addr() net.Addr { return l.inner.Addr() }
+
+// upgrade completes the WebSocket handshake and passes the connection on to
+// Accept.
+func (l *wsListener) upgrade(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	// A connection that is not upgraded must not linger on keep-alive while
+	// holding a place among the half-open connections.
+	w.Header().Set("Connection", "close")
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "This is a jot relay; connect with WebSocket", http.StatusUpgradeRequired)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Cannot upgrade this connection", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	// The server may have left its deadlines on the connection.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &wsConn{Conn: conn, reader: rw.Reader}
+	select {
+	case l.conns <- ws:
+	case <-l.closed:
+		ws.Close()
+	}
+}
+
+// headerHas reports whether the comma-separated header name lists token.
+func headerHas(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is an upgraded WebSocket connection, read and written as the byte
+// stream its data messages carry.
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader // Holds anything the client sent after the handshake
+
+	remaining uint64  // Payload bytes of the current data frame still to be read
+	mask      [4]byte // The current frame's masking key
+	masked    int     // Payload bytes of the current frame unmasked so far
+
+	writeMu   sync.Mutex // Serializes writes, which replies to pings make from Read
+	closeOnce sync.Once
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.reader.Read(p[:min(uint64(len(p)), c.remaining)])
+	for i := range n {
+		p[i] ^= c.mask[(c.masked+i)%4]
+	}
+	c.masked += n
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers up to the next data frame, answering the
+// control frames before it. It returns io.EOF once the client closes.
+func (c *wsConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return errors.New("websocket: client frame is not masked")
+	}
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if _, err := io.ReadFull(c.reader, c.mask[:]); err != nil {
+		return err
+	}
+	c.masked = 0
+
+	switch opcode {
+	case wsContinuation, wsText, wsBinary:
+		c.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+		if length > maxWSControlPayload {
+			return errors.New("websocket: control frame too long")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+		switch opcode {
+		case wsClose:
+			c.Close()
+			return io.EOF
+		case wsPing:
+			return c.writeFrame(wsPong, payload)
+		}
+		return nil
+	default:
+		return fmt.Errorf("websocket: unknown opcode %#x", opcode)
+	}
+}
+
+// Write sends p to the client as one binary message.
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends one unmasked, final frame, as servers do.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 2, 10+len(payload))
+	frame[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		frame[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close says goodbye with a close frame, if the connection still takes one,
+// and closes it.
+func (c *wsConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeFrame(wsClose, nil)
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Methods a script may call.
+const (
+	MethodStatus       = "status"       // Describe the session
+	MethodParticipants = "participants" // List who is in the session
+	MethodSend         = "send"         // Send Text to the peer as a chat message
+)
+
+// maxRequest is the longest request line, in bytes, the server reads.
+const maxRequest = 64 * 1024
+
+// Request is one line of JSON sent by a script, answered by one Response line.
+type Request struct {
+	Method string `json:"method"`
+	Text   string `json:"text,omitempty"` // For MethodSend
+}
+
+// Response answers a Request. Error is set when OK is not.
+type Response struct {
+	OK           bool          `json:"ok"`
+	Error        string        `json:"error,omitempty"`
+	Status       *Status       `json:"status,omitempty"`
+	Participants []Participant `json:"participants,omitempty"`
+}
+
+// Status describes the session the client is in.
+type Status struct {
+	State     string `json:"state"`  // "setup", "connecting", "waiting", "chatting" or "disconnected"
+	Status    string `json:"status"` // The status bar's text
+	Relay     string `json:"relay"`
+	SessionID string `json:"sessionID,omitempty"`
+	Label     string `json:"label,omitempty"`
+	Peer      string `json:"peer,omitempty"` // The peer's nickname, or alias if they are a contact
+	Recording bool   `json:"recording,omitempty"`
+	Unknown   int    `json:"unknownMessages,omitempty"` // Messages from the peer of types this version does not know
+}
+
+// Participant is someone in the session.
+type Participant struct {
+	Nickname    string `json:"nickname"`
+	You         bool   `json:"you,omitempty"`
+	Owner       bool   `json:"owner,omitempty"`       // Owns the session
+	Observer    bool   `json:"observer,omitempty"`    // Joined as an observer, who may not post
+	Fingerprint string `json:"fingerprint,omitempty"` // Of the identity key
+	Verified    bool   `json:"verified,omitempty"`
+}
+
+// Failed returns a Response reporting err.
+func Failed(err error) Response {
+	return Response{Error: err.Error()}
+}
+
+// Server serves the API on a Unix socket that only the current user can use.
+type Server struct {
+	path     string
+	listener net.Listener
+}
+
+// Listen creates the API socket at path. It fails if another client is
+// already serving there and cleans up sockets left behind by dead clients.
+func Listen(path string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another client is already serving its API at %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict socket permissions: %w", err)
+	}
+	return &Server{path: path, listener: listener}, nil
+}
+
+// Serve answers the requests of every script that connects with handle,
+// until the server is closed. A script may send any number of requests.
+func (s *Server) Serve(handle func(Request) Response) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go serveConn(conn, handle)
+	}
+}
+
+func serveConn(conn net.Conn, handle func(Request) Response) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequest)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		response := Response{}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			response = Failed(fmt.Errorf("invalid request: %w", err))
+		} else {
+			response = handle(req)
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops serving and removes the socket.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// Call sends req to the client serving its API at path and returns the answer.
+func Call(path string, req Request) (Response, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return Response{}, fmt.Errorf("no client is serving its API at %s: %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, fmt.Errorf("failed to send the request: %w", err)
+	}
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return Response{}, fmt.Errorf("failed to read the response: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bjarneo/jot/internal/filetransfer"
+)
+
+// TranscriptName is the name of the transcript inside an archive.
+const TranscriptName = "transcript.txt"
+
+// Write creates an encrypted archive at path holding the transcript and the
+// given files, which are stored under files/ by base name. Files that no
+// longer exist are skipped and returned, so the caller can report them.
+func Write(path, passphrase, transcript string, files []string) ([]string, error) {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not create archive: %w", err)
+	}
+	skipped, err := write(out, passphrase, transcript, files)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return skipped, nil
+}
+
+func write(out io.Writer, passphrase, transcript string, files []string) ([]string, error) {
+	enc, err := newEncryptWriter(out, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(enc)
+
+	now := time.Now()
+	if err := tw.WriteHeader(&tar.Header{Name: TranscriptName, Mode: 0o600, Size: int64(len(transcript)), ModTime: now}); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(tw, transcript); err != nil {
+		return nil, err
+	}
+
+	var skipped []string
+	for _, file := range files {
+		if err := addFile(tw, file); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				skipped = append(skipped, file)
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return skipped, enc.Close()
+}
+
+func addFile(tw *tar.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: "files/" + filepath.Base(path), Mode: 0o600, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// Extract decrypts the archive at path into destDir and returns the paths it wrote.
+// Entry names are sanitized, and existing files are never overwritten.
+func Extract(path, passphrase, destDir string) ([]string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open archive: %w", err)
+	}
+	defer in.Close()
+
+	dec, err := newDecryptReader(in, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(destDir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create output directory: %w", err)
+	}
+
+	var written []string
+	tr := tar.NewReader(dec)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		dir := destDir
+		if filepath.Dir(header.Name) == "files" {
+			dir = filepath.Join(destDir, "files")
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				return written, err
+			}
+		}
+		target := filepath.Join(dir, filetransfer.SanitizeFileName(filepath.Base(header.Name)))
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return written, fmt.Errorf("could not write %s: %w", target, err)
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return written, err
+		}
+		written = append(written, target)
+	}
+}
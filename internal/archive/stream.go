@@ -0,0 +1,187 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// magic identifies an encrypted jot archive and its format version.
+var magic = []byte("JOTREC1\n")
+
+const (
+	saltSize  = 16
+	chunkSize = 64 * 1024
+
+	// Argon2id parameters for new archives. They are stored in the header,
+	// so archives stay readable if the defaults change.
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+
+	headerSize = 8 + saltSize + 12
+)
+
+// ErrWrongPassphrase is returned when an archive cannot be decrypted, which
+// almost always means the passphrase is wrong.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted archive")
+
+// encryptWriter encrypts everything written to it in fixed-size chunks. Each
+// chunk's nonce holds its index and whether it is the last one, so chunks
+// cannot be reordered, dropped or truncated without detection.
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+}
+
+// newEncryptWriter writes an archive header to w and returns a writer that
+// encrypts with a key derived from passphrase. Close must be called to write the final chunk.
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	salt := header[8 : 8+saltSize]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	binary.BigEndian.PutUint32(header[8+saltSize:], argonTime)
+	binary.BigEndian.PutUint32(header[12+saltSize:], argonMemory)
+	binary.BigEndian.PutUint32(header[16+saltSize:], argonThreads)
+
+	aead, err := deriveAEAD(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full buffer is only flushed once more data arrives, so the final
+		// chunk written by Close is never empty unless the archive is.
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close encrypts and writes the final chunk.
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.index, last), e.buf, e.header)
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+	if _, err := e.w.Write(append(length, sealed...)); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptReader reverses encryptWriter.
+type decryptReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+	done   bool
+}
+
+// newDecryptReader reads the archive header from r and returns a reader of the decrypted content.
+func newDecryptReader(r io.Reader, passphrase string) (*decryptReader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:8], magic) {
+		return nil, errors.New("not a jot archive")
+	}
+	aead, err := deriveAEAD(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead, header: header}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	var length uint32
+	if err := binary.Read(d.r, binary.BigEndian, &length); err != nil {
+		return fmt.Errorf("archive is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	if length > chunkSize+uint32(d.aead.Overhead()) {
+		return ErrWrongPassphrase
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("archive is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	// Try the chunk as a regular chunk first, then as the final one.
+	plaintext, err := d.aead.Open(nil, chunkNonce(d.index, false), sealed, d.header)
+	if err != nil {
+		plaintext, err = d.aead.Open(nil, chunkNonce(d.index, true), sealed, d.header)
+		if err != nil {
+			return ErrWrongPassphrase
+		}
+		d.done = true
+	}
+	d.index++
+	d.buf = plaintext
+	return nil
+}
+
+func deriveAEAD(passphrase string, header []byte) (cipher.AEAD, error) {
+	salt := header[8 : 8+saltSize]
+	time := binary.BigEndian.Uint32(header[8+saltSize:])
+	memory := binary.BigEndian.Uint32(header[12+saltSize:])
+	threads := binary.BigEndian.Uint32(header[16+saltSize:])
+	if time == 0 || time > 16 || memory == 0 || memory > 1024*1024 || threads == 0 || threads > 255 {
+		return nil, errors.New("archive has invalid key derivation parameters")
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, time, memory, uint8(threads), chacha20poly1305.KeySize)
+	return chacha20poly1305.New(key)
+}
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Profile holds the settings of one named connection profile.
+type Profile struct {
+	RelayServer string `json:"relayServer,omitempty"` // Relay address; overrides the built-in default
+	Nickname    string `json:"nickname,omitempty"`    // Nickname suggested when joining a session
+	Theme       string `json:"theme,omitempty"`       // UI color theme
+	Timestamps  string `json:"timestamps,omitempty"`  // "absolute" or "relative"
+
+	// Connection settings, overridden by the matching flags. Durations are
+	// written like "10s".
+	ConnectTimeout   string `json:"connectTimeout,omitempty"`
+	HandshakeTimeout string `json:"handshakeTimeout,omitempty"`
+	Retries          *int   `json:"retries,omitempty"`
+	RetryBackoff     string `json:"retryBackoff,omitempty"`
+
+	// Events overrides the file's alerts for the events it lists.
+	Events map[string]Alert `json:"events,omitempty"`
+}
+
+// Events that can be given an Alert.
+const (
+	EventJoin     = "join"     // The peer joined the session
+	EventLeave    = "leave"    // The peer left, or the session ended
+	EventMessage  = "message"  // A message from the peer
+	EventMention  = "mention"  // A message from the peer naming us; takes the place of "message"
+	EventFile     = "file"     // A file transfer, either way, completed
+	EventReminder = "reminder" // One of our /remind reminders came due
+)
+
+// Alert says how the client draws attention to an event. Any combination of
+// fields may be set.
+type Alert struct {
+	Bell    bool   `json:"bell,omitempty"`    // Ring the terminal bell
+	Flash   bool   `json:"flash,omitempty"`   // Flash the status bar
+	Command string `json:"command,omitempty"` // Run this command, split on whitespace and not run through a shell
+}
+
+// File is the client configuration file.
+type File struct {
+	Profiles map[string]Profile `json:"profiles"`
+	Events   map[string]Alert   `json:"events,omitempty"`
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Dir returns the jot directory inside the user's config directory.
+func Dir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "jot"), nil
+}
+
+// DefaultPath returns the location of the configuration file.
+func DefaultPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// Load reads the configuration file at path. A missing file yields an empty configuration.
+func Load(path string) (*File, error) {
+	f := &File{Profiles: make(map[string]Profile)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return f, nil
+		}
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]Profile)
+	}
+	return f, nil
+}
+
+// Profile returns the named profile, or an error if it is not defined.
+func (f *File) Profile(name string) (Profile, error) {
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q is not defined in the config file", name)
+	}
+	return profile, nil
+}
+
+// Alerts returns the alert for each event, with those of profile, if not nil,
+// taking precedence. Unknown event names are an error, so typos do not go
+// unnoticed.
+func (f *File) Alerts(profile *Profile) (map[string]Alert, error) {
+	alerts := make(map[string]Alert)
+	sources := []map[string]Alert{f.Events}
+	if profile != nil {
+		sources = append(sources, profile.Events)
+	}
+	for _, events := range sources {
+		for event, alert := range events {
+			switch event {
+			case EventJoin, EventLeave, EventMessage, EventMention, EventFile, EventReminder:
+				alerts[event] = alert
+			default:
+				return nil, fmt.Errorf("unknown event %q in config file (expected %s, %s, %s, %s, %s or %s)", event, EventJoin, EventLeave, EventMessage, EventMention, EventFile, EventReminder)
+			}
+		}
+	}
+	return alerts, nil
+}
+
+// ProfileDir returns the directory holding a profile's keys and trust store,
+// creating it readable only by the current user.
+func ProfileDir(name string) (string, error) {
+	if !profileNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %q: use letters, digits, '-' and '_'", name)
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	profileDir := filepath.Join(dir, "profiles", name)
+	if err := os.MkdirAll(profileDir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create profile directory: %w", err)
+	}
+	return profileDir, nil
+}
+
+// LoadOrCreateIdentity reads the Ed25519 identity key stored in dir, generating
+// and saving a new one on first use.
+func LoadOrCreateIdentity(dir string) (ed25519.PrivateKey, error) {
+	path := filepath.Join(dir, "identity.key")
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("identity key %s is corrupted", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read identity key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	// O_EXCL so two clients starting at once cannot overwrite each other's key.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("could not save identity key: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(key.Seed()); err != nil {
+		return nil, fmt.Errorf("could not save identity key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,155 @@
+package contacts
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintLength is the length of the hex fingerprints shown by /fingerprint.
+const fingerprintLength = 16
+
+// Contact is a saved peer, identified by the fingerprint of their identity key.
+type Contact struct {
+	Alias       string    `json:"alias"`
+	Fingerprint string    `json:"fingerprint"`
+	Verified    bool      `json:"verified"` // The fingerprint was confirmed out of band
+	AddedAt     time.Time `json:"addedAt"`
+}
+
+// Label returns the name the contact is displayed under.
+func (c Contact) Label() string {
+	if c.Verified {
+		return c.Alias + " (verified)"
+	}
+	return c.Alias
+}
+
+// Store is a local, file-backed roster of contacts keyed by identity fingerprint.
+type Store struct {
+	path     string
+	mu       sync.Mutex
+	Contacts map[string]Contact `json:"contacts"`
+}
+
+// DefaultPath returns the location of the roster in the user's config directory.
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "jot", "contacts.json"), nil
+}
+
+// Load reads the roster at path. A missing file yields an empty roster.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, Contacts: make(map[string]Contact)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("could not read contacts: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("could not parse contacts: %w", err)
+	}
+	if s.Contacts == nil {
+		s.Contacts = make(map[string]Contact)
+	}
+	return s, nil
+}
+
+// Save writes the roster to disk, readable only by the current user.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("could not create contacts directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode contacts: %w", err)
+	}
+	// Write to a temporary file first so a crash never leaves a truncated roster behind.
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("could not write contacts: %w", err)
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+// NormalizeFingerprint lowercases a fingerprint and strips the separators
+// people tend to add when reading one out, e.g. "4F1C:22AB".
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "", "-", "").Replace(fingerprint))
+}
+
+// Add saves alias for fingerprint, replacing any existing entry for that fingerprint.
+// Aliases must be unique so they can be used to refer to contacts.
+func (s *Store) Add(alias, fingerprint string, verified bool) error {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return errors.New("alias must not be empty")
+	}
+	fingerprint = NormalizeFingerprint(fingerprint)
+	if _, err := hex.DecodeString(fingerprint); err != nil || len(fingerprint) != fingerprintLength {
+		return fmt.Errorf("fingerprint must be %d hexadecimal characters", fingerprintLength)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fp, c := range s.Contacts {
+		if fp != fingerprint && strings.EqualFold(c.Alias, alias) {
+			return fmt.Errorf("alias %q is already used for %s", alias, fp)
+		}
+	}
+	s.Contacts[fingerprint] = Contact{Alias: alias, Fingerprint: fingerprint, Verified: verified, AddedAt: time.Now()}
+	return nil
+}
+
+// Remove deletes the contact with the given alias or fingerprint and reports whether one existed.
+func (s *Store) Remove(aliasOrFingerprint string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fingerprint := NormalizeFingerprint(aliasOrFingerprint)
+	if _, ok := s.Contacts[fingerprint]; ok {
+		delete(s.Contacts, fingerprint)
+		return true
+	}
+	for fp, c := range s.Contacts {
+		if strings.EqualFold(c.Alias, aliasOrFingerprint) {
+			delete(s.Contacts, fp)
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the contact saved for fingerprint, if any.
+func (s *Store) Lookup(fingerprint string) (Contact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.Contacts[NormalizeFingerprint(fingerprint)]
+	return c, ok
+}
+
+// List returns all contacts sorted by alias.
+func (s *Store) List() []Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Contact, 0, len(s.Contacts))
+	for _, c := range s.Contacts {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return strings.ToLower(list[i].Alias) < strings.ToLower(list[j].Alias) })
+	return list
+}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"crypto/ed25519"
 	"net"
 
+	"github.com/bjarneo/jot/internal/crypto"
 	"github.com/bjarneo/jot/internal/protocol"
 )
 
@@ -11,12 +13,21 @@ type MessageSender interface {
 	SendError(err error)
 	SendInfo(info string)
 	SendConnection(conn net.Conn)
-	SendSharedKey(key []byte)
+	SendSessionKeys(keys *crypto.SessionKeys)
+	SendPeerHello(hello protocol.Hello)
 	SendReceivedNickname(nickname string)
-	SendReceivedText(text string)
+	SendPeerRecording(recording protocol.Recording)
+	SendReceivedText(text string, signature crypto.SignatureStatus)
+	SendAck(ack protocol.Ack)
+	SendKeepalive()
+	SendBye()
+	SendBlob(blob protocol.Blob)
+	SendPoll(poll protocol.Poll)
+	SendVote(vote protocol.Vote)
+	SendAnnouncement(announcement protocol.Announcement)
 	SendFileOffer(metadata protocol.FileMetadata)
 	SendFileOfferAccepted(metadata protocol.FileMetadata)
-	SendFileOfferRejected()
+	SendFileOfferRejected(metadata protocol.FileMetadata)
 	SendFileOfferFailed(reason string)
 	SendFileSendingComplete()
 	SendFileChunk(chunk []byte)
@@ -24,5 +35,9 @@ type MessageSender interface {
 	SendProgress(percent float64)
 	SendPeerPublicKey(publicKey []byte)
 	SendMyPublicKey(publicKey []byte)
+	SendPeerIdentity(publicKey ed25519.PublicKey)
+	SendRekeyed(rekeys int)
+	SendUnknownMessage(msgType byte)
 	SendConnectionClosed()
+	SendConnectionLost(err error)
 }
@@ -4,95 +4,157 @@ import (
 	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
 	"crypto/rand"
-	"encoding/binary"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
+	"sync"
 
-	"github.com/bjarneo/jot/internal/protocol" // Added for protocol.TypePublicKeyExchange
+	"github.com/bjarneo/jot/internal/protocol"
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
 )
 
+const publicKeySize = 32 // Size of Curve25519 public keys
+
+// Cipher identifies the AEAD used to protect a message. It is sent as the first
+// byte of every ciphertext, so peers can decrypt whichever cipher the other side prefers.
+type Cipher byte
+
 const (
-	maxPublicKeySize = 32               // Size of Curve25519 public keys
-	maxMessageSize   = 10 * 1024 * 1024 // 10MB, arbitrary limit for other messages
+	// CipherAESGCM is AES-256-GCM with 96-bit random nonces.
+	CipherAESGCM Cipher = 0x01
+	// CipherXChaCha20Poly1305 uses 192-bit random nonces, leaving a comfortable
+	// collision margin even for very long, chunk-heavy sessions.
+	CipherXChaCha20Poly1305 Cipher = 0x02
 )
 
-// Encrypt encrypts plaintext using AES-GCM with the given key.
-func Encrypt(plaintext, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+// ParseCipher maps a user-facing cipher name to a Cipher.
+func ParseCipher(name string) (Cipher, error) {
+	switch strings.ToLower(name) {
+	case "aes-gcm", "aes-256-gcm":
+		return CipherAESGCM, nil
+	case "xchacha20", "xchacha20-poly1305":
+		return CipherXChaCha20Poly1305, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (supported: aes-gcm, xchacha20)", name)
 	}
-	gcm, err := cipher.NewGCM(block)
+}
+
+// String returns the user-facing name of the cipher.
+func (c Cipher) String() string {
+	switch c {
+	case CipherAESGCM:
+		return "aes-gcm"
+	case CipherXChaCha20Poly1305:
+		return "xchacha20"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(c))
+	}
+}
+
+// newAEAD creates the AEAD for cipher keyed with key.
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	switch c {
+	case CipherAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, fmt.Errorf("unsupported cipher %d", byte(c))
+	}
+}
+
+// Encrypt encrypts plaintext with the given cipher and key, authenticating aad alongside it.
+// The output is the cipher byte, followed by a random nonce and the sealed message.
+func Encrypt(c Cipher, plaintext, key, aad []byte) ([]byte, error) {
+	return AppendEncrypt(nil, c, plaintext, key, aad)
+}
+
+// AppendEncrypt appends what Encrypt returns to dst, so callers can encrypt
+// into a buffer they reuse. plaintext must not overlap dst.
+func AppendEncrypt(dst []byte, c Cipher, plaintext, key, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(c, key)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, gcm.NonceSize())
+	start := len(dst)
+	out := slices.Grow(dst, 1+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, byte(c))
+	out = out[:len(out)+aead.NonceSize()]
+	nonce := out[start+1:]
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return aead.Seal(out, nonce, plaintext, aad), nil
 }
 
-// Decrypt decrypts ciphertext using AES-GCM with the given key.
-func Decrypt(ciphertext, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+// Decrypt decrypts ciphertext produced by Encrypt with the given key, verifying aad alongside it.
+func Decrypt(ciphertext, key, aad []byte) ([]byte, error) {
+	aead, nonce, sealed, err := openCiphertext(ciphertext, key)
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	return aead.Open(nil, nonce, sealed, aad)
+}
+
+// DecryptInPlace is Decrypt without allocating: the plaintext overwrites
+// ciphertext, whose contents are undefined afterwards even if it fails.
+func DecryptInPlace(ciphertext, key, aad []byte) ([]byte, error) {
+	aead, nonce, sealed, err := openCiphertext(ciphertext, key)
 	if err != nil {
 		return nil, err
 	}
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, errors.New("ciphertext too short")
-	}
-	nonce, actualCiphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, actualCiphertext, nil)
+	return aead.Open(sealed[:0], nonce, sealed, aad)
 }
 
-// Helper for PerformKeyExchange to read one TLV message (unencrypted payload)
-// The caller is responsible for providing a buffered reader.
-func readTLVFromConn(reader *bufio.Reader) (byte, []byte, error) {
-	msgType, err := reader.ReadByte()
+// openCiphertext splits ciphertext produced by Encrypt into its nonce and
+// sealed message, and returns the cipher to open it with.
+func openCiphertext(ciphertext, key []byte) (cipher.AEAD, []byte, []byte, error) {
+	if len(ciphertext) < 1 {
+		return nil, nil, nil, errors.New("ciphertext too short")
+	}
+	aead, err := newAEAD(Cipher(ciphertext[0]), key)
 	if err != nil {
-		return 0, nil, fmt.Errorf("readTLV: failed to read msgType: %w", err)
+		return nil, nil, nil, err
 	}
-
-	var length uint32
-	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
-		return 0, nil, fmt.Errorf("readTLV: failed to read length: %w", err)
+	ciphertext = ciphertext[1:]
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, nil, nil, errors.New("ciphertext too short")
 	}
+	return aead, ciphertext[:nonceSize], ciphertext[nonceSize:], nil
+}
 
-	// Safety limit for public key payload
-	if msgType == protocol.TypePublicKeyExchange {
-		if length != maxPublicKeySize {
-			return 0, nil, fmt.Errorf("readTLV: public key exchange payload must be 32 bytes, got %d", length)
-		}
-	} else {
-		// Generic length check for other types, if this function were to be used more broadly.
-		// For now, it's only used for TypePublicKeyExchange.
-		if length > maxMessageSize {
-			return 0, nil, fmt.Errorf("readTLV: message length %d too large", length)
-		}
-		if length == 0 && msgType != protocol.TypeFileReject { // TypeFileReject can have 0 length data
-			return 0, nil, errors.New("readTLV: received zero length payload for message type that requires data")
-		}
+// readPublicKey reads the peer's public key frame from the key exchange.
+func readPublicKey(reader *bufio.Reader) ([]byte, error) {
+	msgType, payload, err := protocol.ReadFrame(reader)
+	if err != nil {
+		return nil, err
 	}
-
-	payload := make([]byte, length)
-	if _, err := io.ReadFull(reader, payload); err != nil {
-		return 0, nil, fmt.Errorf("readTLV: failed to read payload: %w", err)
+	if msgType != protocol.TypePublicKeyExchange {
+		return nil, fmt.Errorf("expected TypePublicKeyExchange, got %d", msgType)
+	}
+	if len(payload) != publicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", publicKeySize, len(payload))
 	}
-	return msgType, payload, nil
+	return payload, nil
 }
 
-// PerformKeyExchange performs a Curve25519 key exchange using TLV-formatted messages for public keys.
-// It returns the shared key, the user's public key, and the peer's public key.
-func PerformKeyExchange(conn io.ReadWriter, isInitiator bool) ([]byte, []byte, []byte, error) {
+// PerformKeyExchange performs a Curve25519 key exchange, sending the public keys as unencrypted frames.
+// The reader must be the same buffered reader the caller keeps using afterwards, so that
+// any bytes the peer sends right after its public key are not lost in a private buffer.
+// It returns the shared secret, the user's public key, and the peer's public key. The
+// secret is never used as a key itself; DeriveKeys turns it into keys.
+func PerformKeyExchange(reader *bufio.Reader, writer io.Writer, isInitiator bool) ([]byte, []byte, []byte, error) {
 	var privateKey, publicKey [32]byte
 	if _, err := rand.Read(privateKey[:]); err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to generate private key: %w", err)
@@ -101,59 +163,24 @@ func PerformKeyExchange(conn io.ReadWriter, isInitiator bool) ([]byte, []byte, [
 
 	var theirPublicKeyBytes [32]byte
 
-	// Create a buffered reader for the connection once.
-	// conn is an io.ReadWriter; we need io.Reader for bufio.NewReader.
-	// We also need io.Writer for sending.
-	reader := bufio.NewReader(conn)
-	writer := conn // conn itself is an io.Writer
-
 	if isInitiator {
-		// Initiator sends its public key first (TLV, unencrypted)
-		payloadToSend := publicKey[:]
-		msgHeader := make([]byte, 1+4) // 1 byte for type, 4 bytes for length
-		msgHeader[0] = protocol.TypePublicKeyExchange
-		binary.BigEndian.PutUint32(msgHeader[1:], uint32(len(payloadToSend)))
-		fullMsg := append(msgHeader, payloadToSend...)
-
-		if _, err := writer.Write(fullMsg); err != nil {
+		// Initiator sends its public key first, then receives the peer's.
+		if err := protocol.WriteFrame(writer, protocol.TypePublicKeyExchange, publicKey[:]); err != nil {
 			return nil, nil, nil, fmt.Errorf("initiator failed to send public key: %w", err)
 		}
-
-		// Then, initiator receives peer's key (TLV, unencrypted)
-		recvMsgType, recvPayload, err := readTLVFromConn(reader) // Pass the bufio.Reader
+		theirs, err := readPublicKey(reader)
 		if err != nil {
 			return nil, nil, nil, fmt.Errorf("initiator failed to read peer's public key: %w", err)
 		}
-		if recvMsgType != protocol.TypePublicKeyExchange {
-			return nil, nil, nil, fmt.Errorf("initiator expected TypePublicKeyExchange, got %d", recvMsgType)
-		}
-		if len(recvPayload) != 32 {
-			return nil, nil, nil, fmt.Errorf("initiator received peer public key of wrong size: %d", len(recvPayload))
-		}
-		copy(theirPublicKeyBytes[:], recvPayload)
-
-	} else { // Responder
-		// Responder receives peer's key first (TLV, unencrypted)
-		recvMsgType, recvPayload, err := readTLVFromConn(reader) // Pass the bufio.Reader
+		copy(theirPublicKeyBytes[:], theirs)
+	} else {
+		// Responder receives the peer's key first, then sends its own.
+		theirs, err := readPublicKey(reader)
 		if err != nil {
 			return nil, nil, nil, fmt.Errorf("responder failed to read peer's public key: %w", err)
 		}
-		if recvMsgType != protocol.TypePublicKeyExchange {
-			return nil, nil, nil, fmt.Errorf("responder expected TypePublicKeyExchange, got %d", recvMsgType)
-		}
-		if len(recvPayload) != 32 {
-			return nil, nil, nil, fmt.Errorf("responder received peer public key of wrong size: %d", len(recvPayload))
-		}
-		copy(theirPublicKeyBytes[:], recvPayload)
-
-		// Then, responder sends its public key (TLV, unencrypted)
-		payloadToSend := publicKey[:]
-		msgHeader := make([]byte, 1+4) // 1 byte for type, 4 bytes for length
-		msgHeader[0] = protocol.TypePublicKeyExchange
-		binary.BigEndian.PutUint32(msgHeader[1:], uint32(len(payloadToSend)))
-		fullMsg := append(msgHeader, payloadToSend...)
-
-		if _, err := writer.Write(fullMsg); err != nil {
+		copy(theirPublicKeyBytes[:], theirs)
+		if err := protocol.WriteFrame(writer, protocol.TypePublicKeyExchange, publicKey[:]); err != nil {
 			return nil, nil, nil, fmt.Errorf("responder failed to send public key: %w", err)
 		}
 	}
@@ -165,3 +192,61 @@ func PerformKeyExchange(conn io.ReadWriter, isInitiator bool) ([]byte, []byte, [
 
 	return sharedKeyVal, publicKey[:], theirPublicKeyBytes[:], nil
 }
+
+// HKDF info strings for the keys DeriveKeys derives, one per direction and
+// purpose, so no key ever encrypts more than one stream.
+const (
+	chatFromInitiator = "jot v1 chat initiator-to-responder"
+	chatFromResponder = "jot v1 chat responder-to-initiator"
+	fileFromInitiator = "jot v1 file initiator-to-responder"
+	fileFromResponder = "jot v1 file responder-to-initiator"
+)
+
+// DeriveKeys sets the session's keys from the X25519 shared secret with
+// HKDF-SHA256, salted with both public keys so the keys are bound to this
+// exchange, along with the root key a later rekey builds on. k.IsInitiator
+// must already be set, since it decides which direction is ours.
+func (k *SessionKeys) DeriveKeys(secret, myPublicKey, peerPublicKey []byte) error {
+	initiatorKey, responderKey := myPublicKey, peerPublicKey
+	if !k.IsInitiator {
+		initiatorKey, responderKey = peerPublicKey, myPublicKey
+	}
+	salt := slices.Concat(initiatorKey, responderKey)
+	derive := func(info string) ([]byte, error) {
+		return hkdf.Key(sha256.New, secret, salt, info, 32)
+	}
+
+	keys := make([][]byte, 5)
+	for i, info := range []string{chatFromInitiator, chatFromResponder, fileFromInitiator, fileFromResponder, rekeyRoot} {
+		key, err := derive(info)
+		if err != nil {
+			return fmt.Errorf("failed to derive session keys: %w", err)
+		}
+		keys[i] = key
+	}
+	if k.IsInitiator {
+		k.SendKey, k.ReceiveKey, k.fileSendKey, k.fileReceiveKey = keys[0], keys[1], keys[2], keys[3]
+	} else {
+		k.SendKey, k.ReceiveKey, k.fileSendKey, k.fileReceiveKey = keys[1], keys[0], keys[3], keys[2]
+	}
+	k.root = keys[4]
+	if k.sendMu == nil {
+		k.sendMu = new(sync.Mutex)
+	}
+	return nil
+}
+
+// ForFiles returns the keys for a file data connection, which has keys of
+// its own in each direction. They are a copy, which a rekey of the session
+// leaves alone.
+func (k *SessionKeys) ForFiles() *SessionKeys {
+	unlock := k.LockSend()
+	defer unlock()
+	return &SessionKeys{
+		SendKey:     k.fileSendKey,
+		ReceiveKey:  k.fileReceiveKey,
+		Identity:    k.Identity,
+		IsInitiator: k.IsInitiator,
+		Cipher:      k.Cipher,
+	}
+}
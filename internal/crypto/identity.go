@@ -0,0 +1,207 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	envelopeUnsigned byte = 0x00
+	envelopeSigned   byte = 0x01
+)
+
+// SignatureStatus describes the outcome of verifying a signed envelope.
+type SignatureStatus int
+
+const (
+	SignatureValid SignatureStatus = iota
+	SignatureMissing
+	SignatureInvalid
+)
+
+// SessionKeys holds the keys used to protect traffic for one chat session.
+type SessionKeys struct {
+	SendKey     []byte             // Encrypts what we send on the session connection; see DeriveKeys
+	ReceiveKey  []byte             // Decrypts what the peer sends on it
+	Identity    ed25519.PrivateKey // Our identity key, used to sign outgoing messages
+	IsInitiator bool               // Whether we created the session; decides our sender role
+	Cipher      Cipher             // AEAD used for messages we send
+	Password    []byte             // Session password proven with SPAKE2 during the handshake, which then clears it; nil skips it
+	Rekeys      int                // How many times the keys were replaced since the handshake; see StartRekey
+
+	fileSendKey, fileReceiveKey []byte // The same for file data connections; see ForFiles
+
+	sendMu                      *sync.Mutex // Held while a frame is sealed and sent; see LockSend
+	root                        []byte      // Mixed into the next rekey's secret
+	rekeyPrivate                []byte      // Our half of a rekey the peer has not answered yet
+	nextSendKey, nextReceiveKey []byte      // Derived by a rekey, but not switched to yet
+}
+
+var (
+	initiatorRole = []byte("jot-sender:initiator")
+	responderRole = []byte("jot-sender:responder")
+)
+
+// SendAAD returns the associated data that binds our sender role into every ciphertext we produce.
+func (k *SessionKeys) SendAAD() []byte {
+	if k.IsInitiator {
+		return initiatorRole
+	}
+	return responderRole
+}
+
+// ReceiveAAD returns the associated data expected on ciphertexts produced by the peer.
+// Each direction has a key of its own, which already keeps the relay from reflecting
+// our own messages back to us as the peer's; the role binding is a second line of
+// defence should a key ever be shared between directions.
+func (k *SessionKeys) ReceiveAAD() []byte {
+	if k.IsInitiator {
+		return responderRole
+	}
+	return initiatorRole
+}
+
+// GenerateIdentity creates a new Ed25519 identity key pair.
+func GenerateIdentity() (ed25519.PrivateKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	return privateKey, nil
+}
+
+// exchangeKeyContext separates signatures over exchange keys from every other
+// signature an identity key makes.
+var exchangeKeyContext = []byte("jot-exchange-key-v1")
+
+// IdentityPayload returns what the identity message carries: our identity
+// public key, followed by its signature over the exchange key we sent. The
+// signature ties the identity to this key exchange, so a relay that runs an
+// exchange of its own with each end cannot pass the identity on unnoticed.
+func IdentityPayload(identity ed25519.PrivateKey, exchangeKey []byte) []byte {
+	payload := slices.Clone(identity.Public().(ed25519.PublicKey))
+	return append(payload, ed25519.Sign(identity, append(slices.Clone(exchangeKeyContext), exchangeKey...))...)
+}
+
+// ParseIdentityPayload returns the identity key in an identity message, once
+// its signature shows that it vouches for exchangeKey, the exchange key we
+// received from the peer.
+func ParseIdentityPayload(payload, exchangeKey []byte) (ed25519.PublicKey, error) {
+	if len(payload) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, errors.New("identity message has no signature over the exchange key")
+	}
+	identity := ed25519.PublicKey(slices.Clone(payload[:ed25519.PublicKeySize]))
+	if !ed25519.Verify(identity, append(slices.Clone(exchangeKeyContext), exchangeKey...), payload[ed25519.PublicKeySize:]) {
+		return nil, errors.New("identity key did not sign the exchange key")
+	}
+	return identity, nil
+}
+
+// Fingerprint returns the short hex fingerprint shown to users for a public key.
+func Fingerprint(publicKey []byte) string {
+	hash := sha256.Sum256(publicKey)
+	return fmt.Sprintf("%x", hash[:8])
+}
+
+// SessionFingerprint hashes the keys both ends of a session agreed on, the
+// exchange and identity keys of the initiator and of the responder, into a
+// short string for the users to compare. A relay in the middle runs a separate
+// exchange with each end, so the two ends see different strings.
+func SessionFingerprint(initiatorKey, responderKey []byte, initiatorIdentity, responderIdentity ed25519.PublicKey) string {
+	hash := sha256.New()
+	hash.Write([]byte("jot-session-fingerprint-v1"))
+	for _, field := range [][]byte{initiatorKey, responderKey, initiatorIdentity, responderIdentity} {
+		hash.Write(binary.BigEndian.AppendUint16(nil, uint16(len(field))))
+		hash.Write(field)
+	}
+	digits := hex.EncodeToString(hash.Sum(nil)[:16])
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// SignEnvelope wraps data in a signed envelope: a marker byte, the Ed25519
+// signature over msgType and data, then data itself.
+// With a nil identity the envelope is marked as unsigned.
+func SignEnvelope(identity ed25519.PrivateKey, msgType byte, data []byte) []byte {
+	return AppendEnvelope(nil, identity, msgType, data)
+}
+
+// AppendEnvelope appends what SignEnvelope returns to dst. The message type
+// is signed in the place of the signature's last byte, so the data is not
+// copied a second time to sign it.
+func AppendEnvelope(dst []byte, identity ed25519.PrivateKey, msgType byte, data []byte) []byte {
+	if identity == nil {
+		return append(append(dst, envelopeUnsigned), data...)
+	}
+	start := len(dst)
+	envelope := slices.Grow(dst, 1+ed25519.SignatureSize+len(data))
+	envelope = append(envelope, envelopeSigned)
+	envelope = envelope[:len(envelope)+ed25519.SignatureSize]
+	envelope[len(envelope)-1] = msgType
+	envelope = append(envelope, data...)
+	signature := ed25519.Sign(identity, envelope[start+ed25519.SignatureSize:])
+	copy(envelope[start+1:], signature)
+	return envelope
+}
+
+// OpenEnvelope unwraps an envelope produced by SignEnvelope and verifies its
+// signature against peer. A nil peer key reports signed envelopes as invalid,
+// since their signer cannot be established.
+func OpenEnvelope(peer ed25519.PublicKey, msgType byte, envelope []byte) ([]byte, SignatureStatus, error) {
+	if len(envelope) == 0 {
+		return nil, SignatureMissing, errors.New("envelope is empty")
+	}
+	switch envelope[0] {
+	case envelopeUnsigned:
+		return envelope[1:], SignatureMissing, nil
+	case envelopeSigned:
+		if len(envelope) < 1+ed25519.SignatureSize {
+			return nil, SignatureInvalid, errors.New("signed envelope too short")
+		}
+		signature := envelope[1 : 1+ed25519.SignatureSize]
+		data := envelope[1+ed25519.SignatureSize:]
+		if len(peer) != ed25519.PublicKeySize || !ed25519.Verify(peer, signedMessage(msgType, data), signature) {
+			return data, SignatureInvalid, nil
+		}
+		return data, SignatureValid, nil
+	default:
+		return nil, SignatureInvalid, fmt.Errorf("unknown envelope marker %d", envelope[0])
+	}
+}
+
+// OpenEnvelopeInPlace is OpenEnvelope without copying the data to verify it:
+// the message type is written over the last byte of the signature, so the
+// envelope cannot be opened again.
+func OpenEnvelopeInPlace(peer ed25519.PublicKey, msgType byte, envelope []byte) ([]byte, SignatureStatus, error) {
+	if len(envelope) == 0 || envelope[0] != envelopeSigned {
+		return OpenEnvelope(peer, msgType, envelope)
+	}
+	if len(envelope) < 1+ed25519.SignatureSize {
+		return nil, SignatureInvalid, errors.New("signed envelope too short")
+	}
+	var signature [ed25519.SignatureSize]byte
+	copy(signature[:], envelope[1:])
+	message := envelope[ed25519.SignatureSize:]
+	message[0] = msgType
+	if len(peer) != ed25519.PublicKeySize || !ed25519.Verify(peer, message, signature[:]) {
+		return message[1:], SignatureInvalid, nil
+	}
+	return message[1:], SignatureValid, nil
+}
+
+// signedMessage binds the message type into the signed bytes so a signature
+// for one kind of message cannot be replayed as another.
+func signedMessage(msgType byte, data []byte) []byte {
+	return append([]byte{msgType}, data...)
+}
@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+)
+
+// openers are the ways an envelope is opened, which must agree on every one.
+var openers = []struct {
+	name string
+	open func(peer ed25519.PublicKey, msgType byte, envelope []byte) ([]byte, SignatureStatus, error)
+}{
+	{"OpenEnvelope", OpenEnvelope},
+	{"OpenEnvelopeInPlace", OpenEnvelopeInPlace},
+}
+
+func TestOpenEnvelope(t *testing.T) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := identity.Public().(ed25519.PublicKey)
+	const msgType = 0x01
+	data := []byte("hello")
+
+	tests := []struct {
+		name     string
+		envelope func() []byte
+		peer     ed25519.PublicKey
+		msgType  byte
+		want     SignatureStatus
+	}{
+		{"valid", func() []byte { return AppendEnvelope(nil, identity, msgType, data) }, peer, msgType, SignatureValid},
+		{"tampered payload", func() []byte {
+			envelope := AppendEnvelope(nil, identity, msgType, data)
+			envelope[len(envelope)-1] ^= 1
+			return envelope
+		}, peer, msgType, SignatureInvalid},
+		{"tampered signature", func() []byte {
+			envelope := AppendEnvelope(nil, identity, msgType, data)
+			envelope[1] ^= 1
+			return envelope
+		}, peer, msgType, SignatureInvalid},
+		{"mismatched message type", func() []byte { return AppendEnvelope(nil, identity, msgType, data) }, peer, 0x02, SignatureInvalid},
+		{"signed by someone else", func() []byte { return AppendEnvelope(nil, other, msgType, data) }, peer, msgType, SignatureInvalid},
+		{"no peer key", func() []byte { return AppendEnvelope(nil, identity, msgType, data) }, nil, msgType, SignatureInvalid},
+		{"unsigned", func() []byte { return AppendEnvelope(nil, nil, msgType, data) }, peer, msgType, SignatureMissing},
+	}
+	for _, opener := range openers {
+		for _, tt := range tests {
+			t.Run(opener.name+"/"+tt.name, func(t *testing.T) {
+				got, status, err := opener.open(tt.peer, tt.msgType, tt.envelope())
+				if err != nil {
+					t.Fatalf("got error %v", err)
+				}
+				if status != tt.want {
+					t.Errorf("status = %v, want %v", status, tt.want)
+				}
+				if tt.want != SignatureInvalid && !bytes.Equal(got, data) {
+					t.Errorf("data = %q, want %q", got, data)
+				}
+			})
+		}
+	}
+}
+
+func TestAppendEnvelopeKeepsPrefix(t *testing.T) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("header")
+	envelope := AppendEnvelope(bytes.Clone(prefix), identity, 0x01, []byte("hello"))
+	if !bytes.HasPrefix(envelope, prefix) {
+		t.Fatalf("envelope %q lost its prefix", envelope)
+	}
+	got, status, err := OpenEnvelope(identity.Public().(ed25519.PublicKey), 0x01, envelope[len(prefix):])
+	if err != nil || status != SignatureValid || string(got) != "hello" {
+		t.Errorf("got %q, %v, %v; want \"hello\", valid", got, status, err)
+	}
+}
+
+func TestOpenEnvelopeMalformed(t *testing.T) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := identity.Public().(ed25519.PublicKey)
+	envelope := AppendEnvelope(nil, identity, 0x01, []byte("hello"))
+
+	tests := []struct {
+		name     string
+		envelope []byte
+	}{
+		{"empty", nil},
+		{"marker only", envelope[:1]},
+		{"truncated signature", envelope[:ed25519.SignatureSize]},
+		{"unknown marker", append([]byte{0xff}, envelope[1:]...)},
+	}
+	for _, opener := range openers {
+		for _, tt := range tests {
+			t.Run(opener.name+"/"+tt.name, func(t *testing.T) {
+				if _, _, err := opener.open(peer, 0x01, bytes.Clone(tt.envelope)); err == nil {
+					t.Error("opened a malformed envelope")
+				}
+			})
+		}
+		// No length may panic, however short.
+		t.Run(opener.name+"/every truncation", func(t *testing.T) {
+			for n := range len(envelope) {
+				_, status, err := opener.open(peer, 0x01, bytes.Clone(envelope[:n]))
+				if err == nil && status == SignatureValid {
+					t.Errorf("a %d byte truncation opened as valid", n)
+				}
+			}
+		})
+	}
+}
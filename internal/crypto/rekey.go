@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// A rekey replaces the session keys mid-session with keys derived from a
+// fresh X25519 exchange and the root key of the exchange before it, so keys
+// taken from a client later do not decrypt what was sent earlier, while a
+// relay that swapped the rekey's public keys still learns nothing. The old
+// chat keys are overwritten once they are replaced.
+//
+// Each direction switches on its own: the sender of a frame decides which key
+// seals it, and the peer must know which one to open it with. So each end
+// switches the key it sends with right after the frame that tells the peer it
+// does; see protocol.Rekey.
+
+// rekeyRoot is the HKDF info string of the root key each rekey builds on.
+const rekeyRoot = "jot v1 rekey root"
+
+// ErrRekeyPending is returned by StartRekey while an earlier rekey has not
+// completed yet.
+var ErrRekeyPending = errors.New("a rekey is already in progress")
+
+// LockSend locks the keys for sealing and sending one frame, and returns the
+// function that unlocks them. A rekey replaces SendKey only while they are
+// locked, so it always falls between two frames. Keys that were never derived
+// by DeriveKeys, such as those of data connections, have no lock, since only
+// one goroutine sends with them.
+func (k *SessionKeys) LockSend() (unlock func()) {
+	if k.sendMu == nil {
+		return func() {}
+	}
+	k.sendMu.Lock()
+	return k.sendMu.Unlock
+}
+
+// StartRekey generates our half of a rekey and returns the public key to send
+// to the peer. The keys must be locked for sending.
+func (k *SessionKeys) StartRekey() ([]byte, error) {
+	if k.rekeyPrivate != nil || k.nextSendKey != nil || k.nextReceiveKey != nil {
+		return nil, ErrRekeyPending
+	}
+	private, public, err := newExchangeKey()
+	if err != nil {
+		return nil, err
+	}
+	k.rekeyPrivate = private
+	return public, nil
+}
+
+// RekeyPending reports whether we started a rekey the peer has not answered.
+func (k *SessionKeys) RekeyPending() bool {
+	return k.rekeyPrivate != nil
+}
+
+// AnswerRekey derives the next keys from the peer's public key and a fresh
+// one of ours, which it returns for the reply. A rekey of our own the peer has
+// not answered is dropped. The keys must be locked for sending; SwitchSend
+// starts using the new send key once the reply is sent, and SwitchReceive the
+// new receive key once the peer says it switched.
+func (k *SessionKeys) AnswerRekey(peerPublic []byte) ([]byte, error) {
+	k.dropRekey()
+	private, public, err := newExchangeKey()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(private)
+	if err := k.deriveNext(private, public, peerPublic); err != nil {
+		return nil, err
+	}
+	return public, nil
+}
+
+// FinishRekey derives the next keys for the rekey we started from the public
+// key the peer replied with. The keys must be locked for sending.
+func (k *SessionKeys) FinishRekey(peerPublic []byte) error {
+	if k.rekeyPrivate == nil {
+		return errors.New("peer answered a rekey we did not start")
+	}
+	private := k.rekeyPrivate
+	k.rekeyPrivate = nil
+	defer clear(private)
+	public, err := curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return fmt.Errorf("failed to compute rekey public key: %w", err)
+	}
+	return k.deriveNext(private, public, peerPublic)
+}
+
+// SwitchSend replaces the send key with the one the latest rekey derived. The
+// keys must be locked for sending.
+func (k *SessionKeys) SwitchSend() {
+	if k.nextSendKey == nil {
+		return
+	}
+	clear(k.SendKey)
+	k.SendKey, k.nextSendKey = k.nextSendKey, nil
+	k.switched()
+}
+
+// SwitchReceive replaces the receive key with the one the latest rekey
+// derived. It fails if no rekey is waiting for it.
+func (k *SessionKeys) SwitchReceive() error {
+	if k.nextReceiveKey == nil {
+		return errors.New("peer switched keys without a rekey")
+	}
+	clear(k.ReceiveKey)
+	k.ReceiveKey, k.nextReceiveKey = k.nextReceiveKey, nil
+	k.switched()
+	return nil
+}
+
+// switched counts a rekey once both directions use its keys.
+func (k *SessionKeys) switched() {
+	if k.nextSendKey == nil && k.nextReceiveKey == nil {
+		k.Rekeys++
+	}
+}
+
+// dropRekey forgets a rekey we started.
+func (k *SessionKeys) dropRekey() {
+	clear(k.rekeyPrivate)
+	k.rekeyPrivate = nil
+}
+
+// deriveNext derives the keys the next rekey switches to, as DeriveKeys does
+// for the handshake, from the X25519 secret of private and peerPublic and our
+// current root key. The root and file keys are replaced right away, since
+// data connections copy theirs when they start.
+func (k *SessionKeys) deriveNext(private, public, peerPublic []byte) error {
+	secret, err := curve25519.X25519(private, peerPublic)
+	if err != nil {
+		return fmt.Errorf("failed to compute rekey secret: %w", err)
+	}
+	defer clear(secret)
+	next := SessionKeys{IsInitiator: k.IsInitiator}
+	if err := next.DeriveKeys(slices.Concat(secret, k.root), public, peerPublic); err != nil {
+		return err
+	}
+	clear(k.root)
+	k.root, k.fileSendKey, k.fileReceiveKey = next.root, next.fileSendKey, next.fileReceiveKey
+	k.nextSendKey, k.nextReceiveKey = next.SendKey, next.ReceiveKey
+	return nil
+}
+
+// newExchangeKey generates an X25519 key pair.
+func newExchangeKey() (private, public []byte, err error) {
+	private = make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(private); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	public, err = curve25519.X25519(private, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compute public key: %w", err)
+	}
+	return private, public, nil
+}
+
+// ForTransfer returns the keys for a file data connection whose receiver
+// chose key for it, which has keys of its own in each direction. Unlike
+// ForFiles, they do not change when the session is rekeyed.
+func (k *SessionKeys) ForTransfer(key []byte) (*SessionKeys, error) {
+	fromInitiator, err := hkdf.Key(sha256.New, key, nil, fileFromInitiator, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive transfer keys: %w", err)
+	}
+	fromResponder, err := hkdf.Key(sha256.New, key, nil, fileFromResponder, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive transfer keys: %w", err)
+	}
+	files := k.ForFiles()
+	files.SendKey, files.ReceiveKey = fromInitiator, fromResponder
+	if !k.IsInitiator {
+		files.SendKey, files.ReceiveKey = fromResponder, fromInitiator
+	}
+	return files, nil
+}
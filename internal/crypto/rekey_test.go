@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestKeys returns the initiator's keys as DeriveKeys leaves them.
+func newTestKeys(t *testing.T) *SessionKeys {
+	t.Helper()
+	keys := &SessionKeys{IsInitiator: true}
+	if err := keys.DeriveKeys(make([]byte, 32), testInitiatorKey, testResponderKey); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestRekeyOutOfOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		step func(k *SessionKeys) error
+	}{
+		{"answer without request", func(k *SessionKeys) error {
+			return k.FinishRekey(testResponderKey)
+		}},
+		{"switch without rekey", func(k *SessionKeys) error {
+			return k.SwitchReceive()
+		}},
+		{"second request", func(k *SessionKeys) error {
+			if _, err := k.StartRekey(); err != nil {
+				t.Fatalf("StartRekey: %v", err)
+			}
+			_, err := k.StartRekey()
+			if !errors.Is(err, ErrRekeyPending) {
+				t.Errorf("got %v, want ErrRekeyPending", err)
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := newTestKeys(t)
+			if err := tt.step(keys); err == nil {
+				t.Error("took a rekey step out of order")
+			}
+		})
+	}
+}
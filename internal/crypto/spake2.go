@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// SPAKE2 (RFC 9382) over P-256 lets both ends of a session prove to each
+// other that they know the same session password, without revealing it to
+// the relay and without the relay being able to test guesses offline. The
+// exchange keys of the session are bound into the transcript, so a relay that
+// ran a key exchange of its own with each end cannot complete it.
+//
+// Go only offers group operations for P-256 through crypto/elliptic, whose
+// point API is deprecated but still implemented in constant time for P-256.
+
+var (
+	spakeCurve = elliptic.P256()
+	// spakeM and spakeN are the blinding points. They are derived from public
+	// seeds, so nobody knows their discrete logarithms.
+	spakeM = hashToPoint("jot SPAKE2 P-256 M")
+	spakeN = hashToPoint("jot SPAKE2 P-256 N")
+)
+
+// spakePoint is a point on spakeCurve.
+type spakePoint struct{ x, y *big.Int }
+
+// hashToPoint finds the first point whose x coordinate is SHA-256 of seed and
+// a counter, trying counters in turn.
+func hashToPoint(seed string) spakePoint {
+	for counter := uint32(0); ; counter++ {
+		digest := sha256.Sum256(binary.BigEndian.AppendUint32([]byte(seed), counter))
+		x, y := elliptic.UnmarshalCompressed(spakeCurve, append([]byte{2}, digest[:]...))
+		if x != nil {
+			return spakePoint{x, y}
+		}
+	}
+}
+
+// SPAKE2 is one end's state during a SPAKE2 exchange.
+type SPAKE2 struct {
+	initiator bool
+	context   []byte // Both exchange keys, initiator's first
+	w         []byte // The password as a scalar, big-endian
+	scalar    []byte // Our secret scalar
+	share     []byte // What we send
+}
+
+// NewSPAKE2 starts a SPAKE2 exchange for a session whose exchange keys are
+// initiatorKey and responderKey. The password is stretched with Argon2id,
+// salted with both keys, so guesses cannot be computed ahead of a session.
+func NewSPAKE2(password []byte, initiator bool, initiatorKey, responderKey []byte) (*SPAKE2, error) {
+	context := slices.Concat(initiatorKey, responderKey)
+	salt := slices.Concat([]byte("jot-spake2-v1"), context)
+	stretched := argon2.IDKey(password, salt, 1, 64*1024, 4, 64)
+	w := new(big.Int).Mod(new(big.Int).SetBytes(stretched), spakeCurve.Params().N).FillBytes(make([]byte, 32))
+
+	scalar, err := randomScalar()
+	if err != nil {
+		return nil, err
+	}
+	blind := spakeM
+	if !initiator {
+		blind = spakeN
+	}
+	// share = scalar·G + w·blind
+	gx, gy := spakeCurve.ScalarBaseMult(scalar)
+	bx, by := spakeCurve.ScalarMult(blind.x, blind.y, w)
+	sx, sy := spakeCurve.Add(gx, gy, bx, by)
+
+	return &SPAKE2{
+		initiator: initiator,
+		context:   context,
+		w:         w,
+		scalar:    scalar,
+		share:     elliptic.Marshal(spakeCurve, sx, sy),
+	}, nil
+}
+
+// randomScalar returns a uniformly random non-zero scalar for spakeCurve.
+func randomScalar() ([]byte, error) {
+	order := spakeCurve.Params().N
+	for {
+		k, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate SPAKE2 scalar: %w", err)
+		}
+		if k.Sign() != 0 {
+			return k.FillBytes(make([]byte, 32)), nil
+		}
+	}
+}
+
+// Share returns the message we send to the peer.
+func (s *SPAKE2) Share() []byte { return s.share }
+
+// Finish completes the exchange with the peer's share. It returns a key
+// known only to ends that used the same password, the confirmation to send
+// to the peer and the confirmation expected from it. A relay in the middle,
+// or a peer with another password, cannot produce that confirmation.
+func (s *SPAKE2) Finish(peerShare []byte) (key, ours, theirs []byte, err error) {
+	px, py := elliptic.Unmarshal(spakeCurve, peerShare)
+	if px == nil {
+		return nil, nil, nil, errors.New("peer's SPAKE2 share is not a point on P-256")
+	}
+	blind := spakeN
+	if !s.initiator {
+		blind = spakeM
+	}
+	// K = scalar·(peerShare − w·blind)
+	bx, by := spakeCurve.ScalarMult(blind.x, blind.y, s.w)
+	bx, by = spakeCurve.Add(px, py, bx, new(big.Int).Sub(spakeCurve.Params().P, by))
+	kx, ky := spakeCurve.ScalarMult(bx, by, s.scalar)
+	if kx.Sign() == 0 && ky.Sign() == 0 {
+		return nil, nil, nil, errors.New("peer's SPAKE2 share is invalid")
+	}
+
+	initiatorShare, responderShare := s.share, peerShare
+	if !s.initiator {
+		initiatorShare, responderShare = peerShare, s.share
+	}
+	transcript := appendLengthPrefixed(nil, []byte("jot-initiator"), []byte("jot-responder"), s.context, initiatorShare, responderShare, elliptic.Marshal(spakeCurve, kx, ky), s.w)
+	digest := sha256.Sum256(transcript)
+	key, confirmKey := digest[:16], digest[16:]
+
+	confirmKeys, err := hkdf.Key(sha256.New, confirmKey, nil, "ConfirmationKeys", 64)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to derive SPAKE2 confirmation keys: %w", err)
+	}
+	initiatorConfirm, responderConfirm := confirmation(confirmKeys[:32], transcript), confirmation(confirmKeys[32:], transcript)
+	if s.initiator {
+		return key, initiatorConfirm, responderConfirm, nil
+	}
+	return key, responderConfirm, initiatorConfirm, nil
+}
+
+// confirmation is the HMAC one end sends to prove it holds the same key.
+func confirmation(key, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// appendLengthPrefixed appends each field to dst after its length as eight
+// little-endian bytes, the transcript encoding of RFC 9382.
+func appendLengthPrefixed(dst []byte, fields ...[]byte) []byte {
+	for _, field := range fields {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(len(field)))
+		dst = append(dst, field...)
+	}
+	return dst
+}
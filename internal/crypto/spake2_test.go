@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+var (
+	testInitiatorKey = bytes.Repeat([]byte{1}, 32)
+	testResponderKey = bytes.Repeat([]byte{2}, 32)
+)
+
+// newTestSPAKE2 starts one end of an exchange over the test exchange keys.
+func newTestSPAKE2(t *testing.T, password string, initiator bool) *SPAKE2 {
+	t.Helper()
+	s, err := NewSPAKE2([]byte(password), initiator, testInitiatorKey, testResponderKey)
+	if err != nil {
+		t.Fatalf("NewSPAKE2: %v", err)
+	}
+	return s
+}
+
+func TestSPAKE2(t *testing.T) {
+	// A share from an earlier exchange over the same keys and password, as a
+	// relay that recorded it could replay it.
+	earlier := newTestSPAKE2(t, "correct horse", true).Share()
+
+	straight := func(initiator, responder []byte) (toInitiator, toResponder []byte) {
+		return responder, initiator
+	}
+	tests := []struct {
+		name                 string
+		initiator, responder string // The passwords of both ends
+		// deliver gives each end the share it receives, from those sent.
+		deliver func(initiator, responder []byte) (toInitiator, toResponder []byte)
+		want    bool
+	}{
+		{"matching passwords", "correct horse", "correct horse", straight, true},
+		{"empty passwords", "", "", straight, true},
+		{"mismatched passwords", "correct horse", "battery staple", straight, false},
+		{"passwords differing in case", "correct horse", "Correct horse", straight, false},
+		{"shares reflected", "correct horse", "correct horse", func(initiator, responder []byte) ([]byte, []byte) {
+			return initiator, responder
+		}, false},
+		{"shares swapped", "correct horse", "correct horse", func(initiator, responder []byte) ([]byte, []byte) {
+			return initiator, initiator
+		}, false},
+		{"initiator share replayed", "correct horse", "correct horse", func(initiator, responder []byte) ([]byte, []byte) {
+			return responder, earlier
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initiator, responder := newTestSPAKE2(t, tt.initiator, true), newTestSPAKE2(t, tt.responder, false)
+			toInitiator, toResponder := tt.deliver(initiator.Share(), responder.Share())
+			initiatorKey, initiatorOurs, initiatorTheirs, err := initiator.Finish(toInitiator)
+			if err != nil {
+				t.Fatalf("initiator Finish: %v", err)
+			}
+			responderKey, responderOurs, responderTheirs, err := responder.Finish(toResponder)
+			if err != nil {
+				t.Fatalf("responder Finish: %v", err)
+			}
+
+			confirmed := hmac.Equal(initiatorTheirs, responderOurs) && hmac.Equal(responderTheirs, initiatorOurs)
+			if confirmed != tt.want {
+				t.Errorf("confirmed = %v, want %v", confirmed, tt.want)
+			}
+			if same := bytes.Equal(initiatorKey, responderKey); same != tt.want {
+				t.Errorf("keys equal = %v, want %v", same, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPAKE2ExchangeKeysBound(t *testing.T) {
+	// A relay that ran a key exchange of its own with each end leaves them
+	// with different exchange keys, even with the right password.
+	initiator := newTestSPAKE2(t, "correct horse", true)
+	responder, err := NewSPAKE2([]byte("correct horse"), false, testResponderKey, testInitiatorKey)
+	if err != nil {
+		t.Fatalf("NewSPAKE2: %v", err)
+	}
+	_, initiatorOurs, initiatorTheirs, err := initiator.Finish(responder.Share())
+	if err != nil {
+		t.Fatalf("initiator Finish: %v", err)
+	}
+	_, responderOurs, responderTheirs, err := responder.Finish(initiator.Share())
+	if err != nil {
+		t.Fatalf("responder Finish: %v", err)
+	}
+	if hmac.Equal(initiatorTheirs, responderOurs) || hmac.Equal(responderTheirs, initiatorOurs) {
+		t.Error("confirmed an exchange over different exchange keys")
+	}
+}
+
+func TestSPAKE2InvalidShare(t *testing.T) {
+	tests := []struct {
+		name  string
+		share func(s *SPAKE2) []byte
+	}{
+		{"empty", func(*SPAKE2) []byte { return nil }},
+		{"truncated", func(s *SPAKE2) []byte { return s.Share()[:33] }},
+		{"compressed", func(s *SPAKE2) []byte {
+			x, y := elliptic.Unmarshal(spakeCurve, s.Share())
+			return elliptic.MarshalCompressed(spakeCurve, x, y)
+		}},
+		{"not on the curve", func(*SPAKE2) []byte {
+			one := big.NewInt(1).FillBytes(make([]byte, 32))
+			return slices.Concat([]byte{4}, one, one)
+		}},
+		{"identity once unblinded", func(s *SPAKE2) []byte {
+			// w·N leaves the point at infinity once the initiator takes its
+			// blinding off.
+			x, y := spakeCurve.ScalarMult(spakeN.x, spakeN.y, s.w)
+			return elliptic.Marshal(spakeCurve, x, y)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSPAKE2(t, "correct horse", true)
+			if _, _, _, err := s.Finish(tt.share(s)); err == nil {
+				t.Error("Finish accepted an invalid share")
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/x/term"
+)
+
+// DetachKey detaches from the daemon and leaves it running (Ctrl-]).
+const DetachKey = 0x1d
+
+const (
+	enterScreen = "\x1b[?1049h\x1b[2J\x1b[H\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// ErrDetached is returned by Attach when the user detached with DetachKey.
+var ErrDetached = errors.New("detached")
+
+// Attach connects the current terminal to the daemon at path until the user
+// presses DetachKey or the program in the daemon exits.
+func Attach(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("no daemon is running at %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	stdin, stdout := os.Stdin.Fd(), os.Stdout.Fd()
+	if !term.IsTerminal(stdin) {
+		return errors.New("attach needs an interactive terminal")
+	}
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return fmt.Errorf("could not put terminal into raw mode: %w", err)
+	}
+	defer term.Restore(stdin, state)
+
+	os.Stdout.WriteString(enterScreen)
+	defer os.Stdout.WriteString(leaveScreen)
+
+	var writeMu sync.Mutex
+	send := func(frameType byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(conn, frameType, payload)
+	}
+	sendSize := func() {
+		width, height, err := term.GetSize(stdout)
+		if err != nil {
+			return
+		}
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint16(size[0:2], uint16(width))
+		binary.BigEndian.PutUint16(size[2:4], uint16(height))
+		send(frameResize, size)
+	}
+	sendSize()
+	stop := watchResize(sendSize)
+	defer stop()
+
+	done := make(chan error, 2)
+	go func() {
+		// The daemon closes the connection when its program exits.
+		io.Copy(os.Stdout, conn)
+		done <- nil
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			data := buf[:n]
+			if i := bytes.IndexByte(data, DetachKey); i >= 0 {
+				if i > 0 {
+					send(frameInput, data[:i])
+				}
+				done <- ErrDetached
+				return
+			}
+			if err := send(frameInput, data); err != nil {
+				done <- nil
+				return
+			}
+		}
+	}()
+	return <-done
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package daemon
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// DefaultSocketPath returns the socket used when no other path is given.
+func DefaultSocketPath() string {
+	return filepath.Join(os.TempDir(), "jot", "daemon.sock")
+}
+
+// Spawn is not supported on this platform, which cannot detach a process from its console.
+func Spawn(path string, args []string, childEnv string) error {
+	return errors.New("daemon mode is not supported on this platform")
+}
+
+func watchResize(onResize func()) func() {
+	return func() {}
+}
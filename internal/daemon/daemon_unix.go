@@ -0,0 +1,82 @@
+//go:build unix
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultSocketPath returns the socket used when no other path is given, in a
+// directory only the current user can access.
+func DefaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("jot-%d", os.Getuid()), "daemon.sock")
+}
+
+// Spawn starts this executable again with args in a new session, detached from
+// the terminal, with childEnv added to its environment. It waits until the new
+// daemon is listening at path. The daemon's log is written next to the socket.
+func Spawn(path string, args []string, childEnv string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not locate executable: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("could not create socket directory: %w", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(filepath.Dir(path), "daemon.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), childEnv)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start daemon: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("daemon exited during startup (%v); see %s", err, logFile.Name())
+		case <-time.After(50 * time.Millisecond):
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			cmd.Process.Release()
+			return nil
+		}
+	}
+	return fmt.Errorf("daemon did not start listening at %s", path)
+}
+
+// watchResize calls onResize whenever the terminal is resized, until the returned func is called.
+func watchResize(onResize func()) func() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGWINCH)
+	go func() {
+		for range sig {
+			onResize()
+		}
+	}()
+	return func() {
+		signal.Stop(sig)
+		close(sig)
+	}
+}
@@ -0,0 +1,263 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// Frame types sent from an attached client to the daemon.
+const (
+	frameInput  byte = 0x00 // Raw terminal input
+	frameResize byte = 0x01 // Terminal size: uint16 width, uint16 height
+)
+
+// Server owns the listening socket of a daemon and the terminal I/O of the
+// program running inside it. Output is mirrored to every attached client and
+// discarded while none is attached; keystrokes from any client become the
+// program's input, so several terminals can share one conversation.
+type Server struct {
+	path     string
+	listener net.Listener
+	input    *io.PipeReader
+	inputW   *io.PipeWriter
+
+	mu      sync.Mutex
+	clients map[net.Conn]*client
+}
+
+// client is an attached terminal. Output for it is queued and written by a
+// goroutine of its own, so a terminal that reads slowly holds up neither the
+// program nor the other terminals.
+type client struct {
+	conn          net.Conn
+	output        chan []byte // Closed once the client is detached
+	width, height int         // Of its terminal; 0 until it sent its size
+}
+
+// clientQueue is how many writes of output may wait for a client before it is
+// dropped as too far behind; it can attach again for a full repaint.
+const clientQueue = 256
+
+// writeOutput writes the queued output to the client until it is detached.
+func (c *client) writeOutput() {
+	for b := range c.output {
+		c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := c.conn.Write(b); err != nil {
+			// Closing the connection ends attach, which detaches the client;
+			// until then, whatever is still queued fails fast.
+			c.conn.Close()
+		}
+	}
+}
+
+// Listen creates the daemon socket at path. It fails if another daemon is
+// already listening there and cleans up sockets left behind by dead daemons.
+func Listen(path string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("could not create socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a daemon is already running at %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("could not restrict socket permissions: %w", err)
+	}
+
+	input, inputW := io.Pipe()
+	return &Server{path: path, listener: listener, input: input, inputW: inputW, clients: make(map[net.Conn]*client)}, nil
+}
+
+// Run starts the daemon at path and runs the program built by newProgram until
+// it exits. The program must use the server's Input and the server itself as output.
+func Run(path string, newProgram func(s *Server) *tea.Program) error {
+	s, err := Listen(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// The daemon has no terminal of its own, so take colors from the environment
+	// inherited from the terminal it was started in.
+	lipgloss.SetColorProfile(termenv.NewOutput(os.Stdout, termenv.WithUnsafe()).EnvColorProfile())
+
+	p := newProgram(s)
+	go s.serve(p)
+	_, err = p.Run()
+	return err
+}
+
+// Input returns the keystrokes of the attached clients.
+func (s *Server) Input() io.Reader {
+	return s.input
+}
+
+// Attached reports whether at least one client is attached.
+func (s *Server) Attached() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients) > 0
+}
+
+// clientWriteTimeout drops attached clients that stop reading, so a stalled
+// terminal cannot freeze the program for everyone else.
+const clientWriteTimeout = 5 * time.Second
+
+// Write queues program output for all attached clients. Output produced while
+// no client is attached is dropped; attaching forces a full repaint.
+func (s *Server) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return len(b), nil
+	}
+	// The clients' writers share the copy, which none of them modifies.
+	output := bytes.Clone(b)
+	for _, c := range s.clients {
+		select {
+		case c.output <- output:
+		default:
+			c.conn.Close()
+		}
+	}
+	return len(b), nil
+}
+
+// Close stops accepting clients, disconnects the attached ones and removes the socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for conn := range s.clients {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.inputW.Close()
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serve(p *tea.Program) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.attach(conn, p)
+	}
+}
+
+// attach adds conn to the attached clients and feeds its input to the program until it detaches.
+func (s *Server) attach(conn net.Conn, p *tea.Program) {
+	c := &client{conn: conn, output: make(chan []byte, clientQueue)}
+	go c.writeOutput()
+	s.mu.Lock()
+	s.clients[conn] = c
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		close(c.output)
+		size, ok := s.size()
+		s.mu.Unlock()
+		conn.Close()
+		// The terminals that are left may have room for more.
+		if ok {
+			p.Send(size)
+		}
+	}()
+
+	for {
+		frameType, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch frameType {
+		case frameInput:
+			if _, err := s.inputW.Write(payload); err != nil {
+				return
+			}
+		case frameResize:
+			if len(payload) != 4 {
+				continue
+			}
+			s.mu.Lock()
+			c.width = int(binary.BigEndian.Uint16(payload[0:2]))
+			c.height = int(binary.BigEndian.Uint16(payload[2:4]))
+			size, ok := s.size()
+			s.mu.Unlock()
+			// A size message also makes the renderer repaint the whole screen,
+			// which is what a freshly attached terminal needs.
+			if ok {
+				p.Send(size)
+			}
+		}
+	}
+}
+
+// size returns the size to draw the program at: the smallest width and height
+// among the attached terminals, so that every one of them can show all of it.
+// It reports false while none has sent its size. s.mu must be held.
+func (s *Server) size() (tea.WindowSizeMsg, bool) {
+	var size tea.WindowSizeMsg
+	for _, c := range s.clients {
+		if c.width == 0 || c.height == 0 {
+			continue
+		}
+		if size.Width == 0 || c.width < size.Width {
+			size.Width = c.width
+		}
+		if size.Height == 0 || c.height < size.Height {
+			size.Height = c.height
+		}
+	}
+	return size, size.Width > 0
+}
+
+// maxFrameSize bounds frames from attached clients, which only carry keystrokes and sizes.
+const maxFrameSize = 64 * 1024
+
+func writeFrame(w io.Writer, frameType byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = frameType
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds limit", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
@@ -1,6 +1,7 @@
 package filetransfer
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -8,12 +9,16 @@ import (
 	"path/filepath"
 
 	"github.com/bjarneo/jot/internal/core"
+	"github.com/bjarneo/jot/internal/crypto"
 	"github.com/bjarneo/jot/internal/network"
 	"github.com/bjarneo/jot/internal/protocol"
 )
 
-// RequestSendFile initiates a file transfer by sending a file offer.
-func RequestSendFile(conn net.Conn, sharedKey []byte, filePath string, sender core.MessageSender, maxFileSize int64) {
+// RequestSendFile initiates a file transfer by sending a file offer for filePath.
+// The offer carries offerID and is presented to the peer as fileName, or the base name
+// of filePath if fileName is empty. peerMaxFileSize is the limit the peer advertised,
+// or 0 if it is unknown.
+func RequestSendFile(conn net.Conn, keys *crypto.SessionKeys, offerID, filePath, fileName string, sender core.MessageSender, maxFileSize, peerMaxFileSize int64) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		sender.SendError(fmt.Errorf("could not open file: %w", err))
@@ -31,25 +36,29 @@ func RequestSendFile(conn net.Conn, sharedKey []byte, filePath string, sender co
 		sender.SendFileOfferFailed(fmt.Sprintf("file size (%.2f MB) exceeds the limit (%.2f MB)", float64(fileInfo.Size())/1024/1024, float64(maxFileSize)/1024/1024))
 		return
 	}
-
-	meta := protocol.FileMetadata{FileName: filepath.Base(filePath), FileSize: fileInfo.Size(), OriginalPath: filePath}
-	metaBytes, err := meta.ToJSON()
-	if err != nil {
-		sender.SendError(fmt.Errorf("could not create metadata: %w", err))
+	if peerMaxFileSize > 0 && fileInfo.Size() > peerMaxFileSize {
+		sender.SendFileOfferFailed(fmt.Sprintf("file size (%.2f MB) exceeds the peer's limit (%.2f MB)", float64(fileInfo.Size())/1024/1024, float64(peerMaxFileSize)/1024/1024))
 		return
 	}
 
-	if err := network.SendData(conn, sharedKey, protocol.TypeFileOffer, metaBytes); err != nil {
+	if fileName == "" {
+		fileName = filepath.Base(filePath)
+	}
+	meta := protocol.FileMetadata{ID: offerID, FileName: fileName, FileSize: fileInfo.Size()}
+	if err := network.SendJSON(conn, keys, protocol.TypeFileOffer, meta); err != nil {
 		sender.SendError(fmt.Errorf("could not send file offer: %w", err))
 	}
 }
 
-// SendFileChunks sends file content in chunks over the connection.
-func SendFileChunks(conn net.Conn, sharedKey []byte, filePath string, sender core.MessageSender) {
+// ErrCanceled is returned by SendFileChunks when the transfer was canceled.
+var ErrCanceled = errors.New("transfer canceled")
+
+// SendFileChunks sends file content in chunks over the connection, until it
+// is done or stop is closed.
+func SendFileChunks(conn net.Conn, keys *crypto.SessionKeys, filePath string, sender core.MessageSender, stop <-chan struct{}) error {
 	file, err := os.Open(filePath)
 	if err != nil {
-		sender.SendError(fmt.Errorf("could not open file for streaming: %w", err))
-		return
+		return fmt.Errorf("could not open file for streaming: %w", err)
 	}
 	defer file.Close()
 
@@ -58,27 +67,31 @@ func SendFileChunks(conn net.Conn, sharedKey []byte, filePath string, sender cor
 	buffer := make([]byte, 1024*4) // 4KB chunks
 
 	for {
+		select {
+		case <-stop:
+			return ErrCanceled
+		default:
+		}
+
 		bytesRead, err := file.Read(buffer)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			sender.SendError(fmt.Errorf("could not read file chunk: %w", err))
-			return
+			return fmt.Errorf("could not read file chunk: %w", err)
 		}
 
 		chunk := buffer[:bytesRead]
-		if err := network.SendData(conn, sharedKey, protocol.TypeFileChunk, chunk); err != nil {
-			sender.SendError(fmt.Errorf("could not send file chunk: %w", err))
-			return
+		if err := network.SendData(conn, keys, protocol.TypeFileChunk, chunk); err != nil {
+			return fmt.Errorf("could not send file chunk: %w", err)
 		}
 
 		totalBytesSent += int64(bytesRead)
 		sender.SendProgress(float64(totalBytesSent) / float64(fileInfo.Size()))
 	}
 
-	if err := network.SendData(conn, sharedKey, protocol.TypeFileDone, nil); err != nil {
-		sender.SendError(fmt.Errorf("could not send file done message: %w", err))
-		return
+	if err := network.SendData(conn, keys, protocol.TypeFileDone, nil); err != nil {
+		return fmt.Errorf("could not send file done message: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,179 @@
+package filetransfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// QuarantineDir is where incoming files are written while they are being received.
+// It lives next to the download directory so finished files can be renamed into place.
+const QuarantineDir = ".jot-incoming"
+
+// IncomingFile is a file being received from the peer. Its data is written to an
+// opaque temporary name in the quarantine directory and only renamed to its real
+// name once the transfer has completed and verified, so partial or rejected
+// transfers never leave attacker-chosen file names behind. A data connection
+// writes to it from a goroutine of its own, so it is safe for concurrent use.
+type IncomingFile struct {
+	Metadata protocol.FileMetadata
+
+	mu       sync.Mutex
+	file     *os.File
+	written  int64
+	verified bool // The whole file arrived and was closed
+}
+
+// NewIncomingFile creates the quarantine file for an accepted offer.
+func NewIncomingFile(meta protocol.FileMetadata) (*IncomingFile, error) {
+	if err := os.MkdirAll(QuarantineDir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create quarantine directory: %w", err)
+	}
+	file, err := os.CreateTemp(QuarantineDir, "incoming-*.part")
+	if err != nil {
+		return nil, fmt.Errorf("could not create quarantine file: %w", err)
+	}
+	return &IncomingFile{Metadata: meta, file: file}, nil
+}
+
+// Write appends a chunk, refusing data beyond the size announced in the offer.
+func (f *IncomingFile) Write(chunk []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.written+int64(len(chunk)) > f.Metadata.FileSize {
+		return 0, fmt.Errorf("peer sent more data than the %d bytes it offered", f.Metadata.FileSize)
+	}
+	n, err := f.file.Write(chunk)
+	f.written += int64(n)
+	return n, err
+}
+
+// Written returns the number of bytes received so far.
+func (f *IncomingFile) Written() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written
+}
+
+// Verify checks that the whole file arrived and closes it, but leaves it under
+// its opaque name in the quarantine directory, so that it can be checked, for
+// example by a post-receive hook, before Finalize moves it into place. It
+// returns the file's path there.
+func (f *IncomingFile) Verify() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.verify(); err != nil {
+		return "", err
+	}
+	return f.file.Name(), nil
+}
+
+// verify is Verify with f.mu held. It does nothing once the file is verified.
+func (f *IncomingFile) verify() error {
+	if f.verified {
+		return nil
+	}
+	if f.written != f.Metadata.FileSize {
+		f.abort()
+		return fmt.Errorf("transfer incomplete: received %d of %d bytes", f.written, f.Metadata.FileSize)
+	}
+	if err := f.file.Sync(); err != nil {
+		f.abort()
+		return fmt.Errorf("could not flush received file: %w", err)
+	}
+	if err := f.file.Close(); err != nil {
+		os.Remove(f.file.Name())
+		return fmt.Errorf("could not close received file: %w", err)
+	}
+	f.verified = true
+	return nil
+}
+
+// Finalize verifies the transfer, unless Verify already did, and moves the file
+// into destDir under its sanitized offered name, adding a numeric suffix instead
+// of overwriting an existing file. It returns the final path.
+func (f *IncomingFile) Finalize(destDir string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.verify(); err != nil {
+		return "", err
+	}
+
+	destPath, err := availablePath(destDir, f.Name())
+	if err != nil {
+		os.Remove(f.file.Name())
+		return "", err
+	}
+	if err := os.Rename(f.file.Name(), destPath); err != nil {
+		os.Remove(f.file.Name())
+		return "", fmt.Errorf("could not move received file into place: %w", err)
+	}
+	// Best effort: the quarantine directory is only removed once it is empty.
+	os.Remove(QuarantineDir)
+	return destPath, nil
+}
+
+// Name returns the name the file is saved under, without the suffix that
+// Finalize adds when the name is taken.
+func (f *IncomingFile) Name() string {
+	return SanitizeFileName(f.Metadata.FileName)
+}
+
+// Abort discards the partially received file.
+func (f *IncomingFile) Abort() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.abort()
+}
+
+// abort is Abort with f.mu held.
+func (f *IncomingFile) abort() error {
+	f.file.Close()
+	err := os.Remove(f.file.Name())
+	os.Remove(QuarantineDir)
+	return err
+}
+
+// availablePath returns a path in dir for name that does not exist yet.
+func availablePath(dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := filepath.Join(dir, name)
+	for i := 1; i < 1000; i++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		}
+		candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+	}
+	return "", fmt.Errorf("could not find a free file name for %s", name)
+}
+
+// FlaggedDir is where received files are moved when the post-receive hook flags them.
+var FlaggedDir = filepath.Join(QuarantineDir, "flagged")
+
+// Quarantine moves a verified file that was flagged into FlaggedDir, under its
+// sanitized offered name, instead of into the download directory. It returns
+// the file's new path.
+func (f *IncomingFile) Quarantine() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.verify(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(FlaggedDir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create quarantine directory: %w", err)
+	}
+	destPath, err := availablePath(FlaggedDir, f.Name())
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.file.Name(), destPath); err != nil {
+		return "", fmt.Errorf("could not quarantine file: %w", err)
+	}
+	return destPath, nil
+}
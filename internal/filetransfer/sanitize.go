@@ -0,0 +1,94 @@
+package filetransfer
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	fallbackFileName = "received-file"
+	maxFileNameBytes = 255
+)
+
+// windowsReservedNames are device names that cannot be used as file names on
+// Windows, regardless of extension or case.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFileName turns a peer-supplied file name into a safe, single path
+// component. It strips directories written with either separator, drive letters,
+// control and invisible formatting characters (such as right-to-left overrides),
+// characters Windows forbids, leading dots and trailing dots or spaces, and
+// renames reserved Windows device names, as well as names starting with a dash,
+// which a command given the file would take for an option. filepath.Base alone
+// does not handle backslash-separated paths on Unix or any of the
+// Windows-specific cases.
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	// Drop a drive letter such as "C:" that survived without a separator.
+	if len(name) >= 2 && name[1] == ':' {
+		name = name[2:]
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError:
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r):
+			continue
+		case strings.ContainsRune(`<>:"|?*`, r):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+
+	// Leading dots would create hidden files (or "." and ".."); Windows silently
+	// drops trailing dots and spaces, which can make two names collide.
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+
+	stem := name
+	if i := strings.Index(stem, "."); i >= 0 {
+		stem = stem[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] || strings.HasPrefix(name, "-") {
+		name = "_" + name
+	}
+
+	name = truncateFileName(name, maxFileNameBytes)
+	if name == "" {
+		return fallbackFileName
+	}
+	return name
+}
+
+// truncateFileName shortens name to at most limit bytes, keeping a short extension
+// and never splitting a multi-byte character.
+func truncateFileName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+	ext := ""
+	if i := strings.LastIndex(name, "."); i > 0 && len(name)-i <= 16 {
+		ext = name[i:]
+		name = name[:i]
+	}
+	budget := limit - len(ext)
+	for len(name) > budget {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + ext
+}
@@ -0,0 +1,52 @@
+package filetransfer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "report.pdf", "report.pdf"},
+		{"parent directories", "../../x", "x"},
+		{"absolute path", "/etc/passwd", "passwd"},
+		{"drive letter", `C:\x`, "x"},
+		{"drive letter without separator", "C:x", "x"},
+		{"backslash parent directories", `..\..\x`, "x"},
+		{"reserved device name", "CON.txt", "_CON.txt"},
+		{"reserved device name in lower case", "nul", "_nul"},
+		{"right-to-left override", "invoice\u202Etxt.exe", "invoicetxt.exe"},
+		{"control characters", "a\x00b\x1bc\nd\x7f.txt", "abcd.txt"},
+		{"leading dash", "-e", "_-e"},
+		{"leading dash after dots", "..-rf", "_-rf"},
+		{"dash inside", "a-b", "a-b"},
+		{"forbidden characters", `a<b>c:d"e|f?g*h`, "a_b_c_d_e_f_g_h"},
+		{"hidden file", ".bashrc", "bashrc"},
+		{"trailing dots and spaces", "name. . ", "name"},
+		{"empty", "", fallbackFileName},
+		{"dot", ".", fallbackFileName},
+		{"dot dot", "..", fallbackFileName},
+		{"all dots", ".....", fallbackFileName},
+		{"trailing separator", "dir/", fallbackFileName},
+		{"only control characters", "\x01\x02", fallbackFileName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeFileName(tt.in); got != tt.want {
+				t.Errorf("SanitizeFileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFileNameLength(t *testing.T) {
+	got := SanitizeFileName(strings.Repeat("é", 200) + ".txt")
+	if len(got) > maxFileNameBytes {
+		t.Errorf("got %d bytes, want at most %d", len(got), maxFileNameBytes)
+	}
+	if !strings.HasSuffix(got, ".txt") {
+		t.Errorf("got %q, want the extension kept", got)
+	}
+}
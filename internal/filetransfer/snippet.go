@@ -0,0 +1,45 @@
+package filetransfer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxSnippetSize is the largest file /cat will share as a chat message.
+const MaxSnippetSize = 16 * 1024
+
+// ReadSnippet reads a small text file and formats it as a markdown code block,
+// using the file extension as the language hint.
+func ReadSnippet(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %w", err)
+	}
+	defer file.Close()
+
+	// Read one byte past the limit to detect oversized files without trusting Stat.
+	data, err := io.ReadAll(io.LimitReader(file, MaxSnippetSize+1))
+	if err != nil {
+		return "", fmt.Errorf("could not read file: %w", err)
+	}
+	if len(data) > MaxSnippetSize {
+		return "", fmt.Errorf("file is larger than %d KB; use /send instead", MaxSnippetSize/1024)
+	}
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return "", fmt.Errorf("file does not look like text; use /send instead")
+	}
+
+	text := strings.TrimRight(string(data), "\n")
+	// Use a longer fence if the file itself contains one.
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	return fmt.Sprintf("%s%s\n%s\n%s", fence, lang, text, fence), nil
+}
@@ -0,0 +1,62 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds how long a hook may run before it is killed.
+const DefaultTimeout = 2 * time.Minute
+
+// Result is the outcome of running a hook.
+type Result struct {
+	Output   string // Combined stdout and stderr, trimmed
+	ExitCode int    // -1 if the command could not be started or was killed
+}
+
+// Run executes command with args appended. The command string is split on
+// whitespace and never passed through a shell, so peer-controlled values in
+// args or env cannot inject extra commands. env entries are added to the
+// current environment and stdin, if not empty, is written to the hook's input.
+// A non-nil error means the hook could not run; a non-zero exit is reported in
+// the Result instead.
+func Run(command string, args []string, env []string, stdin string) (Result, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return Result{ExitCode: -1}, errors.New("hook command is empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, fields[0], append(fields[1:], args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	result := Result{Output: strings.TrimSpace(output.String())}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return result, nil
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	default:
+		result.ExitCode = -1
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		return result, err
+	}
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UploadBlob leaves data, which must already be encrypted, on the relay for
+// the peer to fetch later, proving with opts.NoticeKey that we take part in
+// sessionID. It returns the token the file can be downloaded with and when the
+// relay drops it.
+func UploadBlob(opts DialOptions, sessionID string, data []byte) (string, time.Time, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer conn.Close()
+
+	uploadMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		NoticeKey string `json:"noticeKey"`
+		Size      int64  `json:"size"`
+	}{
+		Command:   "UPLOAD",
+		SessionID: sessionID,
+		NoticeKey: opts.NoticeKey,
+		Size:      int64(len(data)),
+	}
+	response, err := sendCommand(conn, uploadMsg)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if strings.TrimSpace(response) != "Upload: ready" {
+		return "", time.Time{}, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	if _, err := conn.Write(data); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to upload the file: %w", err)
+	}
+	response, err = readLine(conn)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to read response from relay server: %w", err)
+	}
+	if reason, ok := strings.CutPrefix(response, "Error:"); ok {
+		return "", time.Time{}, parseRelayError(reason)
+	}
+	uploaded, ok := strings.CutPrefix(response, "Uploaded:")
+	token, expiry, found := strings.Cut(strings.TrimSpace(uploaded), " ")
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if !ok || !found || err != nil {
+		return "", time.Time{}, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return token, time.Unix(unix, 0), nil
+}
+
+// DownloadBlob fetches the file the relay keeps under token, refusing files
+// larger than maxSize bytes.
+func DownloadBlob(opts DialOptions, token string, maxSize int64) ([]byte, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	downloadMsg := struct {
+		Command string `json:"command"`
+		Blob    string `json:"blob"`
+	}{
+		Command: "DOWNLOAD",
+		Blob:    token,
+	}
+	response, err := sendCommand(conn, downloadMsg)
+	if err != nil {
+		return nil, err
+	}
+	sizeText, ok := strings.CutPrefix(response, "Download:")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 10, 64)
+	if !ok || err != nil || size < 0 {
+		return nil, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("the file on the relay is larger than the %d bytes expected", maxSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, fmt.Errorf("failed to download the file: %w", err)
+	}
+	return data, nil
+}
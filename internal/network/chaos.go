@@ -0,0 +1,93 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// Chaos makes connections unreliable on purpose, so that acks, transfers and
+// reconnecting can be tested without a bad network to hand. Each frame we send
+// is dropped, delayed or sent twice with the given probabilities. The frames
+// that set up a session are spared, or no session would start.
+type Chaos struct {
+	Drop      float64
+	Duplicate float64
+	Delay     float64
+	MaxDelay  time.Duration // Delayed frames wait up to this long
+}
+
+// defaultChaosDelay is how long a delayed frame waits at most unless told.
+const defaultChaosDelay = 2 * time.Second
+
+// ParseChaos parses a -chaos setting such as "drop=0.05,dup=0.02,delay=0.1:3s",
+// where each number is the probability of that fault per frame.
+func ParseChaos(spec string) (*Chaos, error) {
+	chaos := &Chaos{MaxDelay: defaultChaosDelay}
+	for _, part := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("chaos setting %q is not name=probability", part)
+		}
+		if name == "delay" {
+			if probability, maxDelay, ok := strings.Cut(value, ":"); ok {
+				d, err := time.ParseDuration(maxDelay)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid chaos delay %q", maxDelay)
+				}
+				chaos.MaxDelay, value = d, probability
+			}
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("chaos probability %q is not between 0 and 1", value)
+		}
+		switch name {
+		case "drop":
+			chaos.Drop = p
+		case "dup":
+			chaos.Duplicate = p
+		case "delay":
+			chaos.Delay = p
+		default:
+			return nil, fmt.Errorf("unknown chaos fault %q: use drop, dup or delay", name)
+		}
+	}
+	return chaos, nil
+}
+
+// chaosConn applies Chaos to the frames written to a connection. Every frame
+// is written with a single Write, so it can tell frames from the command lines
+// sent before them, which it leaves alone.
+type chaosConn struct {
+	net.Conn
+	chaos *Chaos
+}
+
+func (c *chaosConn) Write(p []byte) (int, error) {
+	if len(p) < protocol.FrameHeaderSize || int(binary.BigEndian.Uint32(p[1:])) != len(p)-protocol.FrameHeaderSize {
+		return c.Conn.Write(p)
+	}
+	switch p[0] {
+	case protocol.TypePublicKeyExchange, protocol.TypePassword, protocol.TypeIdentity, protocol.TypeHello, protocol.TypeRekey:
+		return c.Conn.Write(p)
+	}
+	if rand.Float64() < c.chaos.Drop {
+		return len(p), nil
+	}
+	if rand.Float64() < c.chaos.Delay {
+		time.Sleep(rand.N(c.chaos.MaxDelay))
+	}
+	if rand.Float64() < c.chaos.Duplicate {
+		if _, err := c.Conn.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
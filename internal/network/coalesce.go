@@ -0,0 +1,106 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+const (
+	// coalesceDelay is how long a small write may wait for others to join it.
+	coalesceDelay = 2 * time.Millisecond
+	// coalesceLimit is the amount of buffered data that is written at once,
+	// without waiting for coalesceDelay.
+	coalesceLimit = 16 * 1024
+)
+
+// CoalescingConn batches small writes, such as the frames of a quick exchange
+// of messages, into fewer and larger writes to the underlying connection. That
+// saves system calls on both ends and wakes the relay once instead of once per
+// frame. A write is delayed by at most coalesceDelay.
+//
+// Every Write is appended whole, so frames written from several goroutines
+// never interleave. Because writes are deferred, a failure to send is reported
+// by the Write or Flush after it, except for the frames in flushedTypes, which
+// go out with the data before them as soon as they are written.
+type CoalescingConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer // Flushes buf; set while buf holds data
+	err   error       // First failed write; every later write fails with it
+}
+
+// flushedTypes are the frames whose senders must learn whether they went out,
+// since nothing else tells them: an ack, the end of a file and a goodbye. A
+// failed timed flush would only reach some unrelated later Write.
+var flushedTypes = [256]bool{protocol.TypeAck: true, protocol.TypeFileDone: true, protocol.TypeBye: true}
+
+// flushFrame writes a frame of msgType that was just written to conn through
+// at once, if conn coalesces writes and msgType is one of flushedTypes, and
+// returns the error of sending it.
+func flushFrame(conn net.Conn, msgType byte) error {
+	if c, ok := conn.(*CoalescingConn); ok && flushedTypes[msgType] {
+		return c.Flush()
+	}
+	return nil
+}
+
+// NewCoalescingConn wraps conn. Reads pass straight through.
+func NewCoalescingConn(conn net.Conn) *CoalescingConn {
+	return &CoalescingConn{Conn: conn}
+}
+
+func (c *CoalescingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(c.buf) == 0 && len(p) >= coalesceLimit {
+		// Nothing to join; skip the copy.
+		n, err := c.Conn.Write(p)
+		c.err = err
+		return n, err
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= coalesceLimit {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(coalesceDelay, func() { c.Flush() })
+	}
+	return len(p), nil
+}
+
+// Flush writes any buffered data now.
+func (c *CoalescingConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+func (c *CoalescingConn) flushLocked() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 || c.err != nil {
+		return c.err
+	}
+	_, c.err = c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return c.err
+}
+
+// Close flushes buffered data and closes the connection.
+func (c *CoalescingConn) Close() error {
+	c.Flush()
+	return c.Conn.Close()
+}
@@ -0,0 +1,44 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// failingConn is a connection every write to which fails.
+type failingConn struct {
+	net.Conn
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (failingConn) Write([]byte) (int, error) { return 0, errWriteFailed }
+
+func TestCoalescingConnReportsFlushedTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgType byte
+		want    error
+	}{
+		{"text waits for the timer", protocol.TypeText, nil},
+		{"ack", protocol.TypeAck, errWriteFailed},
+		{"file done", protocol.TypeFileDone, errWriteFailed},
+		{"bye", protocol.TypeBye, errWriteFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := NewCoalescingConn(failingConn{})
+			keys := testKeys(t, crypto.CipherAESGCM)
+			if err := SendData(conn, keys, tt.msgType, []byte("x")); !errors.Is(err, tt.want) {
+				t.Errorf("SendData = %v, want %v", err, tt.want)
+			}
+			if err := conn.Flush(); !errors.Is(err, errWriteFailed) {
+				t.Errorf("Flush = %v, want %v", err, errWriteFailed)
+			}
+		})
+	}
+}
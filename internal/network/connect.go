@@ -0,0 +1,552 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DialOptions describes how to reach the relay server.
+type DialOptions struct {
+	RelayServerAddr string
+	// Via is an optional first relay that forwards the connection to RelayServerAddr.
+	// The TLS session with RelayServerAddr runs through it, so Via only learns our
+	// address and RelayServerAddr only learns Via's.
+	Via string
+	// OwnerKey is a secret sent with CREATE. Presenting it again later lets the
+	// session's creator make owner-only requests, such as minting invites.
+	OwnerKey string
+	// NoticeKey is a secret sent with CREATE and JOIN. Presenting it again with
+	// Subscribe shows the relay which end of the session wants its notices.
+	NoticeKey string
+	// WaitingRoom asks the relay, with CREATE, to hold joining clients until
+	// the owner admits them over a control connection (see Watch).
+	WaitingRoom bool
+	// Observe asks the relay, with JOIN, to let us in as an observer, who may
+	// not send files. Where the owner is watching the session, the relay asks
+	// the owner first, as in a waiting room.
+	Observe bool
+	// ClientCert, if set, is presented to every relay we open a TLS connection
+	// to, for relays that only admit clients with a certificate from their CA.
+	ClientCert *tls.Certificate
+	// PinnedCert, if set, is the SHA-256 hash of the certificate, or of its
+	// public key, that RelayServerAddr must present. It takes the place of
+	// checking the certificate against the system's CAs, so relays with
+	// self-signed certificates can be reached safely. Via is not pinned.
+	PinnedCert []byte
+	// Identity, if set, signs CREATE and JOIN requests, so relays that reserve
+	// names keep a chosen session ID for this key, and relays that ban keys
+	// can tell who asks. Only pass a key that outlives the session, or a name
+	// is held for a key nobody has any more.
+	Identity ed25519.PrivateKey
+	// Introduce is called when we join a session that needs the owner's approval,
+	// with the relay's prompt: a "Waiting:" line for a waiting room, or a "Locked:"
+	// line for a locked session, where the intro should carry a knock note. Its
+	// result is shown to the owner, who decides whether to let us in. A nil
+	// Introduce joins waiting rooms anonymously and gives up on locked sessions.
+	Introduce func(prompt string) (JoinIntro, error)
+	// Work is called when the relay demands proof of work before CREATE or
+	// JOIN, with its "Challenge:" prompt. A nil Work solves the challenge here.
+	Work func(prompt string) (WorkSolution, error)
+
+	ConnectPolicy
+	// Retrying, if set, is called before each retry with the number of the
+	// retry, how long Connect waits before it, and why the last attempt failed.
+	Retrying func(retry int, wait time.Duration, err error)
+}
+
+// ConnectPolicy sets how long reaching a relay may take and how often Connect
+// tries again when it cannot. The zero value uses the defaults and never retries.
+type ConnectPolicy struct {
+	// ConnectTimeout bounds resolving a relay's address and opening the TCP
+	// connection to it. Zero uses DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+	// HandshakeTimeout bounds the TLS handshake with a relay and the wait for
+	// its answer to our first command. Zero uses DefaultHandshakeTimeout.
+	HandshakeTimeout time.Duration
+	// Retries is how many more times Connect tries to reach the relay when it
+	// cannot. It waits RetryBackoff before the first retry and twice as long
+	// before each one after that. Errors reported by the relay are not retried,
+	// unless it was short of capacity.
+	Retries      int
+	RetryBackoff time.Duration
+	// Chaos, if set, makes every connection drop, delay and repeat frames,
+	// for testing.
+	Chaos *Chaos
+}
+
+const (
+	DefaultConnectTimeout   = 15 * time.Second
+	DefaultHandshakeTimeout = 10 * time.Second
+	// maxRetryBackoff caps the doubling wait between retries.
+	maxRetryBackoff = time.Minute
+)
+
+func (p ConnectPolicy) connectTimeout() time.Duration {
+	if p.ConnectTimeout > 0 {
+		return p.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
+func (p ConnectPolicy) handshakeTimeout() time.Duration {
+	if p.HandshakeTimeout > 0 {
+		return p.HandshakeTimeout
+	}
+	return DefaultHandshakeTimeout
+}
+
+// JoinIntro is how a client joining a session with a waiting room introduces
+// itself to the session owner. The relay passes it on unverified.
+type JoinIntro struct {
+	Nickname    string `json:"nickname"`
+	Fingerprint string `json:"fingerprint"`    // Fingerprint of the joiner's identity key
+	Note        string `json:"note,omitempty"` // Knock note for a locked session
+}
+
+// IsLockedPrompt reports whether an Introduce prompt asks for a knock note.
+func IsLockedPrompt(prompt string) bool {
+	return strings.HasPrefix(prompt, "Locked:")
+}
+
+// Connect dials the relay server and sends the initial CREATE or JOIN command.
+// It returns the connection and the session ID assigned by the relay. A relay
+// that turns us away for lack of capacity is tried again as opts ask, waiting
+// at least as long as the relay suggests.
+func Connect(opts DialOptions, command, sessionID string) (net.Conn, string, error) {
+	conn, id, err := connect(opts, command, sessionID)
+	wait := opts.RetryBackoff
+	for retry := 1; IsCapacityError(err) && retry <= opts.Retries; retry++ {
+		var relayErr *RelayError
+		errors.As(err, &relayErr)
+		wait = min(max(wait, time.Duration(relayErr.Capacity.RetryAfter)*time.Second), maxRetryBackoff)
+		if opts.Retrying != nil {
+			opts.Retrying(retry, wait, err)
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, maxRetryBackoff)
+		conn, id, err = connect(opts, command, sessionID)
+	}
+	return conn, id, err
+}
+
+// connect makes one attempt at Connect.
+func connect(opts DialOptions, command, sessionID string) (net.Conn, string, error) {
+	conn, err := dialWithRetries(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	initialMsgStruct := struct {
+		Command     string `json:"command"`
+		SessionID   string `json:"sessionID,omitempty"`
+		OwnerKey    string `json:"ownerKey,omitempty"`
+		WaitingRoom bool   `json:"waitingRoom,omitempty"`
+		Observe     bool   `json:"observe,omitempty"`
+		NoticeKey   string `json:"noticeKey,omitempty"`
+		*CreateProof
+	}{
+		Command:   command,
+		SessionID: sessionID,
+		NoticeKey: opts.NoticeKey,
+	}
+	if command == "CREATE" {
+		initialMsgStruct.OwnerKey = opts.OwnerKey
+		initialMsgStruct.WaitingRoom = opts.WaitingRoom
+		if opts.Identity != nil {
+			initialMsgStruct.CreateProof = SignCreate(opts.Identity, sessionID, time.Now())
+		}
+	} else if command == "JOIN" {
+		initialMsgStruct.Observe = opts.Observe
+		if opts.Identity != nil {
+			initialMsgStruct.CreateProof = SignJoin(opts.Identity, sessionID, time.Now())
+		}
+	}
+
+	conn.SetDeadline(time.Now().Add(opts.handshakeTimeout()))
+	response, err := sendCommand(conn, initialMsgStruct)
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	// Relays may make us pay for a session with proof of work.
+	if strings.HasPrefix(response, "Challenge:") {
+		if response, err = answerChallenge(conn, opts, response); err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+	}
+
+	// A session with a waiting room or a lock asks us to introduce ourselves,
+	// then answers once the owner has decided.
+	if strings.HasPrefix(response, "Waiting:") || IsLockedPrompt(response) {
+		intro := JoinIntro{}
+		if opts.Introduce != nil {
+			intro, err = opts.Introduce(response)
+		} else if IsLockedPrompt(response) {
+			err = fmt.Errorf("session is locked")
+		}
+		if err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+		if response, err = sendCommand(conn, intro); err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+	}
+
+	if strings.HasPrefix(response, "Session created:") {
+		sessionID = strings.TrimSpace(strings.TrimPrefix(response, "Session created:"))
+	}
+	return conn, sessionID, nil
+}
+
+// answerChallenge solves the relay's proof-of-work challenge and returns its next response.
+func answerChallenge(conn net.Conn, opts DialOptions, prompt string) (string, error) {
+	if opts.Work != nil {
+		solution, err := opts.Work(prompt)
+		if err != nil {
+			return "", err
+		}
+		return sendCommand(conn, solution)
+	}
+	nonce, difficulty, err := ParseChallenge(prompt)
+	if err != nil {
+		return "", err
+	}
+	solution, err := SolveWork(nonce, difficulty)
+	if err != nil {
+		return "", err
+	}
+	return sendCommand(conn, WorkSolution{Solution: solution})
+}
+
+// Invite asks the relay to mint a single-use join token for sessionID, which
+// must have been created with opts.OwnerKey. The peer joins with the token in
+// place of the session ID.
+func Invite(opts DialOptions, sessionID string) (string, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	inviteMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		OwnerKey  string `json:"ownerKey"`
+	}{
+		Command:   "INVITE",
+		SessionID: sessionID,
+		OwnerKey:  opts.OwnerKey,
+	}
+	response, err := sendCommand(conn, inviteMsg)
+	if err != nil {
+		return "", err
+	}
+	token, ok := strings.CutPrefix(response, "Invite:")
+	if !ok {
+		return "", fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// TransferOwnership asks the relay to make our peer the owner of sessionID,
+// which must have been created with opts.OwnerKey. The peer proves its
+// ownership from then on with its own notice key, and opts.OwnerKey stops
+// working.
+func TransferOwnership(opts DialOptions, sessionID string) error {
+	conn, err := dial(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	transferMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		OwnerKey  string `json:"ownerKey"`
+		NoticeKey string `json:"noticeKey"`
+	}{
+		Command:   "TRANSFER",
+		SessionID: sessionID,
+		OwnerKey:  opts.OwnerKey,
+		NoticeKey: opts.NoticeKey,
+	}
+	response, err := sendCommand(conn, transferMsg)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(response, "Transferred:") {
+		return fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return nil
+}
+
+// dialWithRetries calls dial, retrying as opts ask when the relay cannot be reached.
+func dialWithRetries(opts DialOptions) (net.Conn, error) {
+	conn, err := dial(opts)
+	wait := opts.RetryBackoff
+	for retry := 1; err != nil && retry <= opts.Retries && !IsRelayError(err); retry++ {
+		if opts.Retrying != nil {
+			opts.Retrying(retry, wait, err)
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, maxRetryBackoff)
+		conn, err = dial(opts)
+	}
+	return conn, err
+}
+
+// dial opens a connection to the relay server, through the Via relay if one is set.
+func dial(opts DialOptions) (net.Conn, error) {
+	conn, err := dialServer(opts)
+	if err != nil || opts.Chaos == nil {
+		return conn, err
+	}
+	return &chaosConn{Conn: conn, chaos: opts.Chaos}, nil
+}
+
+// dialServer is dial without Chaos.
+func dialServer(opts DialOptions) (net.Conn, error) {
+	if opts.Via == "" {
+		conn, err := dialRelay(nil, opts.RelayServerAddr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to relay server: %w", err)
+		}
+		return conn, nil
+	}
+
+	viaConn, err := dialRelay(nil, opts.Via, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay server %s: %w", opts.Via, err)
+	}
+
+	forwardMsg := struct {
+		Command string `json:"command"`
+		Target  string `json:"target"`
+	}{
+		Command: "FORWARD",
+		Target:  opts.RelayServerAddr,
+	}
+	viaConn.SetDeadline(time.Now().Add(opts.handshakeTimeout()))
+	_, err = sendCommand(viaConn, forwardMsg)
+	viaConn.SetDeadline(time.Time{})
+	if err != nil {
+		viaConn.Close()
+		return nil, err
+	}
+
+	conn, err := dialRelay(viaConn, opts.RelayServerAddr, opts)
+	if err != nil {
+		viaConn.Close()
+		return nil, fmt.Errorf("failed to connect to relay server %s via %s: %w", opts.RelayServerAddr, opts.Via, err)
+	}
+	return conn, nil
+}
+
+// LoadClientCert loads the certificate and key given with -client-cert and
+// -client-key. It returns nil if neither is given.
+func LoadClientCert(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("a client certificate needs both -client-cert and -client-key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// ParseCertPin parses the value of -pin-cert: "sha256:" followed by the hex
+// SHA-256 hash of a certificate or of its public key (SPKI), with or without
+// colons between the bytes, as openssl prints it. It returns nil for "".
+func ParseCertPin(value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	digits, ok := strings.CutPrefix(strings.ToLower(value), "sha256:")
+	if !ok {
+		return nil, fmt.Errorf("-pin-cert must start with sha256:, not %q", value)
+	}
+	pin, err := hex.DecodeString(strings.ReplaceAll(digits, ":", ""))
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("-pin-cert needs the 64 hex digits of a SHA-256 hash after sha256:")
+	}
+	return pin, nil
+}
+
+// verifyPin checks that the certificate a relay presented, or its public key,
+// hashes to pin.
+func verifyPin(pin []byte, state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("relay presented no certificate")
+	}
+	leaf := state.PeerCertificates[0]
+	certHash := sha256.Sum256(leaf.Raw)
+	keyHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	if bytes.Equal(pin, certHash[:]) || bytes.Equal(pin, keyHash[:]) {
+		return nil
+	}
+	return fmt.Errorf("relay certificate does not match -pin-cert; it presented sha256:%x (public key sha256:%x)", certHash, keyHash)
+}
+
+// dialRelay connects to addr, using TLS unless it is a localhost address.
+// With a non-nil tunnel the connection is layered over it instead of dialed
+// directly, and the caller remains responsible for closing the tunnel on error.
+func dialRelay(tunnel net.Conn, addr string, opts DialOptions) (net.Conn, error) {
+	useTLS := !strings.HasPrefix(addr, "localhost:")
+	dialed := tunnel == nil
+	if dialed {
+		var err error
+		if tunnel, err = dialTCP(addr, opts.connectTimeout()); err != nil {
+			return nil, err
+		}
+	}
+	if !useTLS {
+		return tunnel, nil
+	}
+	config, err := tlsConfig(addr, opts)
+	if err != nil {
+		if dialed {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+	conn := tls.Client(tunnel, config)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.handshakeTimeout())
+	defer cancel()
+	if err := conn.HandshakeContext(ctx); err != nil {
+		if dialed {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tlsConfig returns the TLS settings for a connection to the relay at addr.
+func tlsConfig(addr string, opts DialOptions) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+	if opts.ClientCert != nil {
+		config.Certificates = []tls.Certificate{*opts.ClientCert}
+	}
+	if len(opts.PinnedCert) > 0 && addr == opts.RelayServerAddr {
+		// The pin vouches for the certificate in place of a CA, so Go's chain
+		// and name checks are skipped.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPin(opts.PinnedCert, state)
+		}
+	}
+	return config, nil
+}
+
+// sendCommand writes msg as a JSON line and returns the relay's response line.
+func sendCommand(conn net.Conn, msg any) (string, error) {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal initial message: %w", err)
+	}
+
+	if _, err := conn.Write(append(msgBytes, '\n')); err != nil {
+		return "", fmt.Errorf("failed to send initial message to relay server: %w", err)
+	}
+
+	response, err := readLine(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response from relay server: %w", err)
+	}
+
+	if reason, ok := strings.CutPrefix(response, "Error:"); ok {
+		return "", parseRelayError(reason)
+	}
+	return response, nil
+}
+
+// RelayError is an "Error:" answer from a relay. Trying again does not help,
+// unless the relay was only too busy and says so with Capacity.
+type RelayError struct {
+	Reason   string
+	Capacity *Capacity
+}
+
+// Capacity is the detail a relay sends with an "Error:" answer when it refuses
+// new work because it is running short of a resource.
+type Capacity struct {
+	Resource   string `json:"resource"` // "goroutines", "fds" or "memory"
+	Used       int64  `json:"used"`
+	Limit      int64  `json:"limit"`
+	RetryAfter int    `json:"retryAfter"` // Seconds after which the relay suggests trying again
+}
+
+func (e *RelayError) Error() string {
+	if e.Capacity != nil {
+		return fmt.Sprintf("relay server error: Error: %s (%s); try again in %ds", e.Reason, e.Capacity.Resource, e.Capacity.RetryAfter)
+	}
+	return "relay server error: Error: " + e.Reason
+}
+
+// parseRelayError reads what follows "Error:" in a relay's answer. Capacity
+// errors carry their detail as JSON after the reason.
+func parseRelayError(reason string) *RelayError {
+	reason = strings.TrimSpace(reason)
+	if text, detail, ok := strings.Cut(reason, " {"); ok {
+		var capacity Capacity
+		if json.Unmarshal([]byte("{"+detail), &capacity) == nil && capacity.Resource != "" {
+			return &RelayError{Reason: text, Capacity: &capacity}
+		}
+	}
+	return &RelayError{Reason: reason}
+}
+
+// IsCapacityError reports whether err is a relay turning us away because it
+// is too busy, which may pass.
+func IsCapacityError(err error) bool {
+	var relayErr *RelayError
+	return errors.As(err, &relayErr) && relayErr.Capacity != nil
+}
+
+// IsRelayError reports whether err was reported by a relay rather than being
+// a failure to reach it.
+func IsRelayError(err error) bool {
+	var relayErr *RelayError
+	return errors.As(err, &relayErr)
+}
+
+// readLine reads a single newline-terminated line one byte at a time.
+// A buffered reader would risk consuming the first bytes of the key exchange,
+// which the peer may already have sent by the time the relay answers.
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 1024 {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", fmt.Errorf("response line too long")
+}
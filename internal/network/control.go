@@ -0,0 +1,180 @@
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ControlEvent is a notification from the relay about the session we own.
+type ControlEvent struct {
+	Event       string `json:"event"` // "join-request", "join-cancelled", "quota", "limit", "throttled", "pong", "owner", "observer" or "error"
+	RequestID   string `json:"requestID,omitempty"`
+	Nickname    string `json:"nickname,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Note        string `json:"note,omitempty"`     // Set when the joiner knocked on a locked session
+	Observer    bool   `json:"observer,omitempty"` // Set when the joiner asks to observe; an "observer" event says our peer joined as one
+	Message     string `json:"message,omitempty"`
+
+	// Relayed and Limit are the bytes we sent through the relay in this session
+	// and how many it allows, for "quota" events. The relay sends one at 80%
+	// and 95% of the budget, and once it is used up.
+	Relayed int64 `json:"relayed,omitempty"`
+	Limit   int64 `json:"limit,omitempty"`
+	// Resource names a limit we are approaching for "limit" events, with Used
+	// of Limit taken: "waiting-room", "invites", "resume-buffer" or "files".
+	// Like "quota" events, they come at 80% and 95% of the limit, and once it
+	// is reached.
+	Resource string `json:"resource,omitempty"`
+	Used     int64  `json:"used,omitempty"`
+
+	Seq   int64 `json:"seq,omitempty"`   // The ping a "pong" event answers
+	Owner bool  `json:"owner,omitempty"` // With "owner", we now own the session; without it, our peer does
+
+	// Peer, with "throttled", says the relay is slowing down our peer for
+	// sending too fast rather than us; Disconnect says it cut them off for it,
+	// which ends the session.
+	Peer       bool `json:"peer,omitempty"`
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// Control is the owner's control connection to the relay for one session.
+// It runs alongside the session connection, which carries only end-to-end
+// encrypted traffic, and lets the owner manage who may join.
+type Control struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	mu      sync.Mutex
+}
+
+// Watch opens a control connection for sessionID, which must have been created
+// with opts.OwnerKey.
+func Watch(opts DialOptions, sessionID string) (*Control, error) {
+	watchMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		OwnerKey  string `json:"ownerKey"`
+	}{
+		Command:   "WATCH",
+		SessionID: sessionID,
+		OwnerKey:  opts.OwnerKey,
+	}
+	return openControl(opts, watchMsg, "Watching:")
+}
+
+// Subscribe opens a connection on which the relay sends notices about our end
+// of sessionID, such as "quota" events. The session must have been created or
+// joined with opts.NoticeKey. Only Close, Ping and ReadEvent are meaningful on it.
+func Subscribe(opts DialOptions, sessionID string) (*Control, error) {
+	subscribeMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		NoticeKey string `json:"noticeKey"`
+	}{
+		Command:   "NOTICES",
+		SessionID: sessionID,
+		NoticeKey: opts.NoticeKey,
+	}
+	return openControl(opts, subscribeMsg, "Subscribed:")
+}
+
+func openControl(opts DialOptions, msg any, prefix string) (*Control, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	response, err := sendCommand(conn, msg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(response, prefix) {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return &Control{conn: conn, scanner: bufio.NewScanner(conn)}, nil
+}
+
+// ReadEvent blocks until the relay sends the next event.
+func (c *Control) ReadEvent() (ControlEvent, error) {
+	var event ControlEvent
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return event, err
+		}
+		return event, fmt.Errorf("control connection closed")
+	}
+	if err := json.Unmarshal(c.scanner.Bytes(), &event); err != nil {
+		return event, fmt.Errorf("failed to decode control event: %w", err)
+	}
+	return event, nil
+}
+
+// Admit lets the client behind a join request into the session.
+func (c *Control) Admit(requestID string) error {
+	return c.send("ADMIT", requestID)
+}
+
+// Deny turns the client behind a join request away.
+func (c *Control) Deny(requestID string) error {
+	return c.send("DENY", requestID)
+}
+
+// Ban turns the client behind a join request away and keeps its identity key
+// from joining again while the session lasts. With ip set, its IP address is
+// banned too, which also shuts out anyone else who shares it.
+func (c *Control) Ban(requestID string, ip bool) error {
+	return c.write("BAN", struct {
+		Command   string `json:"command"`
+		RequestID string `json:"requestID"`
+		IP        bool   `json:"ip"`
+	}{"BAN", requestID, ip})
+}
+
+// Ping asks the relay for a "pong" event carrying seq, which measures the round
+// trip to the relay. A client that pings tells the relay it is still there while
+// idle, and the relay drops its session connection once the pings stop.
+func (c *Control) Ping(seq int64) error {
+	return c.write("PING", struct {
+		Command string `json:"command"`
+		Seq     int64  `json:"seq"`
+	}{"PING", seq})
+}
+
+// Lock makes the session refuse joiners unless they knock and we admit them.
+func (c *Control) Lock() error {
+	return c.send("LOCK", "")
+}
+
+// Unlock opens a locked session again.
+func (c *Control) Unlock() error {
+	return c.send("UNLOCK", "")
+}
+
+func (c *Control) send(command, requestID string) error {
+	return c.write(command, struct {
+		Command   string `json:"command"`
+		RequestID string `json:"requestID"`
+	}{command, requestID})
+}
+
+func (c *Control) write(command string, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to send %s to relay server: %w", strings.ToLower(command), err)
+	}
+	return nil
+}
+
+// Close closes the control connection.
+func (c *Control) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bjarneo/jot/internal/crypto"
+)
+
+// maxCreateProofAge bounds how old or how far in the future a CreateProof may be.
+const maxCreateProofAge = 5 * time.Minute
+
+// CreateProof is sent with CREATE to show the relay which identity key asks for
+// a session name, so a relay that reserves names can hand the name back to the
+// same key later. Sent with JOIN, it shows who asks to join. Either way it lets
+// a relay turn away identity keys it has banned.
+type CreateProof struct {
+	IdentityKey string `json:"identityKey"` // Hex-encoded ed25519 public key
+	Timestamp   int64  `json:"timestamp"`   // Unix seconds when the proof was made
+	Signature   string `json:"signature"`   // Hex-encoded signature over createProofMessage
+}
+
+// SignCreate proves that identity asks for sessionID at time now.
+func SignCreate(identity ed25519.PrivateKey, sessionID string, now time.Time) *CreateProof {
+	return signProof(identity, "create", sessionID, now)
+}
+
+// SignJoin proves that identity asks to join sessionID at time now.
+func SignJoin(identity ed25519.PrivateKey, sessionID string, now time.Time) *CreateProof {
+	return signProof(identity, "join", sessionID, now)
+}
+
+func signProof(identity ed25519.PrivateKey, purpose, sessionID string, now time.Time) *CreateProof {
+	proof := &CreateProof{
+		IdentityKey: hex.EncodeToString(identity.Public().(ed25519.PublicKey)),
+		Timestamp:   now.Unix(),
+	}
+	proof.Signature = hex.EncodeToString(ed25519.Sign(identity, proof.message(purpose, sessionID)))
+	return proof
+}
+
+// Verify checks the proof sent with CREATE for sessionID and returns the
+// identity key's fingerprint.
+func (p *CreateProof) Verify(sessionID string, now time.Time) (string, error) {
+	return p.verify("create", sessionID, now)
+}
+
+// VerifyJoin checks the proof sent with JOIN for sessionID and returns the
+// identity key's fingerprint.
+func (p *CreateProof) VerifyJoin(sessionID string, now time.Time) (string, error) {
+	return p.verify("join", sessionID, now)
+}
+
+func (p *CreateProof) verify(purpose, sessionID string, now time.Time) (string, error) {
+	publicKey, err := hex.DecodeString(p.IdentityKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid identity key")
+	}
+	signature, err := hex.DecodeString(p.Signature)
+	if err != nil {
+		return "", fmt.Errorf("invalid signature")
+	}
+	age := now.Sub(time.Unix(p.Timestamp, 0))
+	if age > maxCreateProofAge || age < -maxCreateProofAge {
+		return "", fmt.Errorf("proof is too old or from the future")
+	}
+	if !ed25519.Verify(publicKey, p.message(purpose, sessionID), signature) {
+		return "", fmt.Errorf("signature does not match")
+	}
+	return crypto.Fingerprint(publicKey), nil
+}
+
+// message is what the identity key signs. The purpose and session ID bind the
+// proof to one command for one name, so a relay cannot use it to claim another.
+func (p *CreateProof) message(purpose, sessionID string) []byte {
+	return []byte("jot-" + purpose + ":" + sessionID + ":" + strconv.FormatInt(p.Timestamp, 10))
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/bjarneo/jot/internal/core"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// ErrTransferUnused reports that a data connection closed before any of the
+// file arrived on it, as it does when the sender could not open its end and
+// sends the file over the session connection instead.
+var ErrTransferUnused = errors.New("data connection closed before the file arrived")
+
+// OpenData opens a data connection for file transfer id of sessionID, proving
+// with opts.NoticeKey which end of the session we are. The receiver opens its
+// end first; connected reports whether the peer's end was already waiting,
+// and is what the sender needs before it writes anything.
+func OpenData(opts DialOptions, sessionID, id string) (conn net.Conn, connected bool, err error) {
+	conn, err = dial(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	dataMsg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		NoticeKey string `json:"noticeKey"`
+		Transfer  string `json:"transfer"`
+	}{
+		Command:   "DATA",
+		SessionID: sessionID,
+		NoticeKey: opts.NoticeKey,
+		Transfer:  id,
+	}
+	response, err := sendCommand(conn, dataMsg)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	switch strings.TrimSpace(response) {
+	case "Data: waiting":
+		return conn, false, nil
+	case "Data: connected":
+		return conn, true, nil
+	}
+	conn.Close()
+	return nil, false, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+}
+
+// ReceiveTransfer writes the file of size bytes the peer sends over a data
+// connection to file, verifying each chunk against the peer's identity key and
+// reporting progress to sender. Chunks are read, decrypted and verified in one
+// pooled buffer, so a large file costs no allocation per chunk. It returns nil
+// once the file is complete, and ErrTransferUnused if the connection closes
+// before any of it arrived.
+func ReceiveTransfer(conn net.Conn, keys *crypto.SessionKeys, peerIdentity ed25519.PublicKey, file io.Writer, size int64, sender core.MessageSender) error {
+	reader := bufio.NewReader(conn)
+	buf := protocol.GetFrameBuffer()
+	defer protocol.PutFrameBuffer(buf)
+	started := false
+	var written int64
+	reported := -1
+	for {
+		msgType, encrypted, err := protocol.ReadFrameInto(reader, buf)
+		if err != nil {
+			if !started {
+				return ErrTransferUnused
+			}
+			return fmt.Errorf("data connection read error: %w", err)
+		}
+		started = true
+
+		decrypted, err := crypto.DecryptInPlace(encrypted, keys.ReceiveKey, keys.ReceiveAAD())
+		if err != nil {
+			return fmt.Errorf("decryption failed: %w", err)
+		}
+		payload, signature, err := crypto.OpenEnvelopeInPlace(peerIdentity, msgType, decrypted)
+		if err != nil {
+			return fmt.Errorf("failed to open message envelope: %w", err)
+		}
+		if signature == crypto.SignatureInvalid {
+			return fmt.Errorf("a file chunk has an invalid signature")
+		}
+
+		switch msgType {
+		case protocol.TypeFileChunk:
+			n, err := file.Write(payload)
+			if err != nil {
+				return err
+			}
+			written += int64(n)
+			// Report whole percents only, rather than waking the UI for every chunk.
+			if size > 0 {
+				if percent := int(written * 100 / size); percent != reported {
+					reported = percent
+					sender.SendProgress(float64(written) / float64(size))
+				}
+			}
+		case protocol.TypeFileDone:
+			sender.SendFileDone()
+			return nil
+		default:
+			return fmt.Errorf("unexpected message type %d on a data connection", msgType)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Bounds on what a session may be listed as in a relay's public directory.
+const (
+	MaxRoomName  = 40  // Bytes
+	MaxRoomTopic = 120 // Bytes
+
+	maxRoomList = 1024 * 1024 // Bytes of room list we read from the relay
+)
+
+// Listing is what a session owner publishes about the session in the relay's
+// public directory.
+type Listing struct {
+	Name  string `json:"name"`
+	Topic string `json:"topic,omitempty"`
+}
+
+// Room is a session listed in a relay's public directory.
+type Room struct {
+	SessionID    string `json:"sessionID"`
+	Name         string `json:"name"`
+	Topic        string `json:"topic,omitempty"`
+	Participants int    `json:"participants"`
+}
+
+// Publish lists sessionID, which must have been created with opts.OwnerKey,
+// in the relay's public directory, or takes it off with a nil listing.
+func Publish(opts DialOptions, sessionID string, listing *Listing) error {
+	conn, err := dial(opts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	publishMsg := struct {
+		Command   string   `json:"command"`
+		SessionID string   `json:"sessionID"`
+		OwnerKey  string   `json:"ownerKey"`
+		Listing   *Listing `json:"listing,omitempty"`
+	}{
+		Command:   "PUBLISH",
+		SessionID: sessionID,
+		OwnerKey:  opts.OwnerKey,
+		Listing:   listing,
+	}
+	response, err := sendCommand(conn, publishMsg)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(response, "Published:") && !strings.HasPrefix(response, "Unpublished:") {
+		return fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return nil
+}
+
+// ListRooms returns the sessions listed in the relay's public directory.
+func ListRooms(opts DialOptions) ([]Room, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// The list is longer than the lines sendCommand reads.
+	if _, err := conn.Write([]byte(`{"command":"LIST"}` + "\n")); err != nil {
+		return nil, fmt.Errorf("failed to send initial message to relay server: %w", err)
+	}
+	response, err := bufio.NewReader(io.LimitReader(conn, maxRoomList)).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from relay server: %w", err)
+	}
+	if reason, ok := strings.CutPrefix(response, "Error:"); ok {
+		return nil, parseRelayError(reason)
+	}
+	list, ok := strings.CutPrefix(response, "Rooms:")
+	if !ok {
+		return nil, fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	var rooms []Room
+	if err := json.Unmarshal([]byte(list), &rooms); err != nil {
+		return nil, fmt.Errorf("invalid room list from relay server: %w", err)
+	}
+	return rooms, nil
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bjarneo/jot/internal/core"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// handler delivers the opened payload of one message type to the UI. An error
+// is reported to the user and the message dropped; the session carries on.
+type handler func(sender core.MessageSender, payload []byte, signature crypto.SignatureStatus) error
+
+// handlers maps each message type that may arrive after the key exchange to
+// its handler. TypeIdentity and TypeRekey are handled by ListenForMessages
+// itself, since they change how every later message is verified or opened.
+var handlers = map[byte]handler{}
+
+// handle registers h for msgType. Registering a type twice is a programming error.
+func handle(msgType byte, h handler) {
+	if _, exists := handlers[msgType]; exists {
+		panic(fmt.Sprintf("network: message type %d registered twice", msgType))
+	}
+	handlers[msgType] = h
+}
+
+// handleJSON registers a handler for a message type whose payload is a JSON
+// encoded T. The payload is decoded and, if validate is not nil, checked
+// before deliver hands it to the UI. name describes the message in errors.
+func handleJSON[T any](msgType byte, name string, validate func(T) error, deliver func(core.MessageSender, T)) {
+	handle(msgType, func(sender core.MessageSender, payload []byte, _ crypto.SignatureStatus) error {
+		var msg T
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		if validate != nil {
+			if err := validate(msg); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+		}
+		deliver(sender, msg)
+		return nil
+	})
+}
+
+func init() {
+	handleJSON(protocol.TypeHello, "hello", protocol.Hello.Validate, core.MessageSender.SendPeerHello)
+	handleJSON(protocol.TypeRecording, "recording notice", nil, core.MessageSender.SendPeerRecording)
+	handleJSON(protocol.TypeFileOffer, "file offer", protocol.FileMetadata.Validate, core.MessageSender.SendFileOffer)
+	handleJSON(protocol.TypeFileAccept, "file acceptance", nil, core.MessageSender.SendFileOfferAccepted)
+	handleJSON(protocol.TypeAck, "acknowledgement", nil, core.MessageSender.SendAck)
+	handleJSON(protocol.TypeBlob, "uploaded file", protocol.Blob.Validate, core.MessageSender.SendBlob)
+	handleJSON(protocol.TypePoll, "poll", protocol.Poll.Validate, core.MessageSender.SendPoll)
+	handleJSON(protocol.TypeVote, "vote", protocol.Vote.Validate, core.MessageSender.SendVote)
+	handleJSON(protocol.TypeAnnouncement, "announcement mode", nil, core.MessageSender.SendAnnouncement)
+
+	handle(protocol.TypeNickname, func(sender core.MessageSender, payload []byte, _ crypto.SignatureStatus) error {
+		sender.SendReceivedNickname(string(payload))
+		return nil
+	})
+	handle(protocol.TypeText, func(sender core.MessageSender, payload []byte, signature crypto.SignatureStatus) error {
+		sender.SendReceivedText(string(payload), signature)
+		return nil
+	})
+	handle(protocol.TypeTextDeflate, func(sender core.MessageSender, payload []byte, signature crypto.SignatureStatus) error {
+		text, err := protocol.Inflate(payload)
+		if err != nil {
+			return fmt.Errorf("failed to decompress message: %w", err)
+		}
+		sender.SendReceivedText(string(text), signature)
+		return nil
+	})
+	handle(protocol.TypeFileReject, func(sender core.MessageSender, payload []byte, _ crypto.SignatureStatus) error {
+		// Older clients reject without saying which offer.
+		var meta protocol.FileMetadata
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &meta); err != nil {
+				return fmt.Errorf("failed to decode file rejection: %w", err)
+			}
+		}
+		sender.SendFileOfferRejected(meta)
+		return nil
+	})
+	handle(protocol.TypeFileChunk, func(sender core.MessageSender, payload []byte, _ crypto.SignatureStatus) error {
+		sender.SendFileChunk(payload)
+		return nil
+	})
+	handle(protocol.TypeFileDone, func(sender core.MessageSender, _ []byte, _ crypto.SignatureStatus) error {
+		sender.SendFileDone()
+		return nil
+	})
+	handle(protocol.TypeKeepalive, func(sender core.MessageSender, _ []byte, _ crypto.SignatureStatus) error {
+		sender.SendKeepalive()
+		return nil
+	})
+	handle(protocol.TypeBye, func(sender core.MessageSender, _ []byte, _ crypto.SignatureStatus) error {
+		sender.SendBye()
+		return nil
+	})
+}
@@ -0,0 +1,337 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// latencyProbes is how many round trips Diagnose times once the relay answers.
+const latencyProbes = 3
+
+// Check is the outcome of one step of Diagnose.
+type Check struct {
+	Step   string // "DNS", "TCP", "TLS", "Protocol" or "Latency"
+	Err    error  // nil if the step passed
+	Detail string // What was found, or what went wrong
+	Hint   string // What to try next, if we can tell
+}
+
+// Diagnose walks through reaching the relay at opts.RelayServerAddr one layer
+// at a time: resolving its name, opening a TCP connection to each of its
+// addresses, the TLS handshake, a LIST request and the round trip time. Each
+// step is passed to report as it finishes. Diagnose stops at the first step
+// that fails, since the ones after it depend on it, and reports whether all
+// of them passed. Via is not used: the point is to find out which layer
+// between us and the relay fails.
+func Diagnose(opts DialOptions, report func(Check)) bool {
+	addr := opts.RelayServerAddr
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		report(Check{Step: "DNS", Err: err, Detail: fmt.Sprintf("%q is not a host and port", addr), Hint: "Give the relay as host:port, e.g. relay.hemmelig.app:443 or localhost:8080."})
+		return false
+	}
+
+	ips, check := diagnoseDNS(host, opts.connectTimeout())
+	report(check)
+	if check.Err != nil {
+		return false
+	}
+
+	conn, check := diagnoseTCP(ips, port, opts.connectTimeout())
+	report(check)
+	if check.Err != nil {
+		return false
+	}
+
+	conn, check = diagnoseTLS(conn, addr, opts)
+	report(check)
+	if check.Err != nil {
+		return false
+	}
+
+	_, check = diagnoseProtocol(conn, opts.handshakeTimeout())
+	conn.Close()
+	report(check)
+	if check.Err != nil {
+		return false
+	}
+
+	check = diagnoseLatency(opts)
+	report(check)
+	return check.Err == nil
+}
+
+func diagnoseDNS(host string, timeout time.Duration) ([]net.IP, Check) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	started := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	took := time.Since(started)
+	if err != nil {
+		check := Check{Step: "DNS", Err: err, Detail: fmt.Sprintf("could not resolve %s: %v", host, err)}
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			check.Hint = "The name does not exist. Check its spelling, or ask the relay's operator for its address."
+		case errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+			check.Hint = "Your DNS server did not answer in time. Check your network connection or DNS settings."
+		}
+		return nil, check
+	}
+	ips := interleaveFamilies(addrs)
+	if len(ips) == 0 {
+		return nil, Check{Step: "DNS", Err: fmt.Errorf("no addresses found for %s", host), Detail: fmt.Sprintf("%s has no IPv4 or IPv6 addresses", host)}
+	}
+	shown := make([]string, len(ips))
+	for i, ip := range ips {
+		shown[i] = ip.String()
+	}
+	return ips, Check{Step: "DNS", Detail: fmt.Sprintf("%s resolves to %s (%s)", host, strings.Join(shown, ", "), formatDuration(took))}
+}
+
+// diagnoseTCP connects to every address at once, so an address family that
+// is broken shows up even when another one works. It returns a connection to
+// the first address, in dialTCP's order, that accepted one.
+func diagnoseTCP(ips []net.IP, port string, timeout time.Duration) (net.Conn, Check) {
+	type result struct {
+		conn net.Conn
+		err  error
+		took time.Duration
+	}
+	results := make([]chan result, len(ips))
+	for i, ip := range ips {
+		results[i] = make(chan result, 1)
+		go func() {
+			started := time.Now()
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), port), timeout)
+			results[i] <- result{conn, err, time.Since(started)}
+		}()
+	}
+
+	var conn net.Conn
+	var lines []string
+	var failed, refused, timedOut int
+	for i, ip := range ips {
+		r := <-results[i]
+		if r.err != nil {
+			failed++
+			lines = append(lines, fmt.Sprintf("%s: %v", ip, r.err))
+			var netErr net.Error
+			switch {
+			case errors.Is(r.err, syscall.ECONNREFUSED):
+				refused++
+			case errors.As(r.err, &netErr) && netErr.Timeout():
+				timedOut++
+			}
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: connected (%s)", ip, formatDuration(r.took)))
+		if conn == nil {
+			conn = r.conn
+		} else {
+			r.conn.Close()
+		}
+	}
+	detail := strings.Join(lines, "; ")
+	if conn != nil {
+		check := Check{Step: "TCP", Detail: detail}
+		if failed > 0 {
+			check.Hint = "Not every address of the relay could be reached. Jot falls back to the others, so connecting still works, if up to a quarter of a second slower."
+		}
+		return conn, check
+	}
+
+	check := Check{Step: "TCP", Err: fmt.Errorf("no address of the relay accepted a connection on port %s", port), Detail: detail}
+	switch {
+	case refused == len(ips):
+		check.Hint = fmt.Sprintf("The host is up, but nothing listens on port %s. Check the port, and that the relay server is running.", port)
+	case timedOut > 0:
+		check.Hint = fmt.Sprintf("Nothing answered within -connect-timeout. A firewall on your network or in front of the relay may be dropping connections to port %s.", port)
+	}
+	return nil, check
+}
+
+// diagnoseTLS runs the TLS handshake over conn as dialRelay would. Relays at
+// localhost addresses are reached without TLS, so conn is returned as it is.
+func diagnoseTLS(conn net.Conn, addr string, opts DialOptions) (net.Conn, Check) {
+	if strings.HasPrefix(addr, "localhost:") {
+		return conn, Check{Step: "TLS", Detail: "skipped: relays at localhost addresses are reached without TLS"}
+	}
+	config, err := tlsConfig(addr, opts)
+	if err != nil {
+		conn.Close()
+		return nil, Check{Step: "TLS", Err: err, Detail: err.Error()}
+	}
+	tlsConn := tls.Client(conn, config)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.handshakeTimeout())
+	defer cancel()
+	started := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, tlsFailure(err)
+	}
+	took := time.Since(started)
+
+	state := tlsConn.ConnectionState()
+	leaf := state.PeerCertificates[0]
+	trust := "trusted by this system"
+	if len(opts.PinnedCert) > 0 {
+		trust = "matches -pin-cert"
+	}
+	days := int(time.Until(leaf.NotAfter).Hours() / 24)
+	check := Check{Step: "TLS", Detail: fmt.Sprintf("%s, %s; certificate for %s from %s, %s, expires %s (in %d days) (%s)",
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), certName(leaf), leaf.Issuer.CommonName, trust, leaf.NotAfter.Format("2006-01-02"), days, formatDuration(took))}
+	if days < 14 {
+		check.Hint = "The certificate expires soon. Let the relay's operator know."
+	}
+	return tlsConn, check
+}
+
+// tlsFailure explains why a TLS handshake with a relay failed.
+func tlsFailure(err error) Check {
+	check := Check{Step: "TLS", Err: err, Detail: fmt.Sprintf("handshake failed: %v", err)}
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var hostErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &recordErr):
+		check.Hint = "The server did not answer in TLS. Jot uses TLS for every relay but those at localhost addresses, so a remote relay needs -tls-cert and -tls-key; or this port belongs to another service."
+	case errors.Is(err, io.EOF):
+		check.Hint = "The server hung up during the handshake. A relay started without -tls-cert does this, since Jot uses TLS for every relay but those at localhost addresses; or it turned this client away."
+	case errors.As(err, &hostErr):
+		check.Hint = fmt.Sprintf("The certificate is for %s, not for the name you dialed. Use the name it was issued for.", certName(hostErr.Certificate))
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		check.Hint = "The relay's certificate has expired, or your clock is wrong. Let the relay's operator know."
+	case errors.As(err, &authorityErr) && errors.As(err, &verifyErr) && len(verifyErr.UnverifiedCertificates) > 0:
+		leaf := verifyErr.UnverifiedCertificates[0]
+		keyHash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		check.Hint = fmt.Sprintf("The certificate is not signed by a CA this system trusts, which is usual for a self-hosted relay. Once its operator has confirmed it, trust it with -pin-cert sha256:%x (its public key).", keyHash)
+	case alertHint(err) != "":
+		check.Hint = alertHint(err)
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		check.Hint = "The handshake did not finish within -handshake-timeout. The relay may be overloaded, or something on the way holds back TLS."
+	}
+	return check
+}
+
+// alertHint explains the TLS alerts a relay sends when it will not talk to
+// us. With TLS 1.3, a relay rejects a client certificate only after the
+// handshake, so its alert arrives with the first answer instead. Go reports
+// alerts it receives with an unexported type, so they are recognised by
+// their text, which tls.AlertError shares.
+func alertHint(err error) string {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return ""
+	}
+	switch opErr.Err.Error() {
+	case tls.AlertError(116).Error(), tls.AlertError(42).Error(): // certificate_required (TLS 1.3) or bad_certificate
+		return "The relay only admits clients with a certificate from its CA. Pass yours with -client-cert and -client-key."
+	case tls.AlertError(120).Error(): // no_application_protocol
+		return "The relay insists on an ALPN protocol this client does not offer. Ask its operator about -tls-alpn."
+	case tls.AlertError(40).Error(), tls.AlertError(70).Error(): // handshake_failure or protocol_version
+		return "The relay and this client share no TLS version or cipher suite. Ask its operator about -tls-min-version and -tls-ciphers."
+	}
+	return ""
+}
+
+// certName is the name a certificate was issued for.
+func certName(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return strings.Join(cert.DNSNames, ", ")
+	}
+	return cert.Subject.CommonName
+}
+
+// diagnoseProtocol asks the relay for its room directory, which any relay
+// answers without a session, and checks that the answer is one a Jot relay
+// gives. It returns how long the answer took.
+func diagnoseProtocol(conn net.Conn, timeout time.Duration) (time.Duration, Check) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	started := time.Now()
+	if _, err := conn.Write([]byte(`{"command":"LIST"}` + "\n")); err != nil {
+		return 0, Check{Step: "Protocol", Err: err, Detail: fmt.Sprintf("could not send a request: %v", err)}
+	}
+	response, err := bufio.NewReader(io.LimitReader(conn, maxRoomList)).ReadString('\n')
+	took := time.Since(started)
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF) && response == "":
+		return 0, Check{Step: "Protocol", Err: err, Detail: "the connection was closed without an answer",
+			Hint: "The relay may be turning away your address, for example for too many connections. Or it only speaks TLS on this port, which Jot does not use for localhost addresses, or this is not a Jot relay."}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return 0, Check{Step: "Protocol", Err: err, Detail: "no answer within -handshake-timeout",
+			Hint: "Something accepted the connection but does not answer like a Jot relay. Check the port."}
+	case err != nil:
+		return 0, Check{Step: "Protocol", Err: err, Detail: fmt.Sprintf("could not read the answer: %v", err), Hint: alertHint(err)}
+	}
+
+	if list, ok := strings.CutPrefix(response, "Rooms:"); ok {
+		rooms := strings.Count(list, `"sessionID"`)
+		return took, Check{Step: "Protocol", Detail: fmt.Sprintf("the relay answered LIST with %d listed rooms (%s)", rooms, formatDuration(took))}
+	}
+	if reason, ok := strings.CutPrefix(response, "Error:"); ok {
+		// An error still shows we reached a Jot relay, which may simply have
+		// no directory or predate it.
+		return took, Check{Step: "Protocol", Detail: fmt.Sprintf("the relay answered LIST with %q (%s)", strings.TrimSpace(reason), formatDuration(took))}
+	}
+	return 0, Check{Step: "Protocol", Err: errors.New("unexpected answer"), Detail: fmt.Sprintf("unexpected answer: %q", truncate(strings.TrimSpace(response), 80)),
+		Hint: "Whatever listens there is not a Jot relay. Check the host and port."}
+}
+
+// diagnoseLatency times a few more requests on fresh connections. Only the
+// request and its answer are timed, not setting up the connection.
+func diagnoseLatency(opts DialOptions) Check {
+	var fastest, slowest, total time.Duration
+	for range latencyProbes {
+		conn, err := dialServer(DialOptions{RelayServerAddr: opts.RelayServerAddr, ClientCert: opts.ClientCert, PinnedCert: opts.PinnedCert, ConnectPolicy: opts.ConnectPolicy})
+		if err != nil {
+			return Check{Step: "Latency", Err: err, Detail: fmt.Sprintf("connecting again failed: %v", err),
+				Hint: "The relay answered once but not again. It may limit connections from your address, or your connection is unstable."}
+		}
+		took, check := diagnoseProtocol(conn, opts.handshakeTimeout())
+		conn.Close()
+		if check.Err != nil {
+			check.Step = "Latency"
+			return check
+		}
+		if fastest == 0 || took < fastest {
+			fastest = took
+		}
+		slowest = max(slowest, took)
+		total += took
+	}
+	check := Check{Step: "Latency", Detail: fmt.Sprintf("round trip min %s, avg %s, max %s over %d requests",
+		formatDuration(fastest), formatDuration(total/latencyProbes), formatDuration(slowest), latencyProbes)}
+	if fastest > 500*time.Millisecond {
+		check.Hint = "Chat will feel sluggish. A relay closer to you, or to your peer, would help."
+	}
+	return check
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 10*time.Millisecond {
+		return d.Round(100 * time.Microsecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
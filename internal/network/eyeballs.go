@@ -0,0 +1,115 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// attemptDelay is how long dialTCP waits for one address before also trying
+// the next, as recommended by RFC 8305.
+const attemptDelay = 250 * time.Millisecond
+
+// dialTCP connects to addr. When the host has both IPv6 and IPv4 addresses, it
+// races them in the manner of RFC 8305 ("Happy Eyeballs"): addresses are tried
+// alternating between the two families, starting the next one whenever the
+// previous has not connected within attemptDelay, and the first to connect
+// wins. A network where IPv6 is broken therefore costs a quarter of a second
+// rather than an operating system's connect timeout. timeout bounds the whole
+// dial, across all addresses.
+func dialTCP(addr string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	targets := interleaveFamilies(ips)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(targets))
+	var dialer net.Dialer
+	start := func(ip net.IP) {
+		go func() {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			results <- result{conn, err}
+		}()
+	}
+
+	start(targets[0])
+	next, pending := 1, 1
+	var errs []error
+	delay := time.NewTimer(attemptDelay)
+	defer delay.Stop()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				// Attempts still in flight are cancelled; close any that won the race anyway.
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			// A failed attempt starts the next one without waiting for the delay.
+			if next < len(targets) {
+				start(targets[next])
+				next++
+				pending++
+				delay.Reset(attemptDelay)
+			}
+		case <-delay.C:
+			if next < len(targets) {
+				start(targets[next])
+				next++
+				pending++
+				delay.Reset(attemptDelay)
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// interleaveFamilies orders ips for dialTCP: alternating between IPv6 and
+// IPv4, starting with IPv6 if there is any, and otherwise keeping the
+// resolver's order within each family.
+func interleaveFamilies(ips []net.IPAddr) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip.IP)
+		} else {
+			v6 = append(v6, ip.IP)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
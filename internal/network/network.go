@@ -2,130 +2,203 @@ package network
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
+	"crypto/ed25519"
 	"encoding/json"
 	"errors" // Added missing import
 	"fmt"
 	"io"
 	"net"
+	"slices"
+	"sync"
 
 	"github.com/bjarneo/jot/internal/core"
 	"github.com/bjarneo/jot/internal/crypto"
 	"github.com/bjarneo/jot/internal/protocol"
 )
 
-// ListenForMessages reads and processes incoming messages from the connection.
-func ListenForMessages(conn net.Conn, key []byte, sender core.MessageSender, isInitiator bool) {
-	reader := bufio.NewReader(conn)
-
-	// Perform key exchange if key is not provided (first message from peer)
-	var sharedKey []byte
-	var myPublicKey []byte
-	var peerPublicKey []byte
-	var err error
-
-	if key == nil {
-		sharedKey, myPublicKey, peerPublicKey, err = crypto.PerformKeyExchange(conn, isInitiator)
+// Handshake performs the key exchange over conn and announces our identity key.
+// The template provides our identity key and preferred cipher; the returned keys
+// carry the keys derived from the exchange. The reader must be the one used for all later reads.
+func Handshake(conn net.Conn, reader *bufio.Reader, template crypto.SessionKeys, isInitiator bool) (*crypto.SessionKeys, []byte, []byte, error) {
+	secret, myPublicKey, peerPublicKey, err := crypto.PerformKeyExchange(reader, conn, isInitiator)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys := &template
+	keys.IsInitiator = isInitiator
+	if keys.Password != nil {
+		// Both ends proved they know the password, over these exchange keys,
+		// so the relay did not swap them. The key that proof yields goes into
+		// the session keys as well.
+		proof, err := provePassword(conn, reader, keys.Password, isInitiator, myPublicKey, peerPublicKey)
 		if err != nil {
-			sender.SendError(err)
-			return
+			return nil, nil, nil, err
 		}
-		sender.SendSharedKey(sharedKey)
-		sender.SendMyPublicKey(myPublicKey)
-		sender.SendPeerPublicKey(peerPublicKey)
-	} else {
-		sharedKey = key
+		secret = slices.Concat(secret, proof)
+		keys.Password = nil
+	}
+	if err := keys.DeriveKeys(secret, myPublicKey, peerPublicKey); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Announce our identity key before anything else so the peer can verify
+	// the signatures on every message that follows. It signs our exchange key,
+	// which the peer checks against the one it received.
+	if err := SendData(conn, keys, protocol.TypeIdentity, crypto.IdentityPayload(keys.Identity, myPublicKey)); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to send identity key: %w", err)
+	}
+	return keys, myPublicKey, peerPublicKey, nil
+}
+
+// ListenForMessages performs the handshake and then reads and processes incoming messages from the connection.
+// The negotiated session keys are handed to the sender once the exchange completes.
+func ListenForMessages(conn net.Conn, template crypto.SessionKeys, sender core.MessageSender, isInitiator bool) {
+	reader := bufio.NewReader(conn)
+
+	keys, myPublicKey, peerPublicKey, err := Handshake(conn, reader, template, isInitiator)
+	if err != nil {
+		sender.SendError(err)
+		return
 	}
 
+	sender.SendSessionKeys(keys)
+	sender.SendMyPublicKey(myPublicKey)
+	sender.SendPeerPublicKey(peerPublicKey)
+
+	var peerIdentity ed25519.PublicKey
+
 	for {
-		msgType, err := reader.ReadByte()
+		msgType, encryptedMsg, err := protocol.ReadFrame(reader)
 		if err != nil {
 			// If we get an EOF, it means the connection was closed.
 			// This could be the server terminating an inactive session.
 			if err == io.EOF {
 				sender.SendConnectionClosed()
 			} else {
-				sender.SendError(fmt.Errorf("connection read error: %w", err))
+				sender.SendConnectionLost(fmt.Errorf("connection read error: %w", err))
 			}
 			return
 		}
-
-		var length uint32
-		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
-			sender.SendError(fmt.Errorf("failed to read length: %w", err))
-			return
-		}
-
-		encryptedMsg := make([]byte, length)
-		if _, err := io.ReadFull(reader, encryptedMsg); err != nil {
-			sender.SendError(fmt.Errorf("failed to read message body: %w", err))
-			return
+		if msgType == protocol.TypePassword {
+			sender.SendError(ErrPasswordRequired)
+			continue
 		}
 
-		decrypted, err := crypto.Decrypt(encryptedMsg, sharedKey)
+		decrypted, err := crypto.Decrypt(encryptedMsg, keys.ReceiveKey, keys.ReceiveAAD())
 		if err != nil {
+			// A ciphertext that authenticates under our own key was produced by us,
+			// so someone in the middle is replaying it as if the peer had sent it.
+			if _, reflectErr := crypto.Decrypt(encryptedMsg, keys.SendKey, keys.SendAAD()); reflectErr == nil {
+				sender.SendInfo("Security warning: refused to display a message whose cryptographic sender is you, not your peer. The relay may be tampering with the session.")
+				continue
+			}
 			sender.SendError(fmt.Errorf("decryption failed: %w", err))
 			continue
 		}
 
-		switch msgType {
-		case protocol.TypeNickname:
-			sender.SendReceivedNickname(string(decrypted))
-
-		case protocol.TypeText:
-			sender.SendReceivedText(string(decrypted))
-		case protocol.TypeFileOffer:
-			var meta protocol.FileMetadata
-			if err := json.Unmarshal(decrypted, &meta); err != nil {
-				sender.SendError(fmt.Errorf("failed to decode file offer: %w", err))
+		if msgType == protocol.TypeIdentity {
+			// The identity message is signed with the key it carries, proving
+			// possession, and that key signs the exchange key we received. A
+			// relay that swapped exchange keys cannot produce that signature.
+			candidate := ed25519.PublicKey(nil)
+			if len(decrypted) >= 1+ed25519.SignatureSize+ed25519.PublicKeySize {
+				candidate = ed25519.PublicKey(decrypted[1+ed25519.SignatureSize:][:ed25519.PublicKeySize])
+			}
+			payload, status, err := crypto.OpenEnvelope(candidate, msgType, decrypted)
+			if err != nil || status != crypto.SignatureValid {
+				sender.SendInfo("Warning: peer sent an identity key with an invalid signature; their messages cannot be authenticated.")
 				continue
 			}
-			sender.SendFileOffer(meta)
-		case protocol.TypeFileAccept:
-			var meta protocol.FileMetadata
-			if err := json.Unmarshal(decrypted, &meta); err != nil {
-				sender.SendError(fmt.Errorf("failed to decode file acceptance: %w", err))
+			identity, err := crypto.ParseIdentityPayload(payload, peerPublicKey)
+			if err != nil {
+				sender.SendInfo("Security warning: your peer's identity key did not sign the key it exchanged with you. The relay may be intercepting the session; their messages cannot be authenticated.")
 				continue
 			}
-			sender.SendFileOfferAccepted(meta)
-		case protocol.TypeFileReject:
-			sender.SendFileOfferRejected()
-		case protocol.TypeFileChunk:
-			sender.SendFileChunk(decrypted)
-		case protocol.TypeFileDone:
-			sender.SendFileDone()
-		default:
-			sender.SendError(fmt.Errorf("received unknown message type: %d", msgType))
+			peerIdentity = identity
+			sender.SendPeerIdentity(peerIdentity)
+			continue
+		}
+
+		payload, signature, err := crypto.OpenEnvelope(peerIdentity, msgType, decrypted)
+		if err != nil {
+			sender.SendError(fmt.Errorf("failed to open message envelope: %w", err))
+			continue
+		}
+		if msgType != protocol.TypeText && msgType != protocol.TypeTextDeflate && signature == crypto.SignatureInvalid {
+			sender.SendInfo(fmt.Sprintf("Warning: dropped a message of type %d with an invalid signature.", msgType))
+			continue
+		}
+
+		if msgType == protocol.TypeRekey {
+			if err := handleRekey(conn, keys, sender, payload); err != nil {
+				sender.SendError(fmt.Errorf("rekey failed: %w", err))
+			}
+			continue
+		}
+
+		h, ok := handlers[msgType]
+		if !ok {
+			// A newer peer may send types we do not know yet; they are skipped
+			// so both versions can keep talking.
+			sender.SendUnknownMessage(msgType)
+			continue
+		}
+		if err := h(sender, payload, signature); err != nil {
+			sender.SendError(err)
 		}
 	}
 }
 
-// SendData encrypts and sends data over the connection.
-// For TypePublicKeyExchange, data is sent unencrypted.
-func SendData(conn net.Conn, sharedKey []byte, msgType byte, data []byte) error {
-	var payloadToSend []byte
-	var err error
-
+// SendData signs, encrypts and sends data over the connection as one frame.
+// For TypePublicKeyExchange, data is sent unencrypted. The envelope and the
+// frame are built in pooled buffers, so sending allocates nothing per message.
+// The keys stay locked until the frame is written, so a rekey cannot switch
+// them between sealing a frame and sending it.
+func SendData(conn net.Conn, keys *crypto.SessionKeys, msgType byte, data []byte) error {
 	if msgType == protocol.TypePublicKeyExchange {
-		payloadToSend = data // Send raw public key for exchange
-	} else {
-		if sharedKey == nil {
-			// This check is important. If sharedKey is nil for other types, it's an error.
-			return errors.New("shared key is nil, cannot encrypt non-PublicKeyExchange message")
-		}
-		payloadToSend, err = crypto.Encrypt(data, sharedKey)
-		if err != nil {
-			return fmt.Errorf("encryption failed: %w", err)
-		}
+		return protocol.WriteFrame(conn, msgType, data) // Send raw public key for exchange
+	}
+	if keys == nil {
+		return errors.New("send key is nil, cannot encrypt non-PublicKeyExchange message")
 	}
+	unlock := keys.LockSend()
+	defer unlock()
+	return sendFrame(conn, keys, msgType, data)
+}
 
-	// Prepend msgType and length
-	msgHeader := make([]byte, 1+4) // 1 byte for type, 4 bytes for length
-	msgHeader[0] = msgType
-	binary.BigEndian.PutUint32(msgHeader[1:], uint32(len(payloadToSend)))
+// sendFrame is SendData for keys that are already locked.
+func sendFrame(conn net.Conn, keys *crypto.SessionKeys, msgType byte, data []byte) error {
+	if keys.SendKey == nil {
+		// This check is important. If the key is nil for other types, it's an error.
+		return errors.New("send key is nil, cannot encrypt non-PublicKeyExchange message")
+	}
+
+	envelope, frame := protocol.GetFrameBuffer(), protocol.GetFrameBuffer()
+	defer protocol.PutFrameBuffer(envelope)
+	defer protocol.PutFrameBuffer(frame)
+	*envelope = crypto.AppendEnvelope(*envelope, keys.Identity, msgType, data)
+	sealed, err := crypto.AppendEncrypt(append(*frame, make([]byte, protocol.FrameHeaderSize)...), keys.Cipher, *envelope, keys.SendKey, keys.SendAAD())
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+	*frame = sealed
+	if err := protocol.WriteFramePayload(conn, msgType, sealed); err != nil {
+		return err
+	}
+	return flushFrame(conn, msgType)
+}
 
-	fullMsg := append(msgHeader, payloadToSend...)
+// jsonBuffers holds the buffers SendJSON marshals into.
+var jsonBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
 
-	_, err = conn.Write(fullMsg)
-	return err
+// SendJSON marshals v into a pooled buffer and sends it with SendData.
+func SendJSON(conn net.Conn, keys *crypto.SessionKeys, msgType byte, v any) error {
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	defer jsonBuffers.Put(buf)
+	buf.Reset()
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	return SendData(conn, keys, msgType, bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
 }
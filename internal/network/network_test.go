@@ -0,0 +1,76 @@
+package network
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// discardConn is a connection whose writes go nowhere, so benchmarks measure
+// building frames rather than moving them.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+
+// testKeys returns session keys derived from a random secret, as after a
+// key exchange.
+func testKeys(tb testing.TB, cipher crypto.Cipher) *crypto.SessionKeys {
+	tb.Helper()
+	identity, err := crypto.GenerateIdentity()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	secret, mine, theirs := make([]byte, 32), make([]byte, 32), make([]byte, 32)
+	for _, key := range [][]byte{secret, mine, theirs} {
+		rand.Read(key)
+	}
+	keys := &crypto.SessionKeys{Identity: identity, Cipher: cipher, IsInitiator: true}
+	if err := keys.DeriveKeys(secret, mine, theirs); err != nil {
+		tb.Fatal(err)
+	}
+	return keys
+}
+
+var benchmarkSizes = []int{64, 1024, 64 * 1024}
+
+func BenchmarkSendData(b *testing.B) {
+	for _, cipher := range []crypto.Cipher{crypto.CipherAESGCM, crypto.CipherXChaCha20Poly1305} {
+		for _, size := range benchmarkSizes {
+			b.Run(fmt.Sprintf("%s/%dB", cipher, size), func(b *testing.B) {
+				keys := testKeys(b, cipher)
+				data := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for range b.N {
+					if err := SendData(discardConn{}, keys, protocol.TypeText, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkSendFrame measures sendFrame alone, without taking the send lock
+// as SendData does.
+func BenchmarkSendFrame(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			keys := testKeys(b, crypto.CipherAESGCM)
+			data := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for range b.N {
+				if err := sendFrame(discardConn{}, keys, protocol.TypeText, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package network
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+var (
+	// ErrPasswordMismatch means the peer proved knowledge of another password,
+	// or the relay ran a key exchange of its own with each end.
+	ErrPasswordMismatch = errors.New("the session password did not match: either your peer typed another one, or someone in between, such as the relay, is intercepting the session")
+	// ErrPasswordRequired means the peer set a session password and we did not.
+	ErrPasswordRequired = errors.New("your peer set a session password; start Jot with -password and type the same one to talk to them")
+)
+
+// provePassword runs SPAKE2 over the exchange keys just swapped, sending its
+// messages as unencrypted TypePassword frames. Both ends send their share,
+// then their confirmation, so neither waits on the other. It returns the key
+// SPAKE2 agreed on once the peer's confirmation checks out.
+func provePassword(conn io.Writer, reader *bufio.Reader, password []byte, isInitiator bool, myPublicKey, peerPublicKey []byte) ([]byte, error) {
+	initiatorKey, responderKey := myPublicKey, peerPublicKey
+	if !isInitiator {
+		initiatorKey, responderKey = peerPublicKey, myPublicKey
+	}
+	spake, err := crypto.NewSPAKE2(password, isInitiator, initiatorKey, responderKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteFrame(conn, protocol.TypePassword, spake.Share()); err != nil {
+		return nil, fmt.Errorf("failed to send password share: %w", err)
+	}
+	peerShare, err := readPasswordFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+	key, ours, theirs, err := spake.Finish(peerShare)
+	if err != nil {
+		return nil, err
+	}
+	if err := protocol.WriteFrame(conn, protocol.TypePassword, ours); err != nil {
+		return nil, fmt.Errorf("failed to send password confirmation: %w", err)
+	}
+	peerConfirmation, err := readPasswordFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(peerConfirmation, theirs) {
+		return nil, ErrPasswordMismatch
+	}
+	return key, nil
+}
+
+// readPasswordFrame reads the peer's next SPAKE2 message. A peer without a
+// password sends its encrypted identity instead.
+func readPasswordFrame(reader *bufio.Reader) ([]byte, error) {
+	msgType, payload, err := protocol.ReadFrame(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password proof: %w", err)
+	}
+	if msgType != protocol.TypePassword {
+		return nil, errors.New("your peer did not set a session password; both of you need to start Jot with -password and type the same one")
+	}
+	return payload, nil
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MaxWorkBits is the hardest proof-of-work challenge a client will attempt.
+// Each bit doubles the expected work; 28 bits takes several seconds on a laptop.
+const MaxWorkBits = 28
+
+// WorkSolution answers a relay's "Challenge:" prompt.
+type WorkSolution struct {
+	Solution string `json:"solution"`
+}
+
+// ParseChallenge splits a relay prompt of the form "Challenge: <nonce> <bits>".
+func ParseChallenge(prompt string) (nonce string, difficulty int, err error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(prompt), "Challenge:")
+	if !ok {
+		return "", 0, fmt.Errorf("not a challenge: %q", prompt)
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("malformed challenge: %q", prompt)
+	}
+	difficulty, err = strconv.Atoi(fields[1])
+	if err != nil || difficulty < 0 {
+		return "", 0, fmt.Errorf("malformed challenge: %q", prompt)
+	}
+	return fields[0], difficulty, nil
+}
+
+// SolveWork finds a solution whose SHA-256 hash with nonce starts with
+// difficulty zero bits. It refuses challenges harder than MaxWorkBits.
+func SolveWork(nonce string, difficulty int) (string, error) {
+	if difficulty > MaxWorkBits {
+		return "", fmt.Errorf("relay server asks for %d bits of proof of work; at most %d are supported", difficulty, MaxWorkBits)
+	}
+	for counter := uint64(0); ; counter++ {
+		solution := strconv.FormatUint(counter, 10)
+		if VerifyWork(nonce, solution, difficulty) {
+			return solution, nil
+		}
+	}
+}
+
+// VerifyWork reports whether solution answers the challenge for nonce.
+func VerifyWork(nonce, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(nonce + ":" + solution))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
@@ -0,0 +1,40 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Rejoin meets the peer again under sessionID after the session was lost for
+// good, for instance because the relay restarted or did not let us resume. The
+// session's owner creates it anew and the other client joins it. Either may
+// get there first, and the relay may still hold the lost session under the
+// same ID, so both keep trying until deadline.
+func Rejoin(opts DialOptions, owner bool, sessionID string, deadline time.Time) (net.Conn, error) {
+	// Only the two clients know sessionID, and they check each other's identity
+	// keys once they meet, so there is nobody to hold in a waiting room.
+	opts.WaitingRoom = false
+	opts.Introduce = nil
+	command := "JOIN"
+	if owner {
+		command = "CREATE"
+	}
+
+	wait := time.Second
+	for {
+		conn, id, err := Connect(opts, command, sessionID)
+		if err == nil && id == sessionID {
+			return conn, nil
+		}
+		if err == nil {
+			conn.Close()
+			err = fmt.Errorf("the relay still holds a session with ID %s", sessionID)
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, maxResumeBackoff)
+	}
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/bjarneo/jot/internal/core"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// StartRekey asks the peer to replace the session keys with ones from a fresh
+// exchange. The keys switch once the peer answers, which ListenForMessages
+// handles; the UI hears of it through SendRekeyed. It fails with
+// crypto.ErrRekeyPending while an earlier rekey has not completed.
+func StartRekey(conn net.Conn, keys *crypto.SessionKeys) error {
+	unlock := keys.LockSend()
+	defer unlock()
+	public, err := keys.StartRekey()
+	if err != nil {
+		return err
+	}
+	return sendRekey(conn, keys, protocol.Rekey{Public: public})
+}
+
+// sendRekey sends a rekey message with keys that are already locked.
+func sendRekey(conn net.Conn, keys *crypto.SessionKeys, rekey protocol.Rekey) error {
+	data, err := json.Marshal(rekey)
+	if err != nil {
+		return err
+	}
+	return sendFrame(conn, keys, protocol.TypeRekey, data)
+}
+
+// handleRekey takes the peer's part in a rekey: it answers a request, finishes
+// a rekey we started when the answer arrives, and switches the keys we open
+// the peer's messages with when it says it switched. Each frame that tells the
+// peer we switched is sent with the keys still locked, so nothing else goes
+// out between it and the switch.
+func handleRekey(conn net.Conn, keys *crypto.SessionKeys, sender core.MessageSender, payload []byte) error {
+	var rekey protocol.Rekey
+	if err := json.Unmarshal(payload, &rekey); err != nil {
+		return fmt.Errorf("failed to decode rekey: %w", err)
+	}
+	if err := rekey.Validate(); err != nil {
+		return fmt.Errorf("invalid rekey: %w", err)
+	}
+
+	unlock := keys.LockSend()
+	rekeys, err := takeRekey(conn, keys, rekey)
+	unlock()
+	if err != nil {
+		return err
+	}
+	if rekeys > 0 {
+		sender.SendRekeyed(rekeys)
+	}
+	return nil
+}
+
+// takeRekey is handleRekey's part with the keys locked. It returns how often
+// the keys were replaced so far if this message completed a rekey, or zero.
+func takeRekey(conn net.Conn, keys *crypto.SessionKeys, rekey protocol.Rekey) (int, error) {
+	switch {
+	case rekey.Switch:
+		if err := keys.SwitchReceive(); err != nil {
+			return 0, err
+		}
+		return keys.Rekeys, nil
+
+	case rekey.Reply:
+		if err := keys.FinishRekey(rekey.Public); err != nil {
+			return 0, err
+		}
+		// The peer sends with the new keys from its reply on.
+		if err := keys.SwitchReceive(); err != nil {
+			return 0, err
+		}
+		if err := sendRekey(conn, keys, protocol.Rekey{Switch: true}); err != nil {
+			return 0, err
+		}
+		keys.SwitchSend()
+		return keys.Rekeys, nil
+
+	default:
+		if keys.RekeyPending() && keys.IsInitiator {
+			// We both asked at once. The peer answers our request and drops
+			// its own, as we would in its place.
+			return 0, nil
+		}
+		public, err := keys.AnswerRekey(rekey.Public)
+		if err != nil {
+			return 0, err
+		}
+		if err := sendRekey(conn, keys, protocol.Rekey{Public: public, Reply: true}); err != nil {
+			return 0, err
+		}
+		keys.SwitchSend()
+		return 0, nil
+	}
+}
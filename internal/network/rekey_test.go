@@ -0,0 +1,282 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bjarneo/jot/internal/core"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// queuedConn is one end of a net.Pipe whose writes are queued, as a socket's
+// send buffer would take them. Both ends write from their reading goroutines
+// during a rekey, which would block forever on a bare pipe.
+type queuedConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes chan []byte
+	closed bool
+}
+
+func newQueuedConn(conn net.Conn) *queuedConn {
+	c := &queuedConn{Conn: conn, writes: make(chan []byte, 256)}
+	go func() {
+		for p := range c.writes {
+			if _, err := c.Conn.Write(p); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *queuedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.writes <- bytes.Clone(p)
+	return len(p), nil
+}
+
+func (c *queuedConn) Close() error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.writes)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// recorder is a core.MessageSender that hands the events a test waits for to
+// channels. Any other event panics on the nil interface it embeds.
+type recorder struct {
+	core.MessageSender
+	keys     chan *crypto.SessionKeys
+	identity chan ed25519.PublicKey
+	texts    chan string
+	rekeyed  chan int
+	errs     chan error
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		keys:     make(chan *crypto.SessionKeys, 1),
+		identity: make(chan ed25519.PublicKey, 1),
+		texts:    make(chan string, 256),
+		rekeyed:  make(chan int, 16),
+		errs:     make(chan error, 16),
+	}
+}
+
+func (r *recorder) SendSessionKeys(keys *crypto.SessionKeys)               { r.keys <- keys }
+func (r *recorder) SendMyPublicKey([]byte)                                 {}
+func (r *recorder) SendPeerPublicKey([]byte)                               {}
+func (r *recorder) SendPeerIdentity(publicKey ed25519.PublicKey)           { r.identity <- publicKey }
+func (r *recorder) SendReceivedText(text string, _ crypto.SignatureStatus) { r.texts <- text }
+func (r *recorder) SendRekeyed(rekeys int)                                 { r.rekeyed <- rekeys }
+func (r *recorder) SendError(err error)                                    { r.errs <- err }
+func (r *recorder) SendInfo(info string)                                   { r.errs <- fmt.Errorf("info: %s", info) }
+func (r *recorder) SendConnectionClosed()                                  {}
+func (r *recorder) SendConnectionLost(error)                               {}
+
+// receive waits for the next value on ch.
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		var zero T
+		t.Fatalf("timed out waiting for %s", what)
+		return zero
+	}
+}
+
+// peer is one end of a session between two clients.
+type peer struct {
+	conn     net.Conn
+	keys     *crypto.SessionKeys
+	identity ed25519.PrivateKey
+	events   *recorder
+}
+
+// newSession connects two clients over a pipe and waits until both have
+// exchanged keys and identities.
+func newSession(t *testing.T) (initiator, responder *peer) {
+	t.Helper()
+	a, b := net.Pipe()
+	initiator, responder = &peer{conn: newQueuedConn(a)}, &peer{conn: newQueuedConn(b)}
+	for i, p := range []*peer{initiator, responder} {
+		identity, err := crypto.GenerateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.identity, p.events = identity, newRecorder()
+		go ListenForMessages(p.conn, crypto.SessionKeys{Identity: identity, Cipher: crypto.CipherAESGCM}, p.events, i == 0)
+	}
+	t.Cleanup(func() {
+		initiator.conn.Close()
+		responder.conn.Close()
+	})
+	for _, p := range []*peer{initiator, responder} {
+		p.keys = receive(t, p.events.keys, "session keys")
+		receive(t, p.events.identity, "peer identity")
+	}
+	return initiator, responder
+}
+
+// send sends text and checks that to receives it.
+func (p *peer) send(t *testing.T, to *peer, text string) {
+	t.Helper()
+	if err := SendData(p.conn, p.keys, protocol.TypeText, []byte(text)); err != nil {
+		t.Fatalf("SendData: %v", err)
+	}
+	select {
+	case got := <-to.events.texts:
+		if got != text {
+			t.Fatalf("received %q, want %q", got, text)
+		}
+	case err := <-to.events.errs:
+		t.Fatalf("receiving %q: %v", text, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %q", text)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	tests := []struct {
+		name                 string
+		initiator, responder bool // Which ends start the rekey
+	}{
+		{"initiator starts", true, false},
+		{"responder starts", false, true},
+		{"both start at once", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initiator, responder := newSession(t)
+			initiator.send(t, responder, "before")
+			responder.send(t, initiator, "before")
+			staleKey := bytes.Clone(initiator.keys.SendKey)
+
+			// Both requests go out before either end can take the other's.
+			var starters []*peer
+			if tt.initiator {
+				starters = append(starters, initiator)
+			}
+			if tt.responder {
+				starters = append(starters, responder)
+			}
+			var unlocks []func()
+			for _, p := range starters {
+				unlocks = append(unlocks, p.keys.LockSend())
+			}
+			for _, p := range starters {
+				public, err := p.keys.StartRekey()
+				if err != nil {
+					t.Fatalf("StartRekey: %v", err)
+				}
+				if err := sendRekey(p.conn, p.keys, protocol.Rekey{Public: public}); err != nil {
+					t.Fatalf("sendRekey: %v", err)
+				}
+			}
+			for _, unlock := range unlocks {
+				unlock()
+			}
+			for _, p := range []*peer{initiator, responder} {
+				if got := receive(t, p.events.rekeyed, "rekey"); got != 1 {
+					t.Errorf("rekeys = %d, want 1", got)
+				}
+			}
+			if bytes.Equal(initiator.keys.SendKey, staleKey) {
+				t.Fatal("send key did not change")
+			}
+
+			initiator.send(t, responder, "after")
+			responder.send(t, initiator, "after")
+			for _, p := range []*peer{initiator, responder} {
+				select {
+				case err := <-p.events.errs:
+					t.Errorf("unexpected error: %v", err)
+				default:
+				}
+			}
+		})
+	}
+}
+
+// TestRekeyWhileSending sends from both ends while the keys are replaced, so
+// that messages go out before and after each Reply and Switch.
+func TestRekeyWhileSending(t *testing.T) {
+	initiator, responder := newSession(t)
+	const messages = 50
+	var wg sync.WaitGroup
+	for _, p := range []*peer{initiator, responder} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range messages {
+				if p == initiator && i == messages/2 {
+					if err := StartRekey(p.conn, p.keys); err != nil {
+						t.Errorf("StartRekey: %v", err)
+					}
+				}
+				if err := SendData(p.conn, p.keys, protocol.TypeText, fmt.Appendf(nil, "message %d", i)); err != nil {
+					t.Errorf("SendData: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, p := range []*peer{initiator, responder} {
+		for i := range messages {
+			select {
+			case got := <-p.events.texts:
+				if want := fmt.Sprintf("message %d", i); got != want {
+					t.Fatalf("received %q, want %q", got, want)
+				}
+			case err := <-p.events.errs:
+				t.Fatalf("message %d: %v", i, err)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for message %d", i)
+			}
+		}
+		receive(t, p.events.rekeyed, "rekey")
+	}
+}
+
+func TestRekeyRefusesStaleKey(t *testing.T) {
+	initiator, responder := newSession(t)
+	staleKey := bytes.Clone(initiator.keys.SendKey)
+	if err := StartRekey(initiator.conn, initiator.keys); err != nil {
+		t.Fatalf("StartRekey: %v", err)
+	}
+	receive(t, initiator.events.rekeyed, "rekey")
+	receive(t, responder.events.rekeyed, "rekey")
+
+	// Someone who kept the key from before the rekey, or a relay replaying
+	// a frame sealed with it, is not believed.
+	stale := &crypto.SessionKeys{SendKey: staleKey, Identity: initiator.identity, Cipher: crypto.CipherAESGCM, IsInitiator: true}
+	if err := SendData(initiator.conn, stale, protocol.TypeText, []byte("stale")); err != nil {
+		t.Fatalf("SendData: %v", err)
+	}
+	select {
+	case got := <-responder.events.texts:
+		t.Fatalf("received %q sealed with the stale key", got)
+	case <-responder.events.errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the stale frame to be refused")
+	}
+	initiator.send(t, responder, "fresh")
+}
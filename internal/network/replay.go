@@ -0,0 +1,59 @@
+package network
+
+// ReplayBuffer keeps the last bytes written to a stream, addressed by their
+// offset in it, so that whatever the other end missed can be sent again.
+type ReplayBuffer struct {
+	size int
+	data []byte // Grows up to size, then wraps around
+	end  int64  // Offset just past the last byte written
+}
+
+// NewReplayBuffer returns a buffer holding at most size bytes. Memory is only
+// taken as data is written.
+func NewReplayBuffer(size int) *ReplayBuffer {
+	return &ReplayBuffer{size: size}
+}
+
+// Write appends p, dropping the oldest bytes beyond the buffer's size.
+func (b *ReplayBuffer) Write(p []byte) {
+	for len(p) > 0 {
+		var n int
+		if len(b.data) < b.size {
+			n = min(len(p), b.size-len(b.data))
+			b.data = append(b.data, p[:n]...)
+		} else {
+			n = copy(b.data[b.end%int64(b.size):], p)
+		}
+		p = p[n:]
+		b.end += int64(n)
+	}
+}
+
+// End is the offset just past the last byte written.
+func (b *ReplayBuffer) End() int64 {
+	return b.end
+}
+
+// Since returns a copy of the bytes from offset on. It reports false if the
+// buffer no longer holds all of them, or offset lies beyond its end.
+func (b *ReplayBuffer) Since(offset int64) ([]byte, bool) {
+	start := b.end - int64(len(b.data))
+	if offset < start || offset > b.end {
+		return nil, false
+	}
+	out := make([]byte, 0, b.end-offset)
+	for o := offset; o < b.end; {
+		chunk := b.data[o%int64(b.size):]
+		if remaining := b.end - o; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		out = append(out, chunk...)
+		o += int64(len(chunk))
+	}
+	return out, true
+}
+
+// Held is the memory the buffer has taken so far.
+func (b *ReplayBuffer) Held() int {
+	return cap(b.data)
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxReportNote is the longest note, in bytes, a relay accepts with a report.
+const MaxReportNote = 500
+
+// Report tells the relay's operator about abuse by the other participant of a
+// session. It never carries message content, which the relay cannot read
+// anyway; the fingerprints are as the reporting client saw them.
+type Report struct {
+	ReporterFingerprint string `json:"reporterFingerprint,omitempty"`
+	ReportedFingerprint string `json:"reportedFingerprint,omitempty"`
+	Note                string `json:"note,omitempty"`
+}
+
+// SubmitReport files report about sessionID with the relay, proving with
+// opts.NoticeKey that we take part in it, and returns the report's ID.
+func SubmitReport(opts DialOptions, sessionID string, report Report) (string, error) {
+	conn, err := dial(opts)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reportMsg := struct {
+		Command   string  `json:"command"`
+		SessionID string  `json:"sessionID"`
+		NoticeKey string  `json:"noticeKey"`
+		Report    *Report `json:"report"`
+	}{
+		Command:   "REPORT",
+		SessionID: sessionID,
+		NoticeKey: opts.NoticeKey,
+		Report:    &report,
+	}
+	response, err := sendCommand(conn, reportMsg)
+	if err != nil {
+		return "", err
+	}
+	id, ok := strings.CutPrefix(response, "Reported:")
+	if !ok {
+		return "", fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	}
+	return strings.TrimSpace(id), nil
+}
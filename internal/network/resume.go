@@ -0,0 +1,303 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// replaySize bounds what a ResumableConn keeps of what it sent, to send
+	// again whatever the relay missed when the connection dropped.
+	replaySize = 4 * 1024 * 1024
+	// resumeWindow is how long a ResumableConn keeps trying to resume.
+	resumeWindow = 2 * time.Minute
+	// maxResumeBackoff caps the wait between attempts to resume.
+	maxResumeBackoff = 8 * time.Second
+)
+
+// ResumeEvent tells the user of a ResumableConn about a dropped connection.
+type ResumeEvent int
+
+const (
+	ResumeLost   ResumeEvent = iota // The connection dropped; resuming
+	ResumeDone                      // The session resumed without losing data
+	ResumeFailed                    // The session could not be resumed
+)
+
+// Presence is the relay's account of the peer, sent to a client that resumes
+// so it knows the state of the session rather than guessing from what it missed.
+type Presence struct {
+	PeerConnected bool          `json:"peerConnected,omitempty"`
+	PeerAway      time.Duration `json:"peerAway,omitempty"` // How long ago the peer lost its own connection, if it is away
+}
+
+// ResumableConn is a connection to a session that survives losing the
+// connection to the relay, if the relay allows clients to resume. When a read
+// or write fails, it dials the relay again with RESUME, and both ends send what
+// the other missed, so the layers above see an uninterrupted stream. A clean
+// end of the stream means the session is over and is not resumed.
+type ResumableConn struct {
+	opts      DialOptions // NoticeKey proves to the relay which client we are
+	sessionID string
+	events    func(ResumeEvent, *Presence)
+
+	resumeMu sync.Mutex // Held while resuming, and while writing so replays and writes never interleave
+
+	mu       sync.Mutex
+	cond     *sync.Cond // Signalled when conn is replaced or the connection fails for good
+	conn     net.Conn   // nil while resuming
+	last     net.Conn   // The latest connection, even while resuming; it reports the addresses
+	received int64      // Bytes read from the relay
+	replay   *ReplayBuffer
+	err      error // Set once resuming failed or Close was called
+}
+
+// NewResumableConn wraps conn, the connection Connect returned for sessionID.
+// events, if not nil, is told when the connection drops and how that ended,
+// along with the relay's presence snapshot after resuming, if it sent one.
+func NewResumableConn(conn net.Conn, opts DialOptions, sessionID string, events func(ResumeEvent, *Presence)) *ResumableConn {
+	c := &ResumableConn{opts: opts, sessionID: sessionID, events: events, conn: conn, last: conn, replay: NewReplayBuffer(replaySize)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// current returns the connection in use, waiting while a resume is under way.
+func (c *ResumableConn) current() (net.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.conn == nil && c.err == nil {
+		c.cond.Wait()
+	}
+	return c.conn, c.err
+}
+
+func (c *ResumableConn) Read(p []byte) (int, error) {
+	for {
+		conn, err := c.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(p)
+		if n > 0 {
+			c.mu.Lock()
+			accepted := c.conn == conn
+			if accepted {
+				c.received += int64(n)
+			}
+			c.mu.Unlock()
+			// Bytes read from a connection we already gave up on were not
+			// counted, so the relay sends them again.
+			if accepted {
+				return n, nil
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, io.EOF) && c.isCurrent(conn) {
+			return 0, err
+		}
+		if resumeErr := c.resume(conn, err); resumeErr != nil {
+			return 0, resumeErr
+		}
+	}
+}
+
+func (c *ResumableConn) Write(p []byte) (int, error) {
+	c.resumeMu.Lock()
+	c.mu.Lock()
+	conn, err := c.conn, c.err
+	if err == nil {
+		c.replay.Write(p)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		c.resumeMu.Unlock()
+		return 0, err
+	}
+	_, err = conn.Write(p)
+	c.resumeMu.Unlock()
+	if err != nil {
+		// p is in the replay buffer, so resuming sends it.
+		if err := c.resume(conn, err); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *ResumableConn) isCurrent(conn net.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn == conn
+}
+
+// resume replaces failed, which broke with cause, by a resumed connection. If
+// another goroutine already replaced it, there is nothing to do.
+func (c *ResumableConn) resume(failed net.Conn, cause error) error {
+	// Closing failed first makes a write blocked on it give up resumeMu.
+	failed.Close()
+	c.resumeMu.Lock()
+	defer c.resumeMu.Unlock()
+
+	c.mu.Lock()
+	if c.err != nil {
+		defer c.mu.Unlock()
+		return c.err
+	}
+	if c.conn != failed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.conn = nil
+	received := c.received
+	c.mu.Unlock()
+	c.notify(ResumeLost, nil)
+
+	deadline := time.Now().Add(resumeWindow)
+	wait := time.Second
+	for {
+		conn, presence, err := c.dialResume(received)
+		if err == nil {
+			c.mu.Lock()
+			if c.err == nil {
+				c.conn, c.last = conn, conn
+				c.cond.Broadcast()
+			}
+			err = c.err
+			c.mu.Unlock()
+			if err != nil {
+				conn.Close()
+				return err
+			}
+			c.notify(ResumeDone, presence)
+			return nil
+		}
+		if IsRelayError(err) || time.Now().Add(wait).After(deadline) || c.closed() {
+			return c.fail(fmt.Errorf("connection lost (%v) and could not be resumed: %w", cause, err))
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, maxResumeBackoff)
+	}
+}
+
+// dialResume asks the relay for our session back, having read received bytes
+// from it, and sends it again whatever of ours it missed. Relays that predate
+// presence snapshots send none, so the returned presence may be nil.
+func (c *ResumableConn) dialResume(received int64) (net.Conn, *Presence, error) {
+	conn, err := dial(c.opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := struct {
+		Command   string `json:"command"`
+		SessionID string `json:"sessionID"`
+		NoticeKey string `json:"noticeKey"`
+		Received  int64  `json:"received"`
+		Presence  bool   `json:"presence"`
+	}{"RESUME", c.sessionID, c.opts.NoticeKey, received, true}
+
+	conn.SetDeadline(time.Now().Add(c.opts.handshakeTimeout()))
+	response, err := sendCommand(conn, msg)
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	unexpected := fmt.Errorf("unexpected response from relay server: %s", strings.TrimSpace(response))
+	theirs, ok := strings.CutPrefix(response, "Resumed:")
+	if !ok {
+		conn.Close()
+		return nil, nil, unexpected
+	}
+	count, snapshot, _ := strings.Cut(strings.TrimSpace(theirs), " ")
+	relayReceived, err := strconv.ParseInt(count, 10, 64)
+	if err != nil {
+		conn.Close()
+		return nil, nil, unexpected
+	}
+	var presence *Presence
+	if snapshot != "" {
+		presence = &Presence{}
+		if err := json.Unmarshal([]byte(snapshot), presence); err != nil {
+			conn.Close()
+			return nil, nil, unexpected
+		}
+	}
+
+	c.mu.Lock()
+	missed, ok := c.replay.Since(relayReceived)
+	c.mu.Unlock()
+	if !ok {
+		conn.Close()
+		return nil, nil, &RelayError{Reason: "the relay missed more of our data than we kept"}
+	}
+	if _, err := conn.Write(missed); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, presence, nil
+}
+
+// fail gives up on the connection for good.
+func (c *ResumableConn) fail(err error) error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	err = c.err
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.notify(ResumeFailed, nil)
+	return err
+}
+
+func (c *ResumableConn) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+func (c *ResumableConn) notify(event ResumeEvent, presence *Presence) {
+	if c.events != nil {
+		c.events(event, presence)
+	}
+}
+
+// Close closes the connection and stops any resume under way.
+func (c *ResumableConn) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	if c.err == nil {
+		c.err = net.ErrClosed
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// The remaining net.Conn methods apply to the latest connection; deadlines
+// do not carry over to a resumed one.
+
+func (c *ResumableConn) LocalAddr() net.Addr  { return c.latest().LocalAddr() }
+func (c *ResumableConn) RemoteAddr() net.Addr { return c.latest().RemoteAddr() }
+
+func (c *ResumableConn) SetDeadline(t time.Time) error      { return c.latest().SetDeadline(t) }
+func (c *ResumableConn) SetReadDeadline(t time.Time) error  { return c.latest().SetReadDeadline(t) }
+func (c *ResumableConn) SetWriteDeadline(t time.Time) error { return c.latest().SetWriteDeadline(t) }
+
+func (c *ResumableConn) latest() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Supported push notification services.
+const (
+	ProviderNtfy   = "ntfy"
+	ProviderGotify = "gotify"
+)
+
+// Notifier delivers push notifications to an ntfy topic or a Gotify server.
+type Notifier struct {
+	URL            string // ntfy topic URL, or Gotify message URL including ?token=
+	Provider       string
+	IncludeContent bool // Send message content, not just the subject line
+	client         *http.Client
+}
+
+// New returns a Notifier for the given endpoint and provider.
+func New(endpoint, provider string, includeContent bool) (*Notifier, error) {
+	if provider != ProviderNtfy && provider != ProviderGotify {
+		return nil, fmt.Errorf("unknown notification provider %q (expected %s or %s)", provider, ProviderNtfy, ProviderGotify)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid notification URL %q", endpoint)
+	}
+	return &Notifier{
+		URL:            endpoint,
+		Provider:       provider,
+		IncludeContent: includeContent,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Notify sends a notification with the given title. content is only included
+// when IncludeContent is set, so messages do not leave the machine by default.
+func (n *Notifier) Notify(title, content string) error {
+	body := "Attach to your jot session to read it."
+	if n.IncludeContent && content != "" {
+		body = content
+	}
+
+	var req *http.Request
+	var err error
+	switch n.Provider {
+	case ProviderGotify:
+		payload, _ := json.Marshal(struct {
+			Title    string `json:"title"`
+			Message  string `json:"message"`
+			Priority int    `json:"priority"`
+		}{title, body, 5})
+		req, err = http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		req, err = http.NewRequest(http.MethodPost, n.URL, bytes.NewReader([]byte(body)))
+		if err == nil {
+			// Encode the title so peer-chosen nicknames cannot break the header.
+			req.Header.Set("Title", mime.QEncoding.Encode("utf-8", title))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not build notification request: %w", err)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not send notification: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification service returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// CompressionDeflate names DEFLATE (RFC 1951) in Hello.Compression.
+const CompressionDeflate = "deflate"
+
+// CompressThreshold is the size below which texts are sent uncompressed.
+// Short texts gain little, and their compressed length says more about their content.
+const CompressThreshold = 1024
+
+// MaxInflatedText bounds a decompressed text, so a small message cannot expand
+// into gigabytes on the receiving side.
+const MaxInflatedText = 4 * 1024 * 1024
+
+// Deflate compresses data for a TypeTextDeflate message.
+func Deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Inflate decompresses the payload of a TypeTextDeflate message.
+func Inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	inflated, err := io.ReadAll(io.LimitReader(r, MaxInflatedText+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(inflated) > MaxInflatedText {
+		return nil, fmt.Errorf("decompressed text exceeds %d bytes", MaxInflatedText)
+	}
+	return inflated, nil
+}
@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Once the relay has paired two clients, everything they send each other is a
+// frame: one of the Type constants, the payload length as a big-endian uint32,
+// and the payload. Only TypePublicKeyExchange frames travel in the clear; every
+// other payload is a signed envelope encrypted under the session key. The
+// relay copies frames without looking at them.
+
+// MaxFramePayload bounds the payload of a frame we are willing to read.
+const MaxFramePayload = 10 * 1024 * 1024
+
+// FrameHeaderSize is the length of the type and length that start a frame.
+const FrameHeaderSize = 1 + 4
+
+// WriteFrame writes one frame to w in a single Write, so frames written from
+// different goroutines never interleave.
+func WriteFrame(w io.Writer, msgType byte, payload []byte) error {
+	if len(payload) > MaxFramePayload {
+		return fmt.Errorf("frame payload of %d bytes exceeds %d", len(payload), MaxFramePayload)
+	}
+	frame := make([]byte, FrameHeaderSize, FrameHeaderSize+len(payload))
+	frame[0] = msgType
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// WriteFramePayload writes frame to w as a frame of type msgType. The payload
+// follows FrameHeaderSize bytes left free for the header, which saves the copy
+// WriteFrame makes to send it in a single Write.
+func WriteFramePayload(w io.Writer, msgType byte, frame []byte) error {
+	length := len(frame) - FrameHeaderSize
+	if length > MaxFramePayload {
+		return fmt.Errorf("frame payload of %d bytes exceeds %d", length, MaxFramePayload)
+	}
+	frame[0] = msgType
+	binary.BigEndian.PutUint32(frame[1:], uint32(length))
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one frame from r. It returns io.EOF only if r ends cleanly
+// before the frame starts.
+func ReadFrame(r io.Reader) (byte, []byte, error) {
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxFramePayload {
+		return 0, nil, fmt.Errorf("frame payload of %d bytes exceeds %d", length, MaxFramePayload)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	return header[0], payload, nil
+}
+
+// maxPooledFrame bounds the buffers kept for reuse, so that one large frame
+// does not pin its buffer for the life of the process.
+const maxPooledFrame = 64 * 1024
+
+// frameBuffers holds the buffers frames are read into and built in, shared by
+// all connections so that busy ones do not allocate for every message.
+var frameBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// GetFrameBuffer takes an empty buffer from the pool. Give it back with
+// PutFrameBuffer once nothing in it is used any more.
+func GetFrameBuffer() *[]byte {
+	return frameBuffers.Get().(*[]byte)
+}
+
+// PutFrameBuffer returns a buffer taken with GetFrameBuffer to the pool.
+func PutFrameBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledFrame {
+		return
+	}
+	*buf = (*buf)[:0]
+	frameBuffers.Put(buf)
+}
+
+// ReadFrameInto reads one frame from r like ReadFrame, but into *buf, growing
+// it as needed. The payload is only valid until the next read into buf.
+func ReadFrameInto(r io.Reader, buf *[]byte) (byte, []byte, error) {
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxFramePayload {
+		return 0, nil, fmt.Errorf("frame payload of %d bytes exceeds %d", length, MaxFramePayload)
+	}
+	if cap(*buf) < int(length) {
+		*buf = make([]byte, length)
+	}
+	payload := (*buf)[:length]
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("failed to read frame payload: %w", err)
+	}
+	return header[0], payload, nil
+}
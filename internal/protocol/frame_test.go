@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+var benchmarkSizes = []int{64, 1024, 64 * 1024}
+
+func BenchmarkWriteFrame(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			payload := make([]byte, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for range b.N {
+				if err := WriteFrame(io.Discard, TypeText, payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkWriteFramePayload(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			frame := make([]byte, FrameHeaderSize+size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for range b.N {
+				if err := WriteFramePayload(io.Discard, TypeText, frame); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// frames returns a reader that yields the same frame of size bytes over and over.
+func frames(b *testing.B, size int) *bytes.Reader {
+	var frame bytes.Buffer
+	if err := WriteFrame(&frame, TypeText, make([]byte, size)); err != nil {
+		b.Fatal(err)
+	}
+	return bytes.NewReader(frame.Bytes())
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			r := frames(b, size)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for range b.N {
+				r.Seek(0, io.SeekStart)
+				if _, _, err := ReadFrame(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadFrameInto(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%dB", size), func(b *testing.B) {
+			r := frames(b, size)
+			buf := GetFrameBuffer()
+			defer PutFrameBuffer(buf)
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			for range b.N {
+				r.Seek(0, io.SeekStart)
+				if _, _, err := ReadFrameInto(r, buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package protocol
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
 
 // --- Protocol Definition ---
+// Message types, sent as the first byte of every frame (see WriteFrame).
 
 const (
 	TypeNickname          byte = 0x00
@@ -12,14 +18,50 @@ const (
 	TypeFileReject        byte = 0x04
 	TypeFileChunk         byte = 0x05
 	TypeFileDone          byte = 0x06
-	TypePublicKeyExchange byte = 0x0A // New type for public key exchange
+	TypeIdentity          byte = 0x07 // Ed25519 identity public key, sent right after key exchange
+	TypeHello             byte = 0x08 // Client limits and preferences, sent before the nickname
+	TypeRecording         byte = 0x09 // Announces that the peer started or stopped recording the session
+	TypePublicKeyExchange byte = 0x0A // Curve25519 public key, the only frame sent unencrypted
+	TypeTextDeflate       byte = 0x0B // Text compressed with DEFLATE, sent only to peers whose hello lists it
+	TypeAck               byte = 0x0C // Acknowledges received texts, sent only to peers whose hello asks for it
+	TypeKeepalive         byte = 0x0D // Tells the peer we are still there, sent only to peers whose hello asks for it
+	TypeBlob              byte = 0x0E // Tells the peer about a file we left on the relay, sent only to peers whose hello asks for it
+	TypePoll              byte = 0x0F // Asks the peer a question with a few answers, sent only to peers whose hello asks for it
+	TypeVote              byte = 0x10 // Answers a poll, sent only to peers whose hello asks for it
+	TypeAnnouncement      byte = 0x11 // Tells the peer whether only the session owner may post, sent only to peers whose hello asks for it
+	TypeBye               byte = 0x12 // Tells the peer we are leaving on purpose, sent only to peers whose hello asks for it
+	TypePassword          byte = 0x13 // SPAKE2 share or confirmation proving the session password, sent unencrypted after the public keys
+	TypeRekey             byte = 0x14 // Replaces the session keys with ones from a fresh exchange, sent only to peers whose hello asks for it
 )
 
 // FileMetadata is sent before the file content itself.
+// The sender's local path is never part of it; senders map the offer ID back to the file they offered.
 type FileMetadata struct {
-	FileName     string `json:"fileName"`
-	FileSize     int64  `json:"fileSize"`
-	OriginalPath string `json:"originalPath,omitempty"` // Used by the sender to know which file to stream
+	ID       string `json:"id,omitempty"` // Identifies the offer in accept and reject replies
+	FileName string `json:"fileName"`
+	FileSize int64  `json:"fileSize"`
+
+	// DataConnection, in an acceptance, says the receiver waits for the file on
+	// a data connection of its own, opened with the offer's ID.
+	DataConnection bool `json:"dataConnection,omitempty"`
+	// Key, in an acceptance with DataConnection, is the key the data
+	// connection is encrypted with, chosen by the receiver. Without it, both
+	// ends use the session's file keys, which a rekey would change under them.
+	Key []byte `json:"key,omitempty"`
+}
+
+// Validate checks a file offer from the peer.
+func (fm FileMetadata) Validate() error {
+	if fm.FileName == "" {
+		return errors.New("missing file name")
+	}
+	if fm.FileSize < 0 {
+		return errors.New("negative file size")
+	}
+	if len(fm.Key) != 0 && len(fm.Key) != TransferKeySize {
+		return errors.New("invalid transfer key")
+	}
+	return nil
 }
 
 // ToJSON marshals the FileMetadata to JSON.
@@ -31,3 +73,173 @@ func (fm *FileMetadata) ToJSON() ([]byte, error) {
 func (fm *FileMetadata) FromJSON(data []byte) error {
 	return json.Unmarshal(data, fm)
 }
+
+// MaxLabel is the longest session label, in bytes, a hello may carry.
+const MaxLabel = 64
+
+// MaxText is the longest chat message, in bytes. Clients refuse to send longer
+// ones and drop those that arrive, so a peer cannot fill our memory with a few
+// huge messages; anything longer goes as a file.
+const MaxText = 64 * 1024
+
+// MaxNickname is the longest nickname, in bytes, shown for the peer.
+const MaxNickname = 64
+
+// Hello advertises a client's limits to its peer right after the key exchange.
+type Hello struct {
+	MaxFileSize int64    `json:"maxFileSize"`           // Largest file, in bytes, the client accepts
+	Compression []string `json:"compression,omitempty"` // Compression the client can decode, such as CompressionDeflate
+	Acks        bool     `json:"acks,omitempty"`        // The client acknowledges texts it receives and understands acks
+
+	DataConnections bool `json:"dataConnections,omitempty"` // The client can send files over a data connection of their own
+	Keepalives      bool `json:"keepalives,omitempty"`      // The client sends keepalives while idle and wants them in return
+	Blobs           bool `json:"blobs,omitempty"`           // The client can fetch files its peer left on the relay
+	Polls           bool `json:"polls,omitempty"`           // The client shows polls and votes in them
+	Announcements   bool `json:"announcements,omitempty"`   // The client holds back its posts in announcement mode
+	Rejoins         bool `json:"rejoins,omitempty"`         // The client says goodbye before leaving, and comes back if the session is lost
+	Handoffs        bool `json:"handoffs,omitempty"`        // The client takes over the session when the relay says its owner handed it over
+	Observer        bool `json:"observer,omitempty"`        // The client joined as an observer and posts nothing
+	Rekeys          bool `json:"rekeys,omitempty"`          // The client can replace the session keys mid-session, and sends files with keys chosen per transfer
+	Cancels         bool `json:"cancels,omitempty"`         // A rejection for a transfer under way makes the client stop its end
+
+	Label    string `json:"label,omitempty"`    // A name for the session, sent only by the client that created it
+	RejoinID string `json:"rejoinID,omitempty"` // Where the session is created again if it is lost, sent only by the client that created it
+}
+
+// Validate checks a hello from the peer.
+func (h Hello) Validate() error {
+	if h.MaxFileSize < 0 {
+		return errors.New("negative maximum file size")
+	}
+	if len(h.Label) > MaxLabel {
+		return fmt.Errorf("session label longer than %d bytes", MaxLabel)
+	}
+	if len(h.RejoinID) > MaxLabel {
+		return fmt.Errorf("rejoin session ID longer than %d bytes", MaxLabel)
+	}
+	return nil
+}
+
+// BlobKeySize is the length of the key a file left on the relay is encrypted with.
+const BlobKeySize = 32
+
+// Blob tells the peer about a file we encrypted and left on our relay for it
+// to fetch. The key never reaches the relay.
+type Blob struct {
+	Token    string    `json:"token"` // Downloads the file from the relay
+	Key      []byte    `json:"key"`   // Decrypts it
+	FileName string    `json:"fileName"`
+	FileSize int64     `json:"fileSize"` // Before encryption
+	Expires  time.Time `json:"expires"`  // When the relay drops it
+}
+
+// Validate checks a blob notice from the peer.
+func (b Blob) Validate() error {
+	if b.Token == "" {
+		return errors.New("missing token")
+	}
+	if len(b.Key) != BlobKeySize {
+		return errors.New("invalid key")
+	}
+	if b.FileName == "" {
+		return errors.New("missing file name")
+	}
+	if b.FileSize < 0 {
+		return errors.New("negative file size")
+	}
+	return nil
+}
+
+// Ack tells the peer how many texts, of either text type, we have received
+// from it so far. Texts arrive in order, so this acknowledges all of them.
+type Ack struct {
+	Received uint64 `json:"received"`
+}
+
+// Recording announces a change in the sender's session recording.
+type Recording struct {
+	Active bool `json:"active"`
+}
+
+// Limits on a poll, in bytes and options.
+const (
+	MaxPollQuestion = 200
+	MaxPollOption   = 100
+	MaxPollOptions  = 9 // Voted for with Alt+1 to Alt+9
+)
+
+// Poll asks the peer a question. A new poll replaces the one before it.
+type Poll struct {
+	ID       string   `json:"id"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// Validate checks a poll from the peer.
+func (p Poll) Validate() error {
+	if p.ID == "" || len(p.ID) > 64 {
+		return errors.New("missing or overlong ID")
+	}
+	if p.Question == "" || len(p.Question) > MaxPollQuestion {
+		return fmt.Errorf("question missing or longer than %d bytes", MaxPollQuestion)
+	}
+	if len(p.Options) < 2 || len(p.Options) > MaxPollOptions {
+		return fmt.Errorf("%d options, not between 2 and %d", len(p.Options), MaxPollOptions)
+	}
+	for _, option := range p.Options {
+		if option == "" || len(option) > MaxPollOption {
+			return fmt.Errorf("option missing or longer than %d bytes", MaxPollOption)
+		}
+	}
+	return nil
+}
+
+// Vote answers the poll with the given ID. A later vote replaces an earlier one.
+type Vote struct {
+	Poll   string `json:"poll"`
+	Option int    `json:"option"` // Index into the poll's options
+}
+
+// Validate checks a vote from the peer.
+func (v Vote) Validate() error {
+	if v.Poll == "" {
+		return errors.New("missing poll ID")
+	}
+	if v.Option < 0 || v.Option >= MaxPollOptions {
+		return fmt.Errorf("option %d out of range", v.Option)
+	}
+	return nil
+}
+
+// Announcement tells the peer whether the session owner turned on announcement
+// mode, in which only the owner, and the peer if given voice, may post. It is
+// kept by the peer's client; the relay knows nothing of it.
+type Announcement struct {
+	Active  bool `json:"active"`
+	Speaker bool `json:"speaker,omitempty"` // The peer may post anyway
+}
+
+// TransferKeySize is the length of the key a file data connection is
+// encrypted with when the receiver chooses one.
+const TransferKeySize = 32
+
+// Rekey replaces the session keys with ones derived from a fresh X25519
+// exchange, so that keys taken from a client later do not decrypt what was
+// sent before. The peer that starts sends its new public key; the other
+// answers with its own in a Reply and switches the keys it sends with right
+// after it. The starting peer switches both directions when the reply
+// arrives, sending a Switch first, after which the other switches the keys it
+// receives with. All three are sent with the keys they replace.
+type Rekey struct {
+	Public []byte `json:"public,omitempty"`
+	Reply  bool   `json:"reply,omitempty"`
+	Switch bool   `json:"switch,omitempty"`
+}
+
+// Validate checks a rekey message from the peer.
+func (r Rekey) Validate() error {
+	if !r.Switch && len(r.Public) != 32 {
+		return errors.New("invalid public key")
+	}
+	return nil
+}
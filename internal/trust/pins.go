@@ -0,0 +1,103 @@
+package trust
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PinStatus describes how a peer's identity key relates to the key pinned for
+// its nickname, the way SSH's known_hosts pins a host's key to its name.
+type PinStatus int
+
+const (
+	// FirstSeen means no key was pinned for the nickname; the peer's is now.
+	FirstSeen PinStatus = iota
+	// Pinned means the peer's key is the one pinned for its nickname.
+	Pinned
+	// Mismatch means a different key is pinned for the nickname.
+	Mismatch
+)
+
+// Pin is the identity key first seen under a nickname.
+type Pin struct {
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+// Pins is a local, file-backed record of the identity key each nickname was
+// first seen with. Unlike the trust store, it needs no action from the user:
+// every peer is pinned the first time it shows up.
+type Pins struct {
+	path  string
+	mu    sync.Mutex
+	Peers map[string]Pin `json:"peers"`
+}
+
+// DefaultPinsPath returns the location of the pinned keys in the user's config directory.
+func DefaultPinsPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "jot", "known_peers.json"), nil
+}
+
+// LoadPins reads the pinned keys at path. A missing file yields no pins.
+func LoadPins(path string) (*Pins, error) {
+	p := &Pins{path: path, Peers: make(map[string]Pin)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p, nil
+		}
+		return nil, fmt.Errorf("could not read pinned keys: %w", err)
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("could not parse pinned keys: %w", err)
+	}
+	if p.Peers == nil {
+		p.Peers = make(map[string]Pin)
+	}
+	return p, nil
+}
+
+// Save writes the pinned keys to disk, readable only by the current user.
+func (p *Pins) Save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return saveJSON(p.path, p, "pinned keys")
+}
+
+// Check compares fingerprint with the key pinned for nickname, pinning it if
+// there is none. A mismatch leaves the pin as it is; see Replace.
+func (p *Pins) Check(nickname, fingerprint string) (PinStatus, Pin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	pin, ok := p.Peers[nickname]
+	switch {
+	case !ok:
+		p.Peers[nickname] = Pin{Fingerprint: fingerprint, FirstSeen: now, LastSeen: now}
+		return FirstSeen, p.Peers[nickname]
+	case pin.Fingerprint != fingerprint:
+		return Mismatch, pin
+	}
+	pin.LastSeen = now
+	p.Peers[nickname] = pin
+	return Pinned, pin
+}
+
+// Replace pins fingerprint for nickname in place of the key pinned before,
+// once the user has accepted the change.
+func (p *Pins) Replace(nickname, fingerprint string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.Peers[nickname] = Pin{Fingerprint: fingerprint, FirstSeen: now, LastSeen: now}
+}
@@ -0,0 +1,110 @@
+package trust
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Status describes how a peer's current fingerprint relates to the trust store.
+type Status int
+
+const (
+	// Unverified means the peer has never been verified.
+	Unverified Status = iota
+	// Verified means the peer's fingerprint matches a verified entry.
+	Verified
+	// Changed means the peer was verified before, but with a different fingerprint.
+	Changed
+)
+
+// Entry is a verification decision for a single peer identity.
+type Entry struct {
+	Fingerprint string    `json:"fingerprint"`
+	VerifiedAt  time.Time `json:"verifiedAt"`
+}
+
+// Store is a local, file-backed record of verified peer fingerprints keyed by nickname.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	Entries map[string]Entry `json:"entries"`
+}
+
+// DefaultPath returns the location of the trust store in the user's config directory.
+func DefaultPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "jot", "trust.json"), nil
+}
+
+// Load reads the trust store at path. A missing file yields an empty store.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, Entries: make(map[string]Entry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("could not read trust store: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("could not parse trust store: %w", err)
+	}
+	if s.Entries == nil {
+		s.Entries = make(map[string]Entry)
+	}
+	return s, nil
+}
+
+// Save writes the trust store to disk, readable only by the current user.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return saveJSON(s.path, s, "trust store")
+}
+
+// saveJSON writes v to path as indented JSON, readable only by the current
+// user. what names the file in errors.
+func saveJSON(path string, v any, what string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("could not create %s directory: %w", what, err)
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode %s: %w", what, err)
+	}
+	// Write to a temporary file first so a crash never leaves a truncated file behind.
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("could not write %s: %w", what, err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Verify records fingerprint as the verified fingerprint for nickname.
+func (s *Store) Verify(nickname, fingerprint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Entries[nickname] = Entry{Fingerprint: fingerprint, VerifiedAt: time.Now()}
+}
+
+// Status reports whether fingerprint is the verified fingerprint for nickname.
+func (s *Store) Status(nickname, fingerprint string) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Entries[nickname]
+	if !ok {
+		return Unverified
+	}
+	if entry.Fingerprint != fingerprint {
+		return Changed
+	}
+	return Verified
+}
@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/hook"
+)
+
+// flashDuration is how long the status bar stays highlighted for a flash alert.
+const flashDuration = 600 * time.Millisecond
+
+// maxRunningAlertCommands bounds how many alert commands may run at once.
+const maxRunningAlertCommands = 4
+
+// flashDoneMsg ends the flash numbered seq, unless a newer one started since.
+type flashDoneMsg struct{ seq int }
+
+// AlertResultMsg reports the outcome of an alert command.
+type AlertResultMsg struct {
+	Event  string
+	Result hook.Result
+	Err    error
+}
+
+// alert draws attention to event as the config file asks: it rings the bell,
+// flashes the status bar and runs a command, in any combination.
+func (m *Model) alert(event string) tea.Cmd {
+	a, ok := m.Alerts[event]
+	if !ok {
+		return nil
+	}
+	var cmds []tea.Cmd
+	if a.Bell {
+		terminal := m.Terminal
+		if terminal == nil {
+			terminal = os.Stdout
+		}
+		cmds = append(cmds, func() tea.Msg {
+			io.WriteString(terminal, "\a")
+			return nil
+		})
+	}
+	if a.Flash {
+		m.flashSeq++
+		m.flashing = true
+		seq := m.flashSeq
+		cmds = append(cmds, tea.Tick(flashDuration, func(time.Time) tea.Msg { return flashDoneMsg{seq: seq} }))
+	}
+	// The peer decides how often most events fire, so excess commands are
+	// dropped rather than queued.
+	if a.Command != "" && m.runningAlertCommands < maxRunningAlertCommands {
+		m.runningAlertCommands++
+		command := a.Command
+		env := []string{
+			"JOT_EVENT=" + event,
+			"JOT_SENDER=" + m.PeerNickname,
+			"JOT_SESSION=" + m.SessionID,
+		}
+		cmds = append(cmds, func() tea.Msg {
+			result, err := hook.Run(command, nil, env, "")
+			return AlertResultMsg{Event: event, Result: result, Err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// textEvent returns the event a text from the peer raises: a mention if it
+// names us and mentions have an alert of their own, a message otherwise.
+func (m *Model) textEvent(text string) string {
+	if _, ok := m.Alerts[config.EventMention]; ok && mentions(text, m.Nickname) {
+		return config.EventMention
+	}
+	return config.EventMessage
+}
+
+// mentions reports whether text contains nickname as a word of its own,
+// ignoring case and a leading '@'.
+func mentions(text, nickname string) bool {
+	if nickname == "" {
+		return false
+	}
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	})
+	for _, word := range words {
+		if strings.EqualFold(word, nickname) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// AnnouncementMsg carries the session owner's announcement mode.
+type AnnouncementMsg struct{ Announcement protocol.Announcement }
+
+// setAnnouncing turns announcement mode on or off for /announce on|off.
+// Only the session owner may.
+func (m *Model) setAnnouncing(arg string) tea.Cmd {
+	now := time.Now()
+	if m.Command != "CREATE" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Only the participant who created the session can turn on announcement mode."})
+		return nil
+	}
+	switch arg {
+	case "on":
+		m.announcing = true
+	case "off":
+		m.announcing, m.peerVoiced = false, false
+	default:
+		state := "off"
+		if m.announcing {
+			state = "on"
+		}
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Announcement mode is %s. Usage: /announce on|off", state)})
+		return nil
+	}
+	if m.announcing {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Announcement mode is on: only you can post. Use /voice-grant to let your peer post too."})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Announcement mode is off: everyone can post."})
+	}
+	return m.sendAnnouncement()
+}
+
+// setPeerVoice lets the peer post in announcement mode, or stops it, for
+// /voice-grant and /voice-revoke.
+func (m *Model) setPeerVoice(voiced bool) tea.Cmd {
+	now := time.Now()
+	switch {
+	case m.Command != "CREATE":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Only the participant who created the session can give voice."})
+		return nil
+	case !m.announcing:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Announcement mode is off, so everyone can post already. Turn it on with /announce on."})
+		return nil
+	}
+	m.peerVoiced = voiced
+	if voiced {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s can post in announcement mode.", m.peerName())})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s can no longer post in announcement mode.", m.peerName())})
+	}
+	return m.sendAnnouncement()
+}
+
+// sendAnnouncement tells a connected peer the announcement mode. A peer that
+// joins later is told once its nickname arrives.
+func (m *Model) sendAnnouncement() tea.Cmd {
+	if !m.IsReady || m.Keys == nil {
+		return nil
+	}
+	if !m.peerAnnouncements {
+		if m.announcing {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("%s's client does not support announcement mode and will not hold back their messages.", m.peerName())})
+		}
+		return nil
+	}
+	conn, keys := m.Conn, m.Keys
+	announcement := protocol.Announcement{Active: m.announcing, Speaker: m.peerVoiced}
+	return func() tea.Msg {
+		if err := network.SendJSON(conn, keys, protocol.TypeAnnouncement, announcement); err != nil {
+			return ErrorMsg{Err: fmt.Errorf("failed to send the announcement mode: %w", err)}
+		}
+		return nil
+	}
+}
+
+// announced records the announcement mode the session owner set.
+func (m *Model) announced(announcement protocol.Announcement) {
+	if m.Command == "CREATE" || announcement == m.announcement {
+		return
+	}
+	previous := m.announcement
+	m.announcement = announcement
+	var content string
+	switch {
+	case !announcement.Active:
+		content = "The session owner turned off announcement mode: everyone can post."
+	case !previous.Active && announcement.Speaker:
+		content = "The session owner turned on announcement mode and gave you voice: you can still post."
+	case !previous.Active:
+		content = "The session owner turned on announcement mode: only they can post."
+	case announcement.Speaker:
+		content = "The session owner gave you voice: you can post in announcement mode."
+	default:
+		content = "The session owner took your voice: only they can post."
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: content})
+}
+
+// silenced reports whether announcement mode keeps us from posting.
+func (m *Model) silenced() bool {
+	return m.announcement.Active && !m.announcement.Speaker
+}
+
+// posts reports whether the input text would post something to the peer.
+// Commands that only answer or look, like /vote, are left alone.
+func posts(text string) bool {
+	if !strings.HasPrefix(text, "/") {
+		return true
+	}
+	for _, command := range []string{"/send ", "/cat ", "/upload ", "/poll "} {
+		if strings.HasPrefix(text, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// announcementStatus is the header's note of announcement mode.
+func (m *Model) announcementStatus() string {
+	switch {
+	case m.announcing:
+		return "Announcement mode"
+	case m.silenced():
+		return "Announcements only"
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package ui
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/api"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/bjarneo/jot/internal/trust"
+)
+
+// apiTimeout is how long a script waits for the program to answer.
+const apiTimeout = 5 * time.Second
+
+// APIRequestMsg carries a request from a script on the client API socket.
+// Reply has room for the one answer, so answering never blocks.
+type APIRequestMsg struct {
+	Request api.Request
+	Reply   chan<- api.Response
+}
+
+// serveAPI answers the requests on server from within p, so they see and
+// change the same state as the keyboard does.
+func serveAPI(server *api.Server, p *tea.Program) {
+	server.Serve(func(req api.Request) api.Response {
+		reply := make(chan api.Response, 1)
+		p.Send(APIRequestMsg{Request: req, Reply: reply})
+		select {
+		case response := <-reply:
+			return response
+		case <-time.After(apiTimeout):
+			return api.Failed(errors.New("the client did not answer"))
+		}
+	})
+}
+
+// answerSetup answers a request made while still at the setup prompts.
+func answerSetup(config Config, req api.Request) api.Response {
+	switch req.Method {
+	case api.MethodStatus:
+		return api.Response{OK: true, Status: &api.Status{State: "setup", Status: "Choosing a session", Relay: config.RelayServerAddr}}
+	case api.MethodParticipants:
+		return api.Response{OK: true}
+	case api.MethodSend:
+		return api.Failed(errors.New("not in a session yet"))
+	}
+	return api.Failed(fmt.Errorf("unknown method %q", req.Method))
+}
+
+// answerAPI answers a request from a script.
+func (m *Model) answerAPI(req api.Request) (api.Response, tea.Cmd) {
+	switch req.Method {
+	case api.MethodStatus:
+		return api.Response{OK: true, Status: m.apiStatus()}, nil
+	case api.MethodParticipants:
+		return api.Response{OK: true, Participants: m.participants()}, nil
+	case api.MethodSend:
+		switch {
+		case req.Text == "":
+			return api.Failed(errors.New("nothing to send")), nil
+		case len(req.Text) > protocol.MaxText:
+			return api.Failed(fmt.Errorf("messages may be at most %d KB", protocol.MaxText/1024)), nil
+		case !m.IsReady || m.Keys == nil:
+			return api.Failed(errors.New("your peer has not joined yet")), nil
+		case !m.IsConnected:
+			return api.Failed(errors.New("the session is disconnected")), nil
+		case m.keyChanged:
+			return api.Failed(errors.New("the peer's identity key changed; accept it with /accept-key first")), nil
+		case m.observing:
+			return api.Failed(errors.New("observers cannot post")), nil
+		case m.silenced():
+			return api.Failed(errors.New("only the session owner can post in announcement mode")), nil
+		}
+		return api.Response{OK: true}, m.sendText(req.Text)
+	}
+	return api.Failed(fmt.Errorf("unknown method %q", req.Method)), nil
+}
+
+// apiStatus describes the session for the API.
+func (m *Model) apiStatus() *api.Status {
+	status := &api.Status{Status: m.Status, Relay: m.RelayServerAddr, SessionID: m.SessionID, Label: m.Label, Recording: m.Recording != nil || m.PeerRecording, Unknown: m.unknownMessages}
+	switch {
+	case m.IsReady && !m.IsConnected:
+		status.State = "disconnected"
+	case m.IsReady:
+		status.State = "chatting"
+		status.Peer = m.peerName()
+	case m.IsConnected:
+		status.State = "waiting"
+	default:
+		status.State = "connecting"
+	}
+	return status
+}
+
+// participants lists us and, once joined, the peer.
+func (m *Model) participants() []api.Participant {
+	owner := m.Command == "CREATE"
+	list := []api.Participant{{Nickname: m.Nickname, You: true, Owner: owner, Observer: m.observing, Fingerprint: crypto.Fingerprint(m.Identity.Public().(ed25519.PublicKey))}}
+	if m.IsReady {
+		list = append(list, api.Participant{Nickname: m.peerName(), Owner: !owner, Observer: m.peerObserver, Fingerprint: m.PeerIdentityFingerprint, Verified: m.PeerTrust == trust.Verified})
+	}
+	return list
+}
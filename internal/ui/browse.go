@@ -0,0 +1,100 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// RoomsMsg carries the relay's public directory, or why it could not be read.
+type RoomsMsg struct {
+	Rooms []network.Room
+	Err   error
+}
+
+// browser lets the user pick a room from the relay's public directory
+// instead of typing a session ID.
+type browser struct {
+	rooms   []network.Room
+	cursor  int
+	loading bool
+	err     error
+}
+
+// load asks the relay for its directory.
+func (b *browser) load(opts network.DialOptions) tea.Cmd {
+	b.loading, b.err = true, nil
+	return func() tea.Msg {
+		rooms, err := network.ListRooms(opts)
+		return RoomsMsg{Rooms: rooms, Err: err}
+	}
+}
+
+// loaded shows the directory msg carries, keeping the cursor on the same room
+// if it is still listed.
+func (b *browser) loaded(msg RoomsMsg) {
+	b.loading, b.err = false, msg.Err
+	if msg.Err != nil {
+		return
+	}
+	var selected string
+	if room, ok := b.selected(); ok {
+		selected = room.SessionID
+	}
+	b.rooms, b.cursor = msg.Rooms, 0
+	for i, room := range b.rooms {
+		if room.SessionID == selected {
+			b.cursor = i
+		}
+	}
+}
+
+// move moves the cursor by delta rooms, stopping at either end.
+func (b *browser) move(delta int) {
+	b.cursor = max(0, min(len(b.rooms)-1, b.cursor+delta))
+}
+
+// selected returns the room under the cursor.
+func (b *browser) selected() (network.Room, bool) {
+	if b.cursor < 0 || b.cursor >= len(b.rooms) {
+		return network.Room{}, false
+	}
+	return b.rooms[b.cursor], true
+}
+
+// roomFull reports whether room already has both of its participants.
+func roomFull(room network.Room) bool {
+	return room.Participants >= 2
+}
+
+func (b *browser) View(relay string) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Rooms listed on %s:\n\n", relay)
+	switch {
+	case b.loading && len(b.rooms) == 0:
+		s.WriteString("Loading...\n")
+	case b.err != nil:
+		s.WriteString(ErrorStyle.Render(fmt.Sprintf("Could not read the directory: %v", b.err)) + "\n")
+	case len(b.rooms) == 0:
+		s.WriteString("No rooms are listed on this relay.\n")
+	}
+	for i, room := range b.rooms {
+		line := fmt.Sprintf("%s (%d/2)", room.Name, room.Participants)
+		if roomFull(room) {
+			line = fmt.Sprintf("%s (full)", room.Name)
+		}
+		if room.Topic != "" {
+			line += TimestampStyle.Render(" - " + room.Topic)
+		}
+		if i == b.cursor {
+			s.WriteString(SenderStyle.Render("> ") + line + "\n")
+		} else {
+			s.WriteString("  " + line + "\n")
+		}
+	}
+	s.WriteString("\n(↑/↓ to choose, enter to join, r to refresh, j to type a session ID, esc to quit)")
+	return s.String()
+}
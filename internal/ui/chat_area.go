@@ -2,15 +2,20 @@ package ui
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath" // Added for filepath.Glob
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/trust"
 )
 
 // SubmitInputMsg is a tea.Msg that signals text was submitted from the textarea.
@@ -32,6 +37,15 @@ type ChatAreaModel struct {
 	messageRenderer *lipgloss.Renderer
 	// Nickname for the "You: " prompt, could be configurable
 	userNickname string
+	// secretPrompt, when set, replaces the prompt and hides what is typed
+	secretPrompt string
+	// relativeTimes shows "2m ago" style times and separators between days
+	relativeTimes bool
+	// scrolledBack stops new messages from scrolling the view to the end,
+	// while the user reads older ones
+	scrolledBack bool
+	// search highlights the matches for /search; nil when not searching
+	search *search
 }
 
 // Message struct for displaying messages, consistent with how renderMessages expects it.
@@ -40,6 +54,12 @@ type Message struct {
 	Timestamp time.Time
 	Sender    string
 	Content   string
+	Trust     trust.Status           // Trust status of the peer when the message was received
+	Signature crypto.SignatureStatus // Signature status of a received message
+	Delivery  Delivery               // How far our own text got; DeliveryNone for everything else
+
+	sentID int    // Numbers our own texts, see Model.sendText
+	seq    uint64 // Position among the texts the peer received from us, once sent
 }
 
 // NewChatAreaModel creates a new UI model for the chat area.
@@ -54,11 +74,14 @@ func NewChatAreaModel(initialWidth, initialHeight int, userNickname string) Chat
 	ta.SetHeight(1)           // Starts as single line, expands automatically
 
 	// Define styles for the textarea prompt and text
-	promptStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	promptStyle := lipgloss.NewStyle().Foreground(theme.Accent)
 
 	ta.FocusedStyle.Prompt = promptStyle // Assign the style object
 	ta.BlurredStyle.Prompt = promptStyle // Assign the style object (can be different if desired)
 	ta.ShowLineNumbers = false
+	// SetWidth leaves room for the prompt's display width, so it is set here
+	// rather than while rendering.
+	ta.Prompt = userNickname + ": "
 
 	vp := viewport.New(initialWidth, initialHeight-3) // Initial guess for viewport height
 
@@ -84,13 +107,25 @@ func (m ChatAreaModel) Init() tea.Cmd {
 func (m ChatAreaModel) Update(msg tea.Msg) (ChatAreaModel, tea.Cmd) {
 	var (
 		tiCmd tea.Cmd
-		vpCmd tea.Cmd
 		cmds  []tea.Cmd
 	)
 
+	// Paging keys scroll the messages and never reach the textarea, where Home
+	// and End would move the cursor. Other keys are for the textarea alone, so
+	// the viewport does not get them.
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.Type {
+		case tea.KeyPgUp, tea.KeyPgDown, tea.KeyHome, tea.KeyEnd:
+			m.scroll(msg.Type)
+			return m, nil
+		}
+		if m.dropKey(msg) {
+			return m, nil
+		}
+	}
+
 	m.textarea, tiCmd = m.textarea.Update(msg)
-	m.viewport, vpCmd = m.viewport.Update(msg)
-	cmds = append(cmds, tiCmd, vpCmd)
+	cmds = append(cmds, tiCmd)
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -100,13 +135,14 @@ func (m ChatAreaModel) Update(msg tea.Msg) (ChatAreaModel, tea.Cmd) {
 			inputValue := strings.TrimSpace(m.textarea.Value())
 			if inputValue != "" {
 				m.textarea.Reset()
+				m.scrolledBack = false
 				// Return a command to the main model indicating input was submitted
 				return m, func() tea.Msg { return SubmitInputMsg{Content: inputValue} }
 			}
 		case tea.KeyTab:
 			currentText := m.textarea.Value()
-			if strings.HasPrefix(currentText, "/send ") {
-				partialPath := strings.TrimPrefix(currentText, "/send ")
+			if command := pathCommand(currentText); command != "" {
+				partialPath := strings.TrimPrefix(currentText, command)
 
 				// If tilde for home directory is used, expand it
 				if strings.HasPrefix(partialPath, "~") {
@@ -125,13 +161,13 @@ func (m ChatAreaModel) Update(msg tea.Msg) (ChatAreaModel, tea.Cmd) {
 				if err == nil && len(matches) > 0 {
 					if len(matches) == 1 {
 						// Single match, complete it
-						m.textarea.SetValue("/send " + matches[0])
+						m.textarea.SetValue(command + matches[0])
 						m.textarea.CursorEnd() // Move cursor to end
 					} else {
 						// Multiple matches, find common prefix
 						prefix := commonPrefix(matches)
 						if prefix != "" && len(prefix) > len(partialPath) {
-							m.textarea.SetValue("/send " + prefix)
+							m.textarea.SetValue(command + prefix)
 							m.textarea.CursorEnd()
 						}
 					}
@@ -148,6 +184,71 @@ func (m ChatAreaModel) Update(msg tea.Msg) (ChatAreaModel, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// Draft returns what has been typed into the input so far.
+func (m *ChatAreaModel) Draft() string {
+	return m.textarea.Value()
+}
+
+// SetDraft replaces what has been typed into the input.
+func (m *ChatAreaModel) SetDraft(text string) {
+	m.textarea.SetValue(text)
+	m.textarea.CursorEnd()
+}
+
+// scroll pages through the messages. Reaching the end again resumes following
+// new messages.
+func (m *ChatAreaModel) scroll(key tea.KeyType) {
+	switch key {
+	case tea.KeyPgUp:
+		m.viewport.PageUp()
+	case tea.KeyPgDown:
+		m.viewport.PageDown()
+	case tea.KeyHome:
+		m.viewport.GotoTop()
+	case tea.KeyEnd:
+		m.viewport.GotoBottom()
+	}
+	m.scrolledBack = !m.viewport.AtBottom()
+}
+
+// topBorder draws the top border of the message box, with how far through the
+// messages the view is, e.g. "57%", when they do not all fit.
+func (m *ChatAreaModel) topBorder() string {
+	b := lipgloss.NormalBorder()
+	var label string
+	if m.viewport.TotalLineCount() > m.viewport.Height {
+		label = fmt.Sprintf(" %d%% ", int(math.Round(m.viewport.ScrollPercent()*100)))
+	}
+	fill := m.width - lipgloss.Width(b.TopLeft+label+b.Top+b.TopRight)
+	if fill < 0 {
+		label, fill = "", max(m.width-lipgloss.Width(b.TopLeft+b.Top+b.TopRight), 0)
+	}
+	return b.TopLeft + strings.Repeat(b.Top, fill) + TimestampStyle.Render(label) + b.Top + b.TopRight
+}
+
+// SetRelativeTimes switches between relative times with day separators and
+// absolute HH:MM times.
+func (m *ChatAreaModel) SetRelativeTimes(relative bool) {
+	m.relativeTimes = relative
+}
+
+// SetSecretPrompt masks the input behind prompt, e.g. while a passphrase is entered.
+// An empty prompt restores normal input.
+func (m *ChatAreaModel) SetSecretPrompt(prompt string) {
+	m.secretPrompt = prompt
+}
+
+// pathCommand returns the command prefix (including the trailing space) if text
+// is a command that takes a file path, or an empty string otherwise.
+func pathCommand(text string) string {
+	for _, command := range []string{"/send ", "/cat "} {
+		if strings.HasPrefix(text, command) {
+			return command
+		}
+	}
+	return ""
+}
+
 // commonPrefix finds the longest common prefix among a list of strings.
 func commonPrefix(strs []string) string {
 	if len(strs) == 0 {
@@ -162,12 +263,24 @@ func commonPrefix(strs []string) string {
 			if len(prefix) == 0 {
 				return ""
 			}
-			prefix = prefix[:len(prefix)-1]
+			// Drop a whole rune, so multi-byte file names are never cut in half.
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
 		}
 	}
 	return prefix
 }
 
+// chatBoxFrameWidth is the horizontal space the borders and padding of the
+// message and input boxes take up.
+var chatBoxFrameWidth = lipgloss.NewStyle().Border(lipgloss.NormalBorder(), true).PaddingLeft(1).PaddingRight(1).GetHorizontalFrameSize()
+
+// contentWidth is the display width, in terminal cells, available for text
+// inside the message and input boxes.
+func (m *ChatAreaModel) contentWidth() int {
+	return max(m.width-chatBoxFrameWidth, 1)
+}
+
 // SetDimensions updates the internal width and height, and resizes components.
 // This should be called by the main model when it processes tea.WindowSizeMsg.
 // The height passed here is the total height allocated for the chat area (viewport + input).
@@ -205,9 +318,11 @@ func (m *ChatAreaModel) SetDimensions(width, totalAllocatedHeight int) {
 		vpHeight = 0
 	}
 
-	m.viewport.Width = m.width // Viewport uses full component width before its own padding/borders
+	// Both fit inside their boxes' borders and padding, so lipgloss never has
+	// to wrap their lines a second time.
+	m.viewport.Width = m.contentWidth()
 	m.viewport.Height = vpHeight
-	m.textarea.SetWidth(m.width) // Textarea uses full component width before its container's padding/borders
+	m.textarea.SetWidth(m.contentWidth())
 
 	// Styles (viewportStyle, inputStyle) are dynamically sized in View()
 	// So, no need to set their width/height here directly, but m.width/m.height (overall)
@@ -220,18 +335,22 @@ func (m *ChatAreaModel) View(messagesToDisplay []Message) string {
 	// Update viewport content
 	renderedMsgs := m.renderMessages(messagesToDisplay)
 	m.viewport.SetContent(renderedMsgs)
-	// Scroll to bottom if content changed, or if explicitly told to.
-	// Main model can manage scroll state or this component can always goto bottom.
-	// For simplicity here, let's assume main model handles when to scroll or we always scroll.
-	m.viewport.GotoBottom()
+	// Follow new messages, unless the user paged back to read older ones.
+	if !m.scrolledBack {
+		m.viewport.GotoBottom()
+	}
+	m.reveal()
 
 	// --- Define styles dynamically based on current dimensions ---
-	// Viewport style: Border on top, left, right. No bottom border as input box provides it.
+	// Viewport style: Border on left and right. The top border, with the scroll
+	// position, is drawn by topBorder, and the input box provides the bottom one.
 	// Padding is applied to the content area of the viewport.
+	// lipgloss widths include padding but not borders, so the boxes take up
+	// exactly m.width.
 	currentViewportStyle := lipgloss.NewStyle().
-		Width(m.width).                                           // Outer width for the viewport's styled box
-		Height(m.viewport.Height).                                // Calculated height for the viewport's styled box
-		Border(lipgloss.NormalBorder(), true, true, false, true). // Top, Right, No Bottom, Left
+		Width(m.contentWidth()+2).                                 // Content plus padding
+		Height(m.viewport.Height).                                 // Calculated height for the viewport's styled box
+		Border(lipgloss.NormalBorder(), false, true, false, true). // Right and Left only
 		PaddingLeft(1).
 		PaddingRight(1)
 	m.viewportStyle = currentViewportStyle
@@ -265,17 +384,18 @@ func (m *ChatAreaModel) View(messagesToDisplay []Message) string {
 	}
 
 	m.inputStyle = baseInputStyle.Copy().
-		Width(m.width).
+		Width(m.contentWidth() + baseInputStyle.GetHorizontalPadding()).
 		Height(finalInputBoxHeight) // Use the height determined by SetDimensions' allocation
 
-	// Update textarea prompt dynamically
-	m.textarea.Prompt = m.userNickname + ": "
-	// The styles for the prompt (FocusedStyle.Prompt, BlurredStyle.Prompt) were set in NewChatAreaModel.
-	// The textarea component will use those styles when rendering its prompt.
+	// The prompt and its styles (FocusedStyle.Prompt, BlurredStyle.Prompt) were set in NewChatAreaModel.
 	textareaViewString := m.textarea.View()
+	if m.secretPrompt != "" {
+		textareaViewString = m.textarea.FocusedStyle.Prompt.Render(m.secretPrompt) + strings.Repeat("•", utf8.RuneCountInString(m.textarea.Value()))
+	}
 
 	// Combine viewport and input box
 	return lipgloss.JoinVertical(lipgloss.Left,
+		m.topBorder(),
 		m.viewportStyle.Render(m.viewport.View()),
 		m.inputStyle.Render(textareaViewString),
 	)
@@ -286,42 +406,71 @@ func (m *ChatAreaModel) View(messagesToDisplay []Message) string {
 func (m *ChatAreaModel) renderMessages(messagesToDisplay []Message) string {
 	var renderedOutputLines []string
 
-	localTimestampStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Faint(true)
+	localTimestampStyle := TimestampStyle
 	// Using m.userNickname to differentiate styling for user's own messages vs peer's.
 	// System/Error senders will be handled specially.
 
-	viewportInternalContentWidth := m.width - m.viewportStyle.GetHorizontalBorderSize() - m.viewportStyle.GetHorizontalPadding()
-	if viewportInternalContentWidth < 1 {
-		viewportInternalContentWidth = 1
+	// Widths are display widths from lipgloss, so wide CJK characters and
+	// emoji count as two cells and wrapped lines stay aligned.
+	viewportInternalContentWidth := m.contentWidth()
+
+	if m.search != nil {
+		m.search.matches = m.search.matches[:0]
 	}
 
-	for _, msg := range messagesToDisplay {
-		timestampStr := localTimestampStyle.Render(msg.Timestamp.Format("15:04"))
+	now := time.Now()
+	for i, msg := range messagesToDisplay {
+		var timestampStr string
+		if m.relativeTimes {
+			if i > 0 && !sameDay(messagesToDisplay[i-1].Timestamp, msg.Timestamp) {
+				renderedOutputLines = append(renderedOutputLines, daySeparator(msg.Timestamp, viewportInternalContentWidth))
+			}
+			timestampStr = localTimestampStyle.Render(relativeTime(msg.Timestamp, now))
+		} else {
+			timestampStr = localTimestampStyle.Render(msg.Timestamp.Format("15:04"))
+		}
 
 		var senderStr string
 		var prefix string
 		var finalContent string
+		firstLine := len(renderedOutputLines)
 
 		if msg.Sender == "System" || msg.Sender == "Error" {
 			isError := msg.Sender == "Error"
 			systemOrErrorStyle := lipgloss.NewStyle().Italic(true)
 			if isError {
-				systemOrErrorStyle = systemOrErrorStyle.Foreground(lipgloss.Color("196")) // Error color from styles.go
+				systemOrErrorStyle = systemOrErrorStyle.Foreground(theme.Error)
 			} else {
-				systemOrErrorStyle = systemOrErrorStyle.Foreground(lipgloss.Color("244")) // System color from styles.go
+				systemOrErrorStyle = systemOrErrorStyle.Foreground(theme.System)
 			}
 			// For system/error, content is directly styled. Prefix is just timestamp.
 			// Content is assumed to be raw and will be wrapped.
 			prefix = fmt.Sprintf("%s --- ", timestampStr) // System messages might not need <Sender>
 			finalContent = systemOrErrorStyle.Render(msg.Content)
 		} else if msg.Sender == m.userNickname {
-			senderStr = lipgloss.NewStyle().Foreground(lipgloss.Color("39")).Render("<" + msg.Sender + ">") // User's sender color (SenderStyle)
+			senderStr = SenderStyle.Render("<" + msg.Sender + ">")
 			prefix = fmt.Sprintf("%s %s ", timestampStr, senderStr)
-			finalContent = msg.Content // Raw content for user's own messages
+			finalContent = m.highlight(msg.Content, firstLine) // Raw content for user's own messages
+			if glyph := msg.Delivery.glyph(); glyph != "" {
+				finalContent += " " + glyph
+			}
 		} else { // Peer's message
-			senderStr = lipgloss.NewStyle().Foreground(lipgloss.Color("220")).Render("<" + msg.Sender + ">") // Peer's sender color (ReceiverStyle)
+			senderLabel := msg.Sender
+			switch msg.Trust {
+			case trust.Verified:
+				senderLabel += " ✓"
+			case trust.Changed:
+				senderLabel += " " + ErrorStyle.Render("⚠")
+			}
+			senderStr = ReceiverStyle.Render("<" + senderLabel + ">")
 			prefix = fmt.Sprintf("%s %s ", timestampStr, senderStr)
-			finalContent = msg.Content // Raw content for peer messages
+			finalContent = m.highlight(msg.Content, firstLine) // Raw content for peer messages
+			switch msg.Signature {
+			case crypto.SignatureMissing:
+				finalContent = ErrorStyle.Render("[unsigned] ") + finalContent
+			case crypto.SignatureInvalid:
+				finalContent = ErrorStyle.Bold(true).Render("[INVALID SIGNATURE] ") + finalContent
+			}
 		}
 
 		prefixLen := lipgloss.Width(prefix)
@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"io"
+	"time"
+
+	"github.com/bjarneo/jot/internal/api"
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/notify"
+)
+
+// Config holds the client options chosen on the command line.
+type Config struct {
+	RelayServerAddr string
+	Via             string                // Relay that forwards our connection to RelayServerAddr; empty connects directly
+	ClientCert      *tls.Certificate      // Presented to relays that require client certificates; nil presents none
+	PinnedCert      []byte                // Hash the relay's certificate or public key must have; nil checks it against the system's CAs
+	Connect         network.ConnectPolicy // Timeouts and retries for reaching the relay
+	WaitingRoom     bool                  // When creating a session, hold joiners until we admit them
+	Observe         bool                  // When joining, ask to come in as an observer who posts nothing
+	Label           string                // When creating a session, a name for it shared with the peer
+	Browse          bool                  // Start by picking a room from the relay's public directory
+	MaxFileSize     int                   // Maximum size of a file we send or accept, in MB
+	Cipher          crypto.Cipher         // AEAD used for outgoing messages
+	AskPassword     bool                  // Ask for a session password after the nickname, to prove to the peer with SPAKE2
+	Password        []byte                // The session password typed at that prompt; nil uses none
+	Compress        bool                  // Compress long outgoing texts for peers that support it
+
+	RelativeTimestamps bool // Show "2m ago" style times and day separators instead of HH:MM
+
+	MaxIncomingOffers      int // Offers pending or in progress before new ones are rejected; 0 disables
+	MaxUnverifiedMBPerHour int // Data accepted per hour from unverified peers; 0 disables
+	MaxMessageRate         int // Messages from the peer shown per 10 seconds before the rest are held back; 0 disables
+
+	RekeyMessages int           // Texts sent and received after which the session keys are replaced; 0 disables
+	RekeyInterval time.Duration // How long the session keys are used before they are replaced; 0 disables
+
+	// PostReceiveCommand runs on every completed download with the file path
+	// appended, e.g. "clamscan --no-summary". A non-zero exit quarantines the file.
+	PostReceiveCommand string
+
+	// OnMessageCommand runs for every received chat message, with the text on
+	// stdin and the sender and session in JOT_SENDER and JOT_SESSION.
+	OnMessageCommand string
+
+	// Notifier, if set, receives a push notification for each message or file
+	// offer that arrives while Detached reports that nobody is watching.
+	Notifier *notify.Notifier
+	Detached func() bool
+
+	// Alerts says how to draw attention to each event, by config.Event name.
+	Alerts map[string]config.Alert
+	// Terminal receives the bell for alerts; nil uses standard output.
+	Terminal io.Writer
+
+	// Identity is the signing key used for the session; nil generates a fresh one.
+	Identity ed25519.PrivateKey
+	// TrustStorePath overrides the location of the trust store.
+	TrustStorePath string
+	// PinsPath overrides the location of the pinned identity keys.
+	PinsPath string
+	// ContactsPath overrides the location of the contacts roster.
+	ContactsPath string
+	// Nickname is offered as the default in the nickname prompt.
+	Nickname string
+
+	// OpenCommand opens received files for /open; empty uses the platform default.
+	OpenCommand string
+
+	// API, if set, answers scripts that ask about or send to the session.
+	API *api.Server
+}
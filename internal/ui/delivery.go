@@ -0,0 +1,109 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// Delivery is how far one of our own texts got on its way to the peer.
+type Delivery int
+
+const (
+	DeliveryNone      Delivery = iota // Not one of our texts
+	DeliveryPending                   // Not yet handed to the relay
+	DeliveryRelayed                   // Sent to the relay; peers that do not acknowledge texts stay here
+	DeliveryDelivered                 // The peer acknowledged it
+	DeliveryFailed                    // Sending it failed
+)
+
+// glyph is the mark shown after a text in this state.
+func (d Delivery) glyph() string {
+	switch d {
+	case DeliveryPending:
+		return TimestampStyle.Render("⋯")
+	case DeliveryRelayed:
+		return TimestampStyle.Render("→")
+	case DeliveryDelivered:
+		return TimestampStyle.Render("✓")
+	case DeliveryFailed:
+		return ErrorStyle.Render("✗")
+	}
+	return ""
+}
+
+// TextSentMsg reports how sending our text numbered ID went. Seq is its
+// position among the texts the peer received from us, which its acks count.
+type TextSentMsg struct {
+	ID  int
+	Seq uint64
+	Err error
+}
+
+// AckMsg carries an acknowledgement from the peer.
+type AckMsg struct{ Ack protocol.Ack }
+
+// sendTracked sends text, to be shown as our message numbered id, and reports
+// how that went. Texts are numbered in the order they are written, so the
+// peer's acks refer to the same numbers.
+func (m *Model) sendTracked(id int, text string) tea.Cmd {
+	return func() tea.Msg {
+		msgType, payload := m.textPayload(text)
+		m.sendMu.Lock()
+		defer m.sendMu.Unlock()
+		if err := network.SendData(m.Conn, m.Keys, msgType, payload); err != nil {
+			return TextSentMsg{ID: id, Err: err}
+		}
+		m.textsSent++
+		return TextSentMsg{ID: id, Seq: m.textsSent}
+	}
+}
+
+// textSent records the outcome of sending one of our texts.
+func (m *Model) textSent(msg TextSentMsg) {
+	for i := len(m.Messages) - 1; i >= 0; i-- {
+		if m.Messages[i].sentID != msg.ID {
+			continue
+		}
+		switch {
+		case msg.Err != nil:
+			m.Messages[i].Delivery = DeliveryFailed
+		case msg.Seq <= m.textsAcked:
+			m.Messages[i].Delivery = DeliveryDelivered
+		default:
+			m.Messages[i].Delivery = DeliveryRelayed
+		}
+		m.Messages[i].seq = msg.Seq
+		return
+	}
+}
+
+// acknowledged marks our texts the peer says it received as delivered.
+func (m *Model) acknowledged(ack protocol.Ack) {
+	if ack.Received <= m.textsAcked {
+		return
+	}
+	m.textsAcked = ack.Received
+	for i := range m.Messages {
+		if m.Messages[i].Delivery == DeliveryRelayed && m.Messages[i].seq <= m.textsAcked {
+			m.Messages[i].Delivery = DeliveryDelivered
+		}
+	}
+}
+
+// ackText counts a text received from the peer and acknowledges it, if the
+// peer asked for acks.
+func (m *Model) ackText() tea.Cmd {
+	m.textsReceived++
+	if !m.peerAcks {
+		return nil
+	}
+	ack := protocol.Ack{Received: m.textsReceived}
+	return func() tea.Msg {
+		if err := network.SendJSON(m.Conn, m.Keys, protocol.TypeAck, ack); err != nil {
+			return ErrorMsg{Err: err}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// PublishMsg reports the outcome of /publish or /unpublish.
+type PublishMsg struct {
+	Listing *network.Listing // nil for /unpublish
+	Err     error
+}
+
+// publish lists the session in the relay's public directory, so anyone can
+// find and join it. args is "<name> [| topic]"; empty args takes the listing
+// off, as /unpublish does.
+func (m *Model) publish(args string) tea.Cmd {
+	now := time.Now()
+	switch {
+	case m.ownerKey == "":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Only the participant who created the session can list it."})
+		return nil
+	case m.Conn == nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Not connected to the relay yet."})
+		return nil
+	}
+
+	var listing *network.Listing
+	if args != "" {
+		name, topic, _ := strings.Cut(args, "|")
+		listing = &network.Listing{Name: strings.TrimSpace(name), Topic: strings.TrimSpace(topic)}
+		switch {
+		case listing.Name == "":
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Usage: /publish <name> [| topic], e.g. /publish Go help | Ask anything about Go"})
+			return nil
+		case len(listing.Name) > network.MaxRoomName:
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("The name may be at most %d bytes long.", network.MaxRoomName)})
+			return nil
+		case len(listing.Topic) > network.MaxRoomTopic:
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("The topic may be at most %d bytes long.", network.MaxRoomTopic)})
+			return nil
+		}
+	}
+
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		return PublishMsg{Listing: listing, Err: network.Publish(opts, sessionID, listing)}
+	}
+}
+
+// published reports the outcome of /publish or /unpublish.
+func (m *Model) published(msg PublishMsg) {
+	now := time.Now()
+	switch {
+	case msg.Err != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not update the directory listing: %v", msg.Err)})
+	case msg.Listing == nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "The session is no longer listed in the relay's directory."})
+	default:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("The session is listed in the relay's directory as %q. Anyone who finds it there can join, so consider /lock or a waiting room.", msg.Listing.Name)})
+	}
+}
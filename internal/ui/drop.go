@@ -0,0 +1,129 @@
+package ui
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Dragging a file from a file manager onto the terminal pastes its path,
+// quoted or escaped the way the terminal thinks a shell wants it. A paste into
+// an empty input, or right after "/send " or "/cat ", that is nothing but the
+// path of an existing file becomes the command to share it.
+
+// dropKey turns a pasted file path into "/send <path>", or completes the
+// command already typed, and reports whether it did.
+func (m *ChatAreaModel) dropKey(key tea.KeyMsg) bool {
+	if !key.Paste || m.secretPrompt != "" {
+		return false
+	}
+	command := "/send "
+	if value := m.textarea.Value(); value != "" {
+		if command = pathCommand(value); command == "" || value != command {
+			return false
+		}
+	}
+	path, ok := droppedPath(string(key.Runes))
+	if !ok {
+		return false
+	}
+	m.textarea.SetValue(command + path)
+	m.textarea.CursorEnd()
+	return true
+}
+
+// droppedPath returns the file named by pasted, if that is a single path to a
+// regular file. Some terminals paste paths as they are, spaces and all, so the
+// text is tried as it is if it does not unquote to a file.
+func droppedPath(pasted string) (string, bool) {
+	pasted = strings.TrimSpace(pasted)
+	if pasted == "" || strings.ContainsAny(pasted, "\r\n") {
+		return "", false
+	}
+	candidates := []string{pasted}
+	if path, ok := unquotePath(pasted); ok && path != pasted {
+		candidates = []string{path, pasted}
+	}
+	for _, path := range candidates {
+		if path, ok := localPath(path); ok {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// localPath turns a file:// URL into a path and expands a leading ~.
+func localPath(path string) (string, bool) {
+	if strings.HasPrefix(path, "file://") {
+		u, err := url.Parse(path)
+		if err != nil || (u.Host != "" && u.Host != "localhost") {
+			return "", false
+		}
+		path = filepath.FromSlash(u.Path)
+		if runtime.GOOS == "windows" {
+			path = strings.TrimPrefix(path, `\`) // file:///C:/x is \C:\x
+		}
+	}
+	if strings.HasPrefix(path, "~") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(homeDir, path[1:])
+		}
+	}
+	return path, true
+}
+
+// unquotePath undoes the quoting terminals use for dropped paths, and fails if
+// s holds more than one word. Backslashes separate directories on Windows, so
+// there only surrounding double quotes are taken off.
+func unquotePath(s string) (string, bool) {
+	if runtime.GOOS == "windows" {
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			s = s[1 : len(s)-1]
+		}
+		return s, !strings.Contains(s, `"`)
+	}
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			// Within double quotes, a backslash only escapes what the shell treats specially.
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ' ' || r == '\t':
+			return "", false // Several files were dropped
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quote != 0 || escaped {
+		return "", false
+	}
+	return b.String(), true
+}
@@ -0,0 +1,122 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/filetransfer"
+)
+
+// editorDoneMsg reports that the editor opened by editDraft exited.
+type editorDoneMsg struct {
+	path string // The draft file, removed once read
+	err  error
+}
+
+// editorKey opens the editor for Ctrl+X followed by Ctrl+E, as in bash, and
+// reports whether key was part of that chord.
+func (m *Model) editorKey(key tea.KeyMsg) (tea.Cmd, bool) {
+	if m.editorChord {
+		m.editorChord = false
+		if key.Type == tea.KeyCtrlE {
+			return m.editDraft(m.chatArea.Draft()), true
+		}
+		return nil, false
+	}
+	if key.Type == tea.KeyCtrlX && m.recordPrompt == recordPromptNone {
+		m.editorChord = true
+		return nil, true
+	}
+	return nil, false
+}
+
+// editDraft opens $VISUAL or $EDITOR on draft. Whatever is saved is sent as a
+// single message once the editor exits.
+func (m *Model) editDraft(draft string) tea.Cmd {
+	now := time.Now()
+	if m.Detached != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "The editor cannot be opened in a daemon, which has no terminal of its own to give it."})
+		return nil
+	}
+	fields := strings.Fields(editorCommand())
+	file, err := os.CreateTemp("", "jot-draft-*.md")
+	if err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not create the draft file: %v", err)})
+		return nil
+	}
+	_, err = file.WriteString(draft)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not write the draft file: %v", err)})
+		return nil
+	}
+
+	m.chatArea.SetDraft("")
+	path := file.Name()
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg { return editorDoneMsg{path: path, err: err} })
+}
+
+// editorCommand returns the user's editor, which may include arguments such
+// as "code --wait".
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
+// editorDone sends what was saved in the editor. If it cannot be sent, it
+// goes back into the input so nothing is lost.
+func (m *Model) editorDone(msg editorDoneMsg) tea.Cmd {
+	data, readErr := os.ReadFile(msg.path)
+	os.Remove(msg.path)
+	now := time.Now()
+	switch {
+	case msg.err != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("The editor (%s) failed: %v", editorCommand(), msg.err)})
+		return nil
+	case readErr != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not read the draft: %v", readErr)})
+		return nil
+	}
+
+	text := strings.TrimRight(string(data), " \t\r\n")
+	var problem error
+	switch {
+	case strings.TrimSpace(text) == "":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "The draft was empty, so nothing was sent."})
+		return nil
+	case len(text) > filetransfer.MaxSnippetSize:
+		problem = fmt.Errorf("the message is longer than %d KB", filetransfer.MaxSnippetSize/1024)
+	case !m.IsReady || m.Keys == nil:
+		problem = errors.New("your peer has not joined yet")
+	case m.keyChanged:
+		problem = errors.New("your peer's identity key changed; accept it with /accept-key first")
+	case m.observing:
+		problem = errors.New("observers cannot post")
+	case m.silenced():
+		problem = errors.New("only the session owner can post in announcement mode")
+	}
+	if problem != nil {
+		m.chatArea.SetDraft(text)
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Not sent: %v. The draft is back in the input.", problem)})
+		return nil
+	}
+	return m.sendText(text)
+}
@@ -0,0 +1,131 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A peer that sends more than MaxMessageRate messages within floodWindow is
+// flooding us, whether its client misbehaves or was taken over. Rather than
+// let it scroll everything else away, we hold its messages back and count
+// them on a single line until it slows down, and /show displays them. Held
+// messages are still acknowledged, but trigger no alerts, notifications or
+// -on-message hooks.
+//
+// Nor may a flood fill our memory: the held messages are capped across all
+// bursts, and Messages itself keeps only the latest maxHistory lines.
+const (
+	floodWindow     = 10 * time.Second
+	maxHeldMessages = 1000  // Kept across all bursts; older ones are dropped
+	maxHistory      = 10000 // Lines kept in Messages; past it, the oldest tenth is dropped
+)
+
+// flood is a burst of messages held back.
+type flood struct {
+	line  int       // Index in Messages of the line that counts them, or -1 once it scrolled away
+	count int       // Messages in the burst, including any dropped
+	held  []Message // The latest of them, within maxHeldMessages for all bursts
+}
+
+// throttled records a message from the peer arriving at now, and reports
+// whether it comes too fast to be shown.
+func (m *Model) throttled(now time.Time) bool {
+	if m.MaxMessageRate <= 0 {
+		return false
+	}
+	// The peer is flooding if the oldest of its last MaxMessageRate+1
+	// messages arrived within the window.
+	m.peerArrivals = append(m.peerArrivals, now)
+	if len(m.peerArrivals) > m.MaxMessageRate+1 {
+		m.peerArrivals = m.peerArrivals[1:]
+	}
+	if len(m.peerArrivals) > m.MaxMessageRate && now.Sub(m.peerArrivals[0]) < floodWindow {
+		return true
+	}
+	m.flooding = false
+	return false
+}
+
+// holdBack keeps msg for /show and counts it on the burst's line.
+func (m *Model) holdBack(msg Message) {
+	if !m.flooding {
+		m.flooding = true
+		m.Messages = append(m.Messages, Message{Timestamp: msg.Timestamp, Sender: "System"})
+		m.floods = append(m.floods, &flood{line: len(m.Messages) - 1})
+	}
+	f := m.floods[len(m.floods)-1]
+	f.count++
+	f.held = append(f.held, msg)
+	m.dropHeld()
+	m.setFloodLine(f, fmt.Sprintf("%s is sending more than %d messages per %s; %d held back so far. /show displays them.", m.peerName(), m.MaxMessageRate, floodWindow, f.count))
+}
+
+// dropHeld drops the oldest held messages past maxHeldMessages. A burst left
+// with none is forgotten, and its line says what became of them.
+func (m *Model) dropHeld() {
+	total := 0
+	for _, f := range m.floods {
+		total += len(f.held)
+	}
+	for ; total > maxHeldMessages; total-- {
+		oldest := m.floods[0]
+		oldest.held = oldest.held[1:]
+		if len(oldest.held) == 0 {
+			m.setFloodLine(oldest, fmt.Sprintf("%s sent %d messages too fast to show; they were dropped to make room for later ones.", m.peerName(), oldest.count))
+			m.floods = m.floods[1:]
+		}
+	}
+}
+
+// setFloodLine sets the content of the line that counts f, unless it has
+// scrolled away.
+func (m *Model) setFloodLine(f *flood, content string) {
+	if f.line >= 0 {
+		m.Messages[f.line].Content = content
+	}
+}
+
+// trimHistory drops the oldest lines of Messages once there are more than
+// maxHistory, other than those a recording has yet to save.
+func (m *Model) trimHistory() {
+	if len(m.Messages) <= maxHistory {
+		return
+	}
+	drop := len(m.Messages) - maxHistory + maxHistory/10
+	if m.Recording != nil {
+		drop = min(drop, m.Recording.firstMessage)
+	}
+	if drop <= 0 {
+		return
+	}
+	// A copy, so the dropped lines do not stay behind in the array.
+	m.Messages = slices.Clone(m.Messages[drop:])
+	if m.Recording != nil {
+		m.Recording.firstMessage -= drop
+	}
+	for _, f := range m.floods {
+		f.line = max(f.line-drop, -1)
+	}
+}
+
+// showHeld displays the messages held back so far.
+func (m *Model) showHeld() {
+	if len(m.floods) == 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "No messages are held back."})
+		return
+	}
+	for _, f := range m.floods {
+		note := fmt.Sprintf("%s sent too many messages at once; the %d held back are shown below.", m.peerName(), f.count)
+		if f.count > len(f.held) {
+			note = fmt.Sprintf("%s sent too many messages at once; of the %d held back, the latest %d are shown below.", m.peerName(), f.count, len(f.held))
+		}
+		if f.line >= 0 {
+			m.Messages[f.line].Content = note
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: note})
+		}
+		m.Messages = append(m.Messages, f.held...)
+	}
+	m.floods, m.flooding = nil, false
+}
@@ -0,0 +1,91 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// transferredMsg reports how the relay answered /transfer-owner.
+type transferredMsg struct{ err error }
+
+// transferOwnership hands the session to the peer for /transfer-owner
+// <nickname>. The relay checks that we own the session, and tells both
+// clients once the peer owns it.
+func (m *Model) transferOwnership(nickname string) tea.Cmd {
+	now := time.Now()
+	switch {
+	case m.ownerKey == "" || m.Command != "CREATE":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Only the session owner can hand the session over."})
+		return nil
+	case nickname == "":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Usage: /transfer-owner <nickname>"})
+		return nil
+	case !m.IsReady || m.rejoining:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "There is nobody to hand the session to until your peer is connected."})
+		return nil
+	case !strings.EqualFold(nickname, m.PeerNickname) && !strings.EqualFold(nickname, m.peerName()):
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Your peer is %s, not %s.", m.peerName(), nickname)})
+		return nil
+	case m.peerObserver:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s joined as an observer and cannot own the session.", m.peerName())})
+		return nil
+	case !m.peerHandoffs:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s's client cannot take over the session.", m.peerName())})
+		return nil
+	case m.Recording != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Stop recording with /record stop before handing the session over."})
+		return nil
+	}
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		return transferredMsg{err: network.TransferOwnership(opts, sessionID)}
+	}
+}
+
+// transferred reports the relay's answer to /transfer-owner.
+func (m *Model) transferred(msg transferredMsg) {
+	if msg.err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not hand the session over: %v", msg.err)})
+		return
+	}
+	m.handedOver()
+}
+
+// handedOver gives up ownership once the relay has handed the session to the
+// peer. It is called both for the relay's answer and for its notice, and acts
+// on whichever comes first. If announcement mode was on, we keep our voice
+// until the new owner says otherwise.
+func (m *Model) handedOver() {
+	if m.ownerKey == "" {
+		return
+	}
+	m.ownerKey, m.Command = "", "JOIN"
+	if m.control != nil {
+		m.control.Close()
+		m.control = nil
+	}
+	m.JoinRequests = nil
+	m.announcement = protocol.Announcement{Active: m.announcing, Speaker: m.announcing}
+	m.announcing, m.peerVoiced = false, false
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s owns the session now; the lock, invites and directory listing are theirs to manage.", m.peerName())})
+}
+
+// tookOver makes us the session owner after the peer handed it to us. The
+// relay accepts our notice key as the owner key from now on. Announcement
+// mode stays as the old owner left it, with the old owner keeping its voice.
+func (m *Model) tookOver() tea.Cmd {
+	if m.ownerKey != "" {
+		return nil
+	}
+	m.ownerKey, m.Command = m.noticeKey, "CREATE"
+	m.announcing, m.peerVoiced = m.announcement.Active, m.announcement.Active
+	m.announcement = protocol.Announcement{}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s handed the session to you. You can now /lock it, /invite, /publish it and run announcement mode.", m.peerName())})
+	return tea.Batch(m.openControl(), m.sendAnnouncement())
+}
@@ -0,0 +1,70 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// We ping the relay on our NOTICES connection every pingInterval. The pings
+// tell the relay we are still there while we have nothing to send, so it keeps
+// an idle session open, and it drops our connection soon after they stop. The
+// pongs measure the round trip, shown in the header. Relays that predate
+// heartbeats ignore the pings, and we show nothing.
+const (
+	pingInterval = 15 * time.Second
+	// relaySilentAfter is how long the relay may leave our pings unanswered
+	// before the header says so.
+	relaySilentAfter = 2 * pingInterval
+)
+
+// heartbeatTickMsg pings the relay. Ticks for a NOTICES connection we no
+// longer use are dropped.
+type heartbeatTickMsg struct{ notices *network.Control }
+
+// noticesClosedMsg reports that a NOTICES connection closed.
+type noticesClosedMsg struct{ notices *network.Control }
+
+// startHeartbeat starts pinging the relay on notices.
+func (m *Model) startHeartbeat(notices *network.Control) tea.Cmd {
+	m.notices, m.lastPong, m.relayRTT = notices, time.Time{}, 0
+	return m.pingRelay(time.Now())
+}
+
+// pingRelay sends the next ping and schedules the one after.
+func (m *Model) pingRelay(now time.Time) tea.Cmd {
+	notices := m.notices
+	m.pingSeq++
+	seq := m.pingSeq
+	m.pingSent = now
+	next := tea.Tick(pingInterval, func(time.Time) tea.Msg { return heartbeatTickMsg{notices: notices} })
+	return tea.Batch(next, func() tea.Msg {
+		// A failed ping means the connection is gone, which watchNotices reports.
+		notices.Ping(seq)
+		return nil
+	})
+}
+
+// pong records the relay's answer to ping seq. Answers to earlier pings are
+// late and would overstate the round trip.
+func (m *Model) pong(seq int64, now time.Time) {
+	if seq != m.pingSeq {
+		return
+	}
+	m.relayRTT = now.Sub(m.pingSent)
+	m.lastPong = now
+}
+
+// relayStatus is shown in the header once the relay answered a ping.
+func (m *Model) relayStatus(now time.Time) string {
+	if m.notices == nil || !m.IsConnected || m.lastPong.IsZero() {
+		return ""
+	}
+	if silent := now.Sub(m.lastPong); silent >= relaySilentAfter {
+		return fmt.Sprintf("relay silent for %ds", int(silent/time.Second))
+	}
+	return fmt.Sprintf("relay %d ms", max(m.relayRTT.Milliseconds(), 1))
+}
@@ -5,20 +5,23 @@ import (
 	"log"
 	"strings"
 
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/util"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/bjarneo/jot/internal/util"
 )
 
 type InitialModel struct {
-	program         *tea.Program
-	relayServerAddr string
-	maxFileSize     int
-	choice          string
-	sessionIDInput  textinput.Model
-	nicknameInput   textinput.Model
-	state           initialState
-	err             error
+	program        *tea.Program
+	config         Config
+	choice         string
+	sessionIDInput textinput.Model
+	nicknameInput  textinput.Model
+	passwordInput  textinput.Model
+	browser        browser
+	state          initialState
+	window         tea.WindowSizeMsg // The terminal's size, handed on to the chat
+	err            error
 }
 
 type initialState int
@@ -27,29 +30,128 @@ const (
 	chooseCreateOrJoin initialState = iota
 	enterSessionID
 	enterNickname
+	enterPassword
+	browseRooms
 )
 
-func NewInitialModel(relayServerAddr string, maxFileSize int) *InitialModel {
+func NewInitialModel(config Config) *InitialModel {
 	sessionIDInput := textinput.New()
 	// Placeholder will be set dynamically based on choice
 	nicknameInput := textinput.New()
 	nicknameInput.Placeholder = "Your Nickname"
+	passwordInput := textinput.New()
+	passwordInput.Placeholder = "Session Password"
+	passwordInput.EchoMode = textinput.EchoPassword
 
 	m := &InitialModel{
-		relayServerAddr: relayServerAddr,
-		maxFileSize:     maxFileSize,
-		sessionIDInput:  sessionIDInput,
-		nicknameInput:   nicknameInput,
-		state:           chooseCreateOrJoin,
+		config:         config,
+		sessionIDInput: sessionIDInput,
+		nicknameInput:  nicknameInput,
+		passwordInput:  passwordInput,
+		state:          chooseCreateOrJoin,
+	}
+	if config.Browse {
+		m.state = browseRooms
 	}
 	// Initial focus depends on the first state, which is chooseCreateOrJoin, so no input is focused yet.
 	return m
 }
 
 func (m *InitialModel) Init() tea.Cmd {
+	if m.state == browseRooms {
+		return m.browse()
+	}
 	return textinput.Blink // General blink command, specific input focus is handled in Update
 }
 
+// directoryOptions describes how to reach the relay whose directory we browse.
+func (m *InitialModel) directoryOptions() network.DialOptions {
+	return network.DialOptions{RelayServerAddr: m.config.RelayServerAddr, Via: m.config.Via, ClientCert: m.config.ClientCert, PinnedCert: m.config.PinnedCert, ConnectPolicy: m.config.Connect}
+}
+
+// browseKey handles a key pressed while the room browser is shown.
+func (m *InitialModel) browseKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "up":
+		m.browser.move(-1)
+	case "down":
+		m.browser.move(1)
+	case "pgup":
+		m.browser.move(-10)
+	case "pgdown":
+		m.browser.move(10)
+	case "r", "R":
+		if !m.browser.loading {
+			return m.browser.load(m.directoryOptions())
+		}
+	case "j", "J":
+		return m.chooseJoin()
+	case "enter":
+		room, ok := m.browser.selected()
+		if !ok || roomFull(room) {
+			return nil
+		}
+		m.choice = "JOIN"
+		m.sessionIDInput.SetValue(room.SessionID)
+		return m.askNickname()
+	}
+	return nil
+}
+
+// browse shows the rooms listed in the relay's directory.
+func (m *InitialModel) browse() tea.Cmd {
+	m.state = browseRooms
+	m.sessionIDInput.Blur()
+	return m.browser.load(m.directoryOptions())
+}
+
+// chooseJoin asks for the ID of the session to join.
+func (m *InitialModel) chooseJoin() tea.Cmd {
+	m.choice = "JOIN"
+	m.state = enterSessionID
+	m.sessionIDInput.Placeholder = "Session ID to Join (or /browse to pick a listed room)"
+	m.sessionIDInput.SetValue("") // Clear previous value
+	m.sessionIDInput.Focus()
+	return textinput.Blink
+}
+
+// askNickname moves on to the nickname prompt.
+func (m *InitialModel) askNickname() tea.Cmd {
+	m.state = enterNickname
+	m.sessionIDInput.Blur()
+	m.nicknameInput.SetValue(m.config.Nickname) // Reset nickname input in case of re-entry
+	m.nicknameInput.Focus()
+	return textinput.Blink
+}
+
+// askPassword moves on to the session password prompt, for -password.
+func (m *InitialModel) askPassword() tea.Cmd {
+	m.state = enterPassword
+	m.nicknameInput.Blur()
+	m.passwordInput.SetValue("")
+	m.passwordInput.Focus()
+	return textinput.Blink
+}
+
+// startChat leaves the prompts for the chat itself.
+func (m *InitialModel) startChat() (tea.Model, tea.Cmd) {
+	nickname := strings.TrimSpace(m.nicknameInput.Value())
+	if nickname == "" {
+		nickname = util.GenerateRandomNickname()
+	}
+	sessionID := strings.TrimSpace(m.sessionIDInput.Value())
+	config := m.config
+	if config.AskPassword {
+		config.Password = []byte(m.passwordInput.Value())
+	}
+
+	mainModel := NewModel(config, sessionID, nickname, m.choice)
+	mainModel.Program = m.program
+	// The chat gets no size of its own until the window is resized.
+	window := m.window
+	return mainModel, tea.Batch(mainModel.Init(), func() tea.Msg { return window })
+}
+
 func (m *InitialModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
@@ -58,28 +160,32 @@ func (m *InitialModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg.Type {
 		case tea.KeyCtrlC, tea.KeyEsc:
 			return m, tea.Quit
+		}
+		if m.state == browseRooms {
+			return m, m.browseKey(msg)
+		}
+		switch msg.Type {
 		case tea.KeyEnter:
 			switch m.state {
 			case chooseCreateOrJoin:
-				// Not used, selection is based on 'c' or 'j'
+				// Not used, selection is based on 'c', 'j' or 'b'
 			case enterSessionID:
+				if m.choice == "JOIN" && strings.TrimSpace(m.sessionIDInput.Value()) == "/browse" {
+					return m, m.browse()
+				}
 				// Session ID entered (or skipped for create), move to nickname
-				m.state = enterNickname
-				m.nicknameInput.SetValue("") // Clear nickname input in case of re-entry
-				m.nicknameInput.Focus()
-				return m, textinput.Blink
+				return m, m.askNickname()
 			case enterNickname:
+				if m.config.AskPassword {
+					return m, m.askPassword()
+				}
 				// Nickname entered, transition to the main UI
-				nickname := strings.TrimSpace(m.nicknameInput.Value())
-				if nickname == "" {
-					nickname = util.GenerateRandomNickname()
+				return m.startChat()
+			case enterPassword:
+				if m.passwordInput.Value() == "" {
+					return m, nil
 				}
-				sessionID := strings.TrimSpace(m.sessionIDInput.Value())
-				command := m.choice
-
-				mainModel := NewModel(m.relayServerAddr, sessionID, nickname, command, int64(m.maxFileSize))
-				mainModel.Program = m.program
-				return mainModel, mainModel.Init()
+				return m.startChat()
 			}
 		case tea.KeyRunes:
 			if m.state == chooseCreateOrJoin {
@@ -93,15 +199,20 @@ func (m *InitialModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.sessionIDInput.Focus()
 					return m, textinput.Blink
 				} else if s == "J" {
-					m.choice = "JOIN"
-					m.state = enterSessionID
-					m.sessionIDInput.Placeholder = "Session ID to Join"
-					m.sessionIDInput.SetValue("") // Clear previous value
-					m.sessionIDInput.Focus()
-					return m, textinput.Blink
+					return m, m.chooseJoin()
+				} else if s == "B" {
+					return m, m.browse()
 				}
 			}
 		}
+	case tea.WindowSizeMsg:
+		m.window = msg
+	case RoomsMsg:
+		m.browser.loaded(msg)
+		return m, nil
+	case APIRequestMsg:
+		msg.Reply <- answerSetup(m.config, msg.Request)
+		return m, nil
 	case error:
 		m.err = msg
 		return m, nil
@@ -112,6 +223,8 @@ func (m *InitialModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.sessionIDInput, cmd = m.sessionIDInput.Update(msg)
 	case enterNickname:
 		m.nicknameInput, cmd = m.nicknameInput.Update(msg)
+	case enterPassword:
+		m.passwordInput, cmd = m.passwordInput.Update(msg)
 	}
 
 	return m, cmd
@@ -124,13 +237,15 @@ func (m *InitialModel) View() string {
 
 	switch m.state {
 	case chooseCreateOrJoin:
-		return "Do you want to (C)reate a new session or (J)oin an existing one? (C/J)\n"
+		return "Do you want to (C)reate a new session, (J)oin an existing one or (B)rowse the rooms listed on the relay? (C/J/B)\n"
+	case browseRooms:
+		return m.browser.View(m.config.RelayServerAddr)
 	case enterSessionID:
 		var title string
 		if m.choice == "CREATE" {
 			title = "Enter desired Session ID (optional, press Enter to auto-generate):"
 		} else {
-			title = "Enter the Session ID to join:"
+			title = "Enter the Session ID to join, or /browse to pick a room listed on the relay:"
 		}
 		return fmt.Sprintf(
 			"%s\n%s\n\n(esc to quit)",
@@ -142,6 +257,11 @@ func (m *InitialModel) View() string {
 			"Enter your nickname (or press Enter for a random one):\n%s\n\n(esc to quit)",
 			m.nicknameInput.View(),
 		)
+	case enterPassword:
+		return fmt.Sprintf(
+			"Enter the session password you agreed on with your peer. It proves to each other that the relay is not in the middle, and is never sent:\n%s\n\n(esc to quit)",
+			m.passwordInput.View(),
+		)
 	default:
 		return ""
 	}
@@ -151,10 +271,20 @@ func (m *InitialModel) SetProgram(p *tea.Program) {
 	m.program = p
 }
 
-func StartInitialUI(relayServerAddr string, maxFileSize int) {
-	initialModel := NewInitialModel(relayServerAddr, maxFileSize)
-	p := tea.NewProgram(initialModel, tea.WithAltScreen())
+// NewProgram creates the program that runs the session setup prompts and then the chat.
+// Extra options are applied after the defaults, e.g. to replace the terminal I/O.
+func NewProgram(config Config, opts ...tea.ProgramOption) *tea.Program {
+	initialModel := NewInitialModel(config)
+	p := tea.NewProgram(initialModel, append([]tea.ProgramOption{tea.WithAltScreen()}, opts...)...)
 	initialModel.SetProgram(p)
+	if config.API != nil {
+		go serveAPI(config.API, p)
+	}
+	return p
+}
+
+func StartInitialUI(config Config) {
+	p := NewProgram(config)
 
 	if _, err := p.Run(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// narrowWidth is the terminal width below which the header stacks its parts
+// on lines of their own and shortens the session ID.
+const narrowWidth = 60
+
+// shortSessionID is how many characters of the session ID a narrow header shows.
+const shortSessionID = 8
+
+// headerParts are the pieces of the status bar, most important first.
+func (m *Model) headerParts(narrow bool) []string {
+	parts := []string{m.Status}
+	if m.Label != "" {
+		parts = append(parts, m.Label)
+	}
+	if m.SessionID != "" {
+		id := m.SessionID
+		if narrow && len(id) > shortSessionID {
+			id = id[:shortSessionID] + "…"
+		}
+		parts = append(parts, "Session ID: "+id)
+	}
+	if search := m.chatArea.searchStatus(); search != "" {
+		parts = append(parts, search)
+	}
+	if announcement := m.announcementStatus(); announcement != "" {
+		parts = append(parts, announcement)
+	}
+	if m.observing {
+		parts = append(parts, "👁 Observing")
+	}
+	if poll := m.pollStatus(); poll != "" {
+		parts = append(parts, poll)
+	}
+	if liveness := m.livenessStatus(time.Now()); liveness != "" {
+		parts = append(parts, liveness)
+	}
+	if relay := m.relayStatus(time.Now()); relay != "" {
+		parts = append(parts, relay)
+	}
+	return parts
+}
+
+// header lays out the status bar for the window width: one line cut short
+// where it does not fit, or, on a narrow terminal, one line per part.
+func (m *Model) header() string {
+	if m.width == 0 {
+		return strings.Join(m.headerParts(false), " | ")
+	}
+	if m.width < narrowWidth {
+		parts := m.headerParts(true)
+		for i, part := range parts {
+			parts[i] = ansi.Truncate(part, m.width, "…")
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ansi.Truncate(strings.Join(m.headerParts(false), " | "), m.width, "…")
+}
+
+// layout gives the chat area what the header and footer leave of the window.
+// Both change height as the session goes on, so this runs before every render.
+func (m *Model) layout() {
+	if m.width == 0 {
+		return
+	}
+	footerHeight := 0
+	if m.IsTransferring || len(m.PendingOffers) > 0 {
+		footerHeight = 1 + TextareaStyle.GetVerticalBorderSize()
+	}
+	height := max(m.height-lipgloss.Height(m.headerView())-footerHeight, 0)
+	if height != m.chatHeight {
+		m.chatHeight = height
+		m.chatArea.SetDimensions(m.width, height)
+	}
+}
+
+// copySessionID shows the full session ID and puts it on the clipboard with
+// an OSC 52 escape sequence, which terminals that do not support it ignore.
+func (m *Model) copySessionID() tea.Cmd {
+	if m.SessionID == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "There is no session ID yet."})
+		return nil
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Session ID: %s (copied to the clipboard if your terminal allows it)", m.SessionID)})
+	terminal := m.Terminal
+	if terminal == nil {
+		terminal = os.Stdout
+	}
+	id := m.SessionID
+	return func() tea.Msg {
+		io.WriteString(terminal, "\x1b]52;c;"+base64.StdEncoding.EncodeToString([]byte(id))+"\a")
+		return nil
+	}
+}
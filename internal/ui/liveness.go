@@ -0,0 +1,90 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// Peers whose hello asks for keepalives get one from us every keepaliveInterval,
+// and send theirs in return. A peer we have heard nothing from for staleAfter
+// is shown as stale: its client has most likely gone away without closing the
+// connection, and the relay only ends the session once that client's pings to
+// it stop, or after 5 minutes without traffic if it does not ping.
+const (
+	keepaliveInterval = 15 * time.Second
+	livenessRefresh   = 5 * time.Second
+	staleAfter        = 40 * time.Second
+)
+
+// KeepaliveMsg reports a keepalive from the peer.
+type KeepaliveMsg struct{}
+
+// livenessTickMsg sends our keepalive when it is due and checks on the peer.
+// Ticks from an earlier chain carry an old seq and are dropped.
+type livenessTickMsg struct{ seq int }
+
+// startLiveness starts exchanging keepalives with the peer.
+func (m *Model) startLiveness() tea.Cmd {
+	m.peerKeepalives = true
+	m.peerLastSeen = time.Now()
+	m.livenessSeq++
+	return tea.Batch(m.sendKeepalive(), m.livenessTick())
+}
+
+func (m *Model) livenessTick() tea.Cmd {
+	seq := m.livenessSeq
+	return tea.Tick(livenessRefresh, func(time.Time) tea.Msg { return livenessTickMsg{seq: seq} })
+}
+
+// checkLiveness sends a keepalive if one is due and notes when the peer goes
+// quiet. The ticks stop once we are disconnected.
+func (m *Model) checkLiveness(now time.Time) tea.Cmd {
+	if !m.IsConnected {
+		return nil
+	}
+	cmds := []tea.Cmd{m.livenessTick()}
+	if now.Sub(m.lastKeepalive) >= keepaliveInterval {
+		cmds = append(cmds, m.sendKeepalive())
+	}
+	if !m.peerStale && now.Sub(m.peerLastSeen) >= staleAfter {
+		m.peerStale = true
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Nothing has arrived from %s for %s; their client may be gone. The relay ends the session once it notices.", m.peerName(), staleAfter)})
+	}
+	return tea.Batch(cmds...)
+}
+
+// sendKeepalive tells the peer we are still there.
+func (m *Model) sendKeepalive() tea.Cmd {
+	m.lastKeepalive = time.Now()
+	return func() tea.Msg {
+		if err := network.SendData(m.Conn, m.Keys, protocol.TypeKeepalive, nil); err != nil {
+			return ErrorMsg{Err: err}
+		}
+		return nil
+	}
+}
+
+// heard records that something arrived from the peer.
+func (m *Model) heard() {
+	if !m.peerKeepalives {
+		return
+	}
+	m.peerLastSeen = time.Now()
+	if m.peerStale {
+		m.peerStale = false
+		m.Messages = append(m.Messages, Message{Timestamp: m.peerLastSeen, Sender: "System", Content: fmt.Sprintf("%s is back.", m.peerName())})
+	}
+}
+
+// livenessStatus is shown in the header while the peer is stale.
+func (m *Model) livenessStatus(now time.Time) string {
+	if !m.peerStale || !m.IsConnected {
+		return ""
+	}
+	return fmt.Sprintf("%s last seen %ds ago", m.peerName(), int(now.Sub(m.peerLastSeen)/time.Second))
+}
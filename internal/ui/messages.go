@@ -1,22 +1,27 @@
 package ui
 
 import (
+	"crypto/ed25519"
 	"net"
+	"time"
 
-	"github.com/charmbracelet/bubbles/progress"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/hook"
+	"github.com/bjarneo/jot/internal/network"
 	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/charmbracelet/bubbles/progress"
 )
 
 // --- Bubbletea Messages ---
 
 type (
 	ConnectionMsg          struct{ Conn net.Conn }
-	SharedKeyMsg           struct{ Key []byte }
+	SessionKeysMsg         struct{ Keys *crypto.SessionKeys }
+	PeerHelloMsg           struct{ Hello protocol.Hello }
 	ReceivedNicknameMsg    struct{ Nickname string }
-	ReceivedTextMsg        struct{ Text string }
 	FileOfferMsg           struct{ Metadata protocol.FileMetadata }
 	FileOfferAcceptedMsg   struct{ Metadata protocol.FileMetadata } // Sent from receiver to sender
-	FileOfferRejectedMsg   struct{}
+	FileOfferRejectedMsg   struct{ Metadata protocol.FileMetadata }
 	FileOfferFailedMsg     struct{ Reason string }
 	FileSendingCompleteMsg struct{}
 	FileChunkMsg           struct{ Chunk []byte }
@@ -25,6 +30,117 @@ type (
 	FileTransferProgress   float64
 	MyPublicKeyMsg         struct{ PublicKey []byte }
 	PeerPublicKeyMsg       struct{ PublicKey []byte }
+	PeerIdentityMsg        struct{ PublicKey ed25519.PublicKey }
 	ConnectionClosedMsg    struct{}
+	ConnectionLostMsg      struct{ Err error } // Reading from the relay failed other than by the session ending
 	ErrorMsg               struct{ Err error }
 )
+
+// ReceivedTextMsg carries a chat message from the peer along with its signature status.
+type ReceivedTextMsg struct {
+	Text      string
+	Signature crypto.SignatureStatus
+}
+
+// ConnectRetryMsg reports that reaching the relay failed and Connect tries
+// again after Wait, for retry number Retry of Of.
+type ConnectRetryMsg struct {
+	Retry, Of int
+	Wait      time.Duration
+	Err       error
+}
+
+// ResumeMsg reports that the connection to the relay dropped, or how resuming
+// the session afterwards went. Presence is the relay's account of the peer
+// once resumed, if it gave one.
+type ResumeMsg struct {
+	Event    network.ResumeEvent
+	Presence *network.Presence
+}
+
+// WaitingForApprovalMsg reports that the session owner must admit us.
+type WaitingForApprovalMsg struct{}
+
+// KnockPromptMsg asks the user for a knock note because the session is locked.
+// The note, or an empty string to give up, is sent on Reply.
+type KnockPromptMsg struct {
+	Reply chan<- string
+}
+
+// ControlOpenedMsg carries the owner's control connection to the relay.
+type ControlOpenedMsg struct {
+	Control *network.Control
+	Err     error
+}
+
+// ControlEventMsg carries an event from the control connection, or the error that ended it.
+type ControlEventMsg struct {
+	Event network.ControlEvent
+	Err   error
+}
+
+// NoticesOpenedMsg carries the connection on which the relay sends us notices.
+type NoticesOpenedMsg struct {
+	Notices *network.Control
+	Err     error
+}
+
+// RelayNoticeMsg carries a notice from the relay, such as how much of the session's data budget we used.
+type RelayNoticeMsg struct {
+	Event network.ControlEvent
+}
+
+// InviteMsg carries a single-use join token minted by the relay for /invite.
+type InviteMsg struct {
+	Token string
+	Err   error
+}
+
+// TransferFailedMsg reports that the data connection for the incoming offer
+// with ID broke before the file was complete.
+type TransferFailedMsg struct {
+	ID  string
+	Err error
+}
+
+// ReportMsg reports the outcome of filing a /report with the relay.
+type ReportMsg struct {
+	ID  string
+	Err error
+}
+
+// OnMessageResultMsg reports the outcome of the on-message hook for a received chat message.
+type OnMessageResultMsg struct {
+	Result hook.Result
+	Err    error
+}
+
+// PeerRecordingMsg reports that the peer started or stopped recording the session.
+type PeerRecordingMsg struct {
+	Recording protocol.Recording
+}
+
+// RecordingSavedMsg reports the outcome of writing a session recording to disk.
+type RecordingSavedMsg struct {
+	Path    string
+	Skipped []string // Received files that no longer existed when the archive was written
+	Err     error
+}
+
+// NotifyResultMsg reports the outcome of sending a push notification.
+type NotifyResultMsg struct {
+	Err error
+}
+
+// PostReceiveResultMsg reports the outcome of the post-receive hook for a downloaded file.
+type PostReceiveResultMsg struct {
+	Path   string
+	Result hook.Result
+	Err    error
+}
+
+// UnknownMessageMsg reports that the peer sent a message of a type this
+// version does not know, which was skipped.
+type UnknownMessageMsg struct {
+	Type byte
+}
@@ -1,24 +1,35 @@
 package ui
 
 import (
-	"bufio"
-	"crypto/sha256"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/google/uuid"
 
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/contacts"
+	"github.com/bjarneo/jot/internal/crypto"
 	"github.com/bjarneo/jot/internal/filetransfer"
+	"github.com/bjarneo/jot/internal/hook"
 	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/notify"
 	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/bjarneo/jot/internal/trust"
+	"github.com/bjarneo/jot/internal/util"
 )
 
 type programMessageSender struct {
@@ -37,16 +48,56 @@ func (pms *programMessageSender) SendConnection(conn net.Conn) {
 	pms.program.Send(ConnectionMsg{Conn: conn})
 }
 
-func (pms *programMessageSender) SendSharedKey(key []byte) {
-	pms.program.Send(SharedKeyMsg{Key: key})
+func (pms *programMessageSender) SendSessionKeys(keys *crypto.SessionKeys) {
+	pms.program.Send(SessionKeysMsg{Keys: keys})
+}
+
+func (pms *programMessageSender) SendRekeyed(rekeys int) {
+	pms.program.Send(RekeyedMsg{Rekeys: rekeys})
+}
+
+func (pms *programMessageSender) SendPeerHello(hello protocol.Hello) {
+	pms.program.Send(PeerHelloMsg{Hello: hello})
 }
 
 func (pms *programMessageSender) SendReceivedNickname(nickname string) {
 	pms.program.Send(ReceivedNicknameMsg{Nickname: nickname})
 }
 
-func (pms *programMessageSender) SendReceivedText(text string) {
-	pms.program.Send(ReceivedTextMsg{Text: text})
+func (pms *programMessageSender) SendPeerRecording(recording protocol.Recording) {
+	pms.program.Send(PeerRecordingMsg{Recording: recording})
+}
+
+func (pms *programMessageSender) SendReceivedText(text string, signature crypto.SignatureStatus) {
+	pms.program.Send(ReceivedTextMsg{Text: text, Signature: signature})
+}
+
+func (pms *programMessageSender) SendAck(ack protocol.Ack) {
+	pms.program.Send(AckMsg{Ack: ack})
+}
+
+func (pms *programMessageSender) SendKeepalive() {
+	pms.program.Send(KeepaliveMsg{})
+}
+
+func (pms *programMessageSender) SendBye() {
+	pms.program.Send(ByeMsg{})
+}
+
+func (pms *programMessageSender) SendBlob(blob protocol.Blob) {
+	pms.program.Send(BlobMsg{Blob: blob})
+}
+
+func (pms *programMessageSender) SendPoll(poll protocol.Poll) {
+	pms.program.Send(PollMsg{Poll: poll})
+}
+
+func (pms *programMessageSender) SendVote(vote protocol.Vote) {
+	pms.program.Send(VoteMsg{Vote: vote})
+}
+
+func (pms *programMessageSender) SendAnnouncement(announcement protocol.Announcement) {
+	pms.program.Send(AnnouncementMsg{Announcement: announcement})
 }
 
 func (pms *programMessageSender) SendFileOffer(metadata protocol.FileMetadata) {
@@ -57,8 +108,8 @@ func (pms *programMessageSender) SendFileOfferAccepted(metadata protocol.FileMet
 	pms.program.Send(FileOfferAcceptedMsg{Metadata: metadata})
 }
 
-func (pms *programMessageSender) SendFileOfferRejected() {
-	pms.program.Send(FileOfferRejectedMsg{})
+func (pms *programMessageSender) SendFileOfferRejected(metadata protocol.FileMetadata) {
+	pms.program.Send(FileOfferRejectedMsg{Metadata: metadata})
 }
 
 func (pms *programMessageSender) SendFileOfferFailed(reason string) {
@@ -89,10 +140,22 @@ func (pms *programMessageSender) SendMyPublicKey(publicKey []byte) {
 	pms.program.Send(MyPublicKeyMsg{PublicKey: publicKey})
 }
 
+func (pms *programMessageSender) SendPeerIdentity(publicKey ed25519.PublicKey) {
+	pms.program.Send(PeerIdentityMsg{PublicKey: publicKey})
+}
+
+func (pms *programMessageSender) SendUnknownMessage(msgType byte) {
+	pms.program.Send(UnknownMessageMsg{Type: msgType})
+}
+
 func (pms *programMessageSender) SendConnectionClosed() {
 	pms.program.Send(ConnectionClosedMsg{})
 }
 
+func (pms *programMessageSender) SendConnectionLost(err error) {
+	pms.program.Send(ConnectionLostMsg{Err: err})
+}
+
 type InfoMsg struct {
 	Info string
 }
@@ -100,11 +163,23 @@ type InfoMsg struct {
 // Model represents the Bubble Tea UI model.
 type Model struct {
 	RelayServerAddr string
+	Via             string // Optional relay that forwards our connection to RelayServerAddr
+	ClientCert      *tls.Certificate
+	PinnedCert      []byte
+	connectPolicy   network.ConnectPolicy
+	ownerKey        string // Proves to the relay that we created the session; empty when joining
+	noticeKey       string // Proves to the relay which end of the session asks for its notices
+	WaitingRoom     bool   // Whether our session holds joiners until we admit them
 	SessionID       string
+	Label           string // The session's name: ours if we created it, otherwise the creator's
 	Command         string
 	Status          string
 	Conn            net.Conn
-	SharedKey       []byte
+	Keys            *crypto.SessionKeys
+	Identity        ed25519.PrivateKey
+	storedIdentity  bool // Identity is kept on disk, by a profile or by default, so it outlives this session
+	Cipher          crypto.Cipher
+	password        []byte // Proven to the peer at every handshake, so the relay cannot swap our keys
 	Err             error
 	Program         *tea.Program
 
@@ -120,24 +195,138 @@ type Model struct {
 	IsTransferring       bool
 	IsReceiving          bool
 	IsAwaitingAcceptance bool
-	PendingOffer         protocol.FileMetadata
-	ReceivingFile        *os.File
-	TotalBytesReceived   int64
+	PendingOffers        []protocol.FileMetadata // Incoming offers awaiting a decision, oldest first
+	OutgoingOffers       map[string]string       // Offer ID to local path for files we offered
+	Blobs                []protocol.Blob         // Files the peer left on the relay that we have not fetched, oldest first
+	Poll                 *poll                   // The latest poll either of us opened
+	ReceivingFile        *filetransfer.IncomingFile
 	ShowHelp             bool
 	PeerFingerprint      string
 	MyFingerprint        string
 	MaxFileSize          int64
+	PeerMaxFileSize      int64 // Advertised by the peer in its hello; 0 until received
+	Compress             bool  // Compress long texts we send, if the peer can decode them
+	peerInflates         bool  // The peer's hello lists DEFLATE
+	peerAcks             bool  // The peer acknowledges our texts and wants us to acknowledge its own
+	peerDataConns        bool  // The peer can send files over data connections of their own
+	peerBlobs            bool  // The peer can fetch files we leave on the relay
+	peerPolls            bool  // The peer shows polls and votes in them
+	peerAnnouncements    bool  // The peer holds back its posts in announcement mode
+	textsOut             int   // Numbers our texts in Messages, to find them when sending completes
+	sendMu               sync.Mutex
+	textsSent            uint64 // Texts written to the peer, guarded by sendMu
+	textsAcked           uint64 // Texts the peer acknowledged
+	textsReceived        uint64 // Texts received from the peer
+	MaxIncomingOffers    int
+	MaxUnverifiedBytes   int64 // Per hour, from peers that are not verified; 0 disables the limit
+	MaxMessageRate       int   // Messages per floodWindow shown from the peer before the rest are held back; 0 disables
+	unverifiedReceipts   []receipt
+	RekeyMessages        int           // Texts sent and received before the keys are replaced; 0 disables
+	RekeyInterval        time.Duration // How long keys are used before they are replaced; 0 disables
+	rekeying             bool          // We asked the peer for new keys and wait for the switch
+	rekeyedMessages      int           // Texts sent and received since the keys were last replaced
+	lastRekey            time.Time     // When the keys were last exchanged or replaced
+	rekeySeq             int
+	PostReceiveCommand   string
+	heldDownloads        map[string]*filetransfer.IncomingFile // Received files -post-receive is checking, by their path in the quarantine directory
+	OpenCommand          string
+	OnMessageCommand     string
+	Notifier             *notify.Notifier
+	Detached             func() bool // Reports whether nobody is watching; nil outside daemon mode
+	lastNotified         time.Time
+	runningMessageHooks  int
+	Alerts               map[string]config.Alert // How to draw attention to each event
+	Terminal             io.Writer               // Receives the bell; nil uses standard output
+	runningAlertCommands int
+	flashing             bool // The status bar is highlighted for a flash alert
+	editorChord          bool // Ctrl+X was pressed; Ctrl+E next opens the editor
+	flashSeq             int
+	relativeTimes        bool // Show "2m ago" style times; toggled with /timestamps
+	relativeSeq          int
+	Downloads            []string // Paths of files received this session, oldest first
+	transfers            transferList
+
+	width, height int // Of the terminal window; 0 until it is known
+	chatHeight    int // Last given to the chat area
+
+	peerKeepalives bool      // The peer sends keepalives and wants ours
+	peerLastSeen   time.Time // When something last arrived from the peer, once it sends keepalives
+	peerStale      bool      // Nothing arrived from the peer for longer than staleAfter
+	lastKeepalive  time.Time // When we last sent the peer a keepalive
+	livenessSeq    int
+
+	peerArrivals []time.Time // When the peer's latest messages arrived, for throttled
+	floods       []*flood    // Bursts of messages held back until /show
+	flooding     bool        // The latest burst is still going on
+
+	Reminders   []reminder // Pending /remind reminders, soonest first
+	reminderSeq int        // Numbers reminders, so each timer finds its own
+
+	Recording         *recording // Active /record session, if any
+	recordPrompt      int
+	pendingPassphrase string
+	PeerRecording     bool
+
+	announcing   bool                  // We created the session and only we may post
+	peerVoiced   bool                  // The peer may post while we are announcing
+	announcement protocol.Announcement // The owner's announcement mode, when we joined
+
+	rejoinID     string // Where the owner creates the session again if it is lost; from its hello when we joined
+	peerRejoins  bool   // The peer says goodbye before leaving, and comes back if the session is lost
+	peerHandoffs bool   // The peer can take over the session with /transfer-owner
+	observing    bool   // We joined as an observer, so we post nothing
+	peerObserver bool   // The relay told us the peer joined as an observer, so it may not post
+	peerRekeys   bool   // The peer can replace the session keys with /rekey
+	peerCancels  bool   // The peer stops a transfer under way when we reject it
+	peerLeft     bool   // The peer said goodbye
+	budgetSpent  bool   // We used up the relay's data budget, so the session is not rejoined
+	rejoining    bool   // Getting back into a lost session, until the peer's nickname arrives again
+	rejoinSeq    int    // Numbers attempts to rejoin, so a late answer to an earlier one is dropped
+
+	TrustStore     *trust.Store
+	PeerTrust      trust.Status
+	hasWarnedTrust bool
+
+	Pins       *trust.Pins
+	keyChanged bool      // The peer's identity key is not the one pinned for its nickname, and the user has not accepted it
+	heldForKey []Message // The peer's messages held until the user accepts its key
+
+	Contacts                *contacts.Store
+	PeerIdentityFingerprint string            // Fingerprint of the peer's identity key; stable across sessions for peers using a profile
+	peerIdentity            ed25519.PublicKey // Verifies what arrives on data connections
+	myExchangeKey           []byte            // Our X25519 public key for the session
+	unknownMessages         int               // Messages of types we do not know, skipped
+	peerExchangeKey         []byte            // The peer's X25519 public key for the session
+
+	control             *network.Control       // Owner's control connection to the relay, for the waiting room and /lock
+	knockReply          chan<- string          // Set while we are asked for a knock note
+	relayNotice         string                 // Latest relay notice, such as data budget usage, shown in the status bar
+	notices             *network.Control       // Our NOTICES connection to the relay, on which we ping it
+	pingSeq             int64                  // Numbers our pings to the relay
+	pingSent            time.Time              // When the latest ping went out
+	lastPong            time.Time              // When the relay last answered one; zero until it does
+	relayRTT            time.Duration          // Round trip to the relay, as the latest answered ping measured it
+	JoinRequests        []network.ControlEvent // Clients waiting for us to admit them, oldest first
+	admittedFingerprint string                 // Identity fingerprint the admitted joiner announced
 }
 
-func NewModel(relayServerAddr, sessionID, nickname, command string, maxFileSize int64) *Model {
+func NewModel(config Config, sessionID, nickname, command string) *Model {
+	relayServerAddr := config.RelayServerAddr
+
 	initialWidth := 80
 	initialChatAreaHeight := 20
 
 	ca := NewChatAreaModel(initialWidth, initialChatAreaHeight, nickname)
+	ca.SetRelativeTimes(config.RelativeTimestamps)
 	prog := progress.New(progress.WithDefaultGradient())
 
 	m := &Model{
 		RelayServerAddr: relayServerAddr,
+		Via:             config.Via,
+		ClientCert:      config.ClientCert,
+		PinnedCert:      config.PinnedCert,
+		connectPolicy:   config.Connect,
+		WaitingRoom:     config.WaitingRoom,
 		SessionID:       sessionID,
 		Nickname:        nickname,
 		Status:          fmt.Sprintf("Connecting to relay server %s...", relayServerAddr),
@@ -145,59 +334,137 @@ func NewModel(relayServerAddr, sessionID, nickname, command string, maxFileSize
 		Progress:        prog,
 		Messages:        []Message{{Timestamp: time.Now(), Sender: "System", Content: "Waiting for connection..."}},
 		Command:         command,
-		MaxFileSize:     maxFileSize * 1024 * 1024,
+		OutgoingOffers:  make(map[string]string),
+		MaxFileSize:     int64(config.MaxFileSize) * 1024 * 1024,
+		Cipher:          config.Cipher,
+		password:        config.Password,
+		Compress:        config.Compress,
+
+		MaxIncomingOffers:  config.MaxIncomingOffers,
+		MaxUnverifiedBytes: int64(config.MaxUnverifiedMBPerHour) * 1024 * 1024,
+		MaxMessageRate:     config.MaxMessageRate,
+		RekeyMessages:      config.RekeyMessages,
+		RekeyInterval:      config.RekeyInterval,
+
+		PostReceiveCommand: config.PostReceiveCommand,
+		OpenCommand:        config.OpenCommand,
+		OnMessageCommand:   config.OnMessageCommand,
+		Notifier:           config.Notifier,
+		Detached:           config.Detached,
+		Alerts:             config.Alerts,
+		Terminal:           config.Terminal,
+		relativeTimes:      config.RelativeTimestamps,
 	}
-	return m
-}
 
-func (m *Model) Init() tea.Cmd {
-	return func() tea.Msg {
-		var conn net.Conn
-		var err error
-		if strings.HasPrefix(m.RelayServerAddr, "localhost:") {
-			conn, err = net.Dial("tcp", m.RelayServerAddr)
-		} else {
-			conn, err = tls.Dial("tcp", m.RelayServerAddr, nil)
-		}
+	if command == "CREATE" {
+		m.ownerKey = rand.Text()
+		m.rejoinID = uuid.NewString()
+	}
+	m.noticeKey = rand.Text()
+	m.observing = config.Observe && command == "JOIN"
 
+	m.Identity = config.Identity
+	m.storedIdentity = m.Identity != nil
+	if m.Identity == nil {
+		identity, err := crypto.GenerateIdentity()
 		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to connect to relay server: %w", err)}
+			m.Err = err
+			return m
 		}
+		m.Identity = identity
+	}
 
-		initialMsgStruct := struct {
-			Command   string `json:"command"`
-			SessionID string `json:"sessionID,omitempty"`
-		}{
-			Command:   m.Command,
-			SessionID: m.SessionID,
-		}
+	if trustPath, err := pathOrDefault(config.TrustStorePath, trust.DefaultPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else if store, err := trust.Load(trustPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else {
+		m.TrustStore = store
+	}
 
-		msgBytes, err := json.Marshal(initialMsgStruct)
-		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to marshal initial message: %w", err)}
-		}
+	if pinsPath, err := pathOrDefault(config.PinsPath, trust.DefaultPinsPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else if pins, err := trust.LoadPins(pinsPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else {
+		m.Pins = pins
+	}
 
-		_, err = conn.Write(append(msgBytes, '\n'))
-		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to send initial message to relay server: %w", err)}
-		}
+	if contactsPath, err := pathOrDefault(config.ContactsPath, contacts.DefaultPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else if store, err := contacts.Load(contactsPath); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: err.Error()})
+	} else {
+		m.Contacts = store
+	}
+	if command == "CREATE" {
+		m.Label = config.Label
+	}
+	return m
+}
 
-		reader := bufio.NewReader(conn)
-		response, err := reader.ReadString('\n')
-		if err != nil {
-			return ErrorMsg{Err: fmt.Errorf("failed to read response from relay server: %w", err)}
-		}
+// pathOrDefault returns path, or the default location if path is empty.
+func pathOrDefault(path string, defaultPath func() (string, error)) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	return defaultPath()
+}
+
+// waitingCommand reports whether text is a command that works before the peer has joined.
+func waitingCommand(text string) bool {
+	command, _, _ := strings.Cut(text, " ")
+	switch command {
+	case "/invite", "/admit", "/deny", "/ban", "/lock", "/unlock", "/help", "/fingerprint", "/session-fingerprint", "/timestamps", "/search", "/publish", "/unpublish", "/copy-id", "/remind", "/announce":
+		return true
+	}
+	return false
+}
 
-		if strings.HasPrefix(response, "Error:") {
-			return ErrorMsg{Err: fmt.Errorf("relay server error: %s", strings.TrimSpace(response))}
+// dialOptions describes how to reach our relay server.
+func (m *Model) dialOptions() network.DialOptions {
+	opts := network.DialOptions{RelayServerAddr: m.RelayServerAddr, Via: m.Via, ClientCert: m.ClientCert, PinnedCert: m.PinnedCert, OwnerKey: m.ownerKey, NoticeKey: m.noticeKey, WaitingRoom: m.WaitingRoom, Observe: m.observing, Introduce: m.introduce, ConnectPolicy: m.connectPolicy}
+	if m.Program != nil {
+		opts.Retrying = func(retry int, wait time.Duration, err error) {
+			m.Program.Send(ConnectRetryMsg{Retry: retry, Of: m.connectPolicy.Retries, Wait: wait, Err: err})
 		}
+	}
+	if m.storedIdentity {
+		// Relays that reserve session names hold ours for this key, and
+		// relays that ban keys check it.
+		opts.Identity = m.Identity
+	}
+	return opts
+}
 
-		if strings.HasPrefix(response, "Session created:") {
-			m.SessionID = strings.TrimSpace(strings.TrimPrefix(response, "Session created:"))
+func (m *Model) Init() tea.Cmd {
+	var tick tea.Cmd
+	if m.relativeTimes {
+		tick = m.relativeTick()
+	}
+	return tea.Batch(tick, func() tea.Msg {
+		opts := m.dialOptions()
+		conn, sessionID, err := network.Connect(opts, m.Command, m.SessionID)
+		if err != nil {
+			return ErrorMsg{Err: err}
 		}
+		m.SessionID = sessionID
+		return ConnectionMsg{Conn: m.resumable(conn, opts, sessionID)}
+	})
+}
 
-		return ConnectionMsg{Conn: conn}
+// resumable wraps conn, our connection to sessionID, so that if the relay lets
+// clients resume, a dropped connection is picked up again without the session
+// noticing.
+func (m *Model) resumable(conn net.Conn, opts network.DialOptions, sessionID string) net.Conn {
+	opts.Retrying = nil
+	var events func(network.ResumeEvent, *network.Presence)
+	if m.Program != nil {
+		events = func(e network.ResumeEvent, presence *network.Presence) {
+			m.Program.Send(ResumeMsg{Event: e, Presence: presence})
+		}
 	}
+	return network.NewResumableConn(conn, opts, sessionID, events)
 }
 
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -217,6 +484,30 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	}
 
+	// The transfer table takes the keys while it is shown.
+	if key, ok := msg.(tea.KeyMsg); ok && m.transfers.open && !m.ShowHelp {
+		if cmd, used := m.transfersKey(key); used {
+			return m, cmd
+		}
+	}
+	// While searching, n/N and F3 move between matches instead of typing. An
+	// offer waiting for y/n keeps the letters for itself.
+	if key, ok := msg.(tea.KeyMsg); ok && !m.ShowHelp && len(m.PendingOffers) == 0 && m.chatArea.searchKey(key) {
+		return m, nil
+	}
+	// Ctrl+X Ctrl+E opens the draft in an editor.
+	if key, ok := msg.(tea.KeyMsg); ok && !m.ShowHelp {
+		if cmd, chord := m.editorKey(key); chord {
+			return m, cmd
+		}
+	}
+	// Alt+1 to Alt+9 vote in the open poll.
+	if key, ok := msg.(tea.KeyMsg); ok && !m.ShowHelp {
+		if cmd, voted := m.voteKey(key); voted {
+			return m, cmd
+		}
+	}
+
 	m.chatArea, chatAreaCmd = m.chatArea.Update(msg)
 	if chatAreaCmd != nil {
 		cmds = append(cmds, chatAreaCmd)
@@ -229,18 +520,167 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Batch(cmds...)
 		}
 
+		if m.recordPrompt != recordPromptNone {
+			if cmd := m.handleRecordPassphrase(text); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+			break
+		}
+
+		if m.knockReply != nil {
+			m.submitKnock(text)
+			break
+		}
+
+		if m.rejoining && !waitingCommand(text) {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Not sent: waiting for %s to come back.", m.peerName())})
+			break
+		}
+
+		// The input is focused while the owner waits for a peer so /invite can be
+		// used, but there is nobody to send anything to yet.
+		if !m.IsReady && !waitingCommand(text) {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Your peer has not joined yet. Use /invite to create a join token."})
+			break
+		}
+
+		if m.keyChanged && posts(text) {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Not sent: %s's identity key changed. Type /accept-key once you have checked it with them, or /quit.", m.peerName())})
+			break
+		}
+
+		if m.observing && posts(text) {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Not sent: you joined as an observer, so you can read but not post."})
+			break
+		}
+
+		if m.silenced() && posts(text) {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Not sent: the session owner turned on announcement mode, so only they can post."})
+			break
+		}
+
 		if strings.HasPrefix(text, "/send ") {
-			filePath := strings.TrimPrefix(text, "/send ")
-			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Offering to send file: %s", filePath)})
-			m.IsAwaitingAcceptance = true
-			m.Status = fmt.Sprintf("TRANSFERRING: Offering to send %s", filepath.Base(filePath))
-			cmd := func() tea.Msg {
-				filetransfer.RequestSendFile(m.Conn, m.SharedKey, filePath, &programMessageSender{program: m.Program}, m.MaxFileSize)
-				return nil
+			cmds = append(cmds, m.offerFile(parseSendArgs(strings.TrimPrefix(text, "/send "))))
+		} else if strings.HasPrefix(text, "/cat ") {
+			filePath := strings.TrimSpace(strings.TrimPrefix(text, "/cat "))
+			snippet, err := filetransfer.ReadSnippet(filePath)
+			if err != nil {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not share %s: %v", filePath, err)})
+				break
 			}
-			cmds = append(cmds, cmd)
+			cmds = append(cmds, m.sendText(snippet))
 		} else if text == "/help" {
 			m.ShowHelp = !m.ShowHelp
+		} else if text == "/transfers" {
+			m.showTransfers()
+		} else if text == "/timestamps" {
+			cmds = append(cmds, m.setRelativeTimes(!m.relativeTimes))
+			mode := TimestampsAbsolute
+			if m.relativeTimes {
+				mode = TimestampsRelative
+			}
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Showing %s timestamps.", mode)})
+		} else if text == "/search" || strings.HasPrefix(text, "/search ") {
+			m.chatArea.SetSearch(strings.TrimSpace(strings.TrimPrefix(text, "/search")), m.Messages)
+		} else if text == "/upload" || strings.HasPrefix(text, "/upload ") {
+			if cmd := m.upload(strings.TrimSpace(strings.TrimPrefix(text, "/upload"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/fetch" || strings.HasPrefix(text, "/fetch ") {
+			if cmd := m.fetch(strings.TrimSpace(strings.TrimPrefix(text, "/fetch"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/poll" || strings.HasPrefix(text, "/poll ") {
+			if cmd := m.startPoll(strings.TrimSpace(strings.TrimPrefix(text, "/poll"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if strings.HasPrefix(text, "/vote ") {
+			if cmd := m.voteCommand(strings.TrimSpace(strings.TrimPrefix(text, "/vote "))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/edit" || strings.HasPrefix(text, "/edit ") {
+			if cmd := m.editDraft(strings.TrimSpace(strings.TrimPrefix(text, "/edit"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/remind" || strings.HasPrefix(text, "/remind ") {
+			if cmd := m.remind(strings.TrimSpace(strings.TrimPrefix(text, "/remind"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/announce" || strings.HasPrefix(text, "/announce ") {
+			if cmd := m.setAnnouncing(strings.TrimSpace(strings.TrimPrefix(text, "/announce"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/transfer-owner" || strings.HasPrefix(text, "/transfer-owner ") {
+			if cmd := m.transferOwnership(strings.TrimSpace(strings.TrimPrefix(text, "/transfer-owner"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/rekey" {
+			if cmd := m.rekeyCommand(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/voice-grant" || text == "/voice-revoke" {
+			if cmd := m.setPeerVoice(text == "/voice-grant"); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/open" || strings.HasPrefix(text, "/open ") {
+			m.openDownload(strings.TrimSpace(strings.TrimPrefix(text, "/open")))
+		} else if text == "/record" {
+			m.startRecording()
+		} else if text == "/record stop" {
+			if cmd := m.stopRecording(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/verify" {
+			m.verifyPeer()
+		} else if text == "/accept-key" {
+			m.acceptKey()
+		} else if text == "/admit" || strings.HasPrefix(text, "/admit ") {
+			if cmd := m.decideJoinRequest(true, strings.TrimSpace(strings.TrimPrefix(text, "/admit"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/deny" || strings.HasPrefix(text, "/deny ") {
+			if cmd := m.decideJoinRequest(false, strings.TrimSpace(strings.TrimPrefix(text, "/deny"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/ban" || strings.HasPrefix(text, "/ban ") {
+			if cmd := m.banJoinRequest(strings.TrimSpace(strings.TrimPrefix(text, "/ban"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/lock" || text == "/unlock" {
+			if cmd := m.setLocked(text == "/lock"); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/invite" {
+			if cmd := m.invite(); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/publish" || strings.HasPrefix(text, "/publish ") || text == "/unpublish" {
+			args := strings.TrimSpace(strings.TrimPrefix(text, "/publish"))
+			if text == "/publish" {
+				args = "|" // Asks for the usage rather than unlisting
+			} else if text == "/unpublish" {
+				args = ""
+			}
+			if cmd := m.publish(args); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/report" || strings.HasPrefix(text, "/report ") {
+			if cmd := m.reportPeer(strings.TrimSpace(strings.TrimPrefix(text, "/report"))); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		} else if text == "/alias" || strings.HasPrefix(text, "/alias ") {
+			m.aliasPeer(strings.TrimSpace(strings.TrimPrefix(text, "/alias")))
+		} else if text == "/copy-id" {
+			cmds = append(cmds, m.copySessionID())
+		} else if text == "/show" {
+			m.showHeld()
+		} else if text == "/session-fingerprint" {
+			if fingerprint := m.sessionFingerprint(); fingerprint != "" {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Session fingerprint: %s", fingerprint)})
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Read it to your peer over a channel you trust. If theirs differs, someone, such as the relay, is in the middle of the session."})
+			} else {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Peer is not connected or their keys are not yet available."})
+			}
 		} else if text == "/fingerprint" {
 			now := time.Now()
 			if m.MyFingerprint != "" {
@@ -253,15 +693,15 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Peer is not connected or their fingerprint is not yet available."})
 			}
-		} else {
-			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: m.Nickname, Content: text})
-			cmd := func() tea.Msg {
-				if err := network.SendData(m.Conn, m.SharedKey, protocol.TypeText, []byte(text)); err != nil {
-					return ErrorMsg{Err: err}
-				}
-				return nil
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Your Identity Fingerprint: %s", crypto.Fingerprint(m.Identity.Public().(ed25519.PublicKey)))})
+			if m.PeerIdentityFingerprint != "" {
+				m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Peer's Identity Fingerprint: %s (save it with: jot contacts add <alias> %s)", m.PeerIdentityFingerprint, m.PeerIdentityFingerprint)})
 			}
-			cmds = append(cmds, cmd)
+		} else if len(text) > protocol.MaxText {
+			m.chatArea.SetDraft(text)
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Not sent: messages may be at most %d KB. The text is back in the input; share it as a file with /send instead.", protocol.MaxText/1024)})
+		} else {
+			cmds = append(cmds, m.sendText(text))
 		}
 
 	case tea.KeyMsg:
@@ -272,93 +712,162 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else {
 			switch msg.Type {
 			case tea.KeyCtrlC, tea.KeyEsc:
+				m.saveRecordingNow()
+				m.sayGoodbye()
 				if m.Conn != nil {
 					m.Conn.Close()
 				}
+				m.abortReceiving()
 				return m, tea.Quit
 			case tea.KeyRunes:
-				if m.PendingOffer.FileName != "" && len(msg.Runes) > 0 {
+				if len(m.PendingOffers) > 0 && len(msg.Runes) > 0 {
 					switch msg.Runes[0] {
 					case 'y', 'Y':
-						m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Accepting file transfer..."})
-						metaBytes, _ := m.PendingOffer.ToJSON()
-						cmd := func() tea.Msg {
-							if err := network.SendData(m.Conn, m.SharedKey, protocol.TypeFileAccept, metaBytes); err != nil {
-								return ErrorMsg{Err: err}
-							}
-							return nil
+						if cmd := m.acceptOffer(); cmd != nil {
+							cmds = append(cmds, cmd)
 						}
-						cmds = append(cmds, cmd)
-						file, err := os.Create(filepath.Base(m.PendingOffer.FileName))
-						if err != nil {
-							m.Err = err
+						if m.Err != nil {
 							return m, tea.Quit
 						}
-						m.IsTransferring = true
-						m.IsReceiving = true
-						m.ReceivingFile = file
-						m.TotalBytesReceived = 0
-						m.Progress.SetPercent(0)
 					case 'n', 'N':
-						m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Rejected file transfer."})
-						cmd := func() tea.Msg {
-							if err := network.SendData(m.Conn, m.SharedKey, protocol.TypeFileReject, nil); err != nil {
-								return ErrorMsg{Err: err}
-							}
-							return nil
+						if t := m.transfers.find(false, m.PendingOffers[0].ID); t != nil {
+							t.finish(transferRejected, nil)
 						}
-						cmds = append(cmds, cmd)
-						m.PendingOffer = protocol.FileMetadata{}
+						m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Rejected file transfer."})
+						cmds = append(cmds, m.rejectOffer(m.PendingOffers[0]))
+						m.PendingOffers = m.PendingOffers[1:]
 					}
 				}
 			}
 		}
 
 	case tea.WindowSizeMsg:
-		headerHeight := lipgloss.Height(m.headerView())
-		var currentFooterHeight int
-		if m.IsTransferring || m.PendingOffer.FileName != "" {
-			currentFooterHeight = 1 + TextareaStyle.GetVerticalBorderSize()
-		} else {
-			currentFooterHeight = 0
-		}
-		verticalMargin := headerHeight + currentFooterHeight
-		chatAreaHeight := msg.Height - verticalMargin
-		if chatAreaHeight < 0 {
-			chatAreaHeight = 0
-		}
-		m.chatArea.SetDimensions(msg.Width, chatAreaHeight)
+		m.width, m.height, m.chatHeight = msg.Width, msg.Height, -1
 		StatusStyle = StatusStyle.Width(msg.Width)
-		TextareaStyle = TextareaStyle.Width(msg.Width)
+		TextareaStyle = TextareaStyle.Width(msg.Width - TextareaStyle.GetHorizontalBorderSize()) // lipgloss widths leave out borders
 		progressContainerContentWidth := msg.Width - TextareaStyle.GetHorizontalBorderSize() - TextareaStyle.GetHorizontalPadding()
 		if progressContainerContentWidth < 0 {
 			progressContainerContentWidth = 0
 		}
 		m.Progress.Width = progressContainerContentWidth
+		m.layout()
 
 	case ConnectionMsg:
-		m.Conn = msg.Conn
+		m.Conn = network.NewCoalescingConn(msg.Conn)
 		m.Status = "CONNECTING: Performing key exchange..."
 		m.IsConnected = true
-		go network.ListenForMessages(m.Conn, nil, &programMessageSender{program: m.Program}, m.Command == "CREATE")
+		go network.ListenForMessages(m.Conn, crypto.SessionKeys{Identity: m.Identity, Cipher: m.Cipher, Password: m.password}, &programMessageSender{program: m.Program}, m.Command == "CREATE")
+		if m.ownerKey != "" {
+			cmds = append(cmds, func() tea.Msg { return FocusTextareaMsg{} })
+		}
+		// A rejoined session needs no waiting room or lock, since only the
+		// peer knows its ID.
+		if m.ownerKey != "" && !m.rejoining {
+			cmds = append(cmds, m.openControl())
+		}
+
+	case ControlOpenedMsg:
+		if msg.Err != nil {
+			// Without a waiting room the control connection is only needed for /lock.
+			if m.WaitingRoom {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not open the waiting room: %v. Nobody can join until you restart the session.", msg.Err)})
+			}
+			break
+		}
+		m.control = msg.Control
+		if m.WaitingRoom {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Waiting room is open. You will be asked to /admit or /deny everyone who tries to join."})
+		}
+		go watchControl(m.control, m.Program)
+
+	case ControlEventMsg:
+		if cmd := m.handleControlEvent(msg); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case ConnectRetryMsg:
+		m.Status = fmt.Sprintf("Retrying the connection to %s in %s (%d of %d)...", m.RelayServerAddr, msg.Wait, msg.Retry, msg.Of)
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Could not reach the relay server: %v", msg.Err)})
+
+	case ResumeMsg:
+		switch msg.Event {
+		case network.ResumeLost:
+			m.Status = "RECONNECTING: Lost the connection to the relay, resuming the session..."
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Lost the connection to the relay server; trying to resume the session."})
+		case network.ResumeDone:
+			m.Status = "CONNECTED: Session resumed"
+			content := "Reconnected to the relay server; no messages were lost."
+			switch {
+			case msg.Presence == nil:
+			case msg.Presence.PeerConnected:
+				content += fmt.Sprintf(" %s stayed connected meanwhile.", m.peerName())
+			default:
+				content += fmt.Sprintf(" %s lost their connection too, %s ago; the relay is holding the session for them to come back.", m.peerName(), msg.Presence.PeerAway)
+			}
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: content})
+		case network.ResumeFailed:
+			m.Status = "DISCONNECTED: The session could not be resumed"
+		}
+
+	case WaitingForApprovalMsg:
+		m.Status = "WAITING: The session owner must admit you..."
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Your nickname and identity fingerprint were sent to the session owner, who decides whether to let you in."})
 
-	case SharedKeyMsg:
-		m.SharedKey = msg.Key
+	case KnockPromptMsg:
+		m.knockReply = msg.Reply
+		m.Status = "LOCKED: Type a note asking the owner to let you in, or /cancel"
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("This session is locked. Type a short note (at most %d characters) to knock, and the owner decides whether to let you in. Type /cancel to give up.", maxKnockNote)})
+		cmds = append(cmds, func() tea.Msg { return FocusTextareaMsg{} })
+
+	case SessionKeysMsg:
+		m.Keys = msg.Keys
+		m.freshKeys()
 		m.Status = fmt.Sprintf("CONNECTED to %s: Exchanging nicknames...", m.Conn.RemoteAddr().String())
+		hello := protocol.Hello{MaxFileSize: m.MaxFileSize, Compression: []string{protocol.CompressionDeflate}, Acks: true, DataConnections: true, Keepalives: true, Blobs: true, Polls: true, Announcements: true, Rejoins: true, Handoffs: true, Observer: m.observing, Rekeys: true, Cancels: true}
+		if m.Command == "CREATE" {
+			hello.Label = m.Label
+			hello.RejoinID = m.rejoinID
+		}
 		cmd := func() tea.Msg {
-			if err := network.SendData(m.Conn, m.SharedKey, protocol.TypeNickname, []byte(m.Nickname)); err != nil {
+			// The hello goes out first, in the same command, so the peer knows our limits before it sees us.
+			if err := network.SendJSON(m.Conn, m.Keys, protocol.TypeHello, hello); err != nil {
+				return ErrorMsg{Err: err}
+			}
+			if err := network.SendData(m.Conn, m.Keys, protocol.TypeNickname, []byte(m.Nickname)); err != nil {
 				return ErrorMsg{Err: err}
 			}
 			return nil
 		}
-		cmds = append(cmds, cmd)
+		cmds = append(cmds, cmd, m.openNotices())
+
+	case NoticesOpenedMsg:
+		// Relays without notices, and sessions joined through federation, simply go without.
+		if msg.Err == nil {
+			go watchNotices(msg.Notices, m.Program)
+			cmds = append(cmds, m.startHeartbeat(msg.Notices))
+		}
+
+	case noticesClosedMsg:
+		if msg.notices == m.notices {
+			m.notices, m.lastPong, m.relayRTT = nil, time.Time{}, 0
+		}
+
+	case heartbeatTickMsg:
+		if msg.notices == m.notices {
+			cmds = append(cmds, m.pingRelay(time.Now()))
+		}
+
+	case RelayNoticeMsg:
+		if cmd := m.handleRelayNotice(msg.Event); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
 
 	case MyPublicKeyMsg:
-		hash := sha256.Sum256(msg.PublicKey)
-		m.MyFingerprint = fmt.Sprintf("%x", hash[:8])
+		m.myExchangeKey = msg.PublicKey
+		m.MyFingerprint = crypto.Fingerprint(msg.PublicKey)
 	case PeerPublicKeyMsg:
-		hash := sha256.Sum256(msg.PublicKey)
-		m.PeerFingerprint = fmt.Sprintf("%x", hash[:8])
+		m.peerExchangeKey = msg.PublicKey
+		m.PeerFingerprint = crypto.Fingerprint(msg.PublicKey)
 		now := time.Now()
 		if m.MyFingerprint == "" {
 			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Attempting to display fingerprints; your own fingerprint is not yet available."})
@@ -368,83 +877,317 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Peer's Key Fingerprint: %s", m.PeerFingerprint)})
 		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Please verify these fingerprints with your peer through a trusted channel."})
 
+	case PeerIdentityMsg:
+		if err := m.checkRejoinedIdentity(msg.PublicKey); err != nil {
+			m.Conn.Close()
+			m.Err = err
+			return m, tea.Quit
+		}
+		m.peerIdentity = msg.PublicKey
+		m.PeerIdentityFingerprint = crypto.Fingerprint(msg.PublicKey)
+		if m.admittedFingerprint != "" && m.admittedFingerprint != m.PeerIdentityFingerprint {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("WARNING: your peer's identity key (%s) is not the one announced in the waiting room (%s).", m.PeerIdentityFingerprint, m.admittedFingerprint)})
+		}
+		if m.Contacts != nil {
+			if contact, ok := m.Contacts.Lookup(m.PeerIdentityFingerprint); ok {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Your peer's identity key belongs to your contact %s.", contact.Label())})
+			}
+		}
+		if fingerprint := m.sessionFingerprint(); fingerprint != "" {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Session fingerprint: %s. If your peer sees the same, nobody is in the middle.", fingerprint)})
+		}
+
+	case transferredMsg:
+		m.transferred(msg)
+
+	case InviteMsg:
+		if msg.Err != nil {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not create an invite: %v", msg.Err)})
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Invite token: %s", msg.Token)})
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "It can be used once, in place of the session ID. From now on the relay only lets peers join with an invite."})
+		}
+
+	case PublishMsg:
+		m.published(msg)
+
+	case ReportMsg:
+		if msg.Err != nil {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not file the report: %v", msg.Err)})
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Report %s filed with the relay's operator.", msg.ID)})
+		}
+
+	case PeerHelloMsg:
+		m.PeerMaxFileSize = msg.Hello.MaxFileSize
+		m.peerInflates = slices.Contains(msg.Hello.Compression, protocol.CompressionDeflate)
+		m.peerAcks = msg.Hello.Acks
+		m.peerDataConns = msg.Hello.DataConnections
+		m.peerBlobs = msg.Hello.Blobs
+		m.peerPolls = msg.Hello.Polls
+		m.peerAnnouncements = msg.Hello.Announcements
+		m.peerRejoins = msg.Hello.Rejoins
+		m.peerHandoffs = msg.Hello.Handoffs
+		m.peerRekeys = msg.Hello.Rekeys
+		m.peerCancels = msg.Hello.Cancels
+		if m.peerRekeys {
+			cmds = append(cmds, m.startRekeyTimer())
+		}
+		if m.Command == "JOIN" {
+			m.Label = sanitizeRelayText(msg.Hello.Label)
+			m.rejoinID = msg.Hello.RejoinID
+		}
+		if msg.Hello.Keepalives && !m.peerKeepalives {
+			cmds = append(cmds, m.startLiveness())
+		}
+
 	case ReceivedNicknameMsg:
-		m.PeerNickname = msg.Nickname
-		m.Status = fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), m.PeerNickname)
+		m.PeerNickname = clipText(sanitizeRelayText(msg.Nickname), protocol.MaxNickname)
 		m.IsReady = true
-		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Welcome to secure chat! You are %s, connected to %s. Type /help for a list of commands or /send <file_path> to send a file.", m.Nickname, m.PeerNickname)})
+		m.refreshPeerTrust()
+		m.Status = m.chattingStatus()
+		if m.rejoining {
+			m.rejoining = false
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s is back. The chat carries on under new keys, so compare the new session fingerprint to be sure.", m.peerName())})
+			cmds = append(cmds, func() tea.Msg { return FocusTextareaMsg{} })
+			if m.announcing {
+				cmds = append(cmds, m.sendAnnouncement())
+			}
+			break
+		}
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Welcome to secure chat! You are %s, connected to %s. Type /help for a list of commands or /send <file_path> to send a file.", m.Nickname, m.peerName())})
+		if m.PeerTrust == trust.Changed {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("WARNING: %s's key fingerprint has CHANGED since you verified it. Do not trust this peer until you re-verify the fingerprint out of band.", m.peerName())})
+			m.hasWarnedTrust = true
+		}
+		m.checkPin()
+		m.Status = m.chattingStatus()
 		cmds = append(cmds, func() tea.Msg { return FocusTextareaMsg{} })
+		cmds = append(cmds, m.alert(config.EventJoin))
+		if m.announcing {
+			cmds = append(cmds, m.sendAnnouncement())
+		}
 
 	case ReceivedTextMsg:
-		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: m.PeerNickname, Content: msg.Text})
+		if m.PeerTrust != trust.Verified && !m.hasWarnedTrust {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Warning: %s is not verified. Compare fingerprints out of band and run /verify once they match.", m.peerName())})
+			m.hasWarnedTrust = true
+		}
+		m.heard()
+		received := Message{Timestamp: time.Now(), Sender: m.peerName(), Content: msg.Text, Trust: m.PeerTrust, Signature: msg.Signature}
+		if cmd := m.ackText(); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		if cmd := m.countForRekey(); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+		if len(msg.Text) > protocol.MaxText {
+			m.Messages = append(m.Messages, Message{Timestamp: received.Timestamp, Sender: "Error", Content: fmt.Sprintf("Dropped a %d KB message from %s: messages may be at most %d KB.", len(msg.Text)/1024, m.peerName(), protocol.MaxText/1024)})
+		} else if m.peerObserver {
+			m.Messages = append(m.Messages, Message{Timestamp: received.Timestamp, Sender: "Error", Content: fmt.Sprintf("Ignored a message from %s, who joined as an observer and may not post.", m.peerName())})
+		} else if m.keyChanged {
+			m.holdForKey(received)
+		} else if m.throttled(received.Timestamp) {
+			m.holdBack(received)
+		} else {
+			m.Messages = append(m.Messages, received)
+			cmds = append(cmds, m.alert(m.textEvent(msg.Text)))
+			if m.OnMessageCommand != "" {
+				// The peer decides how often this fires, so bound the number of hook processes.
+				if m.runningMessageHooks < maxRunningMessageHooks {
+					m.runningMessageHooks++
+					cmds = append(cmds, m.runOnMessageHook(msg))
+				} else {
+					m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: "On-message hook skipped: too many hooks are still running."})
+				}
+			}
+			if cmd := m.notifyDetached(fmt.Sprintf("New message from %s", m.peerName()), msg.Text); cmd != nil {
+				cmds = append(cmds, cmd)
+			}
+		}
+
+	case TextSentMsg:
+		m.textSent(msg)
+		if msg.Err != nil {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not send your message: %v", msg.Err)})
+		} else if cmd := m.countForRekey(); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case RekeyedMsg:
+		m.rekeyed(msg)
+
+	case rekeyStartedMsg:
+		m.rekeyStarted(msg)
+
+	case rekeyTickMsg:
+		if msg.seq == m.rekeySeq {
+			cmds = append(cmds, m.checkRekeyTimer(time.Now()))
+		}
+
+	case PollMsg:
+		if cmd := m.pollOpened(msg.Poll); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case AnnouncementMsg:
+		m.announced(msg.Announcement)
+
+	case VoteMsg:
+		if cmd := m.voted(msg.Vote); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case BlobMsg:
+		if cmd := m.blobOffered(msg.Blob); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case UploadedMsg:
+		m.uploaded(msg)
+
+	case FetchedMsg:
+		if cmd := m.fetched(msg); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case AckMsg:
+		m.heard()
+		m.acknowledged(msg.Ack)
+
+	case KeepaliveMsg:
+		m.heard()
+
+	case editorDoneMsg:
+		if cmd := m.editorDone(msg); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case APIRequestMsg:
+		response, cmd := m.answerAPI(msg.Request)
+		msg.Reply <- response
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case reminderDueMsg:
+		if cmd := m.reminderDue(msg.id); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+
+	case livenessTickMsg:
+		if msg.seq == m.livenessSeq {
+			cmds = append(cmds, m.checkLiveness(time.Now()))
+		}
 
 	case FileOfferMsg:
-		m.PendingOffer = msg.Metadata
+		msg.Metadata.FileName = filetransfer.SanitizeFileName(msg.Metadata.FileName)
+		offered := &transfer{id: msg.Metadata.ID, peer: m.peerName(), fileName: msg.Metadata.FileName, size: msg.Metadata.FileSize, status: transferOffered}
+		m.transfers.add(offered)
+		if reason := m.offerLimitExceeded(msg.Metadata); reason != "" {
+			offered.finish(transferRejected, errors.New(reason))
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Automatically rejected file offer %s (%.2f MB): %s", msg.Metadata.FileName, float64(msg.Metadata.FileSize)/1024/1024, reason)})
+			cmds = append(cmds, m.rejectOffer(msg.Metadata))
+			break
+		}
+		m.PendingOffers = append(m.PendingOffers, msg.Metadata)
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Peer wants to send you a file: %s (%.2f MB). Accept? (y/n)", msg.Metadata.FileName, float64(msg.Metadata.FileSize)/1024/1024)})
 		m.Status = fmt.Sprintf("TRANSFERRING: Receiving file offer for %s", msg.Metadata.FileName)
+		if cmd := m.notifyDetached(fmt.Sprintf("%s wants to send you a file", m.peerName()), msg.Metadata.FileName); cmd != nil {
+			cmds = append(cmds, cmd)
+		}
 
 	case FileOfferAcceptedMsg:
+		// Only stream files we actually offered; the peer never chooses which local file is read.
+		filePath, ok := m.OutgoingOffers[msg.Metadata.ID]
+		if !ok {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Peer accepted an unknown file offer (%s); ignoring it.", msg.Metadata.FileName)})
+			break
+		}
+		delete(m.OutgoingOffers, msg.Metadata.ID)
+		sending := m.transfers.find(true, msg.Metadata.ID)
+		if sending == nil {
+			sending = &transfer{id: msg.Metadata.ID, outgoing: true, peer: m.peerName(), fileName: msg.Metadata.FileName, path: filePath, size: msg.Metadata.FileSize}
+			m.transfers.add(sending)
+		}
+		sending.status, sending.started, sending.stop = transferActive, time.Now(), make(chan struct{})
 		m.IsAwaitingAcceptance = false
 		m.IsTransferring = true
 		m.Progress.SetPercent(0)
-		m.Status = fmt.Sprintf("TRANSFERRING: Sending %s", filepath.Base(msg.Metadata.OriginalPath))
+		m.Status = fmt.Sprintf("TRANSFERRING: Sending %s", filepath.Base(filePath))
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Peer accepted file: %s. Starting transfer...", msg.Metadata.FileName)})
-		cmds = append(cmds, func() tea.Msg {
-			filetransfer.SendFileChunks(m.Conn, m.SharedKey, msg.Metadata.OriginalPath, &programMessageSender{program: m.Program})
-			return nil
-		})
+		cmds = append(cmds, m.sendFile(filePath, msg.Metadata, sending.stop))
 
 	case FileOfferRejectedMsg:
-		m.IsAwaitingAcceptance = false
-		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Peer rejected the file transfer."})
-		if m.IsConnected {
-			m.Status = fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), m.PeerNickname)
-		} else {
-			m.Status = "Idle"
-		}
+		m.offerRejected(msg)
 
 	case FileOfferFailedMsg:
+		if t := m.transfers.outgoing(transferOffered); t != nil {
+			delete(m.OutgoingOffers, t.id)
+			t.finish(transferFailed, errors.New(msg.Reason))
+		}
 		m.IsAwaitingAcceptance = false
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: "File offer failed: " + msg.Reason})
 		if m.IsConnected {
-			m.Status = fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), m.PeerNickname)
+			m.Status = m.chattingStatus()
 		} else {
 			m.Status = "Idle"
 		}
 
 	case FileSendingCompleteMsg:
+		if t := m.transfers.outgoing(transferActive); t != nil {
+			t.percent = 1
+			t.finish(transferDone, nil)
+		}
 		m.IsTransferring = false
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "File transfer complete."})
+		cmds = append(cmds, m.alert(config.EventFile))
 		if m.IsConnected {
-			m.Status = fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), m.PeerNickname)
+			m.Status = m.chattingStatus()
 		} else {
 			m.Status = "Idle"
 		}
 
+	case TransferFailedMsg:
+		m.transferFailed(msg)
+
 	case FileChunkMsg:
 		if m.IsReceiving && m.ReceivingFile != nil {
-			bytesWritten, err := m.ReceivingFile.Write(msg.Chunk)
-			if err != nil {
-				m.Err = err
-				return m, tea.Quit
+			if _, err := m.ReceivingFile.Write(msg.Chunk); err != nil {
+				m.abortReceiving()
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("File transfer aborted: %v", err)})
+				m.Status = m.chattingStatus()
+				return m, tea.Batch(cmds...)
+			}
+			progressVal := float64(m.ReceivingFile.Written()) / float64(m.ReceivingFile.Metadata.FileSize)
+			if t := m.transfers.find(false, m.ReceivingFile.Metadata.ID); t != nil {
+				t.percent = progressVal
 			}
-			m.TotalBytesReceived += int64(bytesWritten)
-			progressVal := float64(m.TotalBytesReceived) / float64(m.PendingOffer.FileSize)
 			cmds = append(cmds, m.Progress.SetPercent(progressVal))
 		}
 
 	case FileDoneMsg:
 		if m.IsTransferring {
 			if m.IsReceiving {
-				m.ReceivingFile.Close()
+				receiving := m.transfers.find(false, m.ReceivingFile.Metadata.ID)
+				savedPath, cmd, err := m.downloaded(m.ReceivingFile, receiving)
+				switch {
+				case err != nil:
+					m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("File transfer failed: %v", err)})
+				case savedPath == "":
+					m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("File transfer complete. %s is saved once the post-receive hook passes it.", m.ReceivingFile.Name())})
+				default:
+					m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("File transfer complete. Saved as %s (use /open %s to open it)", savedPath, filepath.Base(savedPath))})
+				}
+				cmds = append(cmds, cmd)
 				m.ReceivingFile = nil
-				m.PendingOffer = protocol.FileMetadata{}
+			} else {
+				m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "File transfer complete."})
 			}
 			m.IsTransferring = false
 			m.IsReceiving = false
-			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "File transfer complete."})
 			if m.IsConnected {
-				m.Status = fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), m.PeerNickname)
+				m.Status = m.chattingStatus()
 			} else {
 				m.Status = "Idle"
 			}
@@ -452,36 +1195,161 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case FileTransferProgress:
 		percent := float64(msg)
+		if m.IsReceiving && m.ReceivingFile != nil {
+			if t := m.transfers.find(false, m.ReceivingFile.Metadata.ID); t != nil {
+				t.percent = percent
+			}
+		} else if t := m.transfers.outgoing(transferActive); t != nil {
+			t.percent = percent
+		}
 		cmds = append(cmds, m.Progress.SetPercent(percent))
 		if percent >= 1.0 && !m.IsReceiving {
 			cmds = append(cmds, func() tea.Msg { return FileSendingCompleteMsg{} })
 		}
 
+	case PostReceiveResultMsg:
+		m.postReceiveResult(msg)
+
+	case OnMessageResultMsg:
+		m.runningMessageHooks--
+		switch {
+		case msg.Err != nil:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("On-message hook failed to run: %v", msg.Err)})
+		case msg.Result.ExitCode != 0:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("On-message hook exited with status %d. %s", msg.Result.ExitCode, msg.Result.Output)})
+		}
+
+	case AlertResultMsg:
+		m.runningAlertCommands--
+		switch {
+		case msg.Err != nil:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Alert command for %s failed to run: %v", msg.Event, msg.Err)})
+		case msg.Result.ExitCode != 0:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Alert command for %s exited with status %d. %s", msg.Event, msg.Result.ExitCode, msg.Result.Output)})
+		}
+
+	case relativeTickMsg:
+		// Nothing changes but the time; the redraw after Update updates them.
+		if m.relativeTimes && msg.seq == m.relativeSeq {
+			cmds = append(cmds, m.relativeTick())
+		}
+
+	case flashDoneMsg:
+		if msg.seq == m.flashSeq {
+			m.flashing = false
+		}
+
+	case PeerRecordingMsg:
+		m.PeerRecording = msg.Recording.Active
+		if m.PeerRecording {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("%s started RECORDING this session. Everything from now on, including files you send, is saved to their encrypted archive. Leave the session if you do not consent.", m.peerName())})
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s stopped recording this session.", m.peerName())})
+		}
+		if m.IsConnected {
+			m.Status = m.chattingStatus()
+		}
+
+	case RecordingSavedMsg:
+		switch {
+		case msg.Err != nil:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not write the recording archive: %v", msg.Err)})
+		case len(msg.Skipped) > 0:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Recording saved to %s. Files no longer on disk were left out: %s. Extract it with: jot unpack %s", msg.Path, strings.Join(msg.Skipped, ", "), msg.Path)})
+		default:
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Recording saved to %s. Extract it with: jot unpack %s", msg.Path, msg.Path)})
+		}
+
+	case NotifyResultMsg:
+		if msg.Err != nil {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Push notification failed: %v", msg.Err)})
+		}
+
 	case InfoMsg:
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: msg.Info})
 
+	case UnknownMessageMsg:
+		m.unknownMessages++
+		if m.unknownMessages == 1 {
+			m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s seems to use a newer version of Jot and sent something this version does not understand (message type %d). It was skipped, as is anything else unknown from now on; update Jot to see it.", m.peerName(), msg.Type)})
+		}
+
+	case ByeMsg:
+		m.peerLeft = true
+
+	case rejoinMsg:
+		cmds = append(cmds, m.rejoined(msg))
+
+	case rejoinTimeoutMsg:
+		cmds = append(cmds, m.rejoinTimedOut(msg.seq))
+
+	case ConnectionLostMsg:
+		if m.canRejoin() {
+			cmds = append(cmds, m.rejoin(msg.Err))
+			break
+		}
+		if m.rejoining {
+			cmds = append(cmds, m.giveUpRejoining(msg.Err))
+			break
+		}
+		m.saveRecordingNow()
+		m.abortReceiving()
+		m.Err = msg.Err
+		return m, tea.Quit
+
 	case ConnectionClosedMsg:
+		if m.canRejoin() {
+			cmds = append(cmds, m.rejoin(errors.New("the relay ended it")))
+			break
+		}
+		if m.rejoining {
+			cmds = append(cmds, m.giveUpRejoining(errors.New("the relay ended the session before your peer came back")))
+			break
+		}
+		m.abortReceiving()
+		m.transfers.interrupt(errors.New("the session ended"))
 		m.IsConnected = false
 		m.Status = "DISCONNECTED: Connection closed by server (session may have timed out)."
+		if m.peerLeft {
+			m.Status = fmt.Sprintf("DISCONNECTED: %s left the session.", m.peerName())
+		}
 		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: m.Status})
+		cmds = append(cmds, m.alert(config.EventLeave))
+		if m.Recording != nil {
+			cmds = append(cmds, m.stopRecording())
+		}
 
 	case ErrorMsg:
+		if m.rejoining {
+			// Sends still under way on the lost connection fail; that is expected.
+			break
+		}
+		m.saveRecordingNow()
+		m.abortReceiving()
 		m.Err = msg.Err
 		return m, tea.Quit
 	}
 
+	m.trimHistory()
 	return m, tea.Batch(cmds...)
 }
 
 func (m *Model) View() string {
 	if m.Err != nil {
+		if m.relayNotice != "" {
+			return fmt.Sprintf("An error occurred: %v\nRelay: %s\n\nPress Ctrl+C to quit.", m.Err, m.relayNotice)
+		}
 		return fmt.Sprintf("An error occurred: %v\n\nPress Ctrl+C to quit.", m.Err)
 	}
 
 	if m.ShowHelp {
 		return m.helpView()
 	}
+	if m.transfers.open {
+		return m.transfers.View(m.width)
+	}
 
+	m.layout()
 	chatAreaViewString := m.chatArea.View(m.Messages)
 	footerString := m.footerView()
 
@@ -503,33 +1371,375 @@ func (m *Model) View() string {
 func (m *Model) helpView() string {
 	return lipgloss.NewStyle().Padding(1, 2).Border(lipgloss.RoundedBorder()).Render(
 		"Available Commands:\n" +
-			"  /send <file_path> - Send a file\n" +
+			"  /send <file_path> - Send a file (append 'as <name>' to offer it under another name)\n" +
+			"  /cat <file_path>  - Share a small text file as a code block message\n" +
+			"  /edit [text]      - Write a long or multi-line message in $EDITOR; it is sent when you save and quit\n" +
 			"  /help             - Toggle this help message\n" +
+			"  /timestamps       - Switch between HH:MM and \"2m ago\" timestamps\n" +
+			"  /search [text]    - Highlight messages containing text (no text ends the search)\n" +
+			"  /show             - Show the peer's messages held back because they came too fast\n" +
 			"  /quit             - Disconnect and exit (Ctrl+C/Esc also works)\n" +
-			"  /fingerprint      - Show your and peer's key fingerprints\n" +
+			"  /fingerprint      - Show your and peer's key fingerprints; /session-fingerprint shows one string for both of you to compare\n" +
+			"  /copy-id          - Show the full session ID and copy it to the clipboard\n" +
+			"  /verify           - Mark the peer's current fingerprint as verified\n" +
+			"  /rekey            - Replace the session keys with fresh ones, so keys stolen later cannot read what came before\n" +
+			"  /accept-key       - Pin the peer's new identity key after it changed, and show their held messages\n" +
+			"  /invite           - Get a single-use join token for this session (session owner only)\n" +
+			"  /admit [nickname] - Let a client waiting to join into the session (-waiting-room)\n" +
+			"  /deny [nickname]  - Turn a client waiting to join away (-waiting-room)\n" +
+			"  /ban [-ip] [nick] - Turn a waiting client away and keep their key (and IP) out until the session ends\n" +
+			"  /lock, /unlock    - Make joiners knock before they get in (session owner only)\n" +
+			"  /publish <name> [| topic] - List the session in the relay's directory; /unpublish removes it\n" +
+			"  /alias <nick> <a> - Always show the peer's identity key as <a>\n" +
+			"  /report <nick>    - Report the peer to the relay's operator, with an optional note after the nickname\n" +
+			"  /upload <path>    - Leave an encrypted file on the relay for the peer to /fetch later\n" +
+			"  /fetch [name]     - Download a file the peer left on the relay (the latest if no name is given)\n" +
+			"  /poll \"q\" a b ... - Ask the peer a question; vote with Alt+1 to Alt+9 or /vote <number>\n" +
+			"  /announce on|off  - Let only you post (session owner only); /voice-grant and /voice-revoke let the peer post too\n" +
+			"  /transfer-owner <nick> - Hand the session, its lock and listing to the peer (session owner only)\n" +
+			"  /remind 10m text  - Remind yourself later (-send sends it to the peer; /remind lists, /remind cancel <n>)\n" +
+			"  /open [name]      - Open a received file (the latest if no name is given)\n" +
+			"  /transfers        - List the files sent and received this session, to open, cancel or retry them\n" +
+			"  /record [stop]    - Record the session to an encrypted archive (session owner only)\n" +
 			"\nKeybindings:\n" +
 			"  Ctrl+C/Esc        - Disconnect and exit\n" +
 			"  Enter             - Send message\n" +
+			"  Ctrl+X Ctrl+E     - Continue the message in $EDITOR, as in bash\n" +
+			"  PgUp/PgDn         - Scroll through the messages\n" +
+			"  Home/End          - Jump to the first or the latest message\n" +
+			"  n/N, F3/Shift+F3  - Jump to the previous/next search match (n/N with an empty input)\n" +
 			"\nFile Transfer:\n" +
 			"  'y' or 'Y'        - Accept incoming file offer\n" +
 			"  'n' or 'N'        - Reject incoming file offer\n" +
+			"  o, c, r           - Open, cancel or retry the chosen transfer in /transfers\n" +
 			"\n(Press Esc to close this help menu)",
 	)
 }
 
 func (m *Model) headerView() string {
-	if m.SessionID != "" {
-		return StatusStyle.Render(fmt.Sprintf("%s | Session ID: %s", m.Status, m.SessionID))
+	style := StatusStyle
+	if m.flashing {
+		style = style.Reverse(true)
 	}
-	return StatusStyle.Render(m.Status)
+	return style.Render(m.header())
 }
 
 func (m *Model) footerView() string {
 	if m.IsTransferring {
 		return TextareaStyle.Render(m.Progress.View())
 	}
-	if m.PendingOffer.FileName != "" {
-		return TextareaStyle.Render("Accept file? (y/n)")
+	if len(m.PendingOffers) > 0 {
+		prompt := fmt.Sprintf("Accept %s? (y/n)", m.PendingOffers[0].FileName)
+		if len(m.PendingOffers) > 1 {
+			prompt += fmt.Sprintf(" (%d more waiting)", len(m.PendingOffers)-1)
+		}
+		return TextareaStyle.Render(prompt)
 	}
 	return ""
 }
+
+// chattingStatus returns the status line shown while connected to a peer.
+func (m *Model) chattingStatus() string {
+	peer := m.peerName()
+	switch m.PeerTrust {
+	case trust.Verified:
+		peer += " ✓"
+	case trust.Changed:
+		peer += " (KEY CHANGED)"
+	}
+	if m.keyChanged {
+		peer += " (IDENTITY CHANGED)"
+	}
+	if m.peerObserver {
+		peer += " 👁"
+	}
+	status := fmt.Sprintf("CONNECTED to %s: Chatting with %s", m.Conn.RemoteAddr().String(), peer)
+	if m.Recording != nil || m.PeerRecording {
+		status += " | ● REC"
+	}
+	if m.relayNotice != "" {
+		status += " | " + m.relayNotice
+	}
+	return status
+}
+
+// peerName returns the name the peer is displayed under: the alias of a saved
+// contact with the peer's identity key, or otherwise the nickname they chose.
+func (m *Model) peerName() string {
+	if m.Contacts != nil && m.PeerIdentityFingerprint != "" {
+		if contact, ok := m.Contacts.Lookup(m.PeerIdentityFingerprint); ok {
+			return contact.Label()
+		}
+	}
+	return m.PeerNickname
+}
+
+// refreshPeerTrust looks up the peer's current fingerprint in the trust store.
+func (m *Model) refreshPeerTrust() {
+	if m.TrustStore == nil || m.PeerNickname == "" || m.PeerFingerprint == "" {
+		m.PeerTrust = trust.Unverified
+		return
+	}
+	m.PeerTrust = m.TrustStore.Status(m.PeerNickname, m.PeerFingerprint)
+}
+
+// verifyPeer persists the peer's current fingerprint as verified.
+func (m *Model) verifyPeer() {
+	now := time.Now()
+	if m.TrustStore == nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Trust store is not available."})
+		return
+	}
+	if m.PeerNickname == "" || m.PeerFingerprint == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Peer is not connected or their fingerprint is not yet available."})
+		return
+	}
+	m.TrustStore.Verify(m.PeerNickname, m.PeerFingerprint)
+	if err := m.TrustStore.Save(); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not save trust store: %v", err)})
+		return
+	}
+	m.refreshPeerTrust()
+	m.Status = m.chattingStatus()
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Marked %s (%s) as verified.", m.PeerNickname, m.PeerFingerprint)})
+}
+
+// sessionFingerprint returns the fingerprint of the session's keys, which is
+// the same on both ends unless someone is in the middle, or "" until the
+// peer's keys are known.
+func (m *Model) sessionFingerprint() string {
+	if m.Keys == nil || m.myExchangeKey == nil || m.peerExchangeKey == nil || m.peerIdentity == nil {
+		return ""
+	}
+	mine := m.Identity.Public().(ed25519.PublicKey)
+	if m.Keys.IsInitiator {
+		return crypto.SessionFingerprint(m.myExchangeKey, m.peerExchangeKey, mine, m.peerIdentity)
+	}
+	return crypto.SessionFingerprint(m.peerExchangeKey, m.myExchangeKey, m.peerIdentity, mine)
+}
+
+// invite asks the relay for a single-use join token for our session.
+func (m *Model) invite() tea.Cmd {
+	if m.ownerKey == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Only the participant who created the session can create invites."})
+		return nil
+	}
+	if m.IsReady || m.rejoining {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Your peer has already joined; the session is full."})
+		return nil
+	}
+	if m.Conn == nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Not connected to the relay yet."})
+		return nil
+	}
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		token, err := network.Invite(opts, sessionID)
+		return InviteMsg{Token: token, Err: err}
+	}
+}
+
+// aliasPeer saves a display alias for the peer's identity key in the contacts
+// roster. args is "<nickname> <alias>", where nickname is the peer's nickname or
+// current display name; either may contain spaces.
+func (m *Model) aliasPeer(args string) {
+	now := time.Now()
+	if m.Contacts == nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Contacts are not available."})
+		return
+	}
+	if m.PeerNickname == "" || m.PeerIdentityFingerprint == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Peer is not connected or their identity key is not yet available."})
+		return
+	}
+
+	var alias string
+	for _, name := range []string{m.PeerNickname, m.peerName()} {
+		if rest, ok := strings.CutPrefix(args, name+" "); ok {
+			alias = strings.TrimSpace(rest)
+			break
+		}
+	}
+	if alias == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Usage: /alias <nickname> <alias>, e.g. /alias %s Dana", m.PeerNickname)})
+		return
+	}
+
+	previous, known := m.Contacts.Lookup(m.PeerIdentityFingerprint)
+	if err := m.Contacts.Add(alias, m.PeerIdentityFingerprint, known && previous.Verified); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: err.Error()})
+		return
+	}
+	if err := m.Contacts.Save(); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not save contacts: %v", err)})
+		return
+	}
+	m.Status = m.chattingStatus()
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s (%s) will be shown as %s from now on.", m.PeerNickname, m.PeerIdentityFingerprint, alias)})
+}
+
+// reportPeer files an abuse report about the peer with the relay. args is
+// "<nickname> [note]", where nickname is the peer's nickname or current display
+// name. The report holds both identity fingerprints and the note, never what
+// was said.
+func (m *Model) reportPeer(args string) tea.Cmd {
+	if m.PeerNickname == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Peer is not connected."})
+		return nil
+	}
+	var note string
+	named := false
+	for _, name := range []string{m.PeerNickname, m.peerName()} {
+		if args == name {
+			named = true
+			break
+		}
+		if rest, ok := strings.CutPrefix(args, name+" "); ok {
+			named, note = true, strings.TrimSpace(rest)
+			break
+		}
+	}
+	if !named {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Usage: /report <nickname> [note], e.g. /report %s sent spam links", m.PeerNickname)})
+		return nil
+	}
+	if len(note) > network.MaxReportNote {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("The note may be at most %d bytes long.", network.MaxReportNote)})
+		return nil
+	}
+
+	report := network.Report{
+		ReporterFingerprint: crypto.Fingerprint(m.Identity.Public().(ed25519.PublicKey)),
+		ReportedFingerprint: m.PeerIdentityFingerprint,
+		Note:                note,
+	}
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		id, err := network.SubmitReport(opts, sessionID, report)
+		return ReportMsg{ID: id, Err: err}
+	}
+}
+
+// abortReceiving discards a partially received file, if any.
+func (m *Model) abortReceiving() {
+	if m.ReceivingFile == nil {
+		return
+	}
+	m.ReceivingFile.Abort()
+	m.ReceivingFile = nil
+	m.IsReceiving = false
+	m.IsTransferring = false
+}
+
+// runPostReceiveHook runs the configured post-receive command on a received file
+// held at path, which is saved as name once the command passes it.
+func runPostReceiveHook(command, path, name string) tea.Cmd {
+	return func() tea.Msg {
+		// An absolute path cannot be taken for an option, whatever the file is called.
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return PostReceiveResultMsg{Path: path, Err: err}
+		}
+		result, err := hook.Run(command, []string{abs}, []string{"JOT_FILE=" + abs, "JOT_FILE_NAME=" + name}, "")
+		return PostReceiveResultMsg{Path: path, Result: result, Err: err}
+	}
+}
+
+// notifyInterval is the minimum time between push notifications, so a chatty
+// peer cannot flood the notification service.
+const notifyInterval = 30 * time.Second
+
+// notifyDetached sends a push notification if one is configured and nobody is
+// attached to the daemon. It returns nil when no notification is due.
+func (m *Model) notifyDetached(title, content string) tea.Cmd {
+	if m.Notifier == nil || m.Detached == nil || !m.Detached() {
+		return nil
+	}
+	if time.Since(m.lastNotified) < notifyInterval {
+		return nil
+	}
+	m.lastNotified = time.Now()
+	notifier := m.Notifier
+	return func() tea.Msg {
+		return NotifyResultMsg{Err: notifier.Notify(title, content)}
+	}
+}
+
+// maxRunningMessageHooks bounds how many on-message hooks may run at once.
+const maxRunningMessageHooks = 4
+
+// runOnMessageHook runs the configured on-message command for a received chat message.
+// The text is passed on stdin so it never ends up in the process list or the argument vector.
+func (m *Model) runOnMessageHook(msg ReceivedTextMsg) tea.Cmd {
+	command := m.OnMessageCommand
+	env := []string{
+		"JOT_SENDER=" + m.PeerNickname,
+		"JOT_SESSION=" + m.SessionID,
+		"JOT_VERIFIED=" + strconv.FormatBool(m.PeerTrust == trust.Verified && msg.Signature == crypto.SignatureValid),
+	}
+	return func() tea.Msg {
+		result, err := hook.Run(command, nil, env, msg.Text)
+		return OnMessageResultMsg{Result: result, Err: err}
+	}
+}
+
+// openDownload opens a file received this session, matched by name or path.
+func (m *Model) openDownload(name string) {
+	now := time.Now()
+	if len(m.Downloads) == 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "No files have been received yet."})
+		return
+	}
+	path := m.Downloads[len(m.Downloads)-1]
+	if name != "" {
+		path = ""
+		// Search newest first so repeated names open the latest copy.
+		for i := len(m.Downloads) - 1; i >= 0; i-- {
+			if m.Downloads[i] == name || filepath.Base(m.Downloads[i]) == name {
+				path = m.Downloads[i]
+				break
+			}
+		}
+		if path == "" {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("No received file named %s.", name)})
+			return
+		}
+	}
+	if err := util.OpenFile(path, m.OpenCommand); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: err.Error()})
+		return
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Opening %s...", path)})
+}
+
+// parseSendArgs splits the arguments of /send into the local path and the optional
+// name to offer it as, given with a trailing "as <name>".
+func parseSendArgs(args string) (string, string) {
+	args = strings.TrimSpace(args)
+	if i := strings.LastIndex(args, " as "); i > 0 {
+		if name := strings.TrimSpace(args[i+len(" as "):]); name != "" {
+			return strings.TrimSpace(args[:i]), filepath.Base(name)
+		}
+	}
+	return args, ""
+}
+
+// sendText shows text as our own message and sends it to the peer.
+func (m *Model) sendText(text string) tea.Cmd {
+	m.textsOut++
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: m.Nickname, Content: text, Delivery: DeliveryPending, sentID: m.textsOut})
+	return m.sendTracked(m.textsOut, text)
+}
+
+// textPayload encodes text for sending, compressing long texts when we are
+// asked to and the peer can decode them.
+func (m *Model) textPayload(text string) (byte, []byte) {
+	if !m.Compress || !m.peerInflates || len(text) < protocol.CompressThreshold {
+		return protocol.TypeText, []byte(text)
+	}
+	compressed, err := protocol.Deflate([]byte(text))
+	if err != nil || len(compressed) >= len(text) {
+		return protocol.TypeText, []byte(text)
+	}
+	return protocol.TypeTextDeflate, compressed
+}
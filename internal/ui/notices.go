@@ -0,0 +1,137 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// openNotices subscribes to the relay's notices about our end of the session.
+func (m *Model) openNotices() tea.Cmd {
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		notices, err := network.Subscribe(opts, sessionID)
+		return NoticesOpenedMsg{Notices: notices, Err: err}
+	}
+}
+
+// watchNotices forwards relay notices to the program until the connection closes.
+func watchNotices(notices *network.Control, program *tea.Program) {
+	for {
+		event, err := notices.ReadEvent()
+		if err != nil {
+			program.Send(noticesClosedMsg{notices: notices})
+			return
+		}
+		program.Send(RelayNoticeMsg{Event: event})
+	}
+}
+
+// handleRelayNotice shows a relay notice in the chat and keeps it in the status bar.
+func (m *Model) handleRelayNotice(event network.ControlEvent) tea.Cmd {
+	switch {
+	case event.Event == "pong":
+		m.pong(event.Seq, time.Now())
+		return nil
+	case event.Event == "owner" && event.Owner:
+		return m.tookOver()
+	case event.Event == "owner":
+		m.handedOver()
+		return nil
+	case event.Event == "observer":
+		m.peerObserver = true
+		if m.IsReady {
+			m.Status = m.chattingStatus()
+		}
+		return nil
+	case event.Event == "limit":
+		m.limitNotice(event)
+		return nil
+	case event.Event == "throttled":
+		m.throttledNotice(event)
+		return nil
+	case event.Event != "quota" || event.Limit <= 0:
+		return nil
+	}
+	used, limit := float64(event.Relayed)/1024/1024, float64(event.Limit)/1024/1024
+	if event.Relayed >= event.Limit {
+		m.relayNotice = "relay data budget used up"
+		m.budgetSpent = true
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("You have sent the %.0f MB the relay allows per session. The relay is ending the session; start a new one to send more.", limit)})
+	} else {
+		m.relayNotice = fmt.Sprintf("%d%% of relay data budget used", event.Relayed*100/event.Limit)
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("You have sent %.1f MB of the %.0f MB the relay allows per session. The relay ends the session once the budget is used up.", used, limit)})
+	}
+	if m.IsReady {
+		m.Status = m.chattingStatus()
+	}
+	return nil
+}
+
+// limitNotice tells us that we are approaching, or have reached, one of the
+// relay's other limits. They come over the notice connection, or over the
+// control connection for limits only the owner runs into.
+func (m *Model) limitNotice(event network.ControlEvent) {
+	if event.Limit <= 0 {
+		return
+	}
+	reached := event.Used >= event.Limit
+	var content string
+	switch event.Resource {
+	case "waiting-room":
+		m.relayNotice = fmt.Sprintf("waiting room %d/%d", event.Used, event.Limit)
+		content = fmt.Sprintf("%d of the %d places in your waiting room are taken. Once it is full, the relay turns further joiners away; /admit or /deny those waiting to make room.", event.Used, event.Limit)
+		if reached {
+			content = fmt.Sprintf("Your waiting room is full: the relay turns further joiners away until you /admit or /deny one of the %d waiting.", event.Used)
+		}
+	case "invites":
+		m.relayNotice = fmt.Sprintf("invites %d/%d", event.Used, event.Limit)
+		content = fmt.Sprintf("%d of the %d invites the relay allows for a session are unused; once all of them are, /invite cannot mint more.", event.Used, event.Limit)
+		if reached {
+			content = fmt.Sprintf("You have minted all %d invites the relay allows for a session; /invite cannot mint more.", event.Limit)
+		}
+	case "resume-buffer":
+		m.relayNotice = fmt.Sprintf("%d%% of relay buffer for %s used", event.Used*100/event.Limit, m.peerName())
+		content = fmt.Sprintf("The relay is holding %.1f MB of the %.1f MB it keeps for %s while they are away. If more piles up before they are back, the relay ends the session, so hold back large messages and files until then.", float64(event.Used)/1024/1024, float64(event.Limit)/1024/1024, m.peerName())
+	case "files":
+		m.relayNotice = fmt.Sprintf("files on relay %d/%d", event.Used, event.Limit)
+		content = fmt.Sprintf("This session keeps %d of the %d files it may leave on the relay. Each one makes room again once it expires.", event.Used, event.Limit)
+		if reached {
+			content = fmt.Sprintf("This session keeps the %d files it may leave on the relay; /upload works again once one expires. /send still works.", event.Limit)
+		}
+	default:
+		resource := clipText(sanitizeRelayText(event.Resource), 32)
+		m.relayNotice = fmt.Sprintf("relay %s %d/%d", resource, event.Used, event.Limit)
+		content = fmt.Sprintf("You are using %d of the relay's limit of %d on %s.", event.Used, event.Limit, resource)
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: content})
+	if m.IsReady {
+		m.Status = m.chattingStatus()
+	}
+}
+
+// throttledNotice tells us that the relay is slowing down, or has cut off,
+// one of us for sending faster than it allows.
+func (m *Model) throttledNotice(event network.ControlEvent) {
+	var content string
+	switch {
+	case event.Peer && event.Disconnect:
+		content = fmt.Sprintf("The relay disconnected %s for sending too fast, which ends the session.", m.peerName())
+	case event.Peer:
+		m.relayNotice = fmt.Sprintf("relay slowing down %s", m.peerName())
+		content = fmt.Sprintf("%s is sending faster than the relay allows, so the relay is slowing them down.", m.peerName())
+	case event.Disconnect:
+		m.relayNotice = "relay disconnected you"
+		content = "You kept sending faster than the relay allows, so it disconnected you, which ends the session."
+	default:
+		m.relayNotice = "relay slowing you down"
+		content = "You are sending faster than the relay allows, so it is holding back what you send. Messages and files still arrive, only later; slow down to catch up."
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: content})
+	if m.IsReady {
+		m.Status = m.chattingStatus()
+	}
+}
@@ -0,0 +1,365 @@
+package ui
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/filetransfer"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/bjarneo/jot/internal/trust"
+)
+
+// receipt records an accepted incoming transfer for rate limiting.
+type receipt struct {
+	At    time.Time
+	Bytes int64
+}
+
+// offerLimitExceeded reports why an incoming offer must be rejected automatically,
+// or an empty string if it may be shown to the user.
+func (m *Model) offerLimitExceeded(meta protocol.FileMetadata) string {
+	if m.peerObserver {
+		return "observers may not send files"
+	}
+	if meta.FileSize > m.MaxFileSize {
+		return fmt.Sprintf("file exceeds the %.2f MB limit", float64(m.MaxFileSize)/1024/1024)
+	}
+
+	concurrent := len(m.PendingOffers)
+	if m.IsReceiving {
+		concurrent++
+	}
+	if m.MaxIncomingOffers > 0 && concurrent >= m.MaxIncomingOffers {
+		return fmt.Sprintf("already %d incoming offers in progress (limit %d)", concurrent, m.MaxIncomingOffers)
+	}
+
+	if m.MaxUnverifiedBytes > 0 && m.PeerTrust != trust.Verified {
+		received := m.unverifiedBytesLastHour()
+		if received+meta.FileSize > m.MaxUnverifiedBytes {
+			return fmt.Sprintf("unverified peers may send at most %.2f MB per hour (%.2f MB used)", float64(m.MaxUnverifiedBytes)/1024/1024, float64(received)/1024/1024)
+		}
+	}
+	return ""
+}
+
+// unverifiedBytesLastHour sums the accepted transfers from unverified peers in the last hour.
+func (m *Model) unverifiedBytesLastHour() int64 {
+	cutoff := time.Now().Add(-time.Hour)
+	var total int64
+	kept := m.unverifiedReceipts[:0]
+	for _, r := range m.unverifiedReceipts {
+		if r.At.After(cutoff) {
+			total += r.Bytes
+			kept = append(kept, r)
+		}
+	}
+	m.unverifiedReceipts = kept
+	return total
+}
+
+// acceptOffer accepts the oldest pending offer and starts receiving it.
+func (m *Model) acceptOffer() tea.Cmd {
+	if m.IsReceiving {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Please wait for the current transfer to finish before accepting another file."})
+		return nil
+	}
+	offer := m.PendingOffers[0]
+	m.PendingOffers = m.PendingOffers[1:]
+
+	file, err := filetransfer.NewIncomingFile(offer)
+	if err != nil {
+		m.Err = err
+		return nil
+	}
+	if m.PeerTrust != trust.Verified {
+		m.unverifiedReceipts = append(m.unverifiedReceipts, receipt{At: time.Now(), Bytes: offer.FileSize})
+	}
+	if t := m.transfers.find(false, offer.ID); t != nil {
+		t.status, t.started = transferActive, time.Now()
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Accepting file transfer..."})
+	m.IsTransferring = true
+	m.IsReceiving = true
+	m.ReceivingFile = file
+	m.Progress.SetPercent(0)
+
+	opts, sessionID, keys, peerIdentity := m.dialOptions(), m.SessionID, m.Keys, m.peerIdentity
+	peerDataConns := m.peerDataConns && len(peerIdentity) > 0
+	peerRekeys := m.peerRekeys
+	return func() tea.Msg {
+		// Have the file sent over a data connection of its own, so chat does
+		// not wait behind it. Relays that cannot do this leave it on the
+		// session connection, where we listen as well.
+		if peerDataConns {
+			files := keys.ForFiles()
+			// A peer that can rekey takes a key of the transfer's own, so
+			// a rekey during the transfer does not change its keys.
+			if peerRekeys {
+				key := make([]byte, protocol.TransferKeySize)
+				if _, err := rand.Read(key); err != nil {
+					return ErrorMsg{Err: err}
+				}
+				if files, err = keys.ForTransfer(key); err != nil {
+					return ErrorMsg{Err: err}
+				}
+				offer.Key = key
+			}
+			if conn, _, err := network.OpenData(opts, sessionID, offer.ID); err == nil {
+				offer.DataConnection = true
+				go receiveTransfer(conn, offer.ID, file, files, peerIdentity, m.Program)
+			} else {
+				offer.Key = nil
+			}
+		}
+		if err := network.SendJSON(m.Conn, keys, protocol.TypeFileAccept, offer); err != nil {
+			return ErrorMsg{Err: err}
+		}
+		return nil
+	}
+}
+
+// receiveTransfer receives the file for offer id on a data connection
+// encrypted with keys, writing it to file as it arrives rather than through
+// the UI.
+func receiveTransfer(conn net.Conn, id string, file *filetransfer.IncomingFile, keys *crypto.SessionKeys, peerIdentity ed25519.PublicKey, program *tea.Program) {
+	defer conn.Close()
+	err := network.ReceiveTransfer(conn, keys, peerIdentity, file, file.Metadata.FileSize, &programMessageSender{program: program})
+	if err != nil && !errors.Is(err, network.ErrTransferUnused) {
+		program.Send(TransferFailedMsg{ID: id, Err: err})
+	}
+}
+
+// sendFile streams the file at filePath for the offer the peer accepted, over
+// a data connection if the peer waits for it on one, until stop is closed.
+func (m *Model) sendFile(filePath string, accepted protocol.FileMetadata, stop <-chan struct{}) tea.Cmd {
+	opts, sessionID, keys := m.dialOptions(), m.SessionID, m.Keys
+	return func() tea.Msg {
+		sender := &programMessageSender{program: m.Program}
+		if accepted.DataConnection {
+			conn, connected, err := network.OpenData(opts, sessionID, accepted.ID)
+			if err == nil && connected {
+				defer conn.Close()
+				files := keys.ForFiles()
+				if len(accepted.Key) > 0 {
+					if files, err = keys.ForTransfer(accepted.Key); err != nil {
+						return ErrorMsg{Err: err}
+					}
+				}
+				// Only this transfer is lost if its connection breaks.
+				err := filetransfer.SendFileChunks(conn, files, filePath, sender, stop)
+				if err != nil && !errors.Is(err, filetransfer.ErrCanceled) {
+					return TransferFailedMsg{ID: accepted.ID, Err: err}
+				}
+				return nil
+			}
+			if conn != nil {
+				conn.Close()
+			}
+			// The peer listens on the session connection too.
+		}
+		err := filetransfer.SendFileChunks(m.Conn, keys, filePath, sender, stop)
+		if err != nil && !errors.Is(err, filetransfer.ErrCanceled) {
+			return ErrorMsg{Err: err}
+		}
+		return nil
+	}
+}
+
+// transferFailed gives up on a file whose data connection broke.
+func (m *Model) transferFailed(msg TransferFailedMsg) {
+	now := time.Now()
+	if t := m.transfers.find(true, msg.ID); t != nil {
+		if t.status != transferActive {
+			return
+		}
+		t.finish(transferFailed, msg.Err)
+		m.IsTransferring = false
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Sending %s failed: %v", t.fileName, msg.Err)})
+		m.Status = m.chattingStatus()
+		return
+	}
+	if !m.IsReceiving || m.ReceivingFile == nil || m.ReceivingFile.Metadata.ID != msg.ID {
+		return
+	}
+	if t := m.transfers.find(false, msg.ID); t != nil {
+		t.finish(transferFailed, msg.Err)
+	}
+	m.abortReceiving()
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("File transfer aborted: %v", msg.Err)})
+	m.Status = m.chattingStatus()
+}
+
+// offerRejected handles a rejection from the peer. It rejects an offer of
+// ours, or, if the peer says Cancels in its hello, may withdraw an offer it
+// made or stop a transfer under way in either direction.
+func (m *Model) offerRejected(msg FileOfferRejectedMsg) {
+	now, id := time.Now(), msg.Metadata.ID
+	if id != "" && m.peerCancels {
+		for i, offer := range m.PendingOffers {
+			if offer.ID == id {
+				m.PendingOffers = append(m.PendingOffers[:i], m.PendingOffers[i+1:]...)
+				if t := m.transfers.find(false, id); t != nil {
+					t.finish(transferCanceled, nil)
+				}
+				m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s withdrew the offer of %s.", m.peerName(), offer.FileName)})
+				m.Status = m.chattingStatus()
+				return
+			}
+		}
+		if m.IsReceiving && m.ReceivingFile != nil && m.ReceivingFile.Metadata.ID == id {
+			if t := m.transfers.find(false, id); t != nil {
+				t.finish(transferCanceled, nil)
+			}
+			m.abortReceiving()
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s canceled the transfer of %s.", m.peerName(), msg.Metadata.FileName)})
+			m.Status = m.chattingStatus()
+			return
+		}
+		if t := m.transfers.find(true, id); t != nil && t.status == transferActive {
+			t.finish(transferCanceled, nil)
+			m.IsTransferring = false
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s canceled the transfer of %s.", m.peerName(), t.fileName)})
+			m.Status = m.chattingStatus()
+			return
+		}
+		if t := m.transfers.find(true, id); t != nil && t.status != transferOffered {
+			// The transfer has ended already.
+			return
+		}
+	}
+
+	delete(m.OutgoingOffers, id)
+	offered := m.transfers.find(true, id)
+	if id == "" {
+		offered = m.transfers.outgoing(transferOffered)
+	}
+	if offered != nil && offered.status == transferOffered {
+		offered.finish(transferRejected, nil)
+	}
+	m.IsAwaitingAcceptance = false
+	if msg.Metadata.FileName != "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Peer rejected the file transfer of %s.", msg.Metadata.FileName)})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Peer rejected the file transfer."})
+	}
+	if m.IsConnected {
+		m.Status = m.chattingStatus()
+	} else {
+		m.Status = "Idle"
+	}
+}
+
+// rejectOffer tells the peer that the given offer was declined.
+func (m *Model) rejectOffer(offer protocol.FileMetadata) tea.Cmd {
+	// Only echo the identifying fields back to the sender.
+	reply := protocol.FileMetadata{ID: offer.ID, FileName: offer.FileName, FileSize: offer.FileSize}
+	return func() tea.Msg {
+		if err := network.SendJSON(m.Conn, m.Keys, protocol.TypeFileReject, reply); err != nil {
+			return ErrorMsg{Err: err}
+		}
+		return nil
+	}
+}
+
+// downloaded saves a file received from the peer, and runs what should run for
+// every new download; t is its transfer, if it has one. With -post-receive, the
+// file stays in the quarantine directory until the hook passes it, and the
+// returned path is empty.
+func (m *Model) downloaded(file *filetransfer.IncomingFile, t *transfer) (string, tea.Cmd, error) {
+	if m.PostReceiveCommand == "" {
+		savedPath, err := file.Finalize(".")
+		if err != nil {
+			if t != nil {
+				t.finish(transferFailed, err)
+			}
+			return "", nil, err
+		}
+		if t != nil {
+			t.path, t.percent = savedPath, 1
+			t.finish(transferDone, nil)
+		}
+		m.keepDownload(savedPath)
+		return savedPath, m.alert(config.EventFile), nil
+	}
+
+	heldPath, err := file.Verify()
+	if err != nil {
+		if t != nil {
+			t.finish(transferFailed, err)
+		}
+		return "", nil, err
+	}
+	if t != nil {
+		t.path, t.percent = heldPath, 1
+		t.finish(transferChecking, nil)
+	}
+	if m.heldDownloads == nil {
+		m.heldDownloads = make(map[string]*filetransfer.IncomingFile)
+	}
+	m.heldDownloads[heldPath] = file
+	return "", tea.Batch(m.alert(config.EventFile), runPostReceiveHook(m.PostReceiveCommand, heldPath, file.Name())), nil
+}
+
+// keepDownload adds a saved file to those /open and -record know about.
+func (m *Model) keepDownload(savedPath string) {
+	m.Downloads = append(m.Downloads, savedPath)
+	if m.Recording != nil {
+		m.Recording.files = append(m.Recording.files, savedPath)
+	}
+}
+
+// postReceiveResult saves a file the post-receive hook passed, and quarantines
+// one it flagged or that it could not check.
+func (m *Model) postReceiveResult(msg PostReceiveResultMsg) {
+	file := m.heldDownloads[msg.Path]
+	if file == nil {
+		return
+	}
+	delete(m.heldDownloads, msg.Path)
+	t := m.transfers.saved(msg.Path)
+	now := time.Now()
+
+	if msg.Err == nil && msg.Result.ExitCode == 0 {
+		savedPath, err := file.Finalize(".")
+		if err != nil {
+			if t != nil {
+				t.status, t.err = transferFailed, err
+			}
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Post-receive hook passed %s, but it could not be saved: %v", file.Name(), err)})
+			return
+		}
+		if t != nil {
+			t.path, t.status = savedPath, transferDone
+		}
+		m.keepDownload(savedPath)
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Post-receive hook passed %s. Saved as %s (use /open %s to open it). %s", file.Name(), savedPath, filepath.Base(savedPath), msg.Result.Output)})
+		return
+	}
+
+	reason := fmt.Sprintf("Post-receive hook flagged %s (exit %d)", file.Name(), msg.Result.ExitCode)
+	if msg.Err != nil {
+		reason = fmt.Sprintf("Post-receive hook failed to run on %s: %v", file.Name(), msg.Err)
+	}
+	quarantinedPath, err := file.Quarantine()
+	if t != nil {
+		t.status = transferQuarantined
+		if err == nil {
+			t.path = quarantinedPath
+		}
+	}
+	if err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s. It stays in %s, as it could not be quarantined: %v", reason, filetransfer.QuarantineDir, err)})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s. The file was quarantined to %s. %s", reason, quarantinedPath, msg.Result.Output)})
+	}
+}
@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bjarneo/jot/internal/trust"
+)
+
+// checkPin compares the peer's identity key with the one pinned for its
+// nickname, pinning it the first time the nickname shows up. A different key
+// holds the peer's messages back and keeps us from sending until the user
+// accepts it with /accept-key.
+func (m *Model) checkPin() {
+	if m.Pins == nil || m.PeerNickname == "" || m.PeerIdentityFingerprint == "" {
+		return
+	}
+	now := time.Now()
+	status, pin := m.Pins.Check(m.PeerNickname, m.PeerIdentityFingerprint)
+	switch status {
+	case trust.FirstSeen:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("First session with %s; pinned their identity key %s. You will be warned if it changes.", m.PeerNickname, m.PeerIdentityFingerprint)})
+	case trust.Mismatch:
+		m.keyChanged = true
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("WARNING: %s's identity key has CHANGED since you first met on %s: it was %s and is %s now. Someone may be posing as %s, or they may have reinstalled Jot or started it with -ephemeral-identity. Their messages are held and you cannot send anything until you check with them out of band and type /accept-key, or leave with /quit.", m.PeerNickname, pin.FirstSeen.Format(time.DateOnly), pin.Fingerprint, m.PeerIdentityFingerprint, m.PeerNickname)})
+	}
+	if err := m.Pins.Save(); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not save pinned keys: %v", err)})
+	}
+}
+
+// holdForKey keeps a message from a peer whose key changed until the user
+// accepts the new key.
+func (m *Model) holdForKey(msg Message) {
+	if len(m.heldForKey) == 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: msg.Timestamp, Sender: "System", Content: fmt.Sprintf("Holding %s's messages until you accept their new identity key with /accept-key.", m.peerName())})
+	}
+	m.heldForKey = append(m.heldForKey, msg)
+}
+
+// acceptKey pins the peer's changed identity key for /accept-key and shows
+// the messages held back meanwhile.
+func (m *Model) acceptKey() {
+	now := time.Now()
+	if !m.keyChanged {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Your peer's identity key has not changed; there is nothing to accept."})
+		return
+	}
+	m.Pins.Replace(m.PeerNickname, m.PeerIdentityFingerprint)
+	if err := m.Pins.Save(); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not save pinned keys: %v", err)})
+	}
+	m.keyChanged = false
+	m.Status = m.chattingStatus()
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Pinned %s's new identity key %s.", m.PeerNickname, m.PeerIdentityFingerprint)})
+	m.Messages = append(m.Messages, m.heldForKey...)
+	m.heldForKey = nil
+}
@@ -0,0 +1,201 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// PollMsg carries a poll the peer opened.
+type PollMsg struct{ Poll protocol.Poll }
+
+// VoteMsg carries the peer's vote.
+type VoteMsg struct{ Vote protocol.Vote }
+
+// poll is the latest poll either of us opened, with both votes.
+type poll struct {
+	protocol.Poll
+	mine bool // We opened it
+	ours int  // Index of our choice, or -1
+	peer int  // Index of the peer's choice, or -1
+}
+
+// startPoll opens the poll described by args, a question followed by its
+// options, quoted where they hold spaces. Without args it shows the open poll.
+func (m *Model) startPoll(args string) tea.Cmd {
+	now := time.Now()
+	if args == "" {
+		if m.Poll == nil {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: `Usage: /poll "question" option1 option2 ...`})
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: m.tally()})
+		}
+		return nil
+	}
+	if !m.peerPolls {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s's client does not support polls.", m.peerName())})
+		return nil
+	}
+	words, ok := splitQuoted(args)
+	if !ok || len(words) < 3 {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: `Usage: /poll "question" option1 option2 ... (quote the question and any option with spaces)`})
+		return nil
+	}
+	p := protocol.Poll{ID: uuid.NewString(), Question: words[0], Options: words[1:]}
+	if err := p.Validate(); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not open the poll: %v", err)})
+		return nil
+	}
+	m.Poll = &poll{Poll: p, mine: true, ours: -1, peer: -1}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("You asked: %s\n%s", p.Question, m.pollOptions())})
+
+	conn, keys := m.Conn, m.Keys
+	return func() tea.Msg {
+		if err := network.SendJSON(conn, keys, protocol.TypePoll, p); err != nil {
+			return ErrorMsg{Err: fmt.Errorf("failed to send the poll: %w", err)}
+		}
+		return nil
+	}
+}
+
+// pollOpened shows a poll from the peer, which replaces any open poll.
+func (m *Model) pollOpened(p protocol.Poll) tea.Cmd {
+	p.Question = sanitizeRelayText(p.Question)
+	for i, option := range p.Options {
+		p.Options[i] = sanitizeRelayText(option)
+	}
+	m.Poll = &poll{Poll: p, ours: -1, peer: -1}
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s asks: %s\n%s", m.peerName(), p.Question, m.pollOptions())})
+	return m.notifyDetached(fmt.Sprintf("%s opened a poll", m.peerName()), p.Question)
+}
+
+// pollOptions lists the options of the open poll and how to vote.
+func (m *Model) pollOptions() string {
+	var b strings.Builder
+	for i, option := range m.Poll.Options {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, option)
+	}
+	fmt.Fprintf(&b, "Vote with Alt+1 to Alt+%d, or /vote <number>.", len(m.Poll.Options))
+	return b.String()
+}
+
+// voteKey votes in the open poll for Alt+1 to Alt+9, and reports whether key was one of them.
+func (m *Model) voteKey(key tea.KeyMsg) (tea.Cmd, bool) {
+	if m.Poll == nil || !key.Alt || key.Type != tea.KeyRunes || len(key.Runes) != 1 {
+		return nil, false
+	}
+	choice := int(key.Runes[0] - '1')
+	if choice < 0 || choice >= len(m.Poll.Options) {
+		return nil, false
+	}
+	return m.vote(choice), true
+}
+
+// voteCommand votes for the option numbered by arg.
+func (m *Model) voteCommand(arg string) tea.Cmd {
+	if m.Poll == nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "There is no poll to vote in."})
+		return nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(m.Poll.Options) {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Usage: /vote <number from 1 to %d>", len(m.Poll.Options))})
+		return nil
+	}
+	return m.vote(n - 1)
+}
+
+// vote records our choice in the open poll and tells the peer.
+func (m *Model) vote(choice int) tea.Cmd {
+	m.Poll.ours = choice
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: m.tally()})
+
+	conn, keys := m.Conn, m.Keys
+	vote := protocol.Vote{Poll: m.Poll.ID, Option: choice}
+	return func() tea.Msg {
+		if err := network.SendJSON(conn, keys, protocol.TypeVote, vote); err != nil {
+			return ErrorMsg{Err: fmt.Errorf("failed to send your vote: %w", err)}
+		}
+		return nil
+	}
+}
+
+// voted records the peer's vote. Votes in polls since replaced are dropped.
+func (m *Model) voted(vote protocol.Vote) tea.Cmd {
+	if m.Poll == nil || vote.Poll != m.Poll.ID || vote.Option >= len(m.Poll.Options) {
+		return nil
+	}
+	m.Poll.peer = vote.Option
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: m.tally()})
+	return m.notifyDetached(fmt.Sprintf("%s voted", m.peerName()), m.Poll.Question)
+}
+
+// tally shows the votes in the open poll so far.
+func (m *Model) tally() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Poll: %s", m.Poll.Question)
+	for i, option := range m.Poll.Options {
+		var voters []string
+		if m.Poll.ours == i {
+			voters = append(voters, "you")
+		}
+		if m.Poll.peer == i {
+			voters = append(voters, m.peerName())
+		}
+		fmt.Fprintf(&b, "\n  %d. %s: %d", i+1, option, len(voters))
+		if len(voters) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(voters, ", "))
+		}
+	}
+	return b.String()
+}
+
+// pollStatus is the header's reminder of a poll we have not voted in.
+func (m *Model) pollStatus() string {
+	if m.Poll == nil || m.Poll.ours >= 0 {
+		return ""
+	}
+	return "Poll open: Alt+1-" + strconv.Itoa(len(m.Poll.Options)) + " to vote"
+}
+
+// splitQuoted splits s into words at spaces, keeping together what is inside
+// single or double quotes. It fails on an unterminated quote.
+func splitQuoted(s string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, true
+}
@@ -0,0 +1,156 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/archive"
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// minRecordingPassphrase is the shortest passphrase accepted for a recording archive.
+const minRecordingPassphrase = 8
+
+// recording tracks an active /record session.
+type recording struct {
+	passphrase   string
+	startedAt    time.Time
+	firstMessage int      // Index into Messages of the first recorded message
+	files        []string // Files received while recording
+}
+
+// passphrase prompt stages for /record.
+const (
+	recordPromptNone = iota
+	recordPromptEnter
+	recordPromptConfirm
+)
+
+// startRecording asks for the archive passphrase. Only the session owner may record.
+func (m *Model) startRecording() {
+	now := time.Now()
+	switch {
+	case m.Recording != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Already recording since %s. Use /record stop to save the archive.", m.Recording.startedAt.Format("15:04:05"))})
+	case m.Command != "CREATE":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Only the participant who created the session can record it."})
+	case !m.IsReady || m.Keys == nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Recording can only start once your peer is connected, so they can be told about it."})
+	default:
+		m.recordPrompt = recordPromptEnter
+		m.chatArea.SetSecretPrompt("Archive passphrase: ")
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Enter a passphrase of at least %d characters for the recording archive. It is never sent or shown. Type /cancel to abort.", minRecordingPassphrase)})
+	}
+}
+
+// handleRecordPassphrase consumes input while the passphrase prompt is active.
+func (m *Model) handleRecordPassphrase(text string) tea.Cmd {
+	now := time.Now()
+	if text == "/cancel" {
+		m.cancelRecordPrompt()
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Recording cancelled."})
+		return nil
+	}
+
+	switch m.recordPrompt {
+	case recordPromptEnter:
+		if len(text) < minRecordingPassphrase {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("The passphrase must be at least %d characters. Try again or type /cancel.", minRecordingPassphrase)})
+			return nil
+		}
+		m.pendingPassphrase = text
+		m.recordPrompt = recordPromptConfirm
+		m.chatArea.SetSecretPrompt("Confirm passphrase: ")
+		return nil
+	case recordPromptConfirm:
+		if text != m.pendingPassphrase {
+			m.cancelRecordPrompt()
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Passphrases did not match. Recording was not started."})
+			return nil
+		}
+		passphrase := m.pendingPassphrase
+		m.cancelRecordPrompt()
+		m.Recording = &recording{passphrase: passphrase, startedAt: now, firstMessage: len(m.Messages)}
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Recording started. %s has been notified. Use /record stop to save the encrypted archive.", m.peerName())})
+		m.Status = m.chattingStatus()
+		return m.sendRecordingNotice(true)
+	}
+	return nil
+}
+
+func (m *Model) cancelRecordPrompt() {
+	m.recordPrompt = recordPromptNone
+	m.pendingPassphrase = ""
+	m.chatArea.SetSecretPrompt("")
+}
+
+// stopRecording ends the recording, tells the peer if still connected, and
+// writes the archive in the background.
+func (m *Model) stopRecording() tea.Cmd {
+	if m.Recording == nil {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Not recording. Use /record to start."})
+		return nil
+	}
+	rec := m.Recording
+	m.Recording = nil
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Recording stopped. Writing the encrypted archive..."})
+
+	path, transcript := m.recordingArchivePath(), m.transcript(rec)
+	cmds := []tea.Cmd{func() tea.Msg {
+		skipped, err := archive.Write(path, rec.passphrase, transcript, rec.files)
+		return RecordingSavedMsg{Path: path, Skipped: skipped, Err: err}
+	}}
+	if m.IsConnected && m.Keys != nil {
+		m.Status = m.chattingStatus()
+		cmds = append(cmds, m.sendRecordingNotice(false))
+	}
+	return tea.Batch(cmds...)
+}
+
+// saveRecordingNow writes an active recording synchronously, for when the program is about to exit.
+func (m *Model) saveRecordingNow() {
+	if m.Recording == nil {
+		return
+	}
+	rec := m.Recording
+	m.Recording = nil
+	archive.Write(m.recordingArchivePath(), rec.passphrase, m.transcript(rec), rec.files)
+}
+
+func (m *Model) sendRecordingNotice(active bool) tea.Cmd {
+	conn, keys := m.Conn, m.Keys
+	return func() tea.Msg {
+		if err := network.SendJSON(conn, keys, protocol.TypeRecording, protocol.Recording{Active: active}); err != nil {
+			return ErrorMsg{Err: fmt.Errorf("failed to announce recording: %w", err)}
+		}
+		return nil
+	}
+}
+
+func (m *Model) recordingArchivePath() string {
+	return fmt.Sprintf("jot-recording-%s.jotrec", time.Now().Format("20060102-150405"))
+}
+
+// transcript renders the messages recorded so far as plain text.
+func (m *Model) transcript(rec *recording) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Session: %s\nRecorded by: %s\nPeer: %s (fingerprint %s)\nStarted: %s\nStopped: %s\n\n",
+		m.SessionID, m.Nickname, m.PeerNickname, m.PeerFingerprint,
+		rec.startedAt.Format(time.RFC3339), time.Now().Format(time.RFC3339))
+	for _, msg := range m.Messages[rec.firstMessage:] {
+		content := msg.Content
+		switch msg.Signature {
+		case crypto.SignatureMissing:
+			content = "[unsigned] " + content
+		case crypto.SignatureInvalid:
+			content = "[INVALID SIGNATURE] " + content
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.Format("2006-01-02 15:04:05"), msg.Sender, content)
+	}
+	return b.String()
+}
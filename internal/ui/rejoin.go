@@ -0,0 +1,155 @@
+package ui
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/config"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+// A session whose connection drops for a moment is resumed, if the relay
+// allows it, without anyone noticing. When the session is lost for good, for
+// instance because the relay restarted, clients whose hellos say they rejoin
+// meet again: the owner creates the session anew under the ID it sent in its
+// hello, which only the two clients know, and the peer joins it. The key
+// exchange runs again, and the peer must come back with the same identity key.
+// A client that leaves on purpose says goodbye first, so its peer does not
+// wait for it.
+
+// rejoinWindow is how long we try to meet the peer again.
+const rejoinWindow = 2 * time.Minute
+
+type (
+	// ByeMsg reports that the peer is leaving on purpose.
+	ByeMsg struct{}
+
+	// rejoinMsg carries the connection to the session we rejoined, or why we
+	// could not, for attempt seq.
+	rejoinMsg struct {
+		seq  int
+		conn net.Conn
+		err  error
+	}
+
+	// rejoinTimeoutMsg ends attempt seq if the peer has not come back by then.
+	rejoinTimeoutMsg struct{ seq int }
+)
+
+// canRejoin reports whether a session that was lost should be met again.
+func (m *Model) canRejoin() bool {
+	return m.IsReady && m.peerRejoins && m.rejoinID != "" && !m.peerLeft && !m.budgetSpent
+}
+
+// rejoin starts getting back into the session after it was lost because of cause.
+func (m *Model) rejoin(cause error) tea.Cmd {
+	m.abortReceiving()
+	m.transfers.interrupt(fmt.Errorf("lost the session: %w", cause))
+	m.IsConnected, m.IsReady = false, false
+	m.peerKeepalives, m.peerStale = false, false
+	m.rejoining = true
+	m.rejoinSeq++
+	m.SessionID = m.rejoinID
+	m.forgetSent()
+	m.Status = fmt.Sprintf("REJOINING: Lost the session, waiting for %s to come back...", m.peerName())
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Lost the session (%v). Trying to meet %s again for up to %s; the chat carries on under new keys if they come back.", cause, m.peerName(), rejoinWindow)})
+
+	seq, opts, owner, sessionID := m.rejoinSeq, m.dialOptions(), m.Command == "CREATE", m.rejoinID
+	opts.Retrying = nil
+	deadline := time.Now().Add(rejoinWindow)
+	return tea.Batch(
+		func() tea.Msg {
+			conn, err := network.Rejoin(opts, owner, sessionID, deadline)
+			if err != nil {
+				return rejoinMsg{seq: seq, err: err}
+			}
+			return rejoinMsg{seq: seq, conn: m.resumable(conn, opts, sessionID)}
+		},
+		tea.Tick(rejoinWindow, func(time.Time) tea.Msg { return rejoinTimeoutMsg{seq: seq} }),
+	)
+}
+
+// forgetSent starts counting texts afresh for the new connection. Texts the
+// peer had not acknowledged stay marked as sent to the relay, since they may
+// or may not have arrived, and the new acks do not refer to them.
+func (m *Model) forgetSent() {
+	m.sendMu.Lock()
+	m.textsSent = 0
+	m.sendMu.Unlock()
+	m.textsAcked, m.textsReceived = 0, 0
+
+	unconfirmed := 0
+	for i := range m.Messages {
+		if m.Messages[i].Delivery == DeliveryRelayed {
+			m.Messages[i].seq = math.MaxUint64
+			unconfirmed++
+		}
+	}
+	if m.peerAcks && unconfirmed > 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("%s had not confirmed your last %d message(s); they may not have arrived.", m.peerName(), unconfirmed)})
+	}
+}
+
+// rejoined hands the connection of attempt msg.seq on, unless we gave up on it.
+func (m *Model) rejoined(msg rejoinMsg) tea.Cmd {
+	if !m.rejoining || msg.seq != m.rejoinSeq {
+		if msg.conn != nil {
+			msg.conn.Close()
+		}
+		return nil
+	}
+	if msg.err != nil {
+		return m.giveUpRejoining(msg.err)
+	}
+	return func() tea.Msg { return ConnectionMsg{Conn: msg.conn} }
+}
+
+// rejoinTimedOut gives up on attempt seq if the peer has not come back yet.
+func (m *Model) rejoinTimedOut(seq int) tea.Cmd {
+	if !m.rejoining || seq != m.rejoinSeq {
+		return nil
+	}
+	if m.Conn != nil {
+		m.Conn.Close()
+	}
+	return m.giveUpRejoining(fmt.Errorf("%s did not come back within %s", m.peerName(), rejoinWindow))
+}
+
+// giveUpRejoining leaves the session disconnected for good.
+func (m *Model) giveUpRejoining(err error) tea.Cmd {
+	m.rejoining = false
+	m.IsConnected = false
+	m.Status = "DISCONNECTED: Could not rejoin the session."
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not get back into the session: %v", err)})
+	cmds := []tea.Cmd{m.alert(config.EventLeave)}
+	if m.Recording != nil {
+		cmds = append(cmds, m.stopRecording())
+	}
+	return tea.Batch(cmds...)
+}
+
+// sayGoodbye tells a peer that would otherwise try to rejoin that we are
+// leaving on purpose. It is written before the connection is closed, which
+// sends it on its way.
+func (m *Model) sayGoodbye() {
+	if m.Conn == nil || m.Keys == nil || !m.IsReady || !m.peerRejoins {
+		return
+	}
+	network.SendData(m.Conn, m.Keys, protocol.TypeBye, nil)
+}
+
+// checkRejoinedIdentity fails the session if the client that came back holds
+// another identity key than the peer that was lost.
+func (m *Model) checkRejoinedIdentity(identity ed25519.PublicKey) error {
+	if !m.rejoining || m.peerIdentity == nil || m.peerIdentity.Equal(identity) {
+		return nil
+	}
+	return errors.New("the client that came back to the lost session holds a different identity key than your peer did, so the session was closed")
+}
@@ -0,0 +1,125 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+)
+
+// RekeyedMsg reports that the session keys were replaced, for the Rekeys-th
+// time since the handshake.
+type RekeyedMsg struct{ Rekeys int }
+
+// rekeyStartedMsg reports whether asking the peer for new keys went out.
+type rekeyStartedMsg struct{ err error }
+
+// rekeyTickMsg checks whether -rekey-interval has passed. Ticks from an
+// earlier chain carry an old seq and are dropped.
+type rekeyTickMsg struct{ seq int }
+
+// freshKeys starts counting towards the next rekey over again, for keys that
+// were just exchanged.
+func (m *Model) freshKeys() {
+	m.rekeying = false
+	m.rekeyedMessages = 0
+	m.lastRekey = time.Now()
+}
+
+// rekeyCommand replaces the session keys for /rekey.
+func (m *Model) rekeyCommand() tea.Cmd {
+	now := time.Now()
+	switch {
+	case !m.IsReady || m.rejoining || m.Keys == nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "There are no keys to replace until your peer is connected."})
+		return nil
+	case !m.peerRekeys:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s's client cannot replace the session keys.", m.peerName())})
+		return nil
+	case m.rekeying:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Still waiting for %s to answer the last rekey.", m.peerName())})
+		return nil
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Replacing the session keys..."})
+	return m.startRekey()
+}
+
+// autoRekey replaces the session keys once -rekey-messages or
+// -rekey-interval says they are due, if they can be replaced now.
+func (m *Model) autoRekey() tea.Cmd {
+	if !m.IsReady || m.rejoining || m.Keys == nil || !m.peerRekeys || m.rekeying {
+		return nil
+	}
+	return m.startRekey()
+}
+
+// startRekey asks the peer for new keys. ListenForMessages switches to them
+// when it answers, and reports it with RekeyedMsg.
+func (m *Model) startRekey() tea.Cmd {
+	m.rekeying = true
+	conn, keys := m.Conn, m.Keys
+	return func() tea.Msg {
+		return rekeyStartedMsg{err: network.StartRekey(conn, keys)}
+	}
+}
+
+// rekeyStarted reports a rekey that could not be asked for.
+func (m *Model) rekeyStarted(msg rekeyStartedMsg) {
+	if msg.err == nil {
+		return
+	}
+	if errors.Is(msg.err, crypto.ErrRekeyPending) {
+		// The peer's own rekey is under way, and replaces the keys as well.
+		return
+	}
+	m.rekeying = false
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "Error", Content: fmt.Sprintf("Could not replace the session keys: %v", msg.err)})
+}
+
+// rekeyed notes that the session keys were replaced, by us or the peer.
+func (m *Model) rekeyed(msg RekeyedMsg) {
+	m.freshKeys()
+	m.Messages = append(m.Messages, Message{Timestamp: m.lastRekey, Sender: "System", Content: fmt.Sprintf("Session keys replaced (rekey %d). The old ones are gone, so keys taken from either client from now on cannot decrypt what was sent before.", msg.Rekeys)})
+}
+
+// countForRekey counts a text sent or received, and replaces the keys once
+// there were -rekey-messages of them since the last time.
+func (m *Model) countForRekey() tea.Cmd {
+	m.rekeyedMessages++
+	if m.RekeyMessages <= 0 || m.rekeyedMessages < m.RekeyMessages {
+		return nil
+	}
+	return m.autoRekey()
+}
+
+// startRekeyTimer starts checking for -rekey-interval, for a peer that can
+// replace the keys.
+func (m *Model) startRekeyTimer() tea.Cmd {
+	if m.RekeyInterval <= 0 {
+		return nil
+	}
+	m.rekeySeq++
+	return m.rekeyTick(m.RekeyInterval)
+}
+
+func (m *Model) rekeyTick(after time.Duration) tea.Cmd {
+	seq := m.rekeySeq
+	return tea.Tick(after, func(time.Time) tea.Msg { return rekeyTickMsg{seq: seq} })
+}
+
+// checkRekeyTimer replaces the keys if -rekey-interval passed since they were
+// last replaced, and checks again when it next could have. The ticks stop once
+// we are disconnected.
+func (m *Model) checkRekeyTimer(now time.Time) tea.Cmd {
+	if !m.IsConnected {
+		return nil
+	}
+	if due := m.lastRekey.Add(m.RekeyInterval); now.Before(due) {
+		return m.rekeyTick(due.Sub(now))
+	}
+	return tea.Batch(m.autoRekey(), m.rekeyTick(m.RekeyInterval))
+}
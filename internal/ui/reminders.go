@@ -0,0 +1,140 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/config"
+)
+
+// Reminders are timers kept by the model rather than the connection, so they
+// still fire after the connection to the relay is resumed. They end with the
+// client.
+const (
+	maxReminders     = 20
+	maxReminderDelay = 7 * 24 * time.Hour
+)
+
+// reminder is a pending /remind.
+type reminder struct {
+	id   int
+	due  time.Time
+	text string
+	send bool // Send the text to the peer when due instead of only showing it
+}
+
+// reminderDueMsg fires the reminder with the given id, unless it was cancelled.
+type reminderDueMsg struct{ id int }
+
+// remind handles /remind: "[-send] <duration> <text>" sets a reminder,
+// "cancel <n>" drops one, and nothing at all lists them.
+func (m *Model) remind(args string) tea.Cmd {
+	now := time.Now()
+	if args == "" {
+		m.listReminders(now)
+		return nil
+	}
+	if n, ok := strings.CutPrefix(args, "cancel "); ok {
+		m.cancelReminder(strings.TrimSpace(n))
+		return nil
+	}
+	send := false
+	if rest, ok := strings.CutPrefix(args, "-send "); ok {
+		send, args = true, strings.TrimSpace(rest)
+	}
+	delayText, text, _ := strings.Cut(args, " ")
+	text = unquoteText(strings.TrimSpace(text))
+	delay, err := time.ParseDuration(delayText)
+	if err != nil || delay <= 0 || text == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: `Usage: /remind [-send] <duration, e.g. 10m or 1h30m> "text"`})
+		return nil
+	}
+	if delay > maxReminderDelay {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Reminders can be at most a week away."})
+		return nil
+	}
+	if len(m.Reminders) >= maxReminders {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("You already have %d reminders. Use /remind cancel <n> to drop one.", maxReminders)})
+		return nil
+	}
+
+	m.reminderSeq++
+	r := reminder{id: m.reminderSeq, due: now.Add(delay), text: text, send: send}
+	i, _ := slices.BinarySearchFunc(m.Reminders, r.due, func(r reminder, due time.Time) int { return r.due.Compare(due) })
+	m.Reminders = slices.Insert(m.Reminders, i, r)
+	if send {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("At %s, %q will be sent to %s.", r.due.Format("15:04"), text, m.peerName())})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("You will be reminded at %s.", r.due.Format("15:04"))})
+	}
+	return tea.Tick(delay, func(time.Time) tea.Msg { return reminderDueMsg{id: r.id} })
+}
+
+// listReminders shows the pending reminders, numbered for /remind cancel.
+func (m *Model) listReminders(now time.Time) {
+	if len(m.Reminders) == 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: `No reminders. Set one with /remind 10m "text".`})
+		return
+	}
+	var b strings.Builder
+	b.WriteString("Reminders:")
+	for i, r := range m.Reminders {
+		action := "remind you"
+		if r.send {
+			action = "send to " + m.peerName()
+		}
+		fmt.Fprintf(&b, "\n  %d. %s (%s): %s", i+1, r.due.Format("15:04"), action, r.text)
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: b.String()})
+}
+
+// cancelReminder drops the reminder numbered arg in the list.
+func (m *Model) cancelReminder(arg string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(m.Reminders) {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "No such reminder. Type /remind to list them."})
+		return
+	}
+	r := m.Reminders[n-1]
+	m.Reminders = slices.Delete(m.Reminders, n-1, n)
+	m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Cancelled the reminder: " + r.text})
+}
+
+// reminderDue shows or sends the reminder with the given id.
+func (m *Model) reminderDue(id int) tea.Cmd {
+	i := slices.IndexFunc(m.Reminders, func(r reminder) bool { return r.id == id })
+	if i < 0 {
+		return nil // Cancelled
+	}
+	r := m.Reminders[i]
+	m.Reminders = slices.Delete(m.Reminders, i, i+1)
+	cmds := []tea.Cmd{m.alert(config.EventReminder), m.notifyDetached("Reminder", r.text)}
+	switch {
+	case !r.send:
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: "Reminder: " + r.text})
+	case m.keyChanged:
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Reminder: %s (not sent; your peer's identity key changed)", r.text)})
+	case m.observing:
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Reminder: %s (not sent; you are observing)", r.text)})
+	case m.silenced():
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Reminder: %s (not sent; announcement mode is on)", r.text)})
+	case m.IsReady && m.Keys != nil:
+		cmds = append(cmds, m.sendText(r.text))
+	default:
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Reminder: %s (not sent; your peer is not connected)", r.text)})
+	}
+	return tea.Batch(cmds...)
+}
+
+// unquoteText takes the quotes off text given as a single quoted string.
+func unquoteText(text string) string {
+	if len(text) >= 2 && (text[0] == '"' || text[0] == '\'') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,182 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// search is a /search in progress. Matches are found again on every render, so
+// they follow new messages; only chat messages are searched, not notices.
+type search struct {
+	query   string
+	matches []searchMatch // Oldest first
+	current int           // Index into matches; -1 while there are none
+	jump    bool          // Bring the current match into view on the next render
+}
+
+// searchMatch is where one match is in the rendered messages.
+type searchMatch struct {
+	line int // The first line of the message it is in
+}
+
+// SetSearch highlights every match for query among messages and jumps to the
+// latest one. An empty query ends the search.
+func (m *ChatAreaModel) SetSearch(query string, messages []Message) {
+	if query == "" {
+		m.search = nil
+		return
+	}
+	count := 0
+	for _, msg := range messages {
+		if searchable(msg) {
+			count += len(findMatches(msg.Content, query))
+		}
+	}
+	m.search = &search{query: query, current: count - 1, jump: true}
+}
+
+// searchable reports whether msg is a chat message, rather than a notice.
+func searchable(msg Message) bool {
+	return msg.Sender != "System" && msg.Sender != "Error"
+}
+
+// searchKey moves between matches: n and F3 go to the previous, older match, N
+// and Shift+F3 to the next. The letters only count while the input is empty,
+// so they can still be typed into a message. It reports whether key was used.
+func (m *ChatAreaModel) searchKey(key tea.KeyMsg) bool {
+	if m.search == nil {
+		return false
+	}
+	letter := ""
+	if key.Type == tea.KeyRunes && m.textarea.Value() == "" {
+		letter = string(key.Runes)
+	}
+	switch {
+	case key.Type == tea.KeyF3 || letter == "n":
+		m.search.step(-1)
+	case key.Type == tea.KeyF15 || letter == "N": // Shift+F3
+		m.search.step(1)
+	default:
+		return false
+	}
+	return true
+}
+
+// step moves by delta matches, wrapping around at either end.
+func (s *search) step(delta int) {
+	n := len(s.matches)
+	if n == 0 {
+		return
+	}
+	current := s.current
+	if current < 0 || current >= n {
+		current = n - 1
+	}
+	s.current = ((current+delta)%n + n) % n
+	s.jump = true
+}
+
+// searchStatus describes the search for the status bar, e.g. "3/17 matches".
+func (m *ChatAreaModel) searchStatus() string {
+	if m.search == nil {
+		return ""
+	}
+	switch {
+	case len(m.search.matches) == 0:
+		return fmt.Sprintf("%q: no matches", m.search.query)
+	case m.search.current < 0:
+		return fmt.Sprintf("%q: %d matches", m.search.query, len(m.search.matches))
+	}
+	return fmt.Sprintf("%q: %d/%d matches", m.search.query, m.search.current+1, len(m.search.matches))
+}
+
+// highlight marks the matches in text, the content of a message starting at
+// line, and records them.
+func (m *ChatAreaModel) highlight(text string, line int) string {
+	if m.search == nil {
+		return text
+	}
+	ranges := findMatches(text, m.search.query)
+	if len(ranges) == 0 {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, r := range ranges {
+		style := MatchStyle
+		if len(m.search.matches) == m.search.current {
+			style = CurrentMatchStyle
+		}
+		m.search.matches = append(m.search.matches, searchMatch{line: line})
+		b.WriteString(text[last:r[0]])
+		b.WriteString(styleRunes(style, text[r[0]:r[1]]))
+		last = r[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// reveal scrolls the viewport to the current match, if a jump was asked for.
+func (m *ChatAreaModel) reveal() {
+	s := m.search
+	if s == nil || !s.jump {
+		return
+	}
+	s.jump = false
+	if s.current < 0 || s.current >= len(s.matches) {
+		return
+	}
+	line := s.matches[s.current].line
+	if line < m.viewport.YOffset || line >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(line - m.viewport.Height/3)
+	}
+	m.scrolledBack = !m.viewport.AtBottom()
+}
+
+// styleRunes renders each rune of s on its own, so that wrapping the text
+// never leaves a style open at the end of a line.
+func styleRunes(style lipgloss.Style, s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteString(style.Render(string(r)))
+	}
+	return b.String()
+}
+
+// findMatches returns the byte ranges in text equal to query, ignoring case.
+func findMatches(text, query string) [][2]int {
+	if query == "" {
+		return nil
+	}
+	var found [][2]int
+	for i := 0; i < len(text); {
+		if end, ok := matchAt(text, i, query); ok {
+			found = append(found, [2]int{i, end})
+			i = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return found
+}
+
+// matchAt reports whether text holds query at byte offset i, ignoring case,
+// and where the match ends.
+func matchAt(text string, i int, query string) (int, bool) {
+	for _, q := range query {
+		if i >= len(text) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r != q && !strings.EqualFold(string(r), string(q)) {
+			return 0, false
+		}
+		i += size
+	}
+	return i, true
+}
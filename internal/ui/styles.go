@@ -1,14 +1,96 @@
 package ui
 
-import "github.com/charmbracelet/lipgloss"
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is the set of colors the UI is drawn with.
+type Theme struct {
+	Accent lipgloss.TerminalColor // Input borders and prompt
+	Muted  lipgloss.TerminalColor // Status line, timestamps and info boxes
+	Error  lipgloss.TerminalColor // Errors and trust warnings
+	Self   lipgloss.TerminalColor // Our own nickname
+	Peer   lipgloss.TerminalColor // The peer's nickname
+	System lipgloss.TerminalColor // System notices
+}
+
+// Themes lists the built-in themes selectable with -theme.
+var Themes = map[string]Theme{
+	"default": {
+		Accent: lipgloss.Color("205"),
+		Muted:  lipgloss.Color("240"),
+		Error:  lipgloss.Color("196"),
+		Self:   lipgloss.Color("39"),
+		Peer:   lipgloss.Color("220"),
+		System: lipgloss.Color("244"),
+	},
+	"light": {
+		Accent: lipgloss.Color("162"),
+		Muted:  lipgloss.Color("245"),
+		Error:  lipgloss.Color("160"),
+		Self:   lipgloss.Color("25"),
+		Peer:   lipgloss.Color("130"),
+		System: lipgloss.Color("241"),
+	},
+	"mono": {
+		Accent: lipgloss.NoColor{},
+		Muted:  lipgloss.NoColor{},
+		Error:  lipgloss.NoColor{},
+		Self:   lipgloss.NoColor{},
+		Peer:   lipgloss.NoColor{},
+		System: lipgloss.NoColor{},
+	},
+}
 
 var (
-	TextareaStyle  = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("205")) // Used for footer elements
-	StatusStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-	ErrorStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
-	SenderStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("39"))
-	ReceiverStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("220"))
-	SystemStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("244")).Italic(true)
-	TimestampStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Faint(true)
-	InfoBoxStyle   = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("240")).Padding(0, 1)
+	theme = Themes["default"]
+
+	TextareaStyle  lipgloss.Style // Used for footer elements
+	StatusStyle    lipgloss.Style
+	ErrorStyle     lipgloss.Style
+	SenderStyle    lipgloss.Style
+	ReceiverStyle  lipgloss.Style
+	SystemStyle    lipgloss.Style
+	TimestampStyle lipgloss.Style
+	InfoBoxStyle   lipgloss.Style
+
+	MatchStyle        lipgloss.Style // Matches for /search
+	CurrentMatchStyle lipgloss.Style // The match /search is on
 )
+
+func init() {
+	setStyles()
+}
+
+// ApplyTheme switches the UI to the named theme. It must be called before the UI starts.
+func ApplyTheme(name string) error {
+	t, ok := Themes[name]
+	if !ok {
+		names := make([]string, 0, len(Themes))
+		for n := range Themes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown theme %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	theme = t
+	setStyles()
+	return nil
+}
+
+func setStyles() {
+	TextareaStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(theme.Accent)
+	StatusStyle = lipgloss.NewStyle().Foreground(theme.Muted)
+	ErrorStyle = lipgloss.NewStyle().Foreground(theme.Error)
+	SenderStyle = lipgloss.NewStyle().Foreground(theme.Self)
+	ReceiverStyle = lipgloss.NewStyle().Foreground(theme.Peer)
+	SystemStyle = lipgloss.NewStyle().Foreground(theme.System).Italic(true)
+	TimestampStyle = lipgloss.NewStyle().Foreground(theme.Muted).Faint(true)
+	InfoBoxStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(theme.Muted).Padding(0, 1)
+	MatchStyle = lipgloss.NewStyle().Reverse(true)
+	CurrentMatchStyle = lipgloss.NewStyle().Reverse(true).Bold(true).Foreground(theme.Accent)
+}
@@ -0,0 +1,68 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Timestamp modes selectable with -timestamps.
+const (
+	TimestampsAbsolute = "absolute" // HH:MM
+	TimestampsRelative = "relative" // "2m ago", with a separator where the day changes
+)
+
+// relativeRefresh is how often relative times are redrawn.
+const relativeRefresh = 30 * time.Second
+
+// relativeTickMsg redraws relative times. Ticks from before the mode was last
+// switched on carry an old seq and are dropped, so only one chain runs.
+type relativeTickMsg struct{ seq int }
+
+// relativeTime describes how long before now t was, padded so that messages
+// stay aligned.
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	var s string
+	switch {
+	case d < time.Minute:
+		s = "now"
+	case d < time.Hour:
+		s = fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 24*time.Hour:
+		s = fmt.Sprintf("%dh ago", int(d/time.Hour))
+	default:
+		s = fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
+	return fmt.Sprintf("%7s", s)
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// daySeparator is the line shown above the first message of a new day.
+func daySeparator(day time.Time, width int) string {
+	return TimestampStyle.Render(lipgloss.PlaceHorizontal(width, lipgloss.Center, "— "+day.Format("Monday, Jan 2")+" —"))
+}
+
+// setRelativeTimes switches the timestamp mode, and starts redrawing relative
+// times while they are shown.
+func (m *Model) setRelativeTimes(relative bool) tea.Cmd {
+	m.relativeTimes = relative
+	m.chatArea.SetRelativeTimes(relative)
+	if !relative {
+		return nil
+	}
+	m.relativeSeq++
+	return m.relativeTick()
+}
+
+func (m *Model) relativeTick() tea.Cmd {
+	seq := m.relativeSeq
+	return tea.Tick(relativeRefresh, func(time.Time) tea.Msg { return relativeTickMsg{seq: seq} })
+}
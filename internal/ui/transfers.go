@@ -0,0 +1,343 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+
+	"github.com/bjarneo/jot/internal/filetransfer"
+	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/bjarneo/jot/internal/util"
+)
+
+// Transfer statuses, as /transfers shows them.
+const (
+	transferOffered     = "offered" // Waiting for the receiver to accept or reject it
+	transferActive      = "active"
+	transferDone        = "done"
+	transferFailed      = "failed"
+	transferRejected    = "rejected"
+	transferCanceled    = "canceled"
+	transferChecking    = "checking"    // Received, and held back until -post-receive passes it
+	transferQuarantined = "quarantined" // Received, but flagged by -post-receive, or it could not run
+)
+
+// transfer is a file sent or received this session, as /transfers lists it.
+type transfer struct {
+	id       string // The offer's ID; empty in offers from older clients
+	outgoing bool
+	peer     string
+	fileName string // As offered
+	size     int64
+	path     string // The file we send, or where a received file was saved
+	status   string
+	percent  float64
+	started  time.Time // When the data started to flow; zero until then
+	finished time.Time
+	err      error
+	stop     chan struct{} // Closed to cancel a file we are sending
+}
+
+// finish ends t with status, and err if it failed. A file we are still
+// sending stops, unless it is done.
+func (t *transfer) finish(status string, err error) {
+	if t.stop != nil && t.status == transferActive && status != transferDone {
+		close(t.stop)
+	}
+	t.status, t.err, t.finished = status, err, time.Now()
+}
+
+// speed returns the average bytes per second since the data started to flow,
+// or 0 if it has not.
+func (t *transfer) speed(now time.Time) float64 {
+	if t.started.IsZero() {
+		return 0
+	}
+	if !t.finished.IsZero() {
+		now = t.finished
+	}
+	elapsed := now.Sub(t.started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return t.percent * float64(t.size) / elapsed
+}
+
+// transferList holds the transfers of this session for /transfers, which
+// shows them in place of the chat.
+type transferList struct {
+	items  []*transfer // Oldest first
+	open   bool
+	cursor int
+}
+
+func (l *transferList) add(t *transfer) {
+	l.items = append(l.items, t)
+}
+
+// find returns the newest transfer in the given direction for offer id.
+func (l *transferList) find(outgoing bool, id string) *transfer {
+	for i := len(l.items) - 1; i >= 0; i-- {
+		if t := l.items[i]; t.outgoing == outgoing && t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// outgoing returns the newest file we offered that has status.
+func (l *transferList) outgoing(status string) *transfer {
+	for i := len(l.items) - 1; i >= 0; i-- {
+		if t := l.items[i]; t.outgoing && t.status == status {
+			return t
+		}
+	}
+	return nil
+}
+
+// saved returns the received transfer that was saved at path.
+func (l *transferList) saved(path string) *transfer {
+	for _, t := range l.items {
+		if !t.outgoing && t.path == path {
+			return t
+		}
+	}
+	return nil
+}
+
+// interrupt fails every transfer still offered or under way, because of err.
+func (l *transferList) interrupt(err error) {
+	for _, t := range l.items {
+		if t.status == transferOffered || t.status == transferActive {
+			t.finish(transferFailed, err)
+		}
+	}
+}
+
+// move moves the cursor by delta transfers, stopping at either end.
+func (l *transferList) move(delta int) {
+	l.cursor = max(0, min(len(l.items)-1, l.cursor+delta))
+}
+
+// selected returns the transfer under the cursor.
+func (l *transferList) selected() (*transfer, bool) {
+	if l.cursor < 0 || l.cursor >= len(l.items) {
+		return nil, false
+	}
+	return l.items[l.cursor], true
+}
+
+func (l *transferList) View(width int) string {
+	var s strings.Builder
+	s.WriteString("Transfers this session:\n\n")
+	if len(l.items) == 0 {
+		s.WriteString("No files were sent or received yet.\n")
+	} else {
+		s.WriteString(TimestampStyle.Render(fmt.Sprintf("  %-4s %-16s %-24s %9s %11s %-11s %s", "", "PEER", "FILE", "SIZE", "SPEED", "STATUS", "PATH")) + "\n")
+	}
+	now := time.Now()
+	for i, t := range l.items {
+		direction := "recv"
+		if t.outgoing {
+			direction = "send"
+		}
+		speed := ""
+		if rate := t.speed(now); rate > 0 {
+			speed = formatSize(int64(rate)) + "/s"
+		}
+		status := t.status
+		if t.status == transferActive {
+			status = fmt.Sprintf("%s %d%%", status, int(t.percent*100))
+		}
+		line := fmt.Sprintf("%-4s %-16s %-24s %9s %11s %-11s %s", direction, truncate(t.peer, 16), truncate(t.fileName, 24), formatSize(t.size), speed, status, t.path)
+		if t.err != nil {
+			line += fmt.Sprintf(" (%v)", t.err)
+		}
+		if width > 0 {
+			line = truncate(line, max(width-2, 20))
+		}
+		if i == l.cursor {
+			s.WriteString(SenderStyle.Render("> ") + line + "\n")
+		} else {
+			s.WriteString("  " + line + "\n")
+		}
+	}
+	s.WriteString("\n(↑/↓ to choose, o to open, c to cancel, r to retry, esc to return to the chat)")
+	return s.String()
+}
+
+// formatSize renders a byte count for the transfer table.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(n)/1024/1024/1024)
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/1024/1024)
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// truncate shortens s to at most width runes, marking the cut with an
+// ellipsis.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// showTransfers opens the transfer table for /transfers, on the newest one.
+func (m *Model) showTransfers() {
+	m.transfers.open = true
+	m.transfers.cursor = len(m.transfers.items) - 1
+}
+
+// transfersKey handles a key pressed while the transfer table is shown. It
+// reports false for keys it leaves to the chat, such as Ctrl+C.
+func (m *Model) transfersKey(key tea.KeyMsg) (tea.Cmd, bool) {
+	switch key.String() {
+	case "up", "k":
+		m.transfers.move(-1)
+	case "down", "j":
+		m.transfers.move(1)
+	case "pgup":
+		m.transfers.move(-10)
+	case "pgdown":
+		m.transfers.move(10)
+	case "esc", "q":
+		m.transfers.open = false
+	case "o", "enter":
+		if t, ok := m.transfers.selected(); ok {
+			m.openTransfer(t)
+		}
+	case "c":
+		if t, ok := m.transfers.selected(); ok {
+			return m.cancelTransfer(t), true
+		}
+	case "r":
+		if t, ok := m.transfers.selected(); ok {
+			return m.retryTransfer(t), true
+		}
+	case "ctrl+c":
+		return nil, false
+	}
+	return nil, true
+}
+
+// openTransfer opens a file that was sent or received completely. What
+// happens is shown in the chat, which the table gives way to.
+func (m *Model) openTransfer(t *transfer) {
+	m.transfers.open = false
+	now := time.Now()
+	if t.status != transferDone {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Only completed transfers can be opened; %s is %s.", t.fileName, t.status)})
+		return
+	}
+	if err := util.OpenFile(t.path, m.OpenCommand); err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: err.Error()})
+		return
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Opening %s...", t.path)})
+}
+
+// cancelTransfer withdraws an offer we made, rejects one we were made, or
+// stops a transfer under way in either direction. A transfer under way is
+// only stopped for peers that stop their end when told.
+func (m *Model) cancelTransfer(t *transfer) tea.Cmd {
+	m.transfers.open = false
+	now := time.Now()
+	switch {
+	case t.status != transferOffered && t.status != transferActive:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("The transfer of %s is %s already.", t.fileName, t.status)})
+		return nil
+	case t.status == transferActive && !m.peerCancels:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("%s's client cannot stop a transfer under way.", m.peerName())})
+		return nil
+	case !t.outgoing && t.status == transferOffered:
+		for i, offer := range m.PendingOffers {
+			if offer.ID == t.id {
+				m.PendingOffers = append(m.PendingOffers[:i], m.PendingOffers[i+1:]...)
+				break
+			}
+		}
+		t.finish(transferRejected, nil)
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Rejected file transfer of %s.", t.fileName)})
+		return m.rejectOffer(protocol.FileMetadata{ID: t.id, FileName: t.fileName, FileSize: t.size})
+	}
+
+	if t.outgoing {
+		delete(m.OutgoingOffers, t.id)
+		if t.status == transferOffered {
+			m.IsAwaitingAcceptance = false
+		} else {
+			m.IsTransferring = false
+		}
+	} else {
+		m.abortReceiving()
+	}
+	t.finish(transferCanceled, nil)
+	m.Status = m.chattingStatus()
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Canceled the transfer of %s.", t.fileName)})
+	if !m.peerCancels {
+		// An older client keeps the offer; if it is accepted, we ignore it.
+		return nil
+	}
+	return m.rejectOffer(protocol.FileMetadata{ID: t.id, FileName: t.fileName, FileSize: t.size})
+}
+
+// retryTransfer offers a file we failed to send, or that was canceled or
+// rejected, once more.
+func (m *Model) retryTransfer(t *transfer) tea.Cmd {
+	m.transfers.open = false
+	now := time.Now()
+	switch {
+	case !t.outgoing:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Only files you sent can be retried; ask %s to send %s again.", t.peer, t.fileName)})
+		return nil
+	case t.status != transferFailed && t.status != transferCanceled && t.status != transferRejected:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Only failed, canceled or rejected transfers can be retried; %s is %s.", t.fileName, t.status)})
+		return nil
+	case !m.IsReady:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "There is nobody to send the file to until your peer is connected."})
+		return nil
+	}
+	fileName := ""
+	if t.fileName != filepath.Base(t.path) {
+		fileName = t.fileName
+	}
+	return m.offerFile(t.path, fileName)
+}
+
+// offerFile offers the file at filePath to the peer, for /send and retries,
+// under fileName if it is not empty.
+func (m *Model) offerFile(filePath, fileName string) tea.Cmd {
+	offerID := uuid.NewString()
+	m.OutgoingOffers[offerID] = filePath
+	if fileName != "" {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Offering to send file: %s as %s", filePath, fileName)})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Offering to send file: %s", filePath)})
+	}
+	t := &transfer{id: offerID, outgoing: true, peer: m.peerName(), fileName: fileName, path: filePath, status: transferOffered}
+	if t.fileName == "" {
+		t.fileName = filepath.Base(filePath)
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		t.size = info.Size()
+	}
+	m.transfers.add(t)
+	m.IsAwaitingAcceptance = true
+	m.Status = fmt.Sprintf("TRANSFERRING: Offering to send %s", filepath.Base(filePath))
+	return func() tea.Msg {
+		filetransfer.RequestSendFile(m.Conn, m.Keys, offerID, filePath, fileName, &programMessageSender{program: m.Program}, m.MaxFileSize, m.PeerMaxFileSize)
+		return nil
+	}
+}
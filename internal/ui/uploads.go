@@ -0,0 +1,202 @@
+package ui
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/filetransfer"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+	"github.com/bjarneo/jot/internal/trust"
+)
+
+// blobAAD is authenticated along with every file left on the relay.
+var blobAAD = []byte("jot-blob")
+
+// blobOverhead bounds what encryption adds to a file: the cipher byte, the
+// longest nonce and the tag.
+const blobOverhead = 1 + 24 + 16
+
+// maxBlobs bounds how many of the peer's uploads we remember.
+const maxBlobs = 20
+
+// BlobMsg carries the peer's notice of a file it left on the relay.
+type BlobMsg struct{ Blob protocol.Blob }
+
+// UploadedMsg reports the outcome of /upload.
+type UploadedMsg struct {
+	Blob protocol.Blob
+	Err  error
+}
+
+// FetchedMsg reports the outcome of /fetch.
+type FetchedMsg struct {
+	Blob protocol.Blob
+	File *filetransfer.IncomingFile
+	Err  error
+}
+
+// upload encrypts the file at filePath with a key of its own, leaves it on the
+// relay and tells the peer how to fetch it.
+func (m *Model) upload(filePath string) tea.Cmd {
+	now := time.Now()
+	if filePath == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Usage: /upload <file_path>"})
+		return nil
+	}
+	if !m.peerBlobs {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s's client cannot fetch files from the relay. Use /send instead.", m.peerName())})
+		return nil
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not upload %s: not a readable file", filePath)})
+		return nil
+	}
+	limit := m.MaxFileSize
+	if m.PeerMaxFileSize > 0 && m.PeerMaxFileSize < limit {
+		limit = m.PeerMaxFileSize
+	}
+	if info.Size() > limit {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not upload %s: it exceeds the %.2f MB limit", filePath, float64(limit)/1024/1024)})
+		return nil
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Uploading %s to the relay...", filePath)})
+
+	opts, sessionID, conn, keys, cipher := m.dialOptions(), m.SessionID, m.Conn, m.Keys, m.Cipher
+	return func() tea.Msg {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return UploadedMsg{Err: err}
+		}
+		key := make([]byte, protocol.BlobKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return UploadedMsg{Err: err}
+		}
+		sealed, err := crypto.Encrypt(cipher, data, key, blobAAD)
+		if err != nil {
+			return UploadedMsg{Err: err}
+		}
+		token, expires, err := network.UploadBlob(opts, sessionID, sealed)
+		if err != nil {
+			return UploadedMsg{Err: err}
+		}
+		blob := protocol.Blob{Token: token, Key: key, FileName: filepath.Base(filePath), FileSize: int64(len(data)), Expires: expires}
+		if err := network.SendJSON(conn, keys, protocol.TypeBlob, blob); err != nil {
+			return UploadedMsg{Blob: blob, Err: err}
+		}
+		return UploadedMsg{Blob: blob}
+	}
+}
+
+// uploaded reports how /upload went.
+func (m *Model) uploaded(msg UploadedMsg) {
+	now := time.Now()
+	if msg.Err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not upload the file: %v", msg.Err)})
+		return
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Uploaded %s. %s can fetch it from the relay until %s.", msg.Blob.FileName, m.peerName(), msg.Blob.Expires.Format("15:04"))})
+}
+
+// blobOffered remembers a file the peer left on the relay.
+func (m *Model) blobOffered(blob protocol.Blob) tea.Cmd {
+	blob.FileName = filetransfer.SanitizeFileName(blob.FileName)
+	now := time.Now()
+	size := float64(blob.FileSize) / 1024 / 1024
+	if blob.FileSize > m.MaxFileSize {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s left %s (%.2f MB) on the relay, but it exceeds your %.2f MB limit.", m.peerName(), blob.FileName, size, float64(m.MaxFileSize)/1024/1024)})
+		return nil
+	}
+	m.Blobs = append(m.Blobs, blob)
+	if len(m.Blobs) > maxBlobs {
+		m.Blobs = m.Blobs[len(m.Blobs)-maxBlobs:]
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s left %s (%.2f MB) on the relay until %s. Type /fetch %s to download it.", m.peerName(), blob.FileName, size, blob.Expires.Format("15:04"), blob.FileName)})
+	return m.notifyDetached(fmt.Sprintf("%s left you a file", m.peerName()), blob.FileName)
+}
+
+// fetch downloads the file the peer left on the relay under name, or the
+// latest one, and saves it like a file sent directly.
+func (m *Model) fetch(name string) tea.Cmd {
+	now := time.Now()
+	i := len(m.Blobs) - 1
+	for name != "" && i >= 0 && m.Blobs[i].FileName != name {
+		i--
+	}
+	if i < 0 {
+		if name == "" {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Your peer has not left you any files on the relay."})
+		} else {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Your peer has not left a file named %s on the relay.", name)})
+		}
+		return nil
+	}
+	blob := m.Blobs[i]
+	if now.After(blob.Expires) {
+		m.Blobs = append(m.Blobs[:i], m.Blobs[i+1:]...)
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s is no longer on the relay; it expired at %s.", blob.FileName, blob.Expires.Format("15:04"))})
+		return nil
+	}
+	if m.MaxUnverifiedBytes > 0 && m.PeerTrust != trust.Verified {
+		received := m.unverifiedBytesLastHour()
+		if received+blob.FileSize > m.MaxUnverifiedBytes {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Not fetching %s: unverified peers may send at most %.2f MB per hour (%.2f MB used)", blob.FileName, float64(m.MaxUnverifiedBytes)/1024/1024, float64(received)/1024/1024)})
+			return nil
+		}
+		m.unverifiedReceipts = append(m.unverifiedReceipts, receipt{At: now, Bytes: blob.FileSize})
+	}
+	m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Fetching %s from the relay...", blob.FileName)})
+
+	opts := m.dialOptions()
+	return func() tea.Msg {
+		sealed, err := network.DownloadBlob(opts, blob.Token, blob.FileSize+blobOverhead)
+		if err != nil {
+			return FetchedMsg{Blob: blob, Err: err}
+		}
+		data, err := crypto.Decrypt(sealed, blob.Key, blobAAD)
+		if err != nil {
+			return FetchedMsg{Blob: blob, Err: fmt.Errorf("the file on the relay does not decrypt: %w", err)}
+		}
+		file, err := filetransfer.NewIncomingFile(protocol.FileMetadata{FileName: blob.FileName, FileSize: blob.FileSize})
+		if err != nil {
+			return FetchedMsg{Blob: blob, Err: err}
+		}
+		if _, err := file.Write(data); err != nil {
+			file.Abort()
+			return FetchedMsg{Blob: blob, Err: err}
+		}
+		return FetchedMsg{Blob: blob, File: file}
+	}
+}
+
+// fetched reports how /fetch went, and treats the file like any other download.
+func (m *Model) fetched(msg FetchedMsg) tea.Cmd {
+	now := time.Now()
+	if msg.Err != nil {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not fetch %s: %v", msg.Blob.FileName, msg.Err)})
+		return nil
+	}
+	for i, blob := range m.Blobs {
+		if blob.Token == msg.Blob.Token {
+			m.Blobs = append(m.Blobs[:i], m.Blobs[i+1:]...)
+			break
+		}
+	}
+	savedPath, cmd, err := m.downloaded(msg.File, nil)
+	switch {
+	case err != nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Could not fetch %s: %v", msg.Blob.FileName, err)})
+	case savedPath == "":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Fetched %s. It is saved once the post-receive hook passes it.", msg.Blob.FileName)})
+	default:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Fetched %s. Saved as %s (use /open %s to open it)", msg.Blob.FileName, savedPath, filepath.Base(savedPath))})
+	}
+	return cmd
+}
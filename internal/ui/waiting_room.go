@@ -0,0 +1,326 @@
+package ui
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/bjarneo/jot/internal/crypto"
+	"github.com/bjarneo/jot/internal/network"
+	"github.com/bjarneo/jot/internal/protocol"
+)
+
+const (
+	maxKnockNote     = 200              // Characters in a knock note; the relay cuts longer notes
+	maxRelayText     = 256              // Bytes shown of a note or error the relay passes on
+	maxJoinRequests  = 5                // Join requests kept, as many as the relay lets wait
+	knockNoteTimeout = 90 * time.Second // How long we wait for the user to write a knock note
+)
+
+// introduce answers the relay when we join a session with a waiting room or a
+// lock. It runs on the connecting goroutine, so it reports back through the program.
+func (m *Model) introduce(prompt string) (network.JoinIntro, error) {
+	intro := network.JoinIntro{
+		Nickname:    m.Nickname,
+		Fingerprint: crypto.Fingerprint(m.Identity.Public().(ed25519.PublicKey)),
+	}
+	if m.Program == nil {
+		return intro, nil
+	}
+	if network.IsLockedPrompt(prompt) {
+		reply := make(chan string, 1)
+		m.Program.Send(KnockPromptMsg{Reply: reply})
+		select {
+		case intro.Note = <-reply:
+		case <-time.After(knockNoteTimeout):
+		}
+		if intro.Note == "" {
+			return intro, fmt.Errorf("session is locked")
+		}
+	}
+	m.Program.Send(WaitingForApprovalMsg{})
+	return intro, nil
+}
+
+// submitKnock hands the note typed at the knock prompt to the connecting goroutine.
+func (m *Model) submitKnock(text string) {
+	if text == "/cancel" {
+		text = ""
+	} else if utf8.RuneCountInString(text) > maxKnockNote {
+		m.Messages = append(m.Messages, Message{Timestamp: time.Now(), Sender: "System", Content: fmt.Sprintf("Keep the note to %d characters.", maxKnockNote)})
+		return
+	}
+	m.knockReply <- text
+	m.knockReply = nil
+}
+
+// openControl opens the owner's control connection to the relay.
+func (m *Model) openControl() tea.Cmd {
+	opts, sessionID := m.dialOptions(), m.SessionID
+	return func() tea.Msg {
+		control, err := network.Watch(opts, sessionID)
+		return ControlOpenedMsg{Control: control, Err: err}
+	}
+}
+
+// watchControl forwards events from the control connection to the program until it closes.
+func watchControl(control *network.Control, program *tea.Program) {
+	for {
+		event, err := control.ReadEvent()
+		if err != nil {
+			program.Send(ControlEventMsg{Err: err})
+			return
+		}
+		program.Send(ControlEventMsg{Event: event})
+	}
+}
+
+// handleControlEvent updates the waiting room from a relay event.
+func (m *Model) handleControlEvent(msg ControlEventMsg) tea.Cmd {
+	now := time.Now()
+	if msg.Err != nil {
+		// The relay closes the control connection when the session ends.
+		if m.control != nil && !m.IsReady && !m.rejoining {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: "Lost the control connection to the relay; join requests can no longer be answered."})
+		}
+		m.control = nil
+		m.JoinRequests = nil
+		return nil
+	}
+
+	event := msg.Event
+	switch event.Event {
+	case "join-request":
+		// The relay lets only a few wait; one that sends more is not to be trusted with our memory.
+		if len(m.JoinRequests) >= maxJoinRequests {
+			return nil
+		}
+		event.Nickname = clipText(sanitizeRelayText(event.Nickname), protocol.MaxNickname)
+		event.Note = clipText(sanitizeRelayText(event.Note), maxRelayText)
+		m.JoinRequests = append(m.JoinRequests, event)
+		knocks, wants := "knocks", "wants to join"
+		if event.Observer {
+			knocks, wants = "knocks to observe", "wants to join as an observer, who can read but not post"
+		}
+		if event.Note != "" {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s %s: \u201c%s\u201d. Type /admit to let just them in, or /unlock to open the session.", m.describeJoinRequest(event), knocks, event.Note)})
+			return m.notifyDetached(fmt.Sprintf("%s knocks on your session", joinerName(event)), event.Note)
+		}
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s %s. Type /admit or /deny.", m.describeJoinRequest(event), wants)})
+		return m.notifyDetached(fmt.Sprintf("%s wants to join your session", joinerName(event)), "")
+	case "join-cancelled":
+		if req, ok := m.takeJoinRequest(event.RequestID); ok {
+			m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s is no longer waiting to join.", joinerName(req))})
+		}
+	case "limit":
+		m.limitNotice(event)
+	case "error":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "Error", Content: fmt.Sprintf("Relay: %s", clipText(sanitizeRelayText(event.Message), maxRelayText))})
+	}
+	return nil
+}
+
+// describeJoinRequest names a joiner by nickname and identity fingerprint, and by
+// contact alias if the fingerprint belongs to a saved contact. Both are only
+// claims until the key exchange; the fingerprint is checked once the peer is in.
+func (m *Model) describeJoinRequest(req network.ControlEvent) string {
+	nickname := joinerName(req)
+	if req.Fingerprint == "" {
+		return nickname + " (no identity key)"
+	}
+	if m.Contacts != nil {
+		if contact, ok := m.Contacts.Lookup(req.Fingerprint); ok {
+			return fmt.Sprintf("%s (identity %s, your contact %s)", nickname, req.Fingerprint, contact.Label())
+		}
+	}
+	return fmt.Sprintf("%s (identity %s)", nickname, req.Fingerprint)
+}
+
+// joinerName returns the nickname a joiner announced, which may be empty.
+func joinerName(req network.ControlEvent) string {
+	if req.Nickname == "" {
+		return "Someone"
+	}
+	return req.Nickname
+}
+
+// decideJoinRequest handles /admit and /deny. name selects the request by
+// nickname and may be omitted while only one client is waiting.
+func (m *Model) decideJoinRequest(admit bool, name string) tea.Cmd {
+	now := time.Now()
+	req, ok := m.pickJoinRequest(name)
+	if !ok {
+		return nil
+	}
+	m.takeJoinRequest(req.RequestID)
+	control := m.control
+	if admit {
+		// The session is full once the joiner is in, so the waiting room is done.
+		m.control = nil
+		m.JoinRequests = nil
+		m.admittedFingerprint = req.Fingerprint
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Admitting %s.", joinerName(req))})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Denied %s.", joinerName(req))})
+	}
+	return func() tea.Msg {
+		var err error
+		if admit {
+			err = control.Admit(req.RequestID)
+			control.Close()
+		} else {
+			err = control.Deny(req.RequestID)
+		}
+		if err != nil {
+			return ControlEventMsg{Event: network.ControlEvent{Event: "error", Message: err.Error()}}
+		}
+		return nil
+	}
+}
+
+// banJoinRequest handles /ban [-ip] [name]: it turns a waiting client away and
+// keeps it out for the rest of the session, by identity key and, with -ip, by
+// IP address.
+func (m *Model) banJoinRequest(args string) tea.Cmd {
+	now := time.Now()
+	if m.ownerKey == "" {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Only the participant who created the session can ban."})
+		return nil
+	}
+	banIP := false
+	if args == "-ip" || strings.HasPrefix(args, "-ip ") {
+		banIP, args = true, strings.TrimSpace(strings.TrimPrefix(args, "-ip"))
+	}
+	req, ok := m.pickJoinRequest(args)
+	if !ok {
+		return nil
+	}
+	if req.Fingerprint == "" && !banIP {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("%s has no identity key to ban. Use /ban -ip to ban their IP address instead.", joinerName(req))})
+		return nil
+	}
+	m.takeJoinRequest(req.RequestID)
+	if banIP {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Banned %s and their IP address until the session ends.", joinerName(req))})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Banned %s until the session ends.", joinerName(req))})
+	}
+	control := m.control
+	return func() tea.Msg {
+		if err := control.Ban(req.RequestID, banIP); err != nil {
+			return ControlEventMsg{Event: network.ControlEvent{Event: "error", Message: err.Error()}}
+		}
+		return nil
+	}
+}
+
+// pickJoinRequest finds the waiting client called name, which may be empty
+// while only one is waiting, and explains why when there is no single one.
+func (m *Model) pickJoinRequest(name string) (network.ControlEvent, bool) {
+	now := time.Now()
+	if m.control == nil || len(m.JoinRequests) == 0 {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Nobody is waiting to join."})
+		return network.ControlEvent{}, false
+	}
+
+	var matches []network.ControlEvent
+	for _, req := range m.JoinRequests {
+		if name == "" || strings.EqualFold(req.Nickname, name) {
+			matches = append(matches, req)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Nobody called %s is waiting to join.", name)})
+		return network.ControlEvent{}, false
+	case len(matches) > 1:
+		names := make([]string, len(matches))
+		for i, req := range matches {
+			names[i] = m.describeJoinRequest(req)
+		}
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Several clients are waiting: %s. Name the one you mean.", strings.Join(names, ", "))})
+		return network.ControlEvent{}, false
+	}
+	return matches[0], true
+}
+
+// setLocked handles /lock and /unlock. While the session is locked, joiners
+// must knock with a note and wait for /admit.
+func (m *Model) setLocked(locked bool) tea.Cmd {
+	now := time.Now()
+	switch {
+	case m.ownerKey == "":
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Only the participant who created the session can lock it."})
+		return nil
+	case m.IsReady:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Your peer has already joined; the session is full."})
+		return nil
+	case m.control == nil:
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Not connected to the relay yet."})
+		return nil
+	}
+
+	control := m.control
+	if locked {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Session locked. Anyone who tries to join must knock, and you decide with /admit or /deny."})
+		return func() tea.Msg {
+			if err := control.Lock(); err != nil {
+				return ControlEventMsg{Event: network.ControlEvent{Event: "error", Message: err.Error()}}
+			}
+			return nil
+		}
+	}
+
+	// The relay lets in whoever knocked first, unless the waiting room holds them.
+	if !m.WaitingRoom && len(m.JoinRequests) > 0 {
+		first, _ := m.takeJoinRequest(m.JoinRequests[0].RequestID)
+		m.admittedFingerprint = first.Fingerprint
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: fmt.Sprintf("Session unlocked. Letting in %s, who knocked first.", joinerName(first))})
+	} else {
+		m.Messages = append(m.Messages, Message{Timestamp: now, Sender: "System", Content: "Session unlocked."})
+	}
+	return func() tea.Msg {
+		if err := control.Unlock(); err != nil {
+			return ControlEventMsg{Event: network.ControlEvent{Event: "error", Message: err.Error()}}
+		}
+		return nil
+	}
+}
+
+// takeJoinRequest removes a request from the waiting list.
+func (m *Model) takeJoinRequest(id string) (network.ControlEvent, bool) {
+	for i, req := range m.JoinRequests {
+		if req.RequestID == id {
+			m.JoinRequests = append(m.JoinRequests[:i], m.JoinRequests[i+1:]...)
+			return req, true
+		}
+	}
+	return network.ControlEvent{}, false
+}
+
+// sanitizeRelayText drops control characters from a nickname or note passed on by the
+// relay, or a label sent by the peer, so they cannot inject terminal escape sequences.
+func sanitizeRelayText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// clipText cuts text to at most n bytes, without splitting a character.
+func clipText(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	return text[:n]
+}
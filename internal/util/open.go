@@ -0,0 +1,40 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// OpenFile opens path with command if set (the path is appended as the last
+// argument), or with the platform's default handler otherwise. It does not
+// wait for the opened application to exit. The path is made absolute first, so
+// that a file name starting with a dash cannot be taken for an option.
+func OpenFile(path, command string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", path, err)
+	}
+	path = abs
+	var cmd *exec.Cmd
+	if fields := strings.Fields(command); len(fields) > 0 {
+		cmd = exec.Command(fields[0], append(fields[1:], path)...)
+	} else {
+		switch runtime.GOOS {
+		case "darwin":
+			cmd = exec.Command("open", path)
+		case "windows":
+			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", path)
+		default:
+			cmd = exec.Command("xdg-open", path)
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not open %s: %w", path, err)
+	}
+	// Reap the child in the background so it does not linger as a zombie.
+	go cmd.Wait()
+	return nil
+}